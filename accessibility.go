@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// minContrastRatio is the WCAG AA contrast ratio for body-sized text.
+const minContrastRatio = 4.5
+
+// namedColors covers the CSS color keywords commonly used in emails.
+var namedColors = map[string][3]uint8{
+	"black":  {0, 0, 0},
+	"white":  {255, 255, 255},
+	"gray":   {128, 128, 128},
+	"grey":   {128, 128, 128},
+	"silver": {192, 192, 192},
+	"red":    {255, 0, 0},
+	"green":  {0, 128, 0},
+	"blue":   {0, 0, 255},
+	"navy":   {0, 0, 128},
+	"yellow": {255, 255, 0},
+	"orange": {255, 165, 0},
+	"purple": {128, 0, 128},
+}
+
+// parseColor parses #rgb, #rrggbb, rgb(r, g, b) and the named colors above.
+func parseColor(s string) ([3]uint8, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if c, ok := namedColors[s]; ok {
+		return c, true
+	}
+	if hex, ok := strings.CutPrefix(s, "#"); ok {
+		if len(hex) == 3 {
+			hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+		}
+		if len(hex) != 6 {
+			return [3]uint8{}, false
+		}
+		v, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return [3]uint8{}, false
+		}
+		return [3]uint8{uint8(v >> 16), uint8(v >> 8), uint8(v)}, true
+	}
+	if args, ok := strings.CutPrefix(s, "rgb("); ok {
+		parts := strings.Split(strings.TrimSuffix(args, ")"), ",")
+		if len(parts) != 3 {
+			return [3]uint8{}, false
+		}
+		var c [3]uint8
+		for i, p := range parts {
+			v, err := strconv.Atoi(strings.TrimSpace(p))
+			if err != nil || v < 0 || v > 255 {
+				return [3]uint8{}, false
+			}
+			c[i] = uint8(v)
+		}
+		return c, true
+	}
+	return [3]uint8{}, false
+}
+
+// luminance is the WCAG relative luminance of c.
+func luminance(c [3]uint8) float64 {
+	var l [3]float64
+	for i, v := range c {
+		f := float64(v) / 255
+		if f <= 0.03928 {
+			l[i] = f / 12.92
+		} else {
+			l[i] = math.Pow((f+0.055)/1.055, 2.4)
+		}
+	}
+	return 0.2126*l[0] + 0.7152*l[1] + 0.0722*l[2]
+}
+
+// contrastRatio returns the WCAG contrast ratio between two colors, from 1
+// to 21.
+func contrastRatio(a, b [3]uint8) float64 {
+	la, lb := luminance(a), luminance(b)
+	if la < lb {
+		la, lb = lb, la
+	}
+	return (la + 0.05) / (lb + 0.05)
+}
+
+// inlineStyle returns the declarations of a style attribute keyed by
+// lower-cased property name.
+func inlineStyle(n *html.Node) map[string]string {
+	decls := map[string]string{}
+	for _, attr := range n.Attr {
+		if attr.Key != "style" {
+			continue
+		}
+		for _, decl := range strings.Split(attr.Val, ";") {
+			prop, value, ok := strings.Cut(decl, ":")
+			if ok {
+				value = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "!important"))
+				decls[strings.ToLower(strings.TrimSpace(prop))] = value
+			}
+		}
+	}
+	return decls
+}
+
+func attrValue(n *html.Node, key string) (string, bool) {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
+
+// fontSizePx converts an absolute font-size to pixels. Relative sizes are
+// not resolved and report false.
+func fontSizePx(s string) (float64, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	scale := 1.0
+	switch {
+	case strings.HasSuffix(s, "px"):
+		s = strings.TrimSuffix(s, "px")
+	case strings.HasSuffix(s, "pt"):
+		s, scale = strings.TrimSuffix(s, "pt"), 4.0/3
+	default:
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, false
+	}
+	return v * scale, true
+}
+
+// accessibilityIssues lists images without alt text, inline font sizes
+// below minFontPx and links whose color contrasts poorly with the
+// background they sit on. Backgrounds are inherited from the nearest
+// background-color, background or bgcolor, defaulting to white.
+func accessibilityIssues(doc string, minFontPx float64) ([]string, error) {
+	root, err := html.Parse(strings.NewReader(doc))
+	if err != nil {
+		return nil, err
+	}
+	var issues []string
+	var walk func(n *html.Node, bg [3]uint8)
+	walk = func(n *html.Node, bg [3]uint8) {
+		if n.Type == html.ElementNode {
+			style := inlineStyle(n)
+			for _, v := range []string{style["background-color"], style["background"]} {
+				if c, ok := parseColor(v); ok {
+					bg = c
+					break
+				}
+			}
+			if v, ok := attrValue(n, "bgcolor"); ok {
+				if c, ok := parseColor(v); ok {
+					bg = c
+				}
+			}
+			if px, ok := fontSizePx(style["font-size"]); ok && px < minFontPx {
+				issues = append(issues, fmt.Sprintf("<%s> font-size %s is below %gpx", n.Data, style["font-size"], minFontPx))
+			}
+			switch n.Data {
+			case "img":
+				// An empty alt marks a decorative image, which is fine.
+				if _, ok := attrValue(n, "alt"); !ok {
+					src, _ := attrValue(n, "src")
+					issues = append(issues, fmt.Sprintf("<img src=%q> has no alt text", src))
+				}
+			case "a":
+				if fg, ok := parseColor(style["color"]); ok {
+					if ratio := contrastRatio(fg, bg); ratio < minContrastRatio {
+						href, _ := attrValue(n, "href")
+						issues = append(issues, fmt.Sprintf("link %q color %s has contrast %.1f:1, below %.1f:1", href, style["color"], ratio, minContrastRatio))
+					}
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c, bg)
+		}
+	}
+	walk(root, namedColors["white"])
+	return issues, nil
+}
+
+// checkAccessibility runs the accessibility preflight when
+// ACCESSIBILITY_CHECK_MODE is warn or block. Font sizes below
+// ACCESSIBILITY_MIN_FONT_PX (default 12) are flagged.
+func checkAccessibility(body string) (PreflightResult, bool) {
+	mode := os.Getenv("ACCESSIBILITY_CHECK_MODE")
+	if mode != "warn" && mode != "block" {
+		return PreflightResult{}, false
+	}
+	result := PreflightResult{Check: "accessibility", Status: checkOK}
+	issues, err := accessibilityIssues(body, float64(getEnvInt("ACCESSIBILITY_MIN_FONT_PX", 12)))
+	if err != nil {
+		result.Status, result.Detail = checkFail, err.Error()
+		return result, true
+	}
+	if len(issues) == 0 {
+		return result, true
+	}
+	result.AccessibilityIssues = issues
+	result.Detail = fmt.Sprintf("%d accessibility issues", len(issues))
+	if mode == "block" {
+		result.Status = checkFail
+	} else {
+		result.Status = checkWarn
+	}
+	return result, true
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestContrastRatio(t *testing.T) {
+	black, _ := parseColor("#000")
+	white, _ := parseColor("white")
+	if got := contrastRatio(black, white); got < 20.9 || got > 21.1 {
+		t.Errorf("black on white = %.2f, want 21", got)
+	}
+	if _, ok := parseColor("rgb(300, 0, 0)"); ok {
+		t.Error("out of range rgb parsed")
+	}
+}
+
+func TestAccessibilityIssues(t *testing.T) {
+	doc := `<body>
+		<img src="a.png"><img src="spacer.gif" alt="">
+		<p style="font-size: 9px">fine print</p><p style="font-size:1em">relative</p>
+		<a href="https://example.com/light" style="color:#ccc">light</a>
+		<a href="https://example.com/dark" style="color:#000">dark</a>
+		<div style="background-color:#000"><a href="https://example.com/on-dark" style="color: #111">x</a></div>
+	</body>`
+	issues, err := accessibilityIssues(doc, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`"a.png"`, "9px", "/light", "/on-dark"}
+	if len(issues) != len(want) {
+		t.Fatalf("issues = %q", issues)
+	}
+	for i, w := range want {
+		if !strings.Contains(issues[i], w) {
+			t.Errorf("issue %d = %q, want it to mention %s", i, issues[i], w)
+		}
+	}
+}
+
+func TestCheckAccessibilityMode(t *testing.T) {
+	body := `<img src="a.png">`
+	if _, ok := checkAccessibility(body); ok {
+		t.Error("check ran with ACCESSIBILITY_CHECK_MODE unset")
+	}
+	t.Setenv("ACCESSIBILITY_CHECK_MODE", "warn")
+	if r, _ := checkAccessibility(body); r.Status != checkWarn {
+		t.Errorf("warn mode status = %s", r.Status)
+	}
+	t.Setenv("ACCESSIBILITY_CHECK_MODE", "block")
+	if r, _ := checkAccessibility(body); r.Status != checkFail {
+		t.Errorf("block mode status = %s", r.Status)
+	}
+}
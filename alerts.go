@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+// notifyAdmin sends an operational alert of the given kind to the
+// ALERT_TARGETS that want it (see parseNotifyTarget), to ALERT_WEBHOOK_URL,
+// as a JSON body with subject and text fields (accepted by Slack-style
+// incoming webhooks), and to ADMIN_EMAIL. With none configured it only
+// logs. ALERT_WEBHOOK_URL attempts are recorded in
+// outbound_webhook_deliveries.
+func notifyAdmin(ctx context.Context, db *sql.DB, sender EmailSender, kind, subject, text string) {
+	log.Printf("Admin alert: %s", subject)
+
+	dispatchAlert(ctx, sender, Alert{Kind: kind, Subject: subject, Text: text})
+
+	if credential("ALERT_WEBHOOK_URL") != "" {
+		if err := postAlertWebhook(ctx, db, subject, text); err != nil {
+			log.Printf("Error posting alert webhook: %v", err)
+		}
+	}
+	if to := os.Getenv("ADMIN_EMAIL"); to != "" {
+		m := gomail.NewMessage()
+		setFromHeader(m, "")
+		m.SetHeader("To", to)
+		m.SetHeader("Subject", subject)
+		m.SetBody("text/plain", text)
+		if _, err := sender.Send(ctx, m); err != nil {
+			log.Printf("Error emailing admin alert: %v", err)
+		}
+	}
+}
+
+func postAlertWebhook(ctx context.Context, db *sql.DB, subject, text string) error {
+	body, err := json.Marshal(map[string]string{"subject": subject, "text": subject + "\n\n" + text})
+	if err != nil {
+		return err
+	}
+	_, err = deliverWebhook(ctx, db, "alert", body, 0)
+	return err
+}
+
+// QueueStats describes email waiting to be sent. Depth counts recipients
+// deferred by the warm-up cap plus pending dead letters. OldestAgeSeconds
+// is the age of the oldest running job or pending dead letter; deferred
+// recipients are excluded because waiting is expected for them.
+type QueueStats struct {
+	Deferred         int     `json:"deferred"`
+	DeadLetters      int     `json:"dead_letters"`
+	Depth            int     `json:"depth"`
+	OldestAgeSeconds float64 `json:"oldest_age_seconds"`
+}
+
+func getQueueStats(ctx context.Context, db *sql.DB, now time.Time) (QueueStats, error) {
+	var s QueueStats
+	err := db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(json_extract(j.report, '$.deferred')), 0)
+		FROM newsletter_jobs j
+		WHERE j.status = ?
+			AND j.id = (SELECT MAX(id) FROM newsletter_jobs WHERE article_id = j.article_id)`,
+		jobDeferred).Scan(&s.Deferred)
+	if err != nil {
+		return s, err
+	}
+
+	var oldest sql.NullString
+	err = db.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM dead_letters WHERE status = ?),
+			MIN(
+				COALESCE((SELECT MIN(started_at) FROM newsletter_jobs WHERE status = ?), '9999'),
+				COALESCE((SELECT MIN(created_at) FROM dead_letters WHERE status = ?), '9999'))`,
+		deadLetterPending, jobRunning, deadLetterPending).Scan(&s.DeadLetters, &oldest)
+	if err != nil {
+		return s, err
+	}
+	if t, err := time.Parse(sqliteTimeFormat, oldest.String); err == nil {
+		s.OldestAgeSeconds = now.Sub(t).Seconds()
+	}
+	s.Depth = s.Deferred + s.DeadLetters
+	return s, nil
+}
+
+// queueProblem describes why stats exceed ALERT_QUEUE_DEPTH (default 1000)
+// or ALERT_QUEUE_AGE (default 1h), or returns "". A zero threshold
+// disables that check.
+func queueProblem(s QueueStats) string {
+	if limit := getEnvInt("ALERT_QUEUE_DEPTH", 1000); limit > 0 && s.Depth > limit {
+		return fmt.Sprintf("%d emails are waiting to be sent (threshold %d)", s.Depth, limit)
+	}
+	age := time.Duration(s.OldestAgeSeconds * float64(time.Second))
+	if limit := getEnvDuration("ALERT_QUEUE_AGE", time.Hour); limit > 0 && age > limit {
+		return fmt.Sprintf("the oldest unsent email has waited %s (threshold %s)", age.Round(time.Second), limit)
+	}
+	return ""
+}
+
+// queueMonitor alerts the admin when the send queue crosses a threshold,
+// again every ALERT_REPEAT_INTERVAL (default 6h) while it stays there, and
+// once it recovers.
+type queueMonitor struct {
+	alertedAt time.Time
+}
+
+func (q *queueMonitor) check(ctx context.Context, db *sql.DB, sender EmailSender) error {
+	stats, err := getQueueStats(ctx, db, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("checking send queue: %w", err)
+	}
+
+	problem := queueProblem(stats)
+	switch {
+	case problem != "" && time.Since(q.alertedAt) >= getEnvDuration("ALERT_REPEAT_INTERVAL", 6*time.Hour):
+		notifyAdmin(ctx, db, sender, alertQueue, "Newsletter send queue is backing up",
+			fmt.Sprintf("Alert: %s.\n\nDeferred by warm-up: %d\nPending dead letters: %d\n\nSMTP may be down or a send may be stuck.",
+				problem, stats.Deferred, stats.DeadLetters))
+		q.alertedAt = time.Now()
+	case problem == "" && !q.alertedAt.IsZero():
+		notifyAdmin(ctx, db, sender, alertQueue, "Newsletter send queue recovered", "The send queue is back under its alert thresholds.")
+		q.alertedAt = time.Time{}
+	}
+	return nil
+}
+
+// deliverabilityMonitor alerts the admin when a deliverability check of
+// the sending domain starts failing, and once the checks pass again.
+type deliverabilityMonitor struct {
+	failing bool
+}
+
+func (d *deliverabilityMonitor) check(ctx context.Context, db *sql.DB, sender EmailSender, r dnsResolver) error {
+	domain := senderDomain()
+	if domain == "localhost" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	report := runDeliverabilityChecks(ctx, r, domain)
+
+	switch {
+	case !report.OK && !d.failing:
+		var b strings.Builder
+		fmt.Fprintf(&b, "Deliverability checks for %s failed:\n", domain)
+		for _, c := range report.Checks {
+			if c.Status == checkFail {
+				fmt.Fprintf(&b, "\n%s: %s\n", c.Name, c.Detail)
+			}
+		}
+		b.WriteString("\nNewsletters may land in spam until this is fixed. Details: /api/admin/deliverability\n")
+		notifyAdmin(ctx, db, sender, alertDeliverability, "Deliverability checks failing for "+domain, b.String())
+		d.failing = true
+	case report.OK && d.failing:
+		notifyAdmin(ctx, db, sender, alertDeliverability, "Deliverability checks passing for "+domain, "All deliverability checks pass again.")
+		d.failing = false
+	}
+	return nil
+}
+
+func handleGetQueue(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		stats, err := getQueueStats(r.Context(), db, time.Now().UTC())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	}
+}
+
+// notifyJobFailure tells the admin that a newsletter job failed or was
+// blocked by a preflight check, which otherwise only shows in the logs.
+func notifyJobFailure(ctx context.Context, db *sql.DB, sender EmailSender, job NewsletterJob) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Newsletter job %d for article %d ended with status %q.\n", job.ID, job.ArticleID, job.Status)
+	if job.Report.Error != "" {
+		fmt.Fprintf(&b, "\nError: %s\n", job.Report.Error)
+	}
+	for _, check := range job.Report.Preflight {
+		if check.Status == checkFail {
+			fmt.Fprintf(&b, "\nPreflight check %s failed: %s\n", check.Check, check.Detail)
+		}
+	}
+	fmt.Fprintf(&b, "\nSent: %d, failed: %d. Details: /api/jobs/%d\n", job.Sent, job.Failed, job.ID)
+	notifyAdmin(ctx, db, sender, alertJobFailure, fmt.Sprintf("Newsletter for article %d %s", job.ArticleID, job.Status), b.String())
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueueStatsAndAlert(t *testing.T) {
+	t.Setenv("ALERT_QUEUE_DEPTH", "2")
+	t.Setenv("ALERT_QUEUE_AGE", "30m")
+	db := newTestDB(t)
+
+	now := time.Now().UTC()
+	old := now.Add(-2 * time.Hour).Format(sqliteTimeFormat)
+	if _, err := db.Exec(`INSERT INTO newsletter_jobs (article_id, status, report) VALUES (1, 'deferred', '{"deferred": 2}')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO dead_letters (subscriber_id, article_id, attempts, errors, created_at) VALUES (1, 2, 3, '[]', ?)`, old); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := getQueueStats(context.Background(), db, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Deferred != 2 || stats.DeadLetters != 1 || stats.Depth != 3 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if stats.OldestAgeSeconds < 7190 || stats.OldestAgeSeconds > 7210 {
+		t.Fatalf("oldest age = %vs, want about 2h", stats.OldestAgeSeconds)
+	}
+	if p := queueProblem(stats); !strings.Contains(p, "3 emails are waiting") {
+		t.Fatalf("queueProblem = %q", p)
+	}
+
+	var got map[string]string
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer hook.Close()
+	t.Setenv("ALERT_WEBHOOK_URL", hook.URL)
+	t.Setenv("ADMIN_EMAIL", "admin@example.com")
+	sender := newMockSender("")
+
+	notifyAdmin(context.Background(), db, sender, alertQueue, "Queue backing up", "details")
+	if got["subject"] != "Queue backing up" {
+		t.Fatalf("webhook body = %v", got)
+	}
+	if msgs := sender.Messages(); len(msgs) != 1 || msgs[0].GetHeader("To")[0] != "admin@example.com" {
+		t.Fatalf("admin emails = %d", len(msgs))
+	}
+}
+
+func TestFailedJobEmailsAdmin(t *testing.T) {
+	t.Setenv("ADMIN_EMAIL", "admin@example.com")
+	db := newTestDB(t)
+	sender := newMockSender("")
+
+	// No article 7, so the job fails outright.
+	sendNewsletterForArticle(context.Background(), db, sender, 7)
+
+	msgs := sender.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("sent %d messages, want 1 admin alert", len(msgs))
+	}
+	if subject := msgs[0].GetHeader("Subject"); len(subject) != 1 || subject[0] != "Newsletter for article 7 failed" {
+		t.Fatalf("Subject = %v", subject)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ampEnabled reports whether newsletters carry an AMP for Email part
+// (AMP_ENABLED and the amp_enabled flag). Gmail only renders AMP from
+// senders registered with Google whose mail passes SPF, DKIM and DMARC;
+// other clients ignore the part and show the HTML one.
+func ampEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("AMP_ENABLED"))
+	return enabled && flagEnabled(flagAMP)
+}
+
+// ampTemplateFile returns the AMP template path (AMP_TEMPLATE, default
+// email_amp.html).
+func ampTemplateFile() string {
+	if path := os.Getenv("AMP_TEMPLATE"); path != "" {
+		return path
+	}
+	return "email_amp.html"
+}
+
+// renderAMPBody renders the AMP for Email part for one subscriber. ok is
+// false when AMP is disabled.
+func renderAMPBody(sub Subscriber, article Article) (body string, ok bool, err error) {
+	if !ampEnabled() {
+		return "", false, nil
+	}
+	src, err := os.ReadFile(ampTemplateFile())
+	if err != nil {
+		return "", false, fmt.Errorf("reading AMP template: %w", err)
+	}
+	if !strings.Contains(string(src), "⚡4email") && !strings.Contains(string(src), "amp4email") {
+		return "", false, errors.New("AMP template must declare <html ⚡4email>")
+	}
+	t, err := template.New("amp").Parse(string(src))
+	if err != nil {
+		return "", false, fmt.Errorf("parsing AMP template: %w", err)
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, emailTemplateData(sub, article)); err != nil {
+		return "", false, fmt.Errorf("executing AMP template: %w", err)
+	}
+	return b.String(), true, nil
+}
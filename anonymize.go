@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// runAnonymize copies a database to --out and scrubs personal data from
+// the copy, for use in staging and local development.
+func runAnonymize(args []string) error {
+	fs := flag.NewFlagSet("anonymize", flag.ContinueOnError)
+	dbPath := fs.String("db", databasePath(), "database to copy")
+	out := fs.String("out", "", "path of the anonymized copy, must not exist")
+	salt := fs.String("salt", "", "secret used to hash emails; the same salt gives the same addresses across runs (default random)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *out == "" {
+		return errors.New("--out is required")
+	}
+	if _, err := os.Stat(*out); err == nil {
+		return fmt.Errorf("%s already exists", *out)
+	}
+	if *salt == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		*salt = hex.EncodeToString(b)
+	}
+
+	src := openDB(*dbPath)
+	_, err := src.Exec("VACUUM INTO ?", *out)
+	src.Close()
+	if err != nil {
+		return fmt.Errorf("copying database: %w", err)
+	}
+
+	db := openDB(*out)
+	defer db.Close()
+	if err := anonymizeDatabase(db, *salt); err != nil {
+		os.Remove(*out)
+		return err
+	}
+	log.Printf("Anonymized copy written to %s", *out)
+	return nil
+}
+
+// anonymizedEmail replaces an address with a stable, undeliverable one.
+// Equal addresses map to equal results, so uniqueness is preserved.
+func anonymizedEmail(salt, email string) string {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(strings.ToLower(email)))
+	return "sub-" + hex.EncodeToString(mac.Sum(nil))[:20] + "@example.invalid"
+}
+
+// anonymizeDatabase scrubs emails, names and request metadata in one
+// transaction. Row IDs are untouched, so sends, events and jobs still
+// refer to the right subscribers and articles. Raw webhook payloads are
+// emptied, and poll answers, push subscriptions, stored credentials,
+// admin accounts and sessions are removed; the server recreates the
+// bootstrap admin.
+func anonymizeDatabase(db *sql.DB, salt string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id, email FROM subscribers")
+	if err != nil {
+		return err
+	}
+	emails := map[int]string{}
+	for rows.Next() {
+		var id int
+		var email string
+		if err := rows.Scan(&id, &email); err != nil {
+			rows.Close()
+			return err
+		}
+		emails[id] = email
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for id, email := range emails {
+		if _, err := tx.Exec("UPDATE subscribers SET email = ?, name = ?, stripe_customer_id = NULL WHERE id = ?",
+			anonymizedEmail(salt, email), fmt.Sprintf("Subscriber %d", id), id); err != nil {
+			return fmt.Errorf("anonymizing subscriber %d: %w", id, err)
+		}
+	}
+
+	for _, stmt := range []string{
+		"UPDATE consent_log SET ip = '', user_agent = ''",
+		"UPDATE subscriber_notes SET body = '[redacted]'",
+		"UPDATE events SET detail = ''",
+		"UPDATE dead_letters SET errors = '[]'",
+		"UPDATE audit_log SET diff = NULL, ip = NULL",
+		"UPDATE replies SET from_address = 'reply-' || id || '@example.invalid', body = '[redacted]'",
+		"UPDATE webhook_events SET payload = '{}'",
+		"UPDATE outbound_webhook_deliveries SET payload = '{}', response = ''",
+		"UPDATE channel_deliveries SET recipient = 'recipient-' || id",
+		"DELETE FROM poll_responses",
+		"DELETE FROM push_subscriptions",
+		"DELETE FROM credentials",
+		"DELETE FROM admin_sessions",
+		"DELETE FROM admin_users",
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("%s: %w", stmt, err)
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAnonymize(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "prod.db")
+	db := openDB(src)
+	if err := seedFakeData(db, seedOptions{Subscribers: 20, Articles: 3, SentRatio: 1, Seed: 1}); err != nil {
+		t.Fatal(err)
+	}
+	var sent int
+	db.QueryRow("SELECT COUNT(*) FROM sent_emails").Scan(&sent)
+	db.Close()
+
+	out := filepath.Join(dir, "staging.db")
+	if err := runAnonymize([]string{"--db", src, "--out", out, "--salt", "s"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := runAnonymize([]string{"--db", src, "--out", out}); err == nil {
+		t.Fatal("overwrote an existing file")
+	}
+
+	copyDB := openDB(out)
+	defer copyDB.Close()
+	rows, err := copyDB.Query("SELECT id, email, name FROM subscribers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		var id int
+		var email, name string
+		if err := rows.Scan(&id, &email, &name); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(email, "@example.invalid") || !strings.HasPrefix(name, "Subscriber ") {
+			t.Errorf("subscriber %d not anonymized: %q %q", id, email, name)
+		}
+		n++
+	}
+	if n != 20 {
+		t.Fatalf("%d subscribers in copy", n)
+	}
+
+	var joined int
+	copyDB.QueryRow("SELECT COUNT(*) FROM sent_emails JOIN subscribers ON subscribers.id = sent_emails.subscriber_id").Scan(&joined)
+	if joined != sent || sent == 0 {
+		t.Fatalf("sent_emails joined %d of %d", joined, sent)
+	}
+}
+
+// anonymizedColumns lists every column holding an email address or other
+// recipient identifier that anonymizeDatabase scrubs or deletes. A new
+// table with such a column must be handled there and added here.
+var anonymizedColumns = map[string]bool{
+	"subscribers.email":            true,
+	"replies.from_address":         true,
+	"channel_deliveries.recipient": true,
+}
+
+func TestAnonymizeCoversAddressColumns(t *testing.T) {
+	db := newTestDB(t)
+	rows, err := db.Query(`
+		SELECT m.name, c.name FROM sqlite_master m, pragma_table_info(m.name) c
+		WHERE m.type = 'table'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			t.Fatal(err)
+		}
+		name := strings.ToLower(column)
+		if name == "instead_of_email" {
+			continue // a flag, not an address
+		}
+		if (strings.Contains(name, "email") || strings.Contains(name, "address") || strings.Contains(name, "recipient")) &&
+			!anonymizedColumns[table+"."+column] {
+			t.Errorf("%s.%s looks like an address but anonymizeDatabase does not scrub it", table, column)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAnonymizeScrubsSubscriberData(t *testing.T) {
+	db := newTestDB(t)
+	const email = "ada@lovelace.example"
+	for _, stmt := range []string{
+		"INSERT INTO subscribers (email, name) VALUES ('" + email + "', 'Ada')",
+		"INSERT INTO articles (title, content) VALUES ('Hello', '')",
+		"INSERT INTO replies (subscriber_id, article_id, from_address, subject, body) VALUES (1, 1, '" + email + "', 'Re: Hello', 'Write to " + email + "')",
+		"INSERT INTO poll_responses (article_id, subscriber_id, option) VALUES (1, 1, 0)",
+		"INSERT INTO webhook_events (source, payload) VALUES ('ses', '{\"email\":\"" + email + "\"}')",
+		"INSERT INTO outbound_webhook_deliveries (endpoint, payload) VALUES ('https://hooks.example.com', '{\"email\":\"" + email + "\"}')",
+		"INSERT INTO push_subscriptions (subscriber_id, endpoint, p256dh, auth) VALUES (1, 'https://push.example.com/1', 'k', 'a')",
+		"INSERT INTO channel_deliveries (channel, article_id, recipient, subscriber_id, status) VALUES ('sms', 1, '" + email + "', 1, 'sent')",
+		"INSERT INTO credentials (name, value) VALUES ('SMTP_PASSWORD', 'hunter2')",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	if err := anonymizeDatabase(db, "s"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []string{"poll_responses", "push_subscriptions", "credentials"} {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Errorf("%d rows left in %s", n, table)
+		}
+	}
+
+	// No text column anywhere may still contain the original address.
+	rows, err := db.Query(`
+		SELECT m.name, c.name FROM sqlite_master m, pragma_table_info(m.name) c
+		WHERE m.type = 'table'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var columns [][2]string
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			t.Fatal(err)
+		}
+		columns = append(columns, [2]string{table, column})
+	}
+	rows.Close()
+	for _, c := range columns {
+		var n int
+		query := fmt.Sprintf(`SELECT COUNT(*) FROM %q WHERE CAST(%q AS TEXT) LIKE ?`, c[0], c[1])
+		if err := db.QueryRow(query, "%"+email+"%").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Errorf("%s.%s still contains %s", c[0], c[1], email)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigDocument is the declarative configuration accepted by
+// /api/admin/apply. Only settings stored in the database can be managed
+// this way; templates and API keys live in files and the environment.
+type ConfigDocument struct {
+	// Flags maps feature flag names to their values. Stored flags not
+	// listed are reset to their defaults.
+	Flags map[string]bool `json:"flags" yaml:"flags"`
+}
+
+// ConfigChange is one difference between the document and the database.
+type ConfigChange struct {
+	Kind   string      `json:"kind"`
+	Name   string      `json:"name"`
+	Action string      `json:"action"`
+	From   interface{} `json:"from,omitempty"`
+	To     interface{} `json:"to,omitempty"`
+}
+
+// planConfig lists the changes needed to make the database match doc, in
+// a stable order.
+func planConfig(db *sql.DB, doc ConfigDocument) ([]ConfigChange, error) {
+	stored := map[string]bool{}
+	rows, err := db.Query("SELECT name, enabled FROM feature_flags")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var enabled bool
+		if err := rows.Scan(&name, &enabled); err != nil {
+			return nil, err
+		}
+		stored[name] = enabled
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	changes := []ConfigChange{}
+	for name, enabled := range doc.Flags {
+		if _, ok := featureFlags[name]; !ok {
+			return nil, fmt.Errorf("unknown flag %q", name)
+		}
+		current, ok := stored[name]
+		switch {
+		case !ok:
+			changes = append(changes, ConfigChange{Kind: "flag", Name: name, Action: "create", To: enabled})
+		case current != enabled:
+			changes = append(changes, ConfigChange{Kind: "flag", Name: name, Action: "update", From: current, To: enabled})
+		}
+	}
+	for name, current := range stored {
+		if _, ok := doc.Flags[name]; !ok {
+			changes = append(changes, ConfigChange{Kind: "flag", Name: name, Action: "delete", From: current})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Kind != changes[j].Kind {
+			return changes[i].Kind < changes[j].Kind
+		}
+		return changes[i].Name < changes[j].Name
+	})
+	return changes, nil
+}
+
+// applyConfig makes the changes in one transaction.
+func applyConfig(db *sql.DB, changes []ConfigChange) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, c := range changes {
+		switch c.Action {
+		case "create", "update":
+			_, err = tx.Exec(`
+				INSERT INTO feature_flags (name, enabled) VALUES (?, ?)
+				ON CONFLICT (name) DO UPDATE SET enabled = excluded.enabled, updated_at = CURRENT_TIMESTAMP`,
+				c.Name, c.To)
+		case "delete":
+			_, err = tx.Exec("DELETE FROM feature_flags WHERE name = ?", c.Name)
+		}
+		if err != nil {
+			return fmt.Errorf("applying %s %s: %w", c.Kind, c.Name, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return loadFeatureFlags(db)
+}
+
+// decodeConfigDocument reads a JSON or, with a YAML content type, YAML
+// document. Unknown fields are rejected so typos don't pass silently.
+func decodeConfigDocument(r *http.Request) (ConfigDocument, error) {
+	var doc ConfigDocument
+	if strings.Contains(r.Header.Get("Content-Type"), "yaml") {
+		dec := yaml.NewDecoder(r.Body)
+		dec.KnownFields(true)
+		if err := dec.Decode(&doc); err != nil && err != io.EOF {
+			return doc, err
+		}
+		return doc, nil
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&doc)
+	return doc, err
+}
+
+// handleApplyConfig reconciles the database with a declarative document,
+// for GitOps-style management. With ?dry_run=true it only returns the
+// changes it would make.
+func handleApplyConfig(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+
+		doc, err := decodeConfigDocument(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		changes, err := planConfig(db, doc)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !dryRun && len(changes) > 0 {
+			if err := applyConfig(db, changes); err != nil {
+				log.Printf("Error applying configuration: %v", err)
+				http.Error(w, "Error applying configuration", http.StatusInternalServerError)
+				return
+			}
+			recordAudit(db, r, "apply", "config", 0, changes)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"dry_run": dryRun, "changes": changes})
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestApplyConfig(t *testing.T) {
+	db := newTestDB(t)
+	srv := newTestServer(t, db, &mockSender{})
+
+	apply := func(contentType, query, body string) []ConfigChange {
+		t.Helper()
+		resp, err := http.Post(srv.URL+"/api/admin/apply"+query, contentType, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("apply %q: status %d", body, resp.StatusCode)
+		}
+		var result struct {
+			Changes []ConfigChange `json:"changes"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		return result.Changes
+	}
+
+	doc := "flags:\n  tracking_enabled: false\n"
+	if changes := apply("application/yaml", "?dry_run=true", doc); len(changes) != 1 || changes[0].Action != "create" {
+		t.Fatalf("dry run changes = %+v", changes)
+	}
+	if !flagEnabled(flagTracking) {
+		t.Fatal("dry run changed the flag")
+	}
+
+	apply("application/yaml", "", doc)
+	if flagEnabled(flagTracking) {
+		t.Fatal("flag not applied")
+	}
+	if changes := apply("application/yaml", "", doc); len(changes) != 0 {
+		t.Fatalf("reapplying changed %+v", changes)
+	}
+
+	// Flags missing from the document go back to their defaults.
+	if changes := apply("application/json", "", `{"flags":{}}`); len(changes) != 1 || changes[0].Action != "delete" {
+		t.Fatalf("changes = %+v", changes)
+	}
+	if !flagEnabled(flagTracking) {
+		t.Fatal("flag not reset to default")
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"os"
+)
+
+// sendArchiveCopy sends one copy of the article's newsletter to BCC_ARCHIVE,
+// rendered without subscriber personalization. The copy is only sent once
+// per article, however many times the newsletter is triggered.
+func sendArchiveCopy(ctx context.Context, db *sql.DB, sender EmailSender, article Article) {
+	archive := os.Getenv("BCC_ARCHIVE")
+	if archive == "" {
+		return
+	}
+
+	result, err := db.ExecContext(ctx,
+		"UPDATE articles SET archive_sent_at = CURRENT_TIMESTAMP WHERE id = ? AND archive_sent_at IS NULL", article.ID)
+	if err != nil {
+		log.Printf("Error claiming archive copy for article %d: %v", article.ID, err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return
+	}
+
+	m, err := buildNewsletterMessage(Subscriber{Email: archive}, article)
+	if err == nil {
+		_, err = sender.Send(ctx, m)
+	}
+	if err != nil {
+		log.Printf("Error sending archive copy of article %d: %v", article.ID, err)
+		if _, err := db.ExecContext(ctx, "UPDATE articles SET archive_sent_at = NULL WHERE id = ?", article.ID); err != nil {
+			log.Printf("Error releasing archive copy for article %d: %v", article.ID, err)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"html/template"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// maxDescriptionLength caps the og:description taken from the content.
+const maxDescriptionLength = 200
+
+// slugify lowercases s and joins its letters and digits with hyphens.
+func slugify(s string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			hyphen = false
+		} else {
+			hyphen = true
+		}
+	}
+	return b.String()
+}
+
+// articleSlug is the article's path under /articles/. The leading id keeps
+// it unique and lets the title change without breaking old links.
+func articleSlug(article Article) string {
+	if s := slugify(article.Title); s != "" {
+		return strconv.Itoa(article.ID) + "-" + s
+	}
+	return strconv.Itoa(article.ID)
+}
+
+// articleURL is the article's hosted page, or "" without PUBLIC_BASE_URL.
+func articleURL(article Article) string {
+	return publicURL("articles/" + articleSlug(article))
+}
+
+// articleDescription is the start of the content, cut at a word boundary.
+func articleDescription(content string) string {
+	content = strings.Join(strings.Fields(content), " ")
+	if len(content) <= maxDescriptionLength {
+		return content
+	}
+	cut := strings.LastIndex(content[:maxDescriptionLength], " ")
+	if cut <= 0 {
+		cut = maxDescriptionLength
+	}
+	return strings.TrimRight(content[:cut], " ,.;:") + "…"
+}
+
+// getHostedArticle returns the article a hosted page slug refers to.
+func getHostedArticle(ctx context.Context, db *sql.DB, slug string, now time.Time) (Article, bool, error) {
+	idPart, _, _ := strings.Cut(slug, "-")
+	id, err := strconv.Atoi(idPart)
+	if err != nil {
+		return Article{}, false, nil
+	}
+	article, err := getArticle(ctx, db, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return article, false, nil
+	}
+	if err != nil {
+		return article, false, err
+	}
+	return article, articleIsPublic(article, now), nil
+}
+
+// articleIsPublic reports whether the article has a hosted page.
+func articleIsPublic(article Article, now time.Time) bool {
+	return !article.Premium && !article.scheduledTime().After(now)
+}
+
+// ogImageEnabled reports whether article pages link to a generated
+// og:image (OG_IMAGE_ENABLED).
+func ogImageEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("OG_IMAGE_ENABLED"))
+	return enabled
+}
+
+// ArticlePage is the data article.html is rendered with.
+type ArticlePage struct {
+	SiteName    string
+	Title       string
+	Content     string
+	Description string
+	Byline      string
+	URL         string
+	// ImageURL is the generated image with OG_IMAGE_ENABLED, otherwise
+	// OG_IMAGE_URL, which may be empty.
+	ImageURL    string
+	PublishedAt string
+	// ReadingTime is in minutes.
+	ReadingTime int
+	// TOC and Body are set when the article has a table of contents.
+	TOC  []TOCEntry
+	Body template.HTML
+}
+
+// handleArticlePage renders an article's hosted page with Open Graph and
+// Twitter card tags, so shared links unfurl. Outdated slugs redirect to
+// the current one.
+func handleArticlePage(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		article, ok, err := getHostedArticle(r.Context(), db, r.PathValue("slug"), time.Now())
+		if err != nil {
+			log.Printf("Error getting hosted article: %v", err)
+			http.Error(w, "Error getting article", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if slug := articleSlug(article); r.PathValue("slug") != slug {
+			http.Redirect(w, r, "/articles/"+slug, http.StatusMovedPermanently)
+			return
+		}
+
+		page := ArticlePage{
+			SiteName:    os.Getenv("NEWSLETTER_NAME"),
+			Title:       article.Title,
+			Content:     article.Content,
+			Description: article.Excerpt,
+			ReadingTime: article.ReadingTime,
+			Byline:      authorByline(article.Authors),
+			URL:         articleURL(article),
+			ImageURL:    os.Getenv("OG_IMAGE_URL"),
+		}
+		page.TOC, page.Body = articleTOC(article)
+		if !article.PublishedAt.IsZero() {
+			page.PublishedAt = article.PublishedAt.Format(time.RFC3339)
+		}
+		if ogImageEnabled() && page.URL != "" {
+			page.ImageURL = page.URL + "/og.png"
+		}
+
+		var buf bytes.Buffer
+		if err := renderPage(r.Context(), &buf, "article.html", page); err != nil {
+			log.Printf("Error rendering article page: %v", err)
+			http.Error(w, "Error rendering article", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		if !searchIndexingEnabled() {
+			w.Header().Set("X-Robots-Tag", "noindex")
+		}
+		w.Write(buf.Bytes())
+	}
+}
+
+// Open Graph images are drawn at ogScale times smaller than the
+// 1200x630 size platforms expect, then scaled up, as the built-in font is
+// a small bitmap.
+const (
+	ogWidth  = 1200
+	ogHeight = 630
+	ogScale  = 4
+)
+
+// renderOGImage draws the title, wrapped, and the site name as a PNG.
+func renderOGImage(title, siteName string) ([]byte, error) {
+	small := image.NewRGBA(image.Rect(0, 0, ogWidth/ogScale, ogHeight/ogScale))
+	draw.Draw(small, small.Bounds(), image.NewUniform(color.RGBA{0x24, 0x29, 0x2f, 0xff}), image.Point{}, draw.Src)
+
+	face := basicfont.Face7x13
+	d := &font.Drawer{Dst: small, Src: image.White, Face: face}
+	const margin = 12
+	maxWidth := fixed.I(small.Bounds().Dx() - 2*margin)
+	lineHeight := face.Metrics().Height.Ceil() + 2
+
+	var lines []string
+	for _, word := range strings.Fields(title) {
+		if n := len(lines); n > 0 && d.MeasureString(lines[n-1]+" "+word) <= maxWidth {
+			lines[n-1] += " " + word
+		} else {
+			lines = append(lines, word)
+		}
+	}
+	if maxLines := (small.Bounds().Dy() - 3*margin) / lineHeight; len(lines) > maxLines {
+		lines = append(lines[:maxLines-1], lines[maxLines-1]+"…")
+	}
+	for i, line := range lines {
+		d.Dot = fixed.P(margin, margin+face.Metrics().Ascent.Ceil()+i*lineHeight)
+		d.DrawString(line)
+	}
+	if siteName != "" {
+		d.Src = image.NewUniform(color.RGBA{0x8c, 0x95, 0x9f, 0xff})
+		d.Dot = fixed.P(margin, small.Bounds().Dy()-margin)
+		d.DrawString(siteName)
+	}
+
+	full := image.NewRGBA(image.Rect(0, 0, ogWidth, ogHeight))
+	draw.NearestNeighbor.Scale(full, full.Bounds(), small, small.Bounds(), draw.Src, nil)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, full); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// handleArticleOGImage serves the generated og:image of an article page
+// when OG_IMAGE_ENABLED is set.
+func handleArticleOGImage(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !ogImageEnabled() {
+			http.NotFound(w, r)
+			return
+		}
+		article, ok, err := getHostedArticle(r.Context(), db, r.PathValue("slug"), time.Now())
+		if err != nil {
+			log.Printf("Error getting hosted article: %v", err)
+			http.Error(w, "Error getting article", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		img, err := renderOGImage(article.Title, os.Getenv("NEWSLETTER_NAME"))
+		if err != nil {
+			log.Printf("Error rendering og:image for article %d: %v", article.ID, err)
+			http.Error(w, "Error rendering image", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		w.Write(img)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"image/png"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestArticlePage(t *testing.T) {
+	t.Setenv("OG_IMAGE_ENABLED", "true")
+	t.Setenv("NEWSLETTER_NAME", "Weekly")
+	db := newTestDB(t)
+	srv := newTestServer(t, db, &mockSender{})
+	t.Setenv("PUBLIC_BASE_URL", srv.URL)
+
+	postJSON(t, srv.URL+"/api/publish", `{"title":"Hello, World!","content":"First post & more","scheduled_at":"2000-01-01T00:00:00Z"}`)
+	postJSON(t, srv.URL+"/api/publish", `{"title":"Paid","content":"Secret","premium":true,"scheduled_at":"2999-01-01T00:00:00Z"}`)
+
+	resp, err := http.Get(srv.URL + "/articles/1-old-title")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Request.URL.Path != "/articles/1-hello-world" {
+		t.Fatalf("not redirected to the current slug: %s", resp.Request.URL)
+	}
+	for _, want := range []string{
+		`<meta property="og:title" content="Hello, World!">`,
+		`<meta property="og:description" content="First post &amp; more">`,
+		`<meta property="og:url" content="` + srv.URL + `/articles/1-hello-world">`,
+		`<meta property="og:image" content="` + srv.URL + `/articles/1-hello-world/og.png">`,
+		`<meta property="og:site_name" content="Weekly">`,
+		`<p>1 min read</p>`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("page lacks %s", want)
+		}
+	}
+
+	resp, err = http.Get(srv.URL + "/articles/1-hello-world/og.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != ogWidth || b.Dy() != ogHeight {
+		t.Fatalf("og:image is %v", b)
+	}
+
+	resp, err = http.Get(srv.URL + "/articles/2-paid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("premium article page = %d; want 404", resp.StatusCode)
+	}
+}
+
+func TestArticleDescription(t *testing.T) {
+	long := strings.Repeat("word ", 100)
+	got := articleDescription(long)
+	if len(got) > maxDescriptionLength+len("…") || !strings.HasSuffix(got, "word…") {
+		t.Fatalf("articleDescription = %q", got)
+	}
+	if got := articleDescription("  short\n text "); got != "short text" {
+		t.Fatalf("articleDescription = %q", got)
+	}
+}
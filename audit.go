@@ -0,0 +1,90 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type AuditEntry struct {
+	ID         int             `json:"id"`
+	Actor      string          `json:"actor"`
+	IP         string          `json:"ip"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   int             `json:"target_id"`
+	Diff       json.RawMessage `json:"diff"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// recordAudit stores an admin mutation. diff is marshalled to JSON and
+// should describe the changed fields. Mutations made by background jobs
+// pass a nil request and are attributed to "system".
+func recordAudit(db *sql.DB, r *http.Request, action, targetType string, targetID int, diff interface{}) {
+	data, err := json.Marshal(diff)
+	if err != nil {
+		log.Printf("Error encoding audit diff: %v", err)
+		data = []byte("null")
+	}
+	actor, ip := "system", ""
+	if r != nil {
+		actor, ip = requestActor(r), clientIP(r)
+	}
+	_, err = db.Exec("INSERT INTO audit_log (actor, ip, action, target_type, target_id, diff) VALUES (?, ?, ?, ?, ?, ?)",
+		actor, ip, action, targetType, targetID, string(data))
+	if err != nil {
+		log.Printf("Error recording audit entry: %v", err)
+	}
+}
+
+func getAuditEntries(db *sql.DB, limit int) ([]AuditEntry, error) {
+	rows, err := db.Query("SELECT id, actor, COALESCE(ip, ''), action, target_type, target_id, diff, created_at FROM audit_log ORDER BY id DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var diff string
+		if err := rows.Scan(&e.ID, &e.Actor, &e.IP, &e.Action, &e.TargetType, &e.TargetID, &diff, scanTime(&e.CreatedAt)); err != nil {
+			return nil, err
+		}
+		e.Diff = json.RawMessage(diff)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func handleGetAudit(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		limit := 100
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		entries, err := getAuditEntries(db, limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(entries); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
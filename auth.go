@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+type contextKey string
+
+const principalContextKey contextKey = "principal"
+
+// permission is a capability granted to an API key through its roles.
+type permission string
+
+const (
+	permPublish     permission = "publish"
+	permRead        permission = "read"
+	permSubscribers permission = "subscribers"
+	permAdmin       permission = "admin"
+)
+
+// rolePermissions maps each role that can be assigned to an API key to the
+// permissions it grants.
+var rolePermissions = map[string][]permission{
+	"admin":            {permPublish, permRead, permSubscribers, permAdmin},
+	"publisher":        {permPublish, permRead},
+	"read-only":        {permRead},
+	"subscriber-admin": {permRead, permSubscribers},
+}
+
+// principal is the authenticated caller of an admin request: either an API
+// key or a logged-in admin account. The name identifies the actor in the
+// audit log.
+type principal struct {
+	Name        string
+	Permissions map[permission]bool
+}
+
+func (p principal) can(perm permission) bool {
+	return p.Permissions[perm]
+}
+
+// apiKey is a named credential for the admin API. The secret itself is never
+// stored.
+type apiKey struct {
+	principal
+	Secret string
+}
+
+// loadAPIKeys parses API_KEYS, a comma-separated list of
+// name:secret[:role|role...] entries. Keys without roles are admins. When no
+// keys are configured the admin API is left open.
+func loadAPIKeys() []apiKey {
+	var keys []apiKey
+	for i, entry := range strings.Split(os.Getenv("API_KEYS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			log.Printf("Ignoring malformed API_KEYS entry #%d", i+1)
+			continue
+		}
+		roles := []string{"admin"}
+		if len(parts) == 3 && parts[2] != "" {
+			roles = strings.Split(parts[2], "|")
+		}
+
+		key := apiKey{principal: principal{Name: parts[0], Permissions: map[permission]bool{}}, Secret: parts[1]}
+		for _, role := range roles {
+			perms, ok := rolePermissions[role]
+			if !ok {
+				log.Printf("Ignoring unknown role %q for API key %s", role, key.Name)
+				continue
+			}
+			for _, p := range perms {
+				key.Permissions[p] = true
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// requestAPIKey returns the key presented via X-API-Key or an
+// "Authorization: Bearer" header.
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+	return ""
+}
+
+func findAPIKey(keys []apiKey, secret string) (apiKey, bool) {
+	for _, k := range keys {
+		if subtle.ConstantTimeCompare([]byte(k.Secret), []byte(secret)) == 1 {
+			return k, true
+		}
+	}
+	return apiKey{}, false
+}
+
+// authenticator guards admin routes. Requests authenticate with an API key
+// or an admin session cookie; state-changing session requests must also
+// carry the session's CSRF token.
+type authenticator struct {
+	db   *sql.DB
+	keys []apiKey
+}
+
+// enabled reports whether any credentials are configured. Without API keys
+// or admin accounts the admin API is left open.
+func (a *authenticator) enabled() bool {
+	if len(a.keys) > 0 {
+		return true
+	}
+	n, err := countAdminUsers(a.db)
+	if err != nil {
+		log.Printf("Error counting admin users: %v", err)
+		return true
+	}
+	return n > 0
+}
+
+func (a *authenticator) authenticate(r *http.Request) (principal, int) {
+	if secret := requestAPIKey(r); secret != "" {
+		key, ok := findAPIKey(a.keys, secret)
+		if !ok {
+			return principal{}, http.StatusUnauthorized
+		}
+		return key.principal, 0
+	}
+
+	sess, err := sessionFromRequest(a.db, r)
+	if err != nil {
+		return principal{}, http.StatusUnauthorized
+	}
+	if !isSafeMethod(r.Method) && !validCSRFToken(sess, r) {
+		return principal{}, http.StatusForbidden
+	}
+	return sess.principal(), 0
+}
+
+// require rejects requests that are not authenticated as a principal
+// holding perm and stores the principal in the request context. Requests
+// on a public listener get 404 when ADMIN_LISTEN_ADDRS is set.
+func (a *authenticator) require(perm permission, next http.HandlerFunc) http.HandlerFunc {
+	return adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		if !a.enabled() {
+			next(w, r)
+			return
+		}
+		p, status := a.authenticate(r)
+		if status == http.StatusUnauthorized {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if status != 0 || !p.can(perm) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), principalContextKey, p)))
+	})
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// requestCan reports whether the principal that authenticated r holds perm.
+// Every permission is granted when the admin API is unauthenticated.
+func requestCan(r *http.Request, perm permission) bool {
+	p, ok := r.Context().Value(principalContextKey).(principal)
+	if !ok {
+		return true
+	}
+	return p.can(perm)
+}
+
+// requestActor returns the name of the principal that authenticated r, or
+// "anonymous" when the admin API is unauthenticated.
+func requestActor(r *http.Request) string {
+	if p, ok := r.Context().Value(principalContextKey).(principal); ok {
+		return p.Name
+	}
+	return "anonymous"
+}
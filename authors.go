@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Author is a writer credited on articles. Authors are identified by name,
+// so publishing with a known name reuses its record.
+type Author struct {
+	ID        int    `json:"id,omitempty"`
+	Name      string `json:"name"`
+	AvatarURL string `json:"avatar_url,omitempty"`
+	BioURL    string `json:"bio_url,omitempty"`
+}
+
+func (a Author) validate() error {
+	if strings.TrimSpace(a.Name) == "" || strings.ContainsAny(a.Name, "\r\n") {
+		return errors.New("author name must be a single non-empty line")
+	}
+	for field, v := range map[string]string{"avatar_url": a.AvatarURL, "bio_url": a.BioURL} {
+		if v == "" {
+			continue
+		}
+		if u, err := url.Parse(v); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid author %s: must be an http(s) URL", field)
+		}
+	}
+	return nil
+}
+
+// queryer is an execer that can also read back, such as *sql.DB or *sql.Tx.
+type queryer interface {
+	execer
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// saveAuthor creates the author or, if one with the name exists, updates
+// the details given. It returns the author's id.
+func saveAuthor(db queryer, a Author) (int, error) {
+	var id int
+	err := db.QueryRow(`
+		INSERT INTO authors (name, avatar_url, bio_url) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET
+			avatar_url = CASE WHEN excluded.avatar_url != '' THEN excluded.avatar_url ELSE avatar_url END,
+			bio_url = CASE WHEN excluded.bio_url != '' THEN excluded.bio_url ELSE bio_url END
+		RETURNING id`,
+		strings.TrimSpace(a.Name), a.AvatarURL, a.BioURL).Scan(&id)
+	return id, err
+}
+
+// setArticleAuthors credits authors on an article, in the order given.
+func setArticleAuthors(db queryer, articleID int, authors []Author) error {
+	for i, a := range authors {
+		id, err := saveAuthor(db, a)
+		if err != nil {
+			return fmt.Errorf("saving author %q: %w", a.Name, err)
+		}
+		if _, err := db.Exec("INSERT OR IGNORE INTO article_authors (article_id, author_id, position) VALUES (?, ?, ?)", articleID, id, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getArticleAuthors returns the authors credited on an article.
+func getArticleAuthors(ctx context.Context, db *sql.DB, articleID int) ([]Author, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT a.id, a.name, a.avatar_url, a.bio_url
+		FROM article_authors aa JOIN authors a ON a.id = aa.author_id
+		WHERE aa.article_id = ?
+		ORDER BY aa.position`, articleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var authors []Author
+	for rows.Next() {
+		var a Author
+		if err := rows.Scan(&a.ID, &a.Name, &a.AvatarURL, &a.BioURL); err != nil {
+			return nil, err
+		}
+		authors = append(authors, a)
+	}
+	return authors, rows.Err()
+}
+
+// authorByline joins author names for display: "Ada", "Ada and Grace",
+// "Ada, Grace and Linus".
+func authorByline(authors []Author) string {
+	names := make([]string, len(authors))
+	for i, a := range authors {
+		names[i] = a.Name
+	}
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
+
+// handleAuthors lists authors (GET) or creates or updates one by name
+// (POST).
+func handleAuthors(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			rows, err := db.Query("SELECT id, name, avatar_url, bio_url FROM authors ORDER BY name")
+			if err != nil {
+				http.Error(w, "Error listing authors", http.StatusInternalServerError)
+				return
+			}
+			defer rows.Close()
+			authors := []Author{}
+			for rows.Next() {
+				var a Author
+				if err := rows.Scan(&a.ID, &a.Name, &a.AvatarURL, &a.BioURL); err != nil {
+					http.Error(w, "Error listing authors", http.StatusInternalServerError)
+					return
+				}
+				authors = append(authors, a)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(authors)
+
+		case http.MethodPost:
+			var a Author
+			if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := a.validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			id, err := saveAuthor(db, a)
+			if err != nil {
+				log.Printf("Error saving author: %v", err)
+				http.Error(w, "Error saving author", http.StatusInternalServerError)
+				return
+			}
+			a.ID = id
+			recordAudit(db, r, "save", "author", id, a)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(a)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestArticleAuthors(t *testing.T) {
+	db := newTestDB(t)
+	srv := newTestServer(t, db, &mockSender{})
+
+	postJSON(t, srv.URL+"/api/authors", `{"name":"Ada","bio_url":"https://example.com/ada"}`)
+	postJSON(t, srv.URL+"/api/publish", `{"title":"Hello","content":"Hi","scheduled_at":"2999-01-01T00:00:00Z",
+		"authors":[{"name":"Grace","avatar_url":"https://example.com/grace.png"},{"name":"Ada"}]}`)
+
+	article, err := getArticle(context.Background(), db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(article.Authors) != 2 || article.Authors[0].Name != "Grace" || article.Authors[1].BioURL != "https://example.com/ada" {
+		t.Fatalf("authors = %+v", article.Authors)
+	}
+
+	body, err := renderNewsletterBody(Subscriber{}, article)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body, `<img src="https://example.com/grace.png"`) || !strings.Contains(body, `<a href="https://example.com/ada">Ada</a>`) {
+		t.Fatalf("body does not credit the authors:\n%s", body)
+	}
+}
+
+func TestAuthorByline(t *testing.T) {
+	for want, names := range map[string][]string{
+		"":                     nil,
+		"Ada":                  {"Ada"},
+		"Ada and Grace":        {"Ada", "Grace"},
+		"Ada, Grace and Linus": {"Ada", "Grace", "Linus"},
+	} {
+		var authors []Author
+		for _, n := range names {
+			authors = append(authors, Author{Name: n})
+		}
+		if got := authorByline(authors); got != want {
+			t.Errorf("authorByline(%v) = %q; want %q", names, got, want)
+		}
+	}
+}
+
+func TestAuthorValidation(t *testing.T) {
+	if err := validateArticleOverrides(Article{Authors: []Author{{Name: "Ada", BioURL: "javascript:alert(1)"}}}); err == nil {
+		t.Fatal("javascript: bio_url accepted")
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// badgeEnabled reports whether the public subscriber badge is switched on
+// with BADGE_ENABLED. It is off by default so counts are not published
+// without the owner opting in.
+func badgeEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("BADGE_ENABLED"))
+	return enabled
+}
+
+// activeSubscriberCount returns the number of active subscribers. The
+// count is cached for CACHE_TTL and invalidated on subscribe and
+// unsubscribe.
+func activeSubscriberCount(db *sql.DB) (int, error) {
+	if n, ok := subscriberCountCache.get(db); ok {
+		return n, nil
+	}
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM subscribers WHERE unsubscribed_at IS NULL AND deleted_at IS NULL").Scan(&n)
+	if err == nil {
+		subscriberCountCache.set(db, n)
+	}
+	return n, err
+}
+
+// formatCount abbreviates n the way Shields.io badges do: 950, 1.2k, 3.4M.
+func formatCount(n int) string {
+	switch {
+	case n < 1000:
+		return strconv.Itoa(n)
+	case n < 999950:
+		return strings.TrimSuffix(strconv.FormatFloat(float64(n)/1000, 'f', 1, 64), ".0") + "k"
+	default:
+		return strings.TrimSuffix(strconv.FormatFloat(float64(n)/1000000, 'f', 1, 64), ".0") + "M"
+	}
+}
+
+// badgeTextWidth approximates the rendered width of s in 11px Verdana.
+func badgeTextWidth(s string) int {
+	return len(s)*7 + 10
+}
+
+// renderBadge draws a flat two-part badge.
+func renderBadge(label, message, color string) string {
+	lw, mw := badgeTextWidth(label), badgeTextWidth(message)
+	label, message = html.EscapeString(label), html.EscapeString(message)
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[4]s: %[5]s">`+
+		`<title>%[4]s: %[5]s</title>`+
+		`<rect width="%[2]d" height="20" fill="#555"/><rect x="%[2]d" width="%[3]d" height="20" fill="%[6]s"/>`+
+		`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">`+
+		`<text x="%[7]d" y="14">%[4]s</text><text x="%[8]d" y="14">%[5]s</text></g></svg>`,
+		lw+mw, lw, mw, label, message, color, lw/2, lw+mw/2)
+}
+
+// handleSubscriberBadge serves the live subscriber count as a Shields.io
+// endpoint badge (JSON) or, with svg set, as an SVG image. The label is
+// BADGE_LABEL, "readers" by default.
+func handleSubscriberBadge(db *sql.DB, svg bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !badgeEnabled() {
+			http.NotFound(w, r)
+			return
+		}
+
+		n, err := activeSubscriberCount(db)
+		if err != nil {
+			log.Printf("Error counting subscribers for badge: %v", err)
+			http.Error(w, "Error counting subscribers", http.StatusInternalServerError)
+			return
+		}
+		label := os.Getenv("BADGE_LABEL")
+		if label == "" {
+			label = "readers"
+		}
+
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		if svg {
+			w.Header().Set("Content-Type", "image/svg+xml")
+			w.Write([]byte(renderBadge(label, formatCount(n), "#007ec6")))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"schemaVersion": 1,
+			"label":         label,
+			"message":       formatCount(n),
+			"color":         "blue",
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestFormatCount(t *testing.T) {
+	tests := map[int]string{
+		0:       "0",
+		950:     "950",
+		1000:    "1k",
+		1249:    "1.2k",
+		999949:  "999.9k",
+		999950:  "1M",
+		3400000: "3.4M",
+	}
+	for n, want := range tests {
+		if got := formatCount(n); got != want {
+			t.Errorf("formatCount(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
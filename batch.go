@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// maxBatchArticles caps how many articles one batch request may publish.
+const maxBatchArticles = 500
+
+// BatchPublishResult is the outcome of one article in a batch publish.
+type BatchPublishResult struct {
+	Index int    `json:"index"`
+	ID    int    `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// handleBatchPublish publishes several articles in one request. Each
+// article is validated and stored independently, so one bad item does not
+// fail the rest. With suppress_send set no newsletters are sent. With
+// mark_sent set each article is also recorded as already sent to every
+// current subscriber, which is what an archive import wants.
+func handleBatchPublish(db *sql.DB, sender EmailSender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req struct {
+			Articles     []Article `json:"articles"`
+			SuppressSend bool      `json:"suppress_send"`
+			MarkSent     bool      `json:"mark_sent"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(req.Articles) == 0 {
+			http.Error(w, "No articles given", http.StatusBadRequest)
+			return
+		}
+		if len(req.Articles) > maxBatchArticles {
+			http.Error(w, "Too many articles in one batch", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		results := make([]BatchPublishResult, len(req.Articles))
+		for i, article := range req.Articles {
+			results[i].Index = i
+			if err := validateArticleOverrides(article); err != nil {
+				results[i].Error = err.Error()
+				continue
+			}
+			id, err := insertArticle(db, r, article)
+			if err != nil {
+				log.Printf("Error publishing batch article %d: %v", i, err)
+				results[i].Error = "Error publishing article"
+				continue
+			}
+			results[i].ID = id
+			if req.MarkSent {
+				if _, err := markArticleSent(db, id); err != nil {
+					log.Printf("Error marking batch article %d sent: %v", id, err)
+					results[i].Error = "Published but not marked sent"
+				}
+				continue
+			}
+			if !req.SuppressSend && sendsOnPublish(article, time.Now()) {
+				goSendNewsletter(context.WithoutCancel(r.Context()), db, sender, id)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	}
+}
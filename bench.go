@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"gopkg.in/gomail.v2"
+)
+
+// runBench runs the full send pipeline against the mock sender with
+// synthetic subscribers and reports throughput and per-stage latencies.
+// Stages are the pipeline's trace spans.
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	subscribers := fs.Int("subscribers", 1000, "number of synthetic subscribers")
+	latency := fs.Duration("send-latency", 0, "simulated provider latency per message")
+	dbPath := fs.String("db", "", "database file to use (default a temporary one)")
+	quiet := fs.Bool("quiet", true, "suppress pipeline logging")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *dbPath == "" {
+		dir, err := os.MkdirTemp("", "blog-emailing-bench")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		*dbPath = filepath.Join(dir, "bench.db")
+	}
+	db := openDB(*dbPath)
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < *subscribers; i++ {
+		if _, err := tx.Exec("INSERT INTO subscribers (email, name) VALUES (?, ?)", fmt.Sprintf("bench%d@example.com", i), fmt.Sprintf("Bench %d", i)); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	result, err := tx.Exec("INSERT INTO articles (title, content) VALUES ('Benchmark', 'Benchmark issue')")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	articleID, _ := result.LastInsertId()
+
+	stages := &stageRecorder{durations: map[string][]time.Duration{}}
+	if tp, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider); ok {
+		tp.RegisterSpanProcessor(stages)
+	} else {
+		tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(stages))
+		defer tp.Shutdown(context.Background())
+		otel.SetTracerProvider(tp)
+	}
+
+	if *quiet {
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+	sender := &latencySender{next: newMockSender(""), latency: *latency}
+	start := time.Now()
+	sendNewsletterForArticle(context.Background(), db, sender, int(articleID))
+	elapsed := time.Since(start)
+	log.SetOutput(os.Stderr)
+
+	var status, report string
+	var sent, failed int
+	if err := db.QueryRow("SELECT status, sent, failed, COALESCE(report, '') FROM newsletter_jobs ORDER BY id DESC LIMIT 1").Scan(&status, &sent, &failed, &report); err != nil {
+		return err
+	}
+	fmt.Printf("subscribers %d, job %s, sent %d, failed %d\n", *subscribers, status, sent, failed)
+	if status != jobCompleted {
+		fmt.Printf("report: %s\n", report)
+	}
+	fmt.Printf("elapsed %s, throughput %.1f emails/s\n\n", elapsed.Round(time.Millisecond), float64(sent)/elapsed.Seconds())
+	stages.report(os.Stdout)
+	return nil
+}
+
+// latencySender delays each send to simulate a provider round trip.
+type latencySender struct {
+	next    EmailSender
+	latency time.Duration
+}
+
+func (s *latencySender) Send(ctx context.Context, m *gomail.Message) (string, error) {
+	time.Sleep(s.latency)
+	return s.next.Send(ctx, m)
+}
+
+// stageRecorder is a span processor that collects span durations by name.
+type stageRecorder struct {
+	mu        sync.Mutex
+	durations map[string][]time.Duration
+}
+
+func (r *stageRecorder) OnStart(context.Context, sdktrace.ReadWriteSpan) {}
+
+func (r *stageRecorder) OnEnd(s sdktrace.ReadOnlySpan) {
+	r.mu.Lock()
+	r.durations[s.Name()] = append(r.durations[s.Name()], s.EndTime().Sub(s.StartTime()))
+	r.mu.Unlock()
+}
+
+func (r *stageRecorder) Shutdown(context.Context) error   { return nil }
+func (r *stageRecorder) ForceFlush(context.Context) error { return nil }
+
+// report prints count, total and latency percentiles for every stage.
+func (r *stageRecorder) report(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.durations))
+	for name := range r.durations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(w, "%-22s %8s %12s %10s %10s %10s\n", "stage", "count", "total", "p50", "p95", "max")
+	for _, name := range names {
+		d := r.durations[name]
+		sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+		var total time.Duration
+		for _, x := range d {
+			total += x
+		}
+		fmt.Fprintf(w, "%-22s %8d %12s %10s %10s %10s\n", name, len(d),
+			total.Round(time.Microsecond), percentile(d, 0.50), percentile(d, 0.95), d[len(d)-1].Round(time.Microsecond))
+	}
+}
+
+// percentile returns the p-th percentile of sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	return sorted[int(p*float64(len(sorted)-1))].Round(time.Microsecond)
+}
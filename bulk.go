@@ -0,0 +1,162 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxBulkSubscribers caps how many subscribers one bulk request may touch.
+const maxBulkSubscribers = 1000
+
+// BulkResult is the outcome for one subscriber in a bulk operation. Status
+// is "updated", "unchanged" (already in the requested state) or
+// "not_found".
+type BulkResult struct {
+	Target string `json:"target"`
+	ID     int    `json:"id,omitempty"`
+	Status string `json:"status"`
+}
+
+// bulkActions apply one bulk action to a subscriber inside the request's
+// transaction and report whether anything changed.
+var bulkActions = map[string]func(tx *sql.Tx, r *http.Request, id int, tag string) (bool, error){
+	"unsubscribe": func(tx *sql.Tx, r *http.Request, id int, _ string) (bool, error) {
+		result, err := tx.Exec("UPDATE subscribers SET unsubscribed_at = CURRENT_TIMESTAMP WHERE id = ? AND unsubscribed_at IS NULL", id)
+		if err != nil {
+			return false, err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return false, nil
+		}
+		if err := recordConsent(tx, r, id, consentActionUnsubscribe, ""); err != nil {
+			return false, err
+		}
+		recordEvent(tx, id, eventUnsubscribed, 0, eventDetail(map[string]string{"via": "bulk"}))
+		return true, nil
+	},
+	"delete": func(tx *sql.Tx, _ *http.Request, id int, _ string) (bool, error) {
+		result, err := tx.Exec("UPDATE subscribers SET deleted_at = CURRENT_TIMESTAMP WHERE id = ? AND deleted_at IS NULL", id)
+		if err != nil {
+			return false, err
+		}
+		n, _ := result.RowsAffected()
+		return n > 0, nil
+	},
+	"tag": func(tx *sql.Tx, _ *http.Request, id int, tag string) (bool, error) {
+		return tagSubscriber(tx, id, tag)
+	},
+	"untag": func(tx *sql.Tx, _ *http.Request, id int, tag string) (bool, error) {
+		return untagSubscriber(tx, id, tag)
+	},
+}
+
+// resolveBulkTarget finds a subscriber that is not deleted by id or email.
+func resolveBulkTarget(tx *sql.Tx, target string) (int, error) {
+	var id int
+	var err error
+	if n, convErr := strconv.Atoi(target); convErr == nil {
+		err = tx.QueryRow("SELECT id FROM subscribers WHERE id = ? AND deleted_at IS NULL", n).Scan(&id)
+	} else {
+		err = tx.QueryRow("SELECT id FROM subscribers WHERE email = ? COLLATE NOCASE AND deleted_at IS NULL", target).Scan(&id)
+	}
+	return id, err
+}
+
+// handleBulkSubscribers applies one action (unsubscribe, delete, tag or
+// untag) to a list of subscriber ids and emails in a single transaction,
+// reporting the outcome per subscriber. Unknown subscribers are reported
+// and skipped; a database error rolls back the whole request.
+func handleBulkSubscribers(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req struct {
+			Action string   `json:"action"`
+			IDs    []int    `json:"ids"`
+			Emails []string `json:"emails"`
+			Tag    string   `json:"tag"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		apply, ok := bulkActions[req.Action]
+		if !ok {
+			http.Error(w, "action must be unsubscribe, delete, tag or untag", http.StatusBadRequest)
+			return
+		}
+		req.Tag = normalizeTag(req.Tag)
+		if (req.Action == "tag" || req.Action == "untag") && req.Tag == "" {
+			http.Error(w, "tag is required", http.StatusBadRequest)
+			return
+		}
+		targets := make([]string, 0, len(req.IDs)+len(req.Emails))
+		for _, id := range req.IDs {
+			targets = append(targets, strconv.Itoa(id))
+		}
+		for _, email := range req.Emails {
+			targets = append(targets, strings.TrimSpace(email))
+		}
+		if len(targets) == 0 {
+			http.Error(w, "No subscribers given", http.StatusBadRequest)
+			return
+		}
+		if len(targets) > maxBulkSubscribers {
+			http.Error(w, "Too many subscribers in one request", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			http.Error(w, "Error updating subscribers", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		results := make([]BulkResult, len(targets))
+		var updated []int
+		for i, target := range targets {
+			results[i] = BulkResult{Target: target, Status: "not_found"}
+			id, err := resolveBulkTarget(tx, target)
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			}
+			if err == nil {
+				results[i].ID = id
+				var changed bool
+				if changed, err = apply(tx, r, id, req.Tag); err == nil {
+					results[i].Status = "unchanged"
+					if changed {
+						results[i].Status = "updated"
+						updated = append(updated, id)
+					}
+				}
+			}
+			if err != nil {
+				log.Printf("Error applying bulk %s to subscriber %s: %v", req.Action, target, err)
+				http.Error(w, "Error updating subscribers", http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Error updating subscribers", http.StatusInternalServerError)
+			return
+		}
+		invalidateSubscriberCount(db)
+		recordAudit(db, r, "bulk_"+req.Action, "subscriber", 0, map[string]interface{}{
+			"ids": updated,
+			"tag": req.Tag,
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	}
+}
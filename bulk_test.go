@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBulkSubscribers(t *testing.T) {
+	db := newTestDB(t)
+	srv := newTestServer(t, db, newMockSender(""))
+	if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES ('ada@example.com', ''), ('grace@example.com', ''), ('alan@example.com', '')"); err != nil {
+		t.Fatal(err)
+	}
+
+	bulk := func(body string) []BulkResult {
+		t.Helper()
+		resp, err := http.Post(srv.URL+"/api/subscribers/bulk", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status %d", resp.StatusCode)
+		}
+		var results []BulkResult
+		if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+			t.Fatal(err)
+		}
+		return results
+	}
+	statuses := func(results []BulkResult) string {
+		var s []string
+		for _, r := range results {
+			s = append(s, r.Status)
+		}
+		return strings.Join(s, ",")
+	}
+
+	if got := statuses(bulk(`{"action":"tag","tag":" VIP ","ids":[1,99],"emails":["Grace@example.com"]}`)); got != "updated,not_found,updated" {
+		t.Errorf("tag: %s", got)
+	}
+	if got := statuses(bulk(`{"action":"tag","tag":"vip","ids":[1]}`)); got != "unchanged" {
+		t.Errorf("tag again: %s", got)
+	}
+	var tagged int
+	db.QueryRow("SELECT COUNT(*) FROM subscriber_tags WHERE tag = 'vip'").Scan(&tagged)
+	if tagged != 2 {
+		t.Errorf("%d subscribers tagged", tagged)
+	}
+
+	if got := statuses(bulk(`{"action":"unsubscribe","ids":[1,2]}`)); got != "updated,updated" {
+		t.Errorf("unsubscribe: %s", got)
+	}
+	if got := statuses(bulk(`{"action":"delete","emails":["alan@example.com"]}`)); got != "updated" {
+		t.Errorf("delete: %s", got)
+	}
+	if n, _ := activeSubscriberCount(db); n != 0 {
+		t.Errorf("%d active subscribers left", n)
+	}
+
+	resp, err := http.Post(srv.URL+"/api/subscribers/bulk", "application/json", strings.NewReader(`{"action":"tag","ids":[1]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("tag without a tag: status %d", resp.StatusCode)
+	}
+}
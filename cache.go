@@ -0,0 +1,148 @@
+package main
+
+import (
+	"database/sql"
+	"html/template"
+	"os"
+	"sync"
+	"time"
+)
+
+// cacheTTL is how long cached reads stay fresh (CACHE_TTL, default 30s).
+// Zero disables the caches.
+func cacheTTL() time.Duration {
+	return getEnvDuration("CACHE_TTL", 30*time.Second)
+}
+
+// ttlCache is a map whose entries expire after cacheTTL. Writers
+// invalidate the entries they change, so the TTL only bounds staleness
+// from writes made outside this process.
+type ttlCache[K comparable, V any] struct {
+	mu      sync.Mutex
+	entries map[K]ttlEntry[V]
+}
+
+type ttlEntry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// maxCacheEntries bounds a cache; expired entries are swept past it.
+const maxCacheEntries = 10000
+
+func (c *ttlCache[K, V]) get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		var zero V
+		return zero, false
+	}
+	return e.value, true
+}
+
+func (c *ttlCache[K, V]) set(key K, value V) {
+	ttl := cacheTTL()
+	if ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[K]ttlEntry[V]{}
+	}
+	now := time.Now()
+	if len(c.entries) >= maxCacheEntries {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	c.entries[key] = ttlEntry[V]{value, now.Add(ttl)}
+}
+
+func (c *ttlCache[K, V]) invalidate(key K) {
+	c.mu.Lock()
+	delete(c.entries, key)
+	c.mu.Unlock()
+}
+
+func (c *ttlCache[K, V]) clear() {
+	c.mu.Lock()
+	c.entries = nil
+	c.mu.Unlock()
+}
+
+// articleKey identifies an article in a particular database.
+type articleKey struct {
+	db *sql.DB
+	id int
+}
+
+var (
+	articleCache         ttlCache[articleKey, Article]
+	subscriberCountCache ttlCache[*sql.DB, int]
+)
+
+// invalidateArticle drops a cached article after it is changed.
+func invalidateArticle(db *sql.DB, id int) {
+	articleCache.invalidate(articleKey{db, id})
+}
+
+// invalidateSubscriberCount drops the cached active-subscriber count after
+// a subscribe or unsubscribe.
+func invalidateSubscriberCount(db *sql.DB) {
+	subscriberCountCache.invalidate(db)
+}
+
+// fileVersion identifies the contents of a file without reading it.
+type fileVersion struct {
+	modified time.Time
+	size     int64
+}
+
+func statFileVersion(path string) (fileVersion, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileVersion{}, false
+	}
+	return fileVersion{info.ModTime(), info.Size()}, true
+}
+
+// cachedEmailTemplate is the parsed email template and the versions of
+// the files it came from. It is reused while none of them has changed, so
+// edited templates are picked up without a TTL.
+var cachedEmailTemplate struct {
+	sync.Mutex
+	t     *template.Template
+	files map[string]fileVersion
+}
+
+// emailTemplateUnchanged reports whether the cached template was parsed
+// from exactly sources, none of which has been modified since. The caller
+// holds cachedEmailTemplate's lock.
+func emailTemplateUnchanged(sources []emailTemplateSource) bool {
+	if cachedEmailTemplate.t == nil || len(cachedEmailTemplate.files) != len(sources) {
+		return false
+	}
+	for _, src := range sources {
+		cached, ok := cachedEmailTemplate.files[src.path]
+		if !ok {
+			return false
+		}
+		if v, ok := statFileVersion(src.path); !ok || v != cached {
+			return false
+		}
+	}
+	return true
+}
+
+// clearCaches empties every cache, e.g. after a configuration reload.
+func clearCaches() {
+	articleCache.clear()
+	subscriberCountCache.clear()
+	cachedEmailTemplate.Lock()
+	cachedEmailTemplate.t = nil
+	cachedEmailTemplate.Unlock()
+}
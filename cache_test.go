@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArticleCacheInvalidatedOnDelete(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := db.Exec("INSERT INTO articles (title, content) VALUES ('Hello', '')"); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := getArticle(ctx, db, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// A write outside the cache is not seen until the entry expires.
+	if _, err := db.Exec("UPDATE articles SET title = 'Changed'"); err != nil {
+		t.Fatal(err)
+	}
+	if a, _ := getArticle(ctx, db, 1); a.Title != "Hello" {
+		t.Fatalf("title = %q; want the cached one", a.Title)
+	}
+
+	if _, err := setDeleted(db, "articles", 1, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getArticle(ctx, db, 1); err == nil {
+		t.Fatal("deleted article still returned")
+	}
+}
+
+func TestEmailTemplateReloadedWhenChanged(t *testing.T) {
+	layout := filepath.Join(t.TempDir(), "layout.html")
+	t.Setenv("EMAIL_LAYOUT", layout)
+	t.Setenv("EMAIL_PARTIALS_DIR", t.TempDir())
+
+	load := func(content string) *template.Template {
+		t.Helper()
+		if err := os.WriteFile(layout, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		tmpl, err := loadEmailTemplate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tmpl
+	}
+	if tmpl := load(`{{define "x"}}one{{end}}`); tmpl.Lookup("x") == nil {
+		t.Fatal("layout not loaded")
+	}
+	if tmpl := load(`{{define "y"}}two, longer{{end}}`); tmpl.Lookup("y") == nil {
+		t.Fatal("changed layout not reparsed")
+	}
+}
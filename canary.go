@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"time"
+)
+
+// canaryRecipients returns how many of pending recipients a first send
+// delivers to before pausing, per CANARY_PERCENT. It returns 0 when
+// canary sends are off or the canary would cover everyone anyway.
+func canaryRecipients(pending int) int {
+	percent, _ := strconv.ParseFloat(os.Getenv("CANARY_PERCENT"), 64)
+	if percent <= 0 || percent >= 100 {
+		return 0
+	}
+	n := int(float64(pending)*percent/100 + 0.999999)
+	if n >= pending {
+		return 0
+	}
+	return max(n, 1)
+}
+
+// shuffleSubscribers puts subscribers in random order, so the canary is a
+// sample of the audience rather than its oldest members.
+func shuffleSubscribers(subscribers []Subscriber) {
+	rand.Shuffle(len(subscribers), func(i, j int) {
+		subscribers[i], subscribers[j] = subscribers[j], subscribers[i]
+	})
+}
+
+// CanaryVerdict records how a canary send was judged.
+type CanaryVerdict struct {
+	Sent            int     `json:"sent"`
+	Bounced         int     `json:"bounced"`
+	Unsubscribed    int     `json:"unsubscribed"`
+	BounceRate      float64 `json:"bounce_rate"`
+	UnsubscribeRate float64 `json:"unsubscribe_rate"`
+	Proceeded       bool    `json:"proceeded"`
+	Reason          string  `json:"reason,omitempty"`
+	EvaluatedAt     string  `json:"evaluated_at"`
+}
+
+// judgeCanary measures an article's sends so far against
+// CANARY_MAX_BOUNCE_RATE (default 0.05) and CANARY_MAX_UNSUBSCRIBE_RATE
+// (default 0.02). Bounces and drops come from delivery webhooks;
+// unsubscribes are those made from the article's emails, which stand in
+// for complaints since providers do not report those here.
+func judgeCanary(ctx context.Context, db *sql.DB, articleID int, now time.Time) (CanaryVerdict, error) {
+	v := CanaryVerdict{EvaluatedAt: now.UTC().Format(sqliteTimeFormat)}
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE delivery_status IN (?, ?))
+		FROM sent_emails
+		WHERE article_id = ? AND delivery_status != ?`,
+		deliveryBounced, deliveryDropped, articleID, deliveryImported).Scan(&v.Sent, &v.Bounced)
+	if err != nil {
+		return v, err
+	}
+	err = db.QueryRowContext(ctx, "SELECT COUNT(DISTINCT subscriber_id) FROM events WHERE article_id = ? AND type = ?",
+		articleID, eventUnsubscribed).Scan(&v.Unsubscribed)
+	if err != nil {
+		return v, err
+	}
+	if v.Sent > 0 {
+		v.BounceRate = float64(v.Bounced) / float64(v.Sent)
+		v.UnsubscribeRate = float64(v.Unsubscribed) / float64(v.Sent)
+	}
+
+	maxBounce := canaryThreshold("CANARY_MAX_BOUNCE_RATE", 0.05)
+	maxUnsubscribe := canaryThreshold("CANARY_MAX_UNSUBSCRIBE_RATE", 0.02)
+	switch {
+	case v.BounceRate > maxBounce:
+		v.Reason = fmt.Sprintf("bounce rate %.1f%% is over %.1f%%", 100*v.BounceRate, 100*maxBounce)
+	case v.UnsubscribeRate > maxUnsubscribe:
+		v.Reason = fmt.Sprintf("unsubscribe rate %.1f%% is over %.1f%%", 100*v.UnsubscribeRate, 100*maxUnsubscribe)
+	default:
+		v.Proceeded = true
+	}
+	return v, nil
+}
+
+func canaryThreshold(name string, fallback float64) float64 {
+	if v, err := strconv.ParseFloat(os.Getenv(name), 64); err == nil {
+		return v
+	}
+	return fallback
+}
+
+// canaryJobs returns the newsletter jobs paused after a canary send whose
+// CANARY_WAIT (default 1h) has passed.
+func canaryJobs(ctx context.Context, db *sql.DB, now time.Time) ([]NewsletterJob, error) {
+	cutoff := now.UTC().Add(-getEnvDuration("CANARY_WAIT", time.Hour)).Format(sqliteTimeFormat)
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+jobColumns+`
+		FROM newsletter_jobs j
+		WHERE j.status = ? AND j.finished_at <= ?
+			AND j.id = (SELECT MAX(id) FROM newsletter_jobs WHERE article_id = j.article_id)
+		ORDER BY j.id`, jobCanary, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []NewsletterJob
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// evaluateCanaries judges every canary whose wait is over. A healthy one
+// is resumed, which sends to everyone who has not yet received the
+// article. An unhealthy one is aborted and the admin alerted; sending the
+// article again through /api/send-newsletter overrides the verdict.
+func evaluateCanaries(ctx context.Context, db *sql.DB, sender EmailSender) error {
+	jobs, err := canaryJobs(ctx, db, time.Now())
+	if err != nil {
+		return fmt.Errorf("finding canary sends: %w", err)
+	}
+	for _, job := range jobs {
+		verdict, err := judgeCanary(ctx, db, job.ArticleID, time.Now())
+		if err != nil {
+			return fmt.Errorf("judging canary for article %d: %w", job.ArticleID, err)
+		}
+		job.Report.Canary = &verdict
+		if !verdict.Proceeded {
+			job.Status = jobAborted
+			finishJob(ctx, db, job)
+			jobLogf(ctx, "Aborted newsletter for article %d after canary: %s", job.ArticleID, verdict.Reason)
+			notifyAdmin(ctx, db, sender, alertJobFailure, fmt.Sprintf("Newsletter for article %d aborted after canary", job.ArticleID),
+				fmt.Sprintf("The canary send of article %d was held back from %d recipients because the %s.\n\nDetails: /api/jobs/%d\n",
+					job.ArticleID, job.Report.CanaryHeld, verdict.Reason, job.ID))
+			continue
+		}
+		job.Status = jobCompleted
+		finishJob(ctx, db, job)
+		jobLogf(ctx, "Canary for article %d passed, sending to the remaining %d recipients", job.ArticleID, job.Report.CanaryHeld)
+		sendNewsletterForArticle(ctx, db, sender, job.ArticleID)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCanaryRecipients(t *testing.T) {
+	t.Setenv("CANARY_PERCENT", "")
+	if n := canaryRecipients(100); n != 0 {
+		t.Errorf("disabled canary = %d", n)
+	}
+	t.Setenv("CANARY_PERCENT", "5")
+	for pending, want := range map[int]int{100: 5, 101: 6, 10: 1, 1: 0} {
+		if got := canaryRecipients(pending); got != want {
+			t.Errorf("canaryRecipients(%d) = %d, want %d", pending, got, want)
+		}
+	}
+}
+
+// sendCanary sends article 1 to half of four subscribers, leaving its job
+// paused for the canary to be judged.
+func sendCanary(t *testing.T) (*sql.DB, *mockSender) {
+	t.Helper()
+	t.Setenv("CANARY_PERCENT", "50")
+	t.Setenv("CANARY_WAIT", "0s")
+	t.Setenv("ADMIN_EMAIL", "admin@example.com")
+	db := newTestDB(t)
+	sender := newMockSender("")
+	if _, err := db.Exec(`INSERT INTO subscribers (email, name) VALUES
+		('a@example.com', ''), ('b@example.com', ''), ('c@example.com', ''), ('d@example.com', '')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO articles (title, content) VALUES ('Hello', 'Body')"); err != nil {
+		t.Fatal(err)
+	}
+	sendNewsletterForArticle(context.Background(), db, sender, 1)
+	job, err := getJob(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != jobCanary || job.Sent != 2 || job.Report.CanaryHeld != 2 {
+		t.Fatalf("canary job = %+v", job)
+	}
+	return db, sender
+}
+
+func TestCanaryProceeds(t *testing.T) {
+	db, sender := sendCanary(t)
+	if err := evaluateCanaries(context.Background(), db, sender); err != nil {
+		t.Fatal(err)
+	}
+	job, err := getJob(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != jobCompleted || job.Report.Canary == nil || !job.Report.Canary.Proceeded {
+		t.Fatalf("judged job = %+v", job)
+	}
+	if n := len(sender.Messages()); n != 4 {
+		t.Fatalf("sent %d messages, want all 4", n)
+	}
+}
+
+func TestCanaryAbortsOnBounces(t *testing.T) {
+	db, sender := sendCanary(t)
+	if _, err := db.Exec("UPDATE sent_emails SET delivery_status = ? WHERE id = (SELECT MIN(id) FROM sent_emails)", deliveryBounced); err != nil {
+		t.Fatal(err)
+	}
+	if err := evaluateCanaries(context.Background(), db, sender); err != nil {
+		t.Fatal(err)
+	}
+	job, err := getJob(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != jobAborted || job.Report.Canary == nil || job.Report.Canary.Bounced != 1 {
+		t.Fatalf("judged job = %+v", job)
+	}
+	msgs := sender.Messages()
+	if len(msgs) != 3 || msgs[2].GetHeader("To")[0] != "admin@example.com" {
+		t.Fatalf("sent %d messages, want 2 canary sends and an admin alert", len(msgs))
+	}
+
+	// An aborted canary is not judged again.
+	if err := evaluateCanaries(context.Background(), db, sender); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(sender.Messages()); n != 3 {
+		t.Fatalf("sent %d messages after abort", n)
+	}
+}
+
+func TestCanaryHoldsBackOtherChannels(t *testing.T) {
+	posts := 0
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts++
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer api.Close()
+	t.Setenv("TELEGRAM_API_URL", api.URL)
+	t.Setenv("TELEGRAM_BOT_TOKEN", "123:abc")
+	t.Setenv("TELEGRAM_CHANNEL", "@weekly")
+
+	db, sender := sendCanary(t)
+	if posts != 0 {
+		t.Fatalf("posted to Telegram %d times while the canary was being judged", posts)
+	}
+	if err := evaluateCanaries(context.Background(), db, sender); err != nil {
+		t.Fatal(err)
+	}
+	if posts != 1 {
+		t.Fatalf("posted to Telegram %d times after the canary passed, want 1", posts)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const channelEmail = "email"
+
+// availableChannels lists the channels a subscriber can choose: email, and
+// push and Telegram when they are configured.
+func availableChannels() []string {
+	channels := []string{channelEmail}
+	if key, _, err := vapidKeys(); err == nil && key != nil {
+		channels = append(channels, channelPush)
+	}
+	if telegramChannel() != "" {
+		channels = append(channels, channelTelegram)
+	}
+	return channels
+}
+
+// channelLabels name the channels in the preference center.
+var channelLabels = map[string]string{
+	channelEmail:    "Email",
+	channelPush:     "Browser notifications",
+	channelTelegram: "Telegram channel",
+}
+
+// parseChannels reads the subscribers.channels column. An empty value
+// means email only.
+func parseChannels(s string) []string {
+	if s == "" {
+		return []string{channelEmail}
+	}
+	return strings.Split(s, ",")
+}
+
+// wants reports whether the subscriber takes newsletters on channel.
+func (s Subscriber) wants(channel string) bool {
+	if len(s.Channels) == 0 {
+		return channel == channelEmail
+	}
+	return slices.Contains(s.Channels, channel)
+}
+
+// setChannels stores a subscriber's channel choice. Every channel must be
+// available and at least one chosen.
+func setChannels(db *sql.DB, subscriberID int, channels []string) error {
+	if len(channels) == 0 {
+		return errors.New("choose at least one channel")
+	}
+	available := availableChannels()
+	for _, c := range channels {
+		if !slices.Contains(available, c) {
+			return errors.New("unknown channel " + c)
+		}
+	}
+	channels = slices.Clone(channels)
+	slices.Sort(channels)
+	channels = slices.Compact(channels)
+	value := strings.Join(channels, ",")
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec("UPDATE subscribers SET channels = ? WHERE id = ? AND channels != ?", value, subscriberID, value)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		recordEvent(tx, subscriberID, eventUpdated, 0, eventDetail(map[string]interface{}{"channels": channels}))
+	}
+	return tx.Commit()
+}
+
+// migratePushOnly moves subscribers who asked for push instead of email,
+// before channel preferences existed, onto the push channel.
+func migratePushOnly(db *sql.DB) {
+	_, err := db.Exec("UPDATE subscribers SET channels = 'push' WHERE id IN (SELECT subscriber_id FROM push_subscriptions WHERE instead_of_email = 1)")
+	if err == nil {
+		_, err = db.Exec("UPDATE push_subscriptions SET instead_of_email = 0 WHERE instead_of_email = 1")
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Channels other than email record each article they deliver in
+// channel_deliveries, one row per recipient, so a resend of the article
+// (after a canary or warm-up deferral) does not notify anyone twice.
+const (
+	channelDeliveryPending = "pending"
+	channelDeliverySent    = "sent"
+	channelDeliveryFailed  = "failed"
+)
+
+// ChannelCounts are a job's deliveries on one channel.
+type ChannelCounts struct {
+	Sent   int `json:"sent"`
+	Failed int `json:"failed"`
+}
+
+// addChannel records a channel's deliveries in the job report, leaving
+// out channels that delivered nothing.
+func (r *JobReport) addChannel(channel string, sent, failed int) {
+	if sent == 0 && failed == 0 {
+		return
+	}
+	if r.Channels == nil {
+		r.Channels = map[string]ChannelCounts{}
+	}
+	r.Channels[channel] = ChannelCounts{Sent: sent, Failed: failed}
+}
+
+// claimChannelDelivery reserves the delivery of an article to a recipient
+// on a channel. It reports false if the delivery is already pending or
+// done; a failed delivery can be claimed again.
+func claimChannelDelivery(ctx context.Context, db *sql.DB, channel string, articleID int, recipient string, subscriberID int) (bool, error) {
+	result, err := db.ExecContext(ctx, `
+		INSERT INTO channel_deliveries (channel, article_id, recipient, subscriber_id, status) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (channel, article_id, recipient) DO UPDATE SET status = excluded.status, error = '', created_at = CURRENT_TIMESTAMP
+		WHERE channel_deliveries.status = ?`,
+		channel, articleID, recipient, nullableID(subscriberID), channelDeliveryPending, channelDeliveryFailed)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// finishChannelDelivery records the outcome of a claimed delivery.
+func finishChannelDelivery(ctx context.Context, db *sql.DB, channel string, articleID int, recipient string, sendErr error) error {
+	status, msg := channelDeliverySent, ""
+	if sendErr != nil {
+		status, msg = channelDeliveryFailed, sendErr.Error()
+	}
+	_, err := db.ExecContext(ctx,
+		"UPDATE channel_deliveries SET status = ?, error = ? WHERE channel = ? AND article_id = ? AND recipient = ?",
+		status, msg, channel, articleID, recipient)
+	return err
+}
+
+// Delivery is one newsletter delivered to a subscriber on one channel.
+// Email deliveries carry the provider's delivery status.
+type Delivery struct {
+	Channel   string    `json:"channel"`
+	ArticleID int       `json:"article_id"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// getDeliveries returns a subscriber's deliveries on every channel, newest
+// first.
+func getDeliveries(db *sql.DB, subscriberID int) ([]Delivery, error) {
+	rows, err := db.Query(`
+		SELECT 'email', article_id, delivery_status, '', sent_at FROM sent_emails WHERE subscriber_id = ?
+		UNION ALL
+		SELECT channel, article_id, status, error, created_at FROM channel_deliveries WHERE subscriber_id = ?
+		ORDER BY 5 DESC, 2 DESC`, subscriberID, subscriberID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := []Delivery{}
+	for rows.Next() {
+		var d Delivery
+		if err := rows.Scan(&d.Channel, &d.ArticleID, &d.Status, &d.Error, scanTime(&d.At)); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func handleSubscriberDeliveries(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid subscriber id", http.StatusBadRequest)
+			return
+		}
+		deliveries, err := getDeliveries(db, id)
+		if err != nil {
+			log.Printf("Error listing deliveries of subscriber %d: %v", id, err)
+			http.Error(w, "Error listing deliveries", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(deliveries)
+	}
+}
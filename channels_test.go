@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestChannelPreferences(t *testing.T) {
+	t.Setenv("TRACKING_SECRET", "secret")
+	vapid, _ := ecdh.P256().GenerateKey(rand.Reader)
+	t.Setenv("VAPID_PRIVATE_KEY", base64.RawURLEncoding.EncodeToString(vapid.Bytes()))
+	t.Setenv("VAPID_PUBLIC_KEY", base64.RawURLEncoding.EncodeToString(vapid.PublicKey().Bytes()))
+	t.Setenv("TELEGRAM_BOT_TOKEN", "123:abc")
+	t.Setenv("TELEGRAM_CHANNEL", "@weekly")
+	telegram := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer telegram.Close()
+	t.Setenv("TELEGRAM_API_URL", telegram.URL)
+	pushes := 0
+	pushService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pushes++
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer pushService.Close()
+
+	db := newTestDB(t)
+	sender := newMockSender("")
+	srv := newTestServer(t, db, sender)
+	t.Setenv("PUBLIC_BASE_URL", srv.URL)
+
+	uaKey, _ := ecdh.P256().GenerateKey(rand.Reader)
+	_, err := db.Exec(`
+		INSERT INTO subscribers (email, name) VALUES ('ada@example.com', 'Ada'), ('grace@example.com', 'Grace'), ('alan@example.com', 'Alan');
+		INSERT INTO push_subscriptions (subscriber_id, endpoint, p256dh, auth) VALUES (3, ?, ?, 'MDEyMzQ1Njc4OWFiY2RlZg')`,
+		pushService.URL, base64.RawURLEncoding.EncodeToString(uaKey.PublicKey().Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	save := func(id int, channels ...string) int {
+		resp, err := http.PostForm(preferencesURL(id, 0), url.Values{"channels": append([]string{""}, channels...)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := save(1); status != http.StatusBadRequest {
+		t.Errorf("saving no channels = %d, want 400", status)
+	}
+	if status := save(1, "fax"); status != http.StatusBadRequest {
+		t.Errorf("saving an unknown channel = %d, want 400", status)
+	}
+	if status := save(2, channelTelegram); status != http.StatusOK {
+		t.Fatalf("saving telegram = %d", status)
+	}
+	if status := save(3, channelEmail, channelPush); status != http.StatusOK {
+		t.Fatalf("saving email and push = %d", status)
+	}
+
+	resp, err := http.Get(preferencesURL(3, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(page), `value="push" checked`) || strings.Contains(string(page), `value="telegram" checked`) {
+		t.Errorf("preference center does not show the saved channels:\n%s", page)
+	}
+
+	if _, err := db.Exec("INSERT INTO articles (title, content) VALUES ('Hello', '')"); err != nil {
+		t.Fatal(err)
+	}
+	sendNewsletterForArticle(context.Background(), db, sender, 1)
+
+	var emailed []string
+	for _, m := range sender.Messages() {
+		emailed = append(emailed, m.GetHeader("To")[0])
+	}
+	if strings.Join(emailed, ",") != "ada@example.com,alan@example.com" {
+		t.Errorf("emailed %v, want ada and alan", emailed)
+	}
+	if pushes != 1 {
+		t.Errorf("pushes = %d, want 1", pushes)
+	}
+
+	resp, err = http.Get(srv.URL + "/api/subscribers/3/deliveries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var deliveries []Delivery
+	if err := json.NewDecoder(resp.Body).Decode(&deliveries); err != nil {
+		t.Fatal(err)
+	}
+	channels := map[string]string{}
+	for _, d := range deliveries {
+		channels[d.Channel] = d.Status
+	}
+	if len(deliveries) != 2 || channels[channelEmail] != deliveryAccepted || channels[channelPush] != channelDeliverySent {
+		t.Errorf("deliveries = %+v", deliveries)
+	}
+}
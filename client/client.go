@@ -0,0 +1,265 @@
+// Package client is a Go client for the blog-emailing HTTP API.
+//
+//	c := client.New("https://news.example.com", os.Getenv("NEWSLETTER_API_KEY"))
+//	id, err := c.Publish(ctx, client.Article{Title: "Hello", Content: "..."})
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client calls the API at BaseURL, authenticating with APIKey.
+type Client struct {
+	BaseURL    string
+	APIKey     string
+	HTTPClient *http.Client
+	// MaxRetries is how many times a failed request is retried. Requests
+	// that may already have taken effect are only retried if they are
+	// safe to repeat; see Client.do.
+	MaxRetries int
+	// RetryWait is the delay before the first retry. It doubles with each
+	// further retry unless the server sends Retry-After.
+	RetryWait time.Duration
+}
+
+// New returns a client for the API at baseURL.
+func New(baseURL, apiKey string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		APIKey:     apiKey,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		MaxRetries: 3,
+		RetryWait:  500 * time.Millisecond,
+	}
+}
+
+// Error is a non-2xx response from the API.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("blog-emailing: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// SubscribeRequest signs an address up for the newsletter.
+type SubscribeRequest struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+	// Source is the referral code the subscriber signed up with.
+	Source string `json:"source,omitempty"`
+	// ConsentVersion is the version of the consent text shown to them.
+	ConsentVersion string `json:"consent_version,omitempty"`
+}
+
+// Article is an article to publish. The optional fields override the
+// service's defaults for this article's newsletter.
+type Article struct {
+	Title         string  `json:"title"`
+	Content       string  `json:"content"`
+	Subject       string  `json:"subject,omitempty"`
+	ReplyTo       string  `json:"reply_to,omitempty"`
+	Series        string  `json:"series,omitempty"`
+	Premium       bool    `json:"premium,omitempty"`
+	MinEngagement float64 `json:"min_engagement,omitempty"`
+	// ScheduledAt delays the newsletter. The zero time sends it now.
+	ScheduledAt   time.Time `json:"-"`
+	EventStart    string    `json:"event_start,omitempty"`
+	EventEnd      string    `json:"event_end,omitempty"`
+	EventLocation string    `json:"event_location,omitempty"`
+	FromName      string    `json:"from_name,omitempty"`
+	Authors       []Author  `json:"authors,omitempty"`
+	// Excerpt summarizes the article. The service derives one from the
+	// content when it is empty.
+	Excerpt string `json:"excerpt,omitempty"`
+	// TOC adds a table of contents built from the content's Markdown
+	// headings.
+	TOC bool `json:"toc,omitempty"`
+}
+
+// Author is a writer credited on an article. Authors are matched by name.
+type Author struct {
+	Name      string `json:"name"`
+	AvatarURL string `json:"avatar_url,omitempty"`
+	BioURL    string `json:"bio_url,omitempty"`
+}
+
+// MarshalJSON encodes ScheduledAt as RFC 3339, omitting it when zero.
+func (a Article) MarshalJSON() ([]byte, error) {
+	type article Article
+	v := struct {
+		article
+		ScheduledAt string `json:"scheduled_at,omitempty"`
+	}{article: article(a)}
+	if !a.ScheduledAt.IsZero() {
+		v.ScheduledAt = a.ScheduledAt.UTC().Format(time.RFC3339)
+	}
+	return json.Marshal(v)
+}
+
+// Job statuses.
+const (
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobBlocked   = "blocked"
+	JobFailed    = "failed"
+	JobDeferred  = "deferred"
+)
+
+// Job is one run of an article's newsletter send.
+type Job struct {
+	ID         int        `json:"id"`
+	ArticleID  int        `json:"article_id"`
+	Status     string     `json:"status"`
+	Sent       int        `json:"sent"`
+	Failed     int        `json:"failed"`
+	Report     JobReport  `json:"report"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// JobReport explains a job's outcome beyond its counts.
+type JobReport struct {
+	Preflight []PreflightResult `json:"preflight,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Deferred  int               `json:"deferred,omitempty"`
+}
+
+// PreflightResult is one pre-send check.
+type PreflightResult struct {
+	Check       string   `json:"check"`
+	Status      string   `json:"status"`
+	Detail      string   `json:"detail,omitempty"`
+	Score       float64  `json:"score,omitempty"`
+	BrokenLinks []string `json:"broken_links,omitempty"`
+}
+
+// Subscribe signs req.Email up.
+func (c *Client) Subscribe(ctx context.Context, req SubscribeRequest) error {
+	return c.do(ctx, http.MethodPost, "/api/subscribe", req, nil, false)
+}
+
+// Publish stores article and, unless it is scheduled, starts sending its
+// newsletter. It returns the article's id. Publish is not retried after
+// failures that may have published the article, so it never publishes
+// twice.
+func (c *Client) Publish(ctx context.Context, article Article) (int, error) {
+	var resp struct {
+		ID int `json:"id"`
+	}
+	err := c.do(ctx, http.MethodPost, "/api/publish", article, &resp, false)
+	return resp.ID, err
+}
+
+// Job returns the send job with the given id.
+func (c *Client) Job(ctx context.Context, id int) (Job, error) {
+	var job Job
+	err := c.do(ctx, http.MethodGet, "/api/jobs/"+strconv.Itoa(id), nil, &job, true)
+	return job, err
+}
+
+// Jobs returns the most recent send jobs for an article, newest first.
+func (c *Client) Jobs(ctx context.Context, articleID int) ([]Job, error) {
+	var jobs []Job
+	err := c.do(ctx, http.MethodGet, "/api/jobs?article_id="+strconv.Itoa(articleID), nil, &jobs, true)
+	return jobs, err
+}
+
+// do sends a request, decoding a JSON response into out. Requests are
+// retried after 429, 502, 503 and 504 responses, which mean the request
+// was not handled. Idempotent requests are also retried after network
+// errors and other 5xx responses.
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}, idempotent bool) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+
+	wait := c.RetryWait
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := c.attempt(ctx, method, path, body, out)
+		if err == nil || attempt >= c.MaxRetries || !retryable(err, idempotent) {
+			return err
+		}
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+func retryable(err error, idempotent bool) bool {
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		return idempotent
+	}
+	switch apiErr.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return idempotent && apiErr.StatusCode >= 500
+}
+
+// attempt makes one request. It returns the server's Retry-After delay
+// along with any error.
+func (c *Client) attempt(ctx context.Context, method, path string, body []byte, out interface{}) (time.Duration, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		var retryAfter time.Duration
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			retryAfter = time.Duration(s) * time.Second
+		}
+		return retryAfter, &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	if out == nil {
+		return 0, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return 0, fmt.Errorf("decoding response: %w", err)
+	}
+	return 0, nil
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPublishSendsArticleAndReturnsID(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/publish" || r.Header.Get("Authorization") != "Bearer key" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var got map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&got)
+		if got["title"] != "Hello" || got["scheduled_at"] != "2026-01-02T03:04:05Z" {
+			http.Error(w, "unexpected body", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]int{"id": 7})
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, "key")
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	id, err := c.Publish(context.Background(), Article{Title: "Hello", ScheduledAt: at})
+	if err != nil || id != 7 {
+		t.Fatalf("Publish = %d, %v", id, err)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			http.Error(w, "busy", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(Job{ID: 1, Status: JobCompleted})
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, "")
+	c.RetryWait = time.Millisecond
+	job, err := c.Job(context.Background(), 1)
+	if err != nil || job.Status != JobCompleted || calls != 3 {
+		t.Fatalf("Job = %+v, %v after %d calls", job, err, calls)
+	}
+
+	// A 500 from publish may mean it was published, so it is not retried.
+	calls = 0
+	_, err = c.Publish(context.Background(), Article{Title: "Hello"})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError || calls != 1 {
+		t.Fatalf("Publish err = %v after %d calls", err, calls)
+	}
+}
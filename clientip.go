@@ -0,0 +1,95 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// trustedProxies are the networks whose X-Forwarded-For and X-Real-IP
+// headers are believed. It is loaded from TRUSTED_PROXIES at startup and
+// again on reload, while requests are being served.
+var trustedProxies struct {
+	sync.RWMutex
+	nets []*net.IPNet
+}
+
+// setTrustedProxies replaces the trusted networks with TRUSTED_PROXIES.
+func setTrustedProxies() {
+	nets := loadTrustedProxies()
+	trustedProxies.Lock()
+	trustedProxies.nets = nets
+	trustedProxies.Unlock()
+}
+
+// loadTrustedProxies parses TRUSTED_PROXIES, a comma-separated list of IP
+// addresses or CIDR ranges.
+func loadTrustedProxies() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("Ignoring invalid TRUSTED_PROXIES entry %q: %v", entry, err)
+			continue
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	trustedProxies.RLock()
+	defer trustedProxies.RUnlock()
+	for _, n := range trustedProxies.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client that made r. Forwarding
+// headers are only honoured when the connection comes from a trusted proxy
+// or over a unix socket, and X-Forwarded-For is walked from the right so a
+// client cannot spoof its address by sending the header itself.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote := net.ParseIP(host)
+	if !fromUnixSocket(r) && (remote == nil || !isTrustedProxy(remote)) {
+		return host
+	}
+
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				break
+			}
+			if !isTrustedProxy(ip) || i == 0 {
+				return ip.String()
+			}
+		}
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return host
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+)
+
+// runCommand runs a command-line subcommand instead of starting the server.
+func runCommand(name string, args []string) error {
+	switch name {
+	case "seed":
+		return runSeed(args)
+	case "anonymize":
+		return runAnonymize(args)
+	case "bench":
+		return runBench(args)
+	case "import":
+		return runImport(args)
+	case "export":
+		return runExport(args)
+	default:
+		return fmt.Errorf("unknown command %q", name)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// getEnvInt returns the integer value of the environment variable key, or
+// def when it is unset or invalid.
+func getEnvInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("Invalid value for %s: %q, using %d", key, v, def)
+		return def
+	}
+	return n
+}
+
+// getEnvDuration returns the duration value of the environment variable key,
+// or def when it is unset or invalid.
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("Invalid value for %s: %q, using %s", key, v, def)
+		return def
+	}
+	return d
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const consentActionSubscribe = "subscribe"
+
+// ConsentRecord is evidence that a subscriber opted in. Source is set
+// when the consent was given somewhere other than a request to this
+// server, such as a Stripe checkout.
+type ConsentRecord struct {
+	ID             int       `json:"id"`
+	SubscriberID   int       `json:"subscriber_id"`
+	Action         string    `json:"action"`
+	Source         string    `json:"source,omitempty"`
+	IP             string    `json:"ip"`
+	UserAgent      string    `json:"user_agent"`
+	ConsentVersion string    `json:"consent_version"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// consentVersion returns the version of the consent text the subscriber
+// agreed to: the one submitted with the form, or CONSENT_VERSION.
+func consentVersion(submitted string) string {
+	if submitted != "" {
+		return submitted
+	}
+	return os.Getenv("CONSENT_VERSION")
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// recordConsent stores the request details of a consent action.
+func recordConsent(db execer, r *http.Request, subscriberID int, action, version string) error {
+	_, err := db.Exec(`
+		INSERT INTO consent_log (subscriber_id, action, ip, user_agent, consent_version)
+		VALUES (?, ?, ?, ?, ?)`,
+		subscriberID, action, clientIP(r), r.UserAgent(), consentVersion(version))
+	return err
+}
+
+// recordStripeConsent stores the opt-in given at a Stripe checkout. The
+// webhook request comes from Stripe rather than the subscriber, so it has
+// no IP address or user agent worth keeping.
+func recordStripeConsent(db execer, subscriberID int) error {
+	_, err := db.Exec(`
+		INSERT INTO consent_log (subscriber_id, action, source, ip, user_agent, consent_version)
+		VALUES (?, ?, 'stripe', '', '', ?)`,
+		subscriberID, consentActionSubscribe, consentVersion(""))
+	return err
+}
+
+func getConsentRecords(db *sql.DB, subscriberID int) ([]ConsentRecord, error) {
+	rows, err := db.Query(`
+		SELECT id, subscriber_id, action, source, ip, user_agent, consent_version, created_at
+		FROM consent_log
+		WHERE subscriber_id = ?
+		ORDER BY id`, subscriberID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []ConsentRecord{}
+	for rows.Next() {
+		var c ConsentRecord
+		if err := rows.Scan(&c.ID, &c.SubscriberID, &c.Action, &c.Source, &c.IP, &c.UserAgent, &c.ConsentVersion, scanTime(&c.CreatedAt)); err != nil {
+			return nil, err
+		}
+		records = append(records, c)
+	}
+	return records, rows.Err()
+}
+
+func handleGetConsent(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid subscriber id", http.StatusBadRequest)
+			return
+		}
+		records, err := getConsentRecords(db, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(records)
+	}
+}
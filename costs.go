@@ -0,0 +1,89 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// sendCostPerEmail is the estimated price of one delivered email
+// (SEND_COST_PER_EMAIL, default 0 for self-hosted SMTP). It is stored with
+// each send, so changing the price does not rewrite past costs.
+func sendCostPerEmail() float64 {
+	cost, _ := strconv.ParseFloat(os.Getenv("SEND_COST_PER_EMAIL"), 64)
+	return max(cost, 0)
+}
+
+// ArticleCost is the estimated delivery cost of one article.
+type ArticleCost struct {
+	ArticleID int     `json:"article_id"`
+	Title     string  `json:"title"`
+	Sends     int     `json:"sends"`
+	Cost      float64 `json:"cost"`
+}
+
+// CostReport totals estimated delivery costs in SEND_COST_CURRENCY
+// (default USD).
+type CostReport struct {
+	Currency string        `json:"currency"`
+	Total    float64       `json:"total"`
+	Articles []ArticleCost `json:"articles"`
+}
+
+// getCostReport sums send costs per article, including archived sends,
+// newest article first. Imported sends were never delivered here and cost
+// nothing.
+func getCostReport(db *sql.DB) (CostReport, error) {
+	report := CostReport{Currency: os.Getenv("SEND_COST_CURRENCY"), Articles: []ArticleCost{}}
+	if report.Currency == "" {
+		report.Currency = "USD"
+	}
+	rows, err := db.Query(`
+		SELECT c.article_id, COALESCE(a.title, ''), SUM(c.sends), SUM(c.cost)
+		FROM (
+			SELECT article_id, COUNT(*) AS sends, SUM(cost) AS cost
+			FROM sent_emails
+			WHERE delivery_status != ?
+			GROUP BY article_id
+			UNION ALL
+			SELECT article_id, sent - imported, cost
+			FROM sent_email_summaries
+		) c
+		LEFT JOIN articles a ON a.id = c.article_id
+		GROUP BY c.article_id
+		HAVING SUM(c.sends) > 0
+		ORDER BY c.article_id DESC`, deliveryImported)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c ArticleCost
+		if err := rows.Scan(&c.ArticleID, &c.Title, &c.Sends, &c.Cost); err != nil {
+			return report, err
+		}
+		report.Total += c.Cost
+		report.Articles = append(report.Articles, c)
+	}
+	return report, rows.Err()
+}
+
+func handleGetCosts(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		report, err := getCostReport(db)
+		if err != nil {
+			log.Printf("Error computing send costs: %v", err)
+			http.Error(w, "Error computing costs", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
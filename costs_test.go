@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCostReport(t *testing.T) {
+	t.Setenv("SENT_EMAILS_ARCHIVE_DIR", t.TempDir())
+	t.Setenv("SEND_COST_PER_EMAIL", "0.25")
+	db := newTestDB(t)
+	sender := newMockSender("")
+
+	if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES ('ada@example.com', ''), ('grace@example.com', '')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO articles (title, content) VALUES ('Old', ''), ('New', '')"); err != nil {
+		t.Fatal(err)
+	}
+	sendNewsletterForArticle(context.Background(), db, sender, 1)
+	if _, err := db.Exec("UPDATE sent_emails SET sent_at = '2020-01-01 00:00:00'"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := archiveSentEmails(db, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The price changes; earlier sends keep the price they were sent at.
+	t.Setenv("SEND_COST_PER_EMAIL", "0.5")
+	sendNewsletterForArticle(context.Background(), db, sender, 2)
+
+	report, err := getCostReport(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Currency != "USD" || len(report.Articles) != 2 {
+		t.Fatalf("report = %+v", report)
+	}
+	newer, older := report.Articles[0], report.Articles[1]
+	if newer.ArticleID != 2 || newer.Sends != 2 || math.Abs(newer.Cost-1) > 1e-9 {
+		t.Errorf("article 2 = %+v", newer)
+	}
+	if older.ArticleID != 1 || older.Sends != 2 || math.Abs(older.Cost-0.5) > 1e-9 {
+		t.Errorf("archived article 1 = %+v", older)
+	}
+	if math.Abs(report.Total-1.5) > 1e-9 {
+		t.Errorf("total = %v, want 1.5", report.Total)
+	}
+}
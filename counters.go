@@ -0,0 +1,124 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// statCounter is a row count kept up to date by triggers, so summaries
+// don't scan the table. cond selects the counted rows, with "row" standing
+// for the NEW or OLD row. columns are the ones cond depends on; without
+// them updates cannot change the count.
+type statCounter struct {
+	name    string
+	table   string
+	cond    string
+	columns []string
+}
+
+var statCounters = []statCounter{
+	{"subscribers", "subscribers", "row.deleted_at IS NULL", []string{"deleted_at"}},
+	{"active_subscribers", "subscribers", "row.unsubscribed_at IS NULL AND row.deleted_at IS NULL", []string{"unsubscribed_at", "deleted_at"}},
+	{"articles", "articles", "row.deleted_at IS NULL", []string{"deleted_at"}},
+	{"sent_emails", "sent_emails", "1", nil},
+}
+
+// initCounters (re)creates the counter triggers and recounts every
+// counter, which also repairs any drift.
+func initCounters(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("CREATE TABLE IF NOT EXISTS stat_counters (name TEXT PRIMARY KEY, value INTEGER NOT NULL)"); err != nil {
+		return err
+	}
+	for _, c := range statCounters {
+		onNew := "(" + strings.ReplaceAll(c.cond, "row.", "NEW.") + ")"
+		onOld := "(" + strings.ReplaceAll(c.cond, "row.", "OLD.") + ")"
+		update := "UPDATE stat_counters SET value = value + %s WHERE name = '" + c.name + "';"
+		stmts := []string{
+			fmt.Sprintf("DROP TRIGGER IF EXISTS counter_%s_insert", c.name),
+			fmt.Sprintf("DROP TRIGGER IF EXISTS counter_%s_delete", c.name),
+			fmt.Sprintf("DROP TRIGGER IF EXISTS counter_%s_update", c.name),
+			fmt.Sprintf("CREATE TRIGGER counter_%s_insert AFTER INSERT ON %s BEGIN "+update+" END", c.name, c.table, onNew),
+			fmt.Sprintf("CREATE TRIGGER counter_%s_delete AFTER DELETE ON %s BEGIN "+update+" END", c.name, c.table, "-"+onOld),
+		}
+		if len(c.columns) > 0 {
+			stmts = append(stmts, fmt.Sprintf("CREATE TRIGGER counter_%s_update AFTER UPDATE OF %s ON %s BEGIN "+update+" END",
+				c.name, strings.Join(c.columns, ", "), c.table, onNew+" - "+onOld))
+		}
+		stmts = append(stmts, fmt.Sprintf("INSERT OR REPLACE INTO stat_counters (name, value) SELECT '%s', COUNT(*) FROM %s WHERE %s",
+			c.name, c.table, strings.ReplaceAll(c.cond, "row.", "")))
+		for _, stmt := range stmts {
+			if _, err := tx.Exec(stmt); err != nil {
+				return fmt.Errorf("counter %s: %w", c.name, err)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// StatsSummary holds headline counts read from the counter cache.
+type StatsSummary struct {
+	Subscribers        int `json:"subscribers"`
+	ActiveSubscribers  int `json:"active_subscribers"`
+	Articles           int `json:"articles"`
+	SentEmails         int `json:"sent_emails"`
+	ArchivedSentEmails int `json:"archived_sent_emails"`
+}
+
+func getStatsSummary(db *sql.DB) (StatsSummary, error) {
+	var s StatsSummary
+	counts := map[string]*int{
+		"subscribers":        &s.Subscribers,
+		"active_subscribers": &s.ActiveSubscribers,
+		"articles":           &s.Articles,
+		"sent_emails":        &s.SentEmails,
+	}
+	rows, err := db.Query("SELECT name, value FROM stat_counters")
+	if err != nil {
+		return s, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var value int
+		if err := rows.Scan(&name, &value); err != nil {
+			return s, err
+		}
+		if p, ok := counts[name]; ok {
+			*p = value
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return s, err
+	}
+	s.ArchivedSentEmails, err = archivedSentEmailCount(db)
+	return s, err
+}
+
+// handleStatsSummary returns headline counts in constant time, unlike
+// /api/stats which loads every row.
+func handleStatsSummary(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		summary, err := getStatsSummary(db)
+		if err != nil {
+			log.Printf("Error reading stats summary: %v", err)
+			http.Error(w, "Error reading stats", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(summary)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestStatCounters(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES ('ada@example.com', ''), ('grace@example.com', ''), ('alan@example.com', '')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO articles (title, content) VALUES ('Hello', '')"); err != nil {
+		t.Fatal(err)
+	}
+	sendNewsletterForArticle(context.Background(), db, newMockSender(""), 1)
+	if _, err := db.Exec("UPDATE subscribers SET unsubscribed_at = CURRENT_TIMESTAMP WHERE id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := setDeleted(db, "subscribers", 2, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE sent_emails SET open_count = 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := StatsSummary{Subscribers: 2, ActiveSubscribers: 1, Articles: 1, SentEmails: 3}
+	got, err := getStatsSummary(db)
+	if err != nil || got != want {
+		t.Fatalf("summary = %+v, %v; want %+v", got, err, want)
+	}
+
+	// A recount agrees with the incremental counts.
+	if err := initCounters(db); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := getStatsSummary(db); got != want {
+		t.Fatalf("after recount = %+v; want %+v", got, want)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// credentialNames are the settings that may be stored encrypted in the
+// database instead of the environment. A stored value takes precedence.
+var credentialNames = map[string]bool{
+	"SMTP_USERNAME":           true,
+	"SMTP_PASSWORD":           true,
+	"DELIVERY_WEBHOOK_SECRET": true,
+	"INBOUND_WEBHOOK_SECRET":  true,
+	"STRIPE_WEBHOOK_SECRET":   true,
+	"TRACKING_SECRET":         true,
+	"TRACKING_KEYS":           true,
+	"ALERT_WEBHOOK_URL":       true,
+	"ALERT_TARGETS":           true,
+	"EVENT_BUS_URL":           true,
+	"VAPID_PRIVATE_KEY":       true,
+	"TELEGRAM_BOT_TOKEN":      true,
+}
+
+// credentialPrefix marks the encryption format of stored values.
+const credentialPrefix = "v1:"
+
+// storedCredentials caches the decrypted stored credentials.
+var storedCredentials = struct {
+	sync.RWMutex
+	m map[string]string
+}{m: map[string]string{}}
+
+// credential returns a stored credential, falling back to the environment.
+func credential(name string) string {
+	storedCredentials.RLock()
+	value, ok := storedCredentials.m[name]
+	storedCredentials.RUnlock()
+	if ok {
+		return value
+	}
+	return os.Getenv(name)
+}
+
+// credentialsCipher returns the AES-256-GCM cipher keyed by
+// CREDENTIALS_KEY, 32 base64-encoded bytes.
+func credentialsCipher() (cipher.AEAD, error) {
+	raw := os.Getenv("CREDENTIALS_KEY")
+	if raw == "" {
+		return nil, errors.New("CREDENTIALS_KEY is not set")
+	}
+	key, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("CREDENTIALS_KEY must be 32 base64-encoded bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptCredential seals value. The name is authenticated with it, so a
+// stored value cannot be moved to another credential.
+func encryptCredential(aead cipher.AEAD, name, value string) (string, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(name))
+	return credentialPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptCredential(aead cipher.AEAD, name, stored string) (string, error) {
+	encoded, ok := strings.CutPrefix(stored, credentialPrefix)
+	if !ok {
+		return "", errors.New("unknown encryption format")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("malformed ciphertext")
+	}
+	value, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(name))
+	if err != nil {
+		return "", errors.New("decryption failed; is CREDENTIALS_KEY correct?")
+	}
+	return string(value), nil
+}
+
+// loadCredentials decrypts the stored credentials into the cache. It fails
+// if any are stored and CREDENTIALS_KEY cannot decrypt them.
+func loadCredentials(db *sql.DB) error {
+	rows, err := db.Query("SELECT name, value FROM credentials")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	m := map[string]string{}
+	var aead cipher.AEAD
+	for rows.Next() {
+		var name, stored string
+		if err := rows.Scan(&name, &stored); err != nil {
+			return err
+		}
+		if aead == nil {
+			if aead, err = credentialsCipher(); err != nil {
+				return fmt.Errorf("loading stored credentials: %w", err)
+			}
+		}
+		if m[name], err = decryptCredential(aead, name, stored); err != nil {
+			return fmt.Errorf("loading credential %s: %w", name, err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	storedCredentials.Lock()
+	storedCredentials.m = m
+	storedCredentials.Unlock()
+	return nil
+}
+
+// setCredential encrypts and stores a credential, or deletes it when value
+// is empty so the environment applies again.
+func setCredential(db *sql.DB, name, value string) error {
+	if value == "" {
+		if _, err := db.Exec("DELETE FROM credentials WHERE name = ?", name); err != nil {
+			return err
+		}
+		storedCredentials.Lock()
+		delete(storedCredentials.m, name)
+		storedCredentials.Unlock()
+		return nil
+	}
+	aead, err := credentialsCipher()
+	if err != nil {
+		return err
+	}
+	stored, err := encryptCredential(aead, name, value)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		INSERT INTO credentials (name, value) VALUES (?, ?)
+		ON CONFLICT (name) DO UPDATE SET value = excluded.value, updated_at = CURRENT_TIMESTAMP`,
+		name, stored)
+	if err != nil {
+		return err
+	}
+	storedCredentials.Lock()
+	storedCredentials.m[name] = value
+	storedCredentials.Unlock()
+	return nil
+}
+
+// CredentialStatus says where a credential comes from. Values are never
+// returned.
+type CredentialStatus struct {
+	Name      string `json:"name"`
+	Source    string `json:"source"`
+	UpdatedAt string `json:"updated_at,omitempty"`
+}
+
+func listCredentials(db *sql.DB) ([]CredentialStatus, error) {
+	updated := map[string]string{}
+	rows, err := db.Query("SELECT name, updated_at FROM credentials")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, at string
+		if err := rows.Scan(&name, &at); err != nil {
+			return nil, err
+		}
+		updated[name] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	list := make([]CredentialStatus, 0, len(credentialNames))
+	for name := range credentialNames {
+		c := CredentialStatus{Name: name, Source: "unset"}
+		if at, ok := updated[name]; ok {
+			c.Source, c.UpdatedAt = "database", at
+		} else if os.Getenv(name) != "" {
+			c.Source = "environment"
+		}
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// handleCredentials lists where each credential comes from (GET) or stores
+// one (POST with {"name": ..., "value": ...}; an empty value deletes it).
+// Changing an SMTP credential rebuilds the sender so the next message uses
+// it.
+func handleCredentials(db *sql.DB, sender EmailSender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var req struct {
+				Name  string `json:"name"`
+				Value string `json:"value"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if !credentialNames[req.Name] {
+				http.Error(w, "Unknown credential", http.StatusBadRequest)
+				return
+			}
+			if req.Name == "TRACKING_KEYS" {
+				if err := validateSigningKeys(req.Value); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			if err := setCredential(db, req.Name, req.Value); err != nil {
+				log.Printf("Error storing credential %s: %v", req.Name, err)
+				http.Error(w, "Error storing credential: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			action := "set"
+			if req.Value == "" {
+				action = "delete"
+			}
+			recordAudit(db, r, action, "credential", 0, map[string]string{"name": req.Name})
+			if rs, ok := sender.(*reloadableSender); ok && strings.HasPrefix(req.Name, "SMTP_") {
+				next, err := newEmailSender(db)
+				if err != nil {
+					log.Printf("Error rebuilding sender: %v", err)
+				} else {
+					rs.set(next)
+				}
+			}
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		list, err := listCredentials(db)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
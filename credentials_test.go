@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCredentialsEncryptedAtRest(t *testing.T) {
+	t.Setenv("CREDENTIALS_KEY", base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))))
+	t.Setenv("INBOUND_WEBHOOK_SECRET", "from-env")
+	db := newTestDB(t)
+	srv := newTestServer(t, db, &mockSender{})
+
+	postJSON(t, srv.URL+"/api/admin/credentials", `{"name":"INBOUND_WEBHOOK_SECRET","value":"s3cret"}`)
+	if got := credential("INBOUND_WEBHOOK_SECRET"); got != "s3cret" {
+		t.Fatalf("credential = %q", got)
+	}
+
+	var stored string
+	if err := db.QueryRow("SELECT value FROM credentials WHERE name = 'INBOUND_WEBHOOK_SECRET'").Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(stored, "s3cret") || !strings.HasPrefix(stored, credentialPrefix) {
+		t.Fatalf("stored value = %q", stored)
+	}
+
+	// Listing never returns values.
+	resp, err := http.Get(srv.URL + "/api/admin/credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []CredentialStatus
+	json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	for _, c := range list {
+		if c.Name == "INBOUND_WEBHOOK_SECRET" && c.Source != "database" {
+			t.Fatalf("source = %q", c.Source)
+		}
+	}
+
+	// A wrong master key refuses to load rather than using garbage.
+	t.Setenv("CREDENTIALS_KEY", base64.StdEncoding.EncodeToString([]byte(strings.Repeat("x", 32))))
+	if err := loadCredentials(db); err == nil {
+		t.Fatal("loaded credentials with the wrong key")
+	}
+	t.Setenv("CREDENTIALS_KEY", base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))))
+	if err := loadCredentials(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// Deleting the stored value falls back to the environment.
+	postJSON(t, srv.URL+"/api/admin/credentials", `{"name":"INBOUND_WEBHOOK_SECRET","value":""}`)
+	if got := credential("INBOUND_WEBHOOK_SECRET"); got != "from-env" {
+		t.Fatalf("credential after delete = %q", got)
+	}
+}
+
+func TestCredentialsRejectUnknownName(t *testing.T) {
+	t.Setenv("CREDENTIALS_KEY", base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))))
+	db := newTestDB(t)
+	srv := newTestServer(t, db, &mockSender{})
+
+	resp, err := http.Post(srv.URL+"/api/admin/credentials", "application/json", strings.NewReader(`{"name":"PATH","value":"x"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed schedule: either "@every <duration>" or a
+// five-field cron expression (minute hour day-of-month month day-of-week)
+// evaluated in UTC.
+type cronSchedule struct {
+	every                         time.Duration
+	minute, hour, dom, month, dow uint64
+	domRestricted, dowRestricted  bool
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// parseCron parses a cron expression. Fields accept *, numbers, ranges
+// (1-5), lists (1,15) and steps (*/10, 0-30/5); day-of-week 7 is Sunday.
+// The descriptors @hourly, @daily, @weekly, @monthly and @yearly are
+// accepted too.
+func parseCron(expr string) (cronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if d, ok := strings.CutPrefix(expr, "@every "); ok {
+		every, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil || every < time.Second {
+			return cronSchedule{}, fmt.Errorf("invalid @every duration %q", d)
+		}
+		return cronSchedule{every: every}, nil
+	}
+	if d, ok := cronDescriptors[expr]; ok {
+		expr = d
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return cronSchedule{}, fmt.Errorf("cron expression %q must have 5 fields", expr)
+	}
+	var s cronSchedule
+	var err error
+	bounds := []struct {
+		dst      *uint64
+		min, max int
+	}{{&s.minute, 0, 59}, {&s.hour, 0, 23}, {&s.dom, 1, 31}, {&s.month, 1, 12}, {&s.dow, 0, 7}}
+	for i, b := range bounds {
+		if *b.dst, err = parseCronField(fields[i], b.min, b.max); err != nil {
+			return cronSchedule{}, fmt.Errorf("cron field %q: %w", fields[i], err)
+		}
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	// As in Vixie cron, a day field starting with "*", such as "*/2", is
+	// not a restriction for dayMatches.
+	s.domRestricted = !strings.HasPrefix(fields[2], "*")
+	s.dowRestricted = !strings.HasPrefix(fields[4], "*")
+	return s, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepStr)
+			}
+			step = n
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			loStr, hiStr, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(loStr); err != nil {
+				return 0, fmt.Errorf("invalid value %q", loStr)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(hiStr); err != nil {
+					return 0, fmt.Errorf("invalid value %q", hiStr)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("out of range %d-%d", min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// next returns the first time after t that the schedule fires, or the zero
+// time if it never does within five years.
+func (s cronSchedule) next(t time.Time) time.Time {
+	if s.every > 0 {
+		return t.Add(s.every)
+	}
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches follows cron: when both day fields are restricted, either may
+// match.
+func (s cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domRestricted && s.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// periodicTask is background work run on a schedule.
+type periodicTask struct {
+	name string
+	// defaultSchedule applies unless an admin stored another one.
+	defaultSchedule string
+	run             func(ctx context.Context) error
+}
+
+// everyEnv builds an "@every" schedule from a legacy interval variable, so
+// existing deployments keep their configured intervals.
+func everyEnv(name string, fallback time.Duration) string {
+	return "@every " + getEnvDuration(name, fallback).String()
+}
+
+// periodicTasks is all the periodic work the service does.
+func periodicTasks(db *sql.DB, sender EmailSender) []periodicTask {
+	monitor := &queueMonitor{}
+	deliverability := &deliverabilityMonitor{}
+	return []periodicTask{
+		{"scheduled-sends", everyEnv("SCHEDULE_INTERVAL", time.Minute), func(ctx context.Context) error {
+			return sendDueNewsletters(ctx, db, sender)
+		}},
+		{"warmup-resume", everyEnv("WARMUP_RESUME_INTERVAL", time.Hour), func(ctx context.Context) error {
+			return resumeDeferredNewsletters(ctx, db, sender)
+		}},
+		{"canary", everyEnv("CANARY_CHECK_INTERVAL", 5*time.Minute), func(ctx context.Context) error {
+			return evaluateCanaries(ctx, db, sender)
+		}},
+		{"queue-monitor", everyEnv("ALERT_CHECK_INTERVAL", 5*time.Minute), func(ctx context.Context) error {
+			return monitor.check(ctx, db, sender)
+		}},
+		{"deliverability-monitor", everyEnv("DELIVERABILITY_CHECK_INTERVAL", 24*time.Hour), func(ctx context.Context) error {
+			return deliverability.check(ctx, db, sender, net.DefaultResolver)
+		}},
+		{"engagement", everyEnv("ENGAGEMENT_INTERVAL", 24*time.Hour), func(ctx context.Context) error {
+			n, err := updateEngagementScores(db, time.Now().UTC())
+			if n > 0 {
+				jobLogf(ctx, "Updated engagement scores for %d subscribers", n)
+			}
+			return err
+		}},
+		{"retention", everyEnv("RETENTION_INTERVAL", 24*time.Hour), func(ctx context.Context) error {
+			return enforceRetention(ctx, db)
+		}},
+		{"snooze", everyEnv("SNOOZE_CHECK_INTERVAL", time.Hour), func(ctx context.Context) error {
+			return endSnoozes(ctx, db, sender, time.Now())
+		}},
+		{"roundup", everyEnv("ROUNDUP_INTERVAL", time.Hour), func(ctx context.Context) error {
+			if !roundupEnabled() {
+				return nil
+			}
+			return sendRoundup(ctx, db, sender)
+		}},
+	}
+}
+
+// taskSchedule is a task's stored configuration.
+type taskSchedule struct {
+	// expression is empty when the task uses its default.
+	expression string
+	enabled    bool
+	lastRun    time.Time
+}
+
+func loadTaskSchedules(db *sql.DB) (map[string]taskSchedule, error) {
+	rows, err := db.Query("SELECT name, COALESCE(expression, ''), enabled, COALESCE(strftime('%Y-%m-%d %H:%M:%S', last_run_at), '') FROM task_schedules")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	schedules := map[string]taskSchedule{}
+	for rows.Next() {
+		var name, lastRun string
+		var s taskSchedule
+		if err := rows.Scan(&name, &s.expression, &s.enabled, &lastRun); err != nil {
+			return nil, err
+		}
+		s.lastRun, _ = time.Parse(sqliteTimeFormat, lastRun)
+		schedules[name] = s
+	}
+	return schedules, rows.Err()
+}
+
+// effective returns the schedule the task runs on and whether it is
+// enabled. Tasks without a stored row run on their default.
+func (t periodicTask) effective(stored map[string]taskSchedule) (string, taskSchedule) {
+	s, ok := stored[t.name]
+	if !ok {
+		s.enabled = true
+	}
+	expr := s.expression
+	if expr == "" {
+		expr = t.defaultSchedule
+	}
+	return expr, s
+}
+
+// nextRun is when the task is next due. A task that has never run on an
+// @every schedule is due at once.
+func nextRun(sched cronSchedule, lastRun, now time.Time) time.Time {
+	if lastRun.IsZero() {
+		if sched.every > 0 {
+			return now
+		}
+		return sched.next(now)
+	}
+	return sched.next(lastRun)
+}
+
+// cronPollInterval caps how long the scheduler sleeps, so edited schedules
+// take effect promptly.
+const cronPollInterval = 30 * time.Second
+
+// scheduler runs periodic tasks when they are due. A task never overlaps
+// itself; a run that outlasts its interval delays the next one.
+type scheduler struct {
+	db    *sql.DB
+	tasks []periodicTask
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+func newScheduler(db *sql.DB, tasks []periodicTask) *scheduler {
+	return &scheduler{db: db, tasks: tasks, stop: make(chan struct{}), done: make(chan struct{})}
+}
+
+// run starts due tasks until shutdown is called.
+func (s *scheduler) run() {
+	defer close(s.done)
+	for {
+		schedulerHeartbeat.beat()
+		wake := s.tick(time.Now().UTC())
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(time.Until(wake)):
+		}
+	}
+}
+
+// shutdown stops the scheduler starting tasks and returns once it has.
+// Tasks already running carry on; they are counted in activeSends. Call it
+// only once run has been started.
+func (s *scheduler) shutdown() {
+	close(s.stop)
+	<-s.done
+}
+
+// tick starts every due task and returns when to check again.
+func (s *scheduler) tick(now time.Time) time.Time {
+	wake := now.Add(cronPollInterval)
+	stored, err := loadTaskSchedules(s.db)
+	if err != nil {
+		log.Printf("Error loading task schedules: %v", err)
+		return wake
+	}
+	for _, t := range s.tasks {
+		expr, st := t.effective(stored)
+		if !st.enabled {
+			continue
+		}
+		sched, err := parseCron(expr)
+		if err != nil {
+			log.Printf("Error parsing schedule of task %s: %v", t.name, err)
+			continue
+		}
+		due := nextRun(sched, st.lastRun, now)
+		if due.After(now) {
+			if !due.IsZero() && due.Before(wake) {
+				wake = due
+			}
+			continue
+		}
+		s.start(t, now)
+	}
+	return wake
+}
+
+func (s *scheduler) start(t periodicTask, now time.Time) {
+	if _, ok := startTask(s.db, t, triggerSchedule); !ok {
+		return
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO task_schedules (name, last_run_at) VALUES (?, ?)
+		ON CONFLICT (name) DO UPDATE SET last_run_at = excluded.last_run_at`,
+		t.name, now.Format(sqliteTimeFormat))
+	if err != nil {
+		log.Printf("Error recording run of task %s: %v", t.name, err)
+	}
+}
+
+// TaskSchedule describes a periodic task in the admin API.
+type TaskSchedule struct {
+	Name     string `json:"name"`
+	Schedule string `json:"schedule"`
+	Default  string `json:"default"`
+	Enabled  bool   `json:"enabled"`
+	LastRun  string `json:"last_run,omitempty"`
+	NextRun  string `json:"next_run,omitempty"`
+}
+
+func listTaskSchedules(db *sql.DB, tasks []periodicTask, now time.Time) ([]TaskSchedule, error) {
+	stored, err := loadTaskSchedules(db)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]TaskSchedule, 0, len(tasks))
+	for _, t := range tasks {
+		expr, st := t.effective(stored)
+		ts := TaskSchedule{Name: t.name, Schedule: expr, Default: t.defaultSchedule, Enabled: st.enabled}
+		if !st.lastRun.IsZero() {
+			ts.LastRun = st.lastRun.Format(time.RFC3339)
+		}
+		if sched, err := parseCron(expr); err == nil && st.enabled {
+			if next := nextRun(sched, st.lastRun, now); !next.IsZero() {
+				ts.NextRun = next.Format(time.RFC3339)
+			}
+		}
+		list = append(list, ts)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// handleTaskSchedules lists the periodic tasks (GET) or changes one (POST
+// with {"name", "schedule", "enabled"}). An empty schedule restores the
+// default.
+func handleTaskSchedules(db *sql.DB, tasks []periodicTask) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var req struct {
+				Name     string `json:"name"`
+				Schedule string `json:"schedule"`
+				Enabled  *bool  `json:"enabled"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			known := false
+			for _, t := range tasks {
+				known = known || t.name == req.Name
+			}
+			if !known {
+				http.Error(w, "Unknown task", http.StatusBadRequest)
+				return
+			}
+			if req.Schedule != "" {
+				if _, err := parseCron(req.Schedule); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			enabled := req.Enabled == nil || *req.Enabled
+			_, err := db.Exec(`
+				INSERT INTO task_schedules (name, expression, enabled) VALUES (?, NULLIF(?, ''), ?)
+				ON CONFLICT (name) DO UPDATE SET expression = excluded.expression, enabled = excluded.enabled`,
+				req.Name, req.Schedule, enabled)
+			if err != nil {
+				log.Printf("Error saving schedule of task %s: %v", req.Name, err)
+				http.Error(w, "Error saving schedule", http.StatusInternalServerError)
+				return
+			}
+			recordAudit(db, r, "update", "task_schedule", 0, map[string]interface{}{
+				"name":     req.Name,
+				"schedule": req.Schedule,
+				"enabled":  enabled,
+			})
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		list, err := listTaskSchedules(db, tasks, time.Now().UTC())
+		if err != nil {
+			log.Printf("Error listing task schedules: %v", err)
+			http.Error(w, "Error listing schedules", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
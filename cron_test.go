@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	from := time.Date(2024, 1, 31, 10, 17, 30, 0, time.UTC) // a Wednesday
+	for _, tc := range []struct {
+		expr string
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2024, 1, 31, 10, 30, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2024, 2, 1, 3, 0, 0, 0, time.UTC)},
+		{"0 9 * * 1-5", time.Date(2024, 2, 1, 9, 0, 0, 0, time.UTC)},
+		{"30 8 * * 7", time.Date(2024, 2, 4, 8, 30, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"@every 90m", from.Add(90 * time.Minute)},
+		// Either day field may match when both are restricted.
+		{"0 0 15 * 5", time.Date(2024, 2, 2, 0, 0, 0, 0, time.UTC)},
+		// A stepped "*" is not a restriction: odd days that are Mondays.
+		{"0 0 */2 * 1", time.Date(2024, 2, 5, 0, 0, 0, 0, time.UTC)},
+	} {
+		s, err := parseCron(tc.expr)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", tc.expr, err)
+		}
+		if got := s.next(from); !got.Equal(tc.want) {
+			t.Errorf("%q next = %v, want %v", tc.expr, got, tc.want)
+		}
+	}
+
+	for _, bad := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "@every 1ms", "@sometimes"} {
+		if _, err := parseCron(bad); err == nil {
+			t.Errorf("parseCron(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestSchedulerRunsDueTasks(t *testing.T) {
+	db := newTestDB(t)
+	var runs atomic.Int32
+	done := make(chan struct{}, 1)
+	s := newScheduler(db, []periodicTask{{name: "test", defaultSchedule: "@every 1h", run: func(context.Context) error {
+		runs.Add(1)
+		done <- struct{}{}
+		return nil
+	}}})
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	// A task that never ran on an @every schedule is due at once.
+	if wake := s.tick(now); !wake.After(now) {
+		t.Fatalf("wake = %v", wake)
+	}
+	<-done
+	time.Sleep(10 * time.Millisecond)
+	s.tick(now.Add(30 * time.Minute))
+	s.tick(now.Add(61 * time.Minute))
+	<-done
+	if n := runs.Load(); n != 2 {
+		t.Fatalf("task ran %d times; want 2", n)
+	}
+
+	if _, err := db.Exec("UPDATE task_schedules SET enabled = 0 WHERE name = 'test'"); err != nil {
+		t.Fatal(err)
+	}
+	s.tick(now.Add(5 * time.Hour))
+	time.Sleep(10 * time.Millisecond)
+	if n := runs.Load(); n != 2 {
+		t.Fatalf("disabled task ran")
+	}
+}
+
+func TestSchedulerShutdown(t *testing.T) {
+	db := newTestDB(t)
+	var runs atomic.Int32
+	s := newScheduler(db, []periodicTask{{name: "test", defaultSchedule: "@every 1h", run: func(context.Context) error {
+		runs.Add(1)
+		return nil
+	}}})
+	go s.run()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.shutdown()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not return")
+	}
+	// The task started by the first tick is counted in activeSends, so
+	// waiting on it covers the run.
+	activeSends.Wait()
+	if n := runs.Load(); n != 1 {
+		t.Errorf("runs = %d, want 1", n)
+	}
+}
+
+func TestTaskSchedulesAPI(t *testing.T) {
+	db := newTestDB(t)
+	srv := newTestServer(t, db, &mockSender{})
+
+	resp, err := http.Post(srv.URL+"/api/admin/schedules", "application/json", strings.NewReader(`{"name":"retention","schedule":"61 * * * *"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("invalid schedule = %d", resp.StatusCode)
+	}
+
+	resp, err = http.Post(srv.URL+"/api/admin/schedules", "application/json", strings.NewReader(`{"name":"retention","schedule":"0 4 * * *"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []TaskSchedule
+	json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	found := false
+	for _, ts := range list {
+		if ts.Name == "retention" {
+			found = true
+			if ts.Schedule != "0 4 * * *" || ts.Default != "@every 24h0m0s" || !ts.Enabled || ts.NextRun == "" {
+				t.Errorf("retention = %+v", ts)
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("schedules = %+v", list)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+const (
+	deadLetterPending  = "pending"
+	deadLetterResolved = "resolved"
+)
+
+// DeadLetter is a newsletter email that could not be sent after all
+// retries. Errors holds every failed attempt, oldest first.
+type DeadLetter struct {
+	ID           int       `json:"id"`
+	SubscriberID int       `json:"subscriber_id"`
+	ArticleID    int       `json:"article_id"`
+	Attempts     int       `json:"attempts"`
+	Errors       []string  `json:"errors"`
+	Status       string    `json:"status"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// sendWithRetry sends m, retrying up to SEND_MAX_ATTEMPTS times (default 3)
+// with a backoff starting at SEND_RETRY_BACKOFF (default 2s) and doubling.
+// It returns a description of every failed attempt.
+func sendWithRetry(ctx context.Context, sender EmailSender, m *gomail.Message) (string, []string, error) {
+	maxAttempts := max(getEnvInt("SEND_MAX_ATTEMPTS", 3), 1)
+	backoff := getEnvDuration("SEND_RETRY_BACKOFF", 2*time.Second)
+
+	var failures []string
+	for attempt := 1; ; attempt++ {
+		providerID, err := sender.Send(ctx, m)
+		if err == nil {
+			return providerID, failures, nil
+		}
+		failures = append(failures, fmt.Sprintf("%s attempt %d: %v", time.Now().UTC().Format(time.RFC3339), attempt, err))
+		if attempt >= maxAttempts {
+			return "", failures, err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return "", failures, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// recordDeadLetter stores the failed attempts of a send, appending to the
+// existing dead letter for the same subscriber and article if there is one.
+func recordDeadLetter(ctx context.Context, db *sql.DB, subscriberID, articleID int, failures []string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int
+	var raw string
+	err = tx.QueryRowContext(ctx, "SELECT id, errors FROM dead_letters WHERE subscriber_id = ? AND article_id = ?",
+		subscriberID, articleID).Scan(&id, &raw)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		data, _ := json.Marshal(failures)
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO dead_letters (subscriber_id, article_id, attempts, errors)
+			VALUES (?, ?, ?, ?)`, subscriberID, articleID, len(failures), string(data))
+	case err == nil:
+		var history []string
+		json.Unmarshal([]byte(raw), &history)
+		data, _ := json.Marshal(append(history, failures...))
+		_, err = tx.ExecContext(ctx, `
+			UPDATE dead_letters
+			SET attempts = attempts + ?, errors = ?, status = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?`, len(failures), string(data), deadLetterPending, id)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func getDeadLetters(db *sql.DB, status string) ([]DeadLetter, error) {
+	rows, err := db.Query(`
+		SELECT id, subscriber_id, article_id, attempts, errors, status, created_at, updated_at
+		FROM dead_letters
+		WHERE ? = '' OR status = ?
+		ORDER BY id`, status, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	letters := []DeadLetter{}
+	for rows.Next() {
+		var d DeadLetter
+		var raw string
+		if err := rows.Scan(&d.ID, &d.SubscriberID, &d.ArticleID, &d.Attempts, &raw, &d.Status, scanTime(&d.CreatedAt), scanTime(&d.UpdatedAt)); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(raw), &d.Errors); err != nil {
+			return nil, err
+		}
+		letters = append(letters, d)
+	}
+	return letters, rows.Err()
+}
+
+func getActiveSubscriber(ctx context.Context, db *sql.DB, id int) (Subscriber, error) {
+	var s Subscriber
+	err := db.QueryRowContext(ctx, `
+		SELECT id, email, name, tier, tracking_opt_out FROM subscribers
+		WHERE id = ? AND unsubscribed_at IS NULL AND deleted_at IS NULL`, id).Scan(&s.ID, &s.Email, &s.Name, &s.Tier, &s.TrackingOptOut)
+	return s, err
+}
+
+// redriveDeadLetters sends the given pending dead letters again. Letters
+// whose subscriber has since left, or who already received the article, are
+// resolved without sending. Failures are appended to the letter's history.
+func redriveDeadLetters(ctx context.Context, db *sql.DB, sender EmailSender, letters []DeadLetter) {
+	for _, d := range letters {
+		if d.Status != deadLetterPending {
+			continue
+		}
+		sub, err := getActiveSubscriber(ctx, db, d.SubscriberID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error loading subscriber %d for dead letter %d: %v", d.SubscriberID, d.ID, err)
+			continue
+		}
+		if err == nil {
+			article, err := getArticle(ctx, db, d.ArticleID)
+			if err != nil {
+				log.Printf("Error loading article %d for dead letter %d: %v", d.ArticleID, d.ID, err)
+				continue
+			}
+			claimed, err := claimRecipient(ctx, db, sub.ID, article.ID)
+			if err != nil {
+				log.Printf("Error claiming subscriber %d for dead letter %d: %v", sub.ID, d.ID, err)
+				continue
+			}
+			if claimed {
+				messageID, providerID, err := sendEmail(ctx, db, sender, sub, article)
+				if err != nil {
+					releaseRecipient(context.WithoutCancel(ctx), db, sub.ID, article.ID)
+					continue
+				}
+				markEmailSent(ctx, db, sub.ID, article.ID, messageID, providerID)
+			}
+		}
+		if _, err := db.ExecContext(ctx, "UPDATE dead_letters SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+			deadLetterResolved, d.ID); err != nil {
+			log.Printf("Error resolving dead letter %d: %v", d.ID, err)
+		}
+	}
+}
+
+// handleDeadLetters lists dead letters (GET, optionally ?status=pending) or
+// re-drives them (POST with {"ids": [...]}, or an empty body for every
+// pending letter).
+func handleDeadLetters(db *sql.DB, sender EmailSender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			letters, err := getDeadLetters(db, r.URL.Query().Get("status"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(letters)
+
+		case http.MethodPost:
+			var req struct {
+				IDs []int `json:"ids"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			pending, err := getDeadLetters(db, deadLetterPending)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if len(req.IDs) > 0 {
+				wanted := map[int]bool{}
+				for _, id := range req.IDs {
+					wanted[id] = true
+				}
+				selected := pending[:0]
+				for _, d := range pending {
+					if wanted[d.ID] {
+						selected = append(selected, d)
+					}
+				}
+				pending = selected
+			}
+
+			recordAudit(db, r, "redrive", "dead_letter", 0, map[string]interface{}{"ids": req.IDs, "count": len(pending)})
+			go redriveDeadLetters(context.WithoutCancel(r.Context()), db, sender, pending)
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(map[string]int{"redriving": len(pending)})
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
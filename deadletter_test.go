@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+
+	"gopkg.in/gomail.v2"
+)
+
+// flakySender fails every send until it is switched on.
+type flakySender struct {
+	*mockSender
+	mu sync.Mutex
+	up bool
+}
+
+func (s *flakySender) Send(ctx context.Context, m *gomail.Message) (string, error) {
+	s.mu.Lock()
+	up := s.up
+	s.mu.Unlock()
+	if !up {
+		return "", errors.New("connection refused")
+	}
+	return s.mockSender.Send(ctx, m)
+}
+
+func TestDeadLetterRedrive(t *testing.T) {
+	t.Setenv("SEND_MAX_ATTEMPTS", "2")
+	t.Setenv("SEND_RETRY_BACKOFF", "0")
+	db := newTestDB(t)
+	sender := &flakySender{mockSender: newMockSender("")}
+	srv := newTestServer(t, db, sender)
+
+	if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES ('ada@example.com', 'Ada')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO articles (title, content) VALUES ('Hello', '')"); err != nil {
+		t.Fatal(err)
+	}
+	sendNewsletterForArticle(context.Background(), db, sender, 1)
+
+	resp, err := http.Get(srv.URL + "/api/dead-letters?status=pending")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var letters []DeadLetter
+	err = json.NewDecoder(resp.Body).Decode(&letters)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 || letters[0].Attempts != 2 || len(letters[0].Errors) != 2 {
+		t.Fatalf("dead letters = %+v", letters)
+	}
+
+	sender.mu.Lock()
+	sender.up = true
+	sender.mu.Unlock()
+	resp, err = http.Post(srv.URL+"/api/dead-letters", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("redrive: status %d", resp.StatusCode)
+	}
+	waitForMessages(t, sender.mockSender, 1)
+	if n := countSentEmails(t, db, 1); n != 1 {
+		t.Fatalf("sent_emails rows = %d, want 1", n)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+var startTime = time.Now()
+
+func init() {
+	expvar.Publish("runtime", expvar.Func(func() interface{} {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		return map[string]interface{}{
+			"goroutines":     runtime.NumGoroutine(),
+			"uptime_seconds": int64(time.Since(startTime).Seconds()),
+			"heap_alloc":     ms.HeapAlloc,
+			"heap_inuse":     ms.HeapInuse,
+			"heap_objects":   ms.HeapObjects,
+			"sys":            ms.Sys,
+			"num_gc":         ms.NumGC,
+			"pause_total_ns": ms.PauseTotalNs,
+		}
+	}))
+}
+
+// registerDebugHandlers exposes net/http/pprof and expvar on mux, gated
+// behind the admin permission. The packages' own registrations on
+// http.DefaultServeMux are never served.
+func registerDebugHandlers(mux *http.ServeMux, auth *authenticator) {
+	handlers := map[string]http.HandlerFunc{
+		"/debug/pprof/":        pprof.Index,
+		"/debug/pprof/cmdline": pprof.Cmdline,
+		"/debug/pprof/profile": pprof.Profile,
+		"/debug/pprof/symbol":  pprof.Symbol,
+		"/debug/pprof/trace":   pprof.Trace,
+		"/debug/vars":          expvar.Handler().ServeHTTP,
+	}
+	for pattern, h := range handlers {
+		mux.HandleFunc(pattern, requireCredentials(auth, auth.require(permAdmin, h)))
+	}
+}
+
+// requireCredentials hides next unless API keys or admin accounts are
+// configured, so the debug endpoints are never served by an open admin API.
+func requireCredentials(auth *authenticator, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !auth.enabled() {
+			http.NotFound(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// normalizeEmail reduces an address to the mailbox it delivers to: it is
+// lowercased, a +tag is dropped and, for Gmail, dots in the local part are
+// ignored.
+func normalizeEmail(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return email
+	}
+	local, _, _ = strings.Cut(local, "+")
+	if domain == "googlemail.com" {
+		domain = "gmail.com"
+	}
+	if domain == "gmail.com" {
+		local = strings.ReplaceAll(local, ".", "")
+	}
+	return local + "@" + domain
+}
+
+// DuplicateGroup is a set of subscribers that are probably one person.
+// Reason is "email" when their addresses normalize to the same mailbox and
+// "name" when they share a name under different addresses.
+type DuplicateGroup struct {
+	Reason      string       `json:"reason"`
+	Key         string       `json:"key"`
+	Subscribers []Subscriber `json:"subscribers"`
+}
+
+// findDuplicateSubscribers groups subscribers by normalized email and then
+// by name. A pair already grouped by email is not repeated by name.
+func findDuplicateSubscribers(db *sql.DB) ([]DuplicateGroup, error) {
+	subscribers, err := getAllSubscribers(db)
+	if err != nil {
+		return nil, err
+	}
+	byEmail := map[string][]Subscriber{}
+	byName := map[string][]Subscriber{}
+	for _, s := range subscribers {
+		byEmail[normalizeEmail(s.Email)] = append(byEmail[normalizeEmail(s.Email)], s)
+		if name := strings.ToLower(strings.Join(strings.Fields(s.Name), " ")); name != "" {
+			byName[name] = append(byName[name], s)
+		}
+	}
+
+	groups := []DuplicateGroup{}
+	for key, subs := range byEmail {
+		if len(subs) > 1 {
+			groups = append(groups, DuplicateGroup{Reason: "email", Key: key, Subscribers: subs})
+		}
+	}
+	for key, subs := range byName {
+		mailboxes := map[string]bool{}
+		for _, s := range subs {
+			mailboxes[normalizeEmail(s.Email)] = true
+		}
+		if len(mailboxes) > 1 {
+			groups = append(groups, DuplicateGroup{Reason: "name", Key: key, Subscribers: subs})
+		}
+	}
+	for _, g := range groups {
+		sort.Slice(g.Subscribers, func(i, j int) bool { return g.Subscribers[i].ID < g.Subscribers[j].ID })
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Reason != groups[j].Reason {
+			return groups[i].Reason == "email"
+		}
+		return groups[i].Key < groups[j].Key
+	})
+	return groups, nil
+}
+
+// mergeSubscribers folds subscriber from into keep and deletes from.
+// Events, notes, replies, consent records, referrals and push
+// subscriptions move to keep; for sends, tags, poll responses and dead
+// letters keep's own row wins where both have one. keep takes from's name
+// if it has none, the earlier signup date and the premium tier if either
+// had it. Its address and subscription status are unchanged.
+func mergeSubscribers(db *sql.DB, keep, from int) error {
+	if keep == from {
+		return errors.New("cannot merge a subscriber into itself")
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	emails := map[int]string{}
+	for _, id := range []int{keep, from} {
+		var email string
+		err := tx.QueryRow("SELECT email FROM subscribers WHERE id = ? AND deleted_at IS NULL", id).Scan(&email)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("subscriber %d: %w", id, err)
+		}
+		if err != nil {
+			return err
+		}
+		emails[id] = email
+	}
+
+	for _, q := range []string{
+		"UPDATE OR IGNORE sent_emails SET subscriber_id = ? WHERE subscriber_id = ?",
+		"UPDATE events SET subscriber_id = ? WHERE subscriber_id = ?",
+		"UPDATE subscriber_notes SET subscriber_id = ? WHERE subscriber_id = ?",
+		"UPDATE replies SET subscriber_id = ? WHERE subscriber_id = ?",
+		"UPDATE consent_log SET subscriber_id = ? WHERE subscriber_id = ?",
+		"UPDATE subscribers SET referred_by = ? WHERE referred_by = ?",
+		"UPDATE push_subscriptions SET subscriber_id = ? WHERE subscriber_id = ?",
+		"UPDATE channel_deliveries SET subscriber_id = ? WHERE subscriber_id = ?",
+		"UPDATE OR IGNORE subscriber_tags SET subscriber_id = ? WHERE subscriber_id = ?",
+		"UPDATE OR IGNORE poll_responses SET subscriber_id = ? WHERE subscriber_id = ?",
+		"UPDATE OR IGNORE dead_letters SET subscriber_id = ? WHERE subscriber_id = ?",
+	} {
+		if _, err := tx.Exec(q, keep, from); err != nil {
+			return fmt.Errorf("merging subscriber history: %w", err)
+		}
+	}
+	for _, table := range []string{"sent_emails", "subscriber_tags", "poll_responses", "dead_letters"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE subscriber_id = ?", from); err != nil {
+			return fmt.Errorf("merging %s: %w", table, err)
+		}
+	}
+	_, err = tx.Exec(`
+		UPDATE subscribers SET
+			name = CASE WHEN COALESCE(subscribers.name, '') = '' THEN f.from_name ELSE subscribers.name END,
+			subscribed_at = MIN(subscribers.subscribed_at, f.from_subscribed_at),
+			tier = CASE WHEN f.from_tier = ? THEN f.from_tier ELSE subscribers.tier END,
+			stripe_customer_id = COALESCE(subscribers.stripe_customer_id, f.from_stripe_customer_id)
+		FROM (
+			SELECT name AS from_name, subscribed_at AS from_subscribed_at, tier AS from_tier,
+				stripe_customer_id AS from_stripe_customer_id
+			FROM subscribers WHERE id = ?
+		) AS f
+		WHERE subscribers.id = ?`, tierPremium, from, keep)
+	if err != nil {
+		return fmt.Errorf("merging subscriber fields: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM subscribers WHERE id = ?", from); err != nil {
+		return err
+	}
+	recordEvent(tx, keep, eventUpdated, 0, eventDetail(map[string]interface{}{"merged_from": from, "email": emails[from]}))
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	invalidateSubscriberCount(db)
+	return nil
+}
+
+func handleFindDuplicates(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		groups, err := findDuplicateSubscribers(db)
+		if err != nil {
+			log.Printf("Error finding duplicate subscribers: %v", err)
+			http.Error(w, "Error finding duplicates", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(groups)
+	}
+}
+
+// handleMergeSubscribers merges {"merge": id} into {"keep": id}.
+func handleMergeSubscribers(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req struct {
+			Keep  int `json:"keep"`
+			Merge int `json:"merge"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Keep == 0 || req.Merge == 0 || req.Keep == req.Merge {
+			http.Error(w, "keep and merge must be two different subscriber ids", http.StatusBadRequest)
+			return
+		}
+		err := mergeSubscribers(db, req.Keep, req.Merge)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Subscriber not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error merging subscriber %d into %d: %v", req.Merge, req.Keep, err)
+			http.Error(w, "Error merging subscribers", http.StatusInternalServerError)
+			return
+		}
+		recordAudit(db, r, "merge", "subscriber", req.Keep, map[string]int{"merged": req.Merge})
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}
+}
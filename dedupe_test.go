@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := map[string]string{
+		"Ada@Example.com":        "ada@example.com",
+		"ada+news@example.com":   "ada@example.com",
+		"a.d.a@gmail.com":        "ada@gmail.com",
+		"A.da+x@googlemail.com":  "ada@gmail.com",
+		"first.last@example.com": "first.last@example.com",
+		"not-an-address":         "not-an-address",
+	}
+	for in, want := range tests {
+		if got := normalizeEmail(in); got != want {
+			t.Errorf("normalizeEmail(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestFindAndMergeDuplicates(t *testing.T) {
+	db := newTestDB(t)
+	srv := newTestServer(t, db, newMockSender(""))
+
+	_, err := db.Exec(`
+		INSERT INTO subscribers (email, name, subscribed_at) VALUES
+			('ada@gmail.com', 'Ada', '2024-03-01 00:00:00'),
+			('a.da+news@gmail.com', '', '2023-01-01 00:00:00'),
+			('grace@example.com', 'Grace Hopper', '2024-01-01 00:00:00'),
+			('grace@navy.example', 'grace  hopper', '2024-01-01 00:00:00');
+		INSERT INTO articles (title, content) VALUES ('One', ''), ('Two', '');
+		INSERT INTO sent_emails (subscriber_id, article_id) VALUES (1, 1), (2, 1), (2, 2);
+		INSERT INTO subscriber_tags (subscriber_id, tag) VALUES (1, 'vip'), (2, 'vip'), (2, 'early');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groups, err := findDuplicateSubscribers(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 || groups[0].Reason != "email" || groups[1].Reason != "name" {
+		t.Fatalf("groups = %+v", groups)
+	}
+	if g := groups[0].Subscribers; g[0].ID != 1 || g[1].ID != 2 {
+		t.Errorf("email group = %+v", groups[0].Subscribers)
+	}
+
+	merge := func() int {
+		resp, err := http.Post(srv.URL+"/api/subscribers/merge", "application/json", strings.NewReader(`{"keep": 1, "merge": 2}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := merge(); status != http.StatusOK {
+		t.Fatalf("merge status = %d", status)
+	}
+
+	var sends, tags, remaining int
+	var subscribedAt string
+	db.QueryRow("SELECT COUNT(*) FROM sent_emails WHERE subscriber_id = 1").Scan(&sends)
+	db.QueryRow("SELECT COUNT(*) FROM subscriber_tags WHERE subscriber_id = 1").Scan(&tags)
+	db.QueryRow("SELECT COUNT(*) FROM subscribers WHERE id = 2").Scan(&remaining)
+	db.QueryRow("SELECT subscribed_at FROM subscribers WHERE id = 1").Scan(&subscribedAt)
+	// Both received article 1; keep's send of it wins.
+	if sends != 2 || tags != 2 || remaining != 0 {
+		t.Errorf("after merge: sends = %d, tags = %d, merged row left = %d", sends, tags, remaining)
+	}
+	if subscribedAt[:10] != "2023-01-01" {
+		t.Errorf("subscribed_at = %s, want the earlier date", subscribedAt)
+	}
+
+	if status := merge(); status != http.StatusNotFound {
+		t.Errorf("merging a missing subscriber = %d, want 404", status)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// dnsResolver is the subset of *net.Resolver used by the deliverability
+// checks.
+type dnsResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+const (
+	checkOK   = "ok"
+	checkWarn = "warn"
+	checkFail = "fail"
+)
+
+type DeliverabilityCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+type DeliverabilityReport struct {
+	Domain string                `json:"domain"`
+	OK     bool                  `json:"ok"`
+	Checks []DeliverabilityCheck `json:"checks"`
+}
+
+// txtRecords returns the TXT records at name that start with prefix.
+func txtRecords(ctx context.Context, r dnsResolver, name, prefix string) ([]string, error) {
+	records, err := r.LookupTXT(ctx, name)
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var matching []string
+	for _, rec := range records {
+		if strings.HasPrefix(strings.ToLower(rec), strings.ToLower(prefix)) {
+			matching = append(matching, rec)
+		}
+	}
+	return matching, nil
+}
+
+// tagValue returns the value of tag in a semicolon-separated DKIM or DMARC
+// record.
+func tagValue(record, tag string) string {
+	for _, part := range strings.Split(record, ";") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if ok && strings.EqualFold(strings.TrimSpace(k), tag) {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+func checkSPF(ctx context.Context, r dnsResolver, domain string) DeliverabilityCheck {
+	c := DeliverabilityCheck{Name: "spf"}
+	records, err := txtRecords(ctx, r, domain, "v=spf1")
+	switch {
+	case err != nil:
+		c.Status, c.Detail = checkFail, fmt.Sprintf("lookup failed: %v", err)
+	case len(records) == 0:
+		c.Status, c.Detail = checkFail, "no SPF record found"
+	case len(records) > 1:
+		c.Status, c.Detail = checkFail, "multiple SPF records found; receivers treat this as a permanent error"
+	case strings.Contains(records[0], "+all"):
+		c.Status, c.Detail = checkFail, "SPF record allows any sender (+all)"
+	case !strings.Contains(records[0], "all"):
+		c.Status, c.Detail = checkWarn, "SPF record has no terminating all mechanism: "+records[0]
+	default:
+		c.Status, c.Detail = checkOK, records[0]
+	}
+	return c
+}
+
+func checkDKIM(ctx context.Context, r dnsResolver, domain, selector, expectedKey string) DeliverabilityCheck {
+	c := DeliverabilityCheck{Name: "dkim"}
+	if selector == "" {
+		c.Status, c.Detail = checkWarn, "DKIM_SELECTOR is not configured"
+		return c
+	}
+	name := selector + "._domainkey." + domain
+	records, err := txtRecords(ctx, r, name, "")
+	if err != nil {
+		c.Status, c.Detail = checkFail, fmt.Sprintf("lookup of %s failed: %v", name, err)
+		return c
+	}
+	var key string
+	for _, rec := range records {
+		if k := tagValue(rec, "p"); k != "" {
+			key = k
+			break
+		}
+	}
+	switch {
+	case key == "":
+		c.Status, c.Detail = checkFail, "no DKIM public key published at "+name
+	case expectedKey != "" && strings.Join(strings.Fields(expectedKey), "") != key:
+		c.Status, c.Detail = checkFail, "published key at "+name+" does not match DKIM_PUBLIC_KEY"
+	default:
+		c.Status, c.Detail = checkOK, "public key published at "+name
+	}
+	return c
+}
+
+func checkDMARC(ctx context.Context, r dnsResolver, domain string) DeliverabilityCheck {
+	c := DeliverabilityCheck{Name: "dmarc"}
+	records, err := txtRecords(ctx, r, "_dmarc."+domain, "v=DMARC1")
+	switch {
+	case err != nil:
+		c.Status, c.Detail = checkFail, fmt.Sprintf("lookup failed: %v", err)
+	case len(records) == 0:
+		c.Status, c.Detail = checkFail, "no DMARC record found at _dmarc."+domain
+	case strings.EqualFold(tagValue(records[0], "p"), "none"):
+		c.Status, c.Detail = checkWarn, "DMARC policy is p=none (monitoring only): "+records[0]
+	default:
+		c.Status, c.Detail = checkOK, records[0]
+	}
+	return c
+}
+
+// checkReverseDNS verifies that each address of the SMTP host has a PTR
+// record that resolves back to the same address.
+func checkReverseDNS(ctx context.Context, r dnsResolver, host string) DeliverabilityCheck {
+	c := DeliverabilityCheck{Name: "reverse_dns"}
+	if host == "" {
+		c.Status, c.Detail = checkWarn, "SMTP_HOST is not configured"
+		return c
+	}
+	addrs, err := r.LookupHost(ctx, host)
+	if err != nil {
+		c.Status, c.Detail = checkFail, fmt.Sprintf("resolving %s failed: %v", host, err)
+		return c
+	}
+
+	var problems, confirmed []string
+	for _, addr := range addrs {
+		names, err := r.LookupAddr(ctx, addr)
+		if err != nil || len(names) == 0 {
+			problems = append(problems, addr+" has no PTR record")
+			continue
+		}
+		forward, err := r.LookupHost(ctx, names[0])
+		if err != nil || !containsString(forward, addr) {
+			problems = append(problems, fmt.Sprintf("%s -> %s does not resolve back", addr, names[0]))
+			continue
+		}
+		confirmed = append(confirmed, addr+" -> "+names[0])
+	}
+	if len(problems) > 0 {
+		c.Status, c.Detail = checkFail, strings.Join(problems, "; ")
+	} else {
+		c.Status, c.Detail = checkOK, strings.Join(confirmed, "; ")
+	}
+	return c
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// runDeliverabilityChecks checks the DNS configuration of the sending
+// domain. The report is OK when no check failed.
+func runDeliverabilityChecks(ctx context.Context, r dnsResolver, domain string) DeliverabilityReport {
+	report := DeliverabilityReport{Domain: domain, OK: true}
+	report.Checks = []DeliverabilityCheck{
+		checkSPF(ctx, r, domain),
+		checkDKIM(ctx, r, domain, os.Getenv("DKIM_SELECTOR"), os.Getenv("DKIM_PUBLIC_KEY")),
+		checkDMARC(ctx, r, domain),
+		checkReverseDNS(ctx, r, os.Getenv("SMTP_HOST")),
+	}
+	for _, c := range report.Checks {
+		if c.Status == checkFail {
+			report.OK = false
+		}
+	}
+	return report
+}
+
+func handleDeliverability() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		domain := r.URL.Query().Get("domain")
+		if domain == "" {
+			domain = senderDomain()
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		report := runDeliverabilityChecks(ctx, net.DefaultResolver, domain)
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
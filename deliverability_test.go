@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+type fakeResolver struct {
+	txt   map[string][]string
+	hosts map[string][]string
+	ptr   map[string][]string
+}
+
+func (f fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if v, ok := f.txt[name]; ok {
+		return v, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (f fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if v, ok := f.hosts[host]; ok {
+		return v, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func (f fakeResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	if v, ok := f.ptr[addr]; ok {
+		return v, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+}
+
+func TestRunDeliverabilityChecks(t *testing.T) {
+	t.Setenv("DKIM_SELECTOR", "mail")
+	t.Setenv("DKIM_PUBLIC_KEY", "")
+	t.Setenv("SMTP_HOST", "smtp.example.com")
+
+	r := fakeResolver{
+		txt: map[string][]string{
+			"example.com":                 {"google-site-verification=x", "v=spf1 include:_spf.example.net -all"},
+			"mail._domainkey.example.com": {"v=DKIM1; k=rsa; p=MIIBIjAN"},
+			"_dmarc.example.com":          {"v=DMARC1; p=none; rua=mailto:d@example.com"},
+		},
+		hosts: map[string][]string{
+			"smtp.example.com": {"192.0.2.10"},
+			"mx1.example.com.": {"192.0.2.10"},
+		},
+		ptr: map[string][]string{"192.0.2.10": {"mx1.example.com."}},
+	}
+
+	report := runDeliverabilityChecks(context.Background(), r, "example.com")
+	want := map[string]string{
+		"spf":         checkOK,
+		"dkim":        checkOK,
+		"dmarc":       checkWarn,
+		"reverse_dns": checkOK,
+	}
+	for _, c := range report.Checks {
+		if c.Status != want[c.Name] {
+			t.Errorf("%s = %s (%s), want %s", c.Name, c.Status, c.Detail, want[c.Name])
+		}
+	}
+	if !report.OK {
+		t.Error("report should be OK when only warnings are present")
+	}
+
+	report = runDeliverabilityChecks(context.Background(), fakeResolver{}, "example.com")
+	if report.OK {
+		t.Error("report should fail when no records are published")
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	deliveryAccepted  = "accepted"
+	deliveryDelivered = "delivered"
+	deliveryDeferred  = "deferred"
+	deliveryBounced   = "bounced"
+	deliveryDropped   = "dropped"
+	// deliveryImported marks sends recorded by a back-catalog import
+	// rather than sent by this service.
+	deliveryImported = "imported"
+)
+
+// deliveryEvent is a delivery status update from the email provider. Both
+// the generic field names and SendGrid's (event, smtp-id, sg_message_id) are
+// accepted.
+type deliveryEvent struct {
+	MessageID   string `json:"message_id"`
+	SMTPID      string `json:"smtp-id"`
+	SGMessageID string `json:"sg_message_id"`
+	Status      string `json:"status"`
+	Event       string `json:"event"`
+}
+
+// providerEventStatuses maps provider event names to delivery statuses.
+var providerEventStatuses = map[string]string{
+	"delivered": deliveryDelivered,
+	"delivery":  deliveryDelivered,
+	"deferred":  deliveryDeferred,
+	"deferral":  deliveryDeferred,
+	"bounce":    deliveryBounced,
+	"bounced":   deliveryBounced,
+	"dropped":   deliveryDropped,
+	"rejected":  deliveryDropped,
+}
+
+func (e deliveryEvent) status() string {
+	name := e.Status
+	if name == "" {
+		name = e.Event
+	}
+	return providerEventStatuses[strings.ToLower(strings.TrimSpace(name))]
+}
+
+// ids returns the identifiers the event may refer to a send by.
+func (e deliveryEvent) ids() []string {
+	var ids []string
+	for _, id := range []string{e.MessageID, e.SMTPID, e.SGMessageID} {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	// SendGrid appends ".filter..." to the ID it returned at send time.
+	if base, _, ok := strings.Cut(e.SGMessageID, "."); ok {
+		ids = append(ids, base)
+	}
+	return ids
+}
+
+// applyDeliveryEvent updates the send the event refers to. It reports
+// whether a matching send was found. Applying the same event twice, as
+// provider retries and reprocessing do, records its bounce only once.
+func applyDeliveryEvent(db *sql.DB, e deliveryEvent) (bool, error) {
+	status := e.status()
+	if status == "" {
+		return false, nil
+	}
+	for _, id := range e.ids() {
+		var subscriberID, articleID int
+		err := db.QueryRow(`
+			UPDATE sent_emails
+			SET delivery_status = ?, status_updated_at = CURRENT_TIMESTAMP
+			WHERE provider_message_id = ? OR message_id = ?
+			RETURNING subscriber_id, article_id`,
+			status, id, id).Scan(&subscriberID, &articleID)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if status == deliveryBounced || status == deliveryDropped {
+			var seen bool
+			err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM events WHERE subscriber_id = ? AND article_id = ? AND type = ?)",
+				subscriberID, articleID, eventBounced).Scan(&seen)
+			if err != nil {
+				return false, err
+			}
+			if !seen {
+				recordEvent(db, subscriberID, eventBounced, articleID, status)
+			}
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// handleDeliveryWebhook receives delivery status events from the provider,
+// verified by verifyDeliveryWebhook. The body is an event or an array of
+// events.
+func handleDeliveryWebhook(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if credential("DELIVERY_WEBHOOK_SECRET") == "" && os.Getenv("SENDGRID_WEBHOOK_PUBLIC_KEY") == "" {
+			http.Error(w, "Delivery webhook is not configured", http.StatusNotFound)
+			return
+		}
+		payload, ok := readVerifiedWebhook(db, w, r, "delivery", verifyDeliveryWebhook)
+		if !ok {
+			return
+		}
+
+		var raw json.RawMessage
+		if err := json.Unmarshal(payload, &raw); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var events []deliveryEvent
+		if strings.HasPrefix(strings.TrimSpace(string(raw)), "[") {
+			if err := json.Unmarshal(raw, &events); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		} else {
+			var e deliveryEvent
+			if err := json.Unmarshal(raw, &e); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			events = append(events, e)
+		}
+
+		updated := 0
+		for _, e := range events {
+			if err := storeWebhookEvent(db, webhookSourceDelivery, e); err != nil {
+				log.Printf("Error storing delivery event: %v", err)
+			}
+			ok, err := applyDeliveryEvent(db, e)
+			if err != nil {
+				log.Printf("Error applying delivery event: %v", err)
+				http.Error(w, "Error applying delivery event", http.StatusInternalServerError)
+				return
+			}
+			if ok {
+				updated++
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"received": len(events), "updated": updated})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDeliveryWebhookUpdatesStatus(t *testing.T) {
+	t.Setenv("DELIVERY_WEBHOOK_SECRET", "s3cret")
+	db := newTestDB(t)
+	sender := newMockSender("")
+	srv := newTestServer(t, db, sender)
+
+	for _, email := range []string{"ada@example.com", "grace@example.com"} {
+		if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES (?, '')", email); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("INSERT INTO articles (title, content) VALUES ('Hello', '')"); err != nil {
+		t.Fatal(err)
+	}
+	sendNewsletterForArticle(context.Background(), db, sender, 1)
+
+	var messageID string
+	if err := db.QueryRow("SELECT message_id FROM sent_emails WHERE provider_message_id = 'mock-2'").Scan(&messageID); err != nil {
+		t.Fatal(err)
+	}
+
+	body := `[{"sg_message_id":"mock-1.filter0001","event":"delivered"},{"smtp-id":"` + messageID + `","event":"bounce"},{"message_id":"unknown","status":"dropped"}]`
+	resp, err := http.Post(srv.URL+"/api/webhooks/delivery?token=wrong", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("wrong token: status %d", resp.StatusCode)
+	}
+
+	postJSON(t, srv.URL+"/api/webhooks/delivery?token=s3cret", body)
+
+	statuses := map[string]string{}
+	rows, err := db.Query("SELECT provider_message_id, delivery_status FROM sent_emails")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, status string
+		if err := rows.Scan(&id, &status); err != nil {
+			t.Fatal(err)
+		}
+		statuses[id] = status
+	}
+	if statuses["mock-1"] != deliveryDelivered || statuses["mock-2"] != deliveryBounced {
+		t.Fatalf("statuses = %v", statuses)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpCapture is a minimal SMTP server that accepts every message and
+// keeps it in memory, so tests can exercise the real SMTP sender.
+type smtpCapture struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	messages []capturedMessage
+}
+
+type capturedMessage struct {
+	From string
+	To   []string
+	*mail.Message
+}
+
+func newSMTPCapture(t *testing.T) *smtpCapture {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &smtpCapture{ln: ln}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go c.serve(conn)
+		}
+	}()
+	return c
+}
+
+func (c *smtpCapture) port() int {
+	return c.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (c *smtpCapture) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost ESMTP capture")
+	var from string
+	var to []string
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250 localhost")
+		case "MAIL":
+			from, to = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>"), nil
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			to = append(to, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			msg, err := mail.ReadMessage(bufio.NewReader(tp.DotReader()))
+			if err != nil {
+				tp.PrintfLine("554 %v", err)
+				continue
+			}
+			// mail.ReadMessage leaves the body unread.
+			body, _ := io.ReadAll(msg.Body)
+			msg.Body = strings.NewReader(string(body))
+			c.mu.Lock()
+			c.messages = append(c.messages, capturedMessage{from, to, msg})
+			c.mu.Unlock()
+			tp.PrintfLine("250 OK")
+		case "RSET", "NOOP":
+			tp.PrintfLine("250 OK")
+		case "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+		default:
+			tp.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+// waitFor returns the first captured message to addr whose subject
+// contains subject, failing the test if none arrives in time.
+func (c *smtpCapture) waitFor(t *testing.T, addr, subject string) capturedMessage {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		for _, m := range c.messages {
+			if len(m.To) == 1 && m.To[0] == addr && strings.Contains(m.Header.Get("Subject"), subject) {
+				c.mu.Unlock()
+				return m
+			}
+		}
+		c.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("no message about %q to %s captured", subject, addr)
+	return capturedMessage{}
+}
+
+// htmlBody returns the decoded text/html part of a captured message.
+func htmlBody(t *testing.T, m capturedMessage) string {
+	t.Helper()
+	body, err := findPart(textproto.MIMEHeader(m.Header), m.Body, "text/html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func findPart(header textproto.MIMEHeader, body io.Reader, want string) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		r := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := r.NextRawPart()
+			if err != nil {
+				return "", err
+			}
+			if s, err := findPart(part.Header, part, want); err == nil {
+				return s, nil
+			}
+		}
+	}
+	if mediaType != want {
+		return "", io.EOF
+	}
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	b, err := io.ReadAll(body)
+	return string(b), err
+}
+
+// TestEndToEnd runs a reader's journey through the full HTTP stack and the
+// real SMTP sender, capturing mail with an in-process SMTP server:
+// subscribe, welcome email, publish, newsletter delivery and open
+// tracking. Run it alone with make e2e.
+func TestEndToEnd(t *testing.T) {
+	capture := newSMTPCapture(t)
+	t.Setenv("EMAIL_PROVIDER", "smtp")
+	t.Setenv("SMTP_HOST", "127.0.0.1")
+	t.Setenv("SMTP_PORT", strconv.Itoa(capture.port()))
+	t.Setenv("EMAIL_FROM", "news@example.com")
+	t.Setenv("TRACKING_SECRET", "secret")
+	t.Setenv("WELCOME_EMAIL_ENABLED", "true")
+
+	db := newTestDB(t)
+	sender, err := newEmailSender(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(newHandler(db, sender, &authenticator{db: db}, periodicTasks(db, sender)))
+	t.Cleanup(srv.Close)
+	t.Setenv("PUBLIC_BASE_URL", srv.URL)
+
+	postJSON(t, srv.URL+"/api/subscribe", `{"email":"ada@example.com","name":"Ada"}`)
+	welcome := capture.waitFor(t, "ada@example.com", "")
+	if welcome.From != "news@example.com" {
+		t.Errorf("welcome envelope sender = %q", welcome.From)
+	}
+
+	postJSON(t, srv.URL+"/api/publish", `{"title":"Issue 1","content":"Hello readers"}`)
+	newsletter := capture.waitFor(t, "ada@example.com", "Issue 1")
+	if newsletter.Header.Get("List-Unsubscribe") == "" {
+		t.Error("newsletter has no List-Unsubscribe header")
+	}
+	body := htmlBody(t, newsletter)
+	if !strings.Contains(body, "Hello readers") {
+		t.Errorf("newsletter body does not contain the article:\n%s", body)
+	}
+
+	pixel := regexp.MustCompile(regexp.QuoteMeta(srv.URL) + `/t/o/[^"]+`).FindString(body)
+	if pixel == "" {
+		t.Fatalf("newsletter has no open tracking pixel:\n%s", body)
+	}
+	resp, err := http.Get(pixel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("tracking pixel: status %d", resp.StatusCode)
+	}
+
+	var opened int
+	err = db.QueryRowContext(context.Background(),
+		"SELECT COUNT(*) FROM sent_emails WHERE opened_at IS NOT NULL AND subscriber_id = (SELECT id FROM subscribers WHERE email = 'ada@example.com')").Scan(&opened)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened != 1 {
+		t.Errorf("%d opened sends recorded, want 1", opened)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// smtpUTF8Enabled reports whether the email provider accepts SMTPUTF8
+// (SMTP_UTF8), which is needed to deliver to addresses with a non-ASCII
+// local part. Internationalized domains work without it.
+func smtpUTF8Enabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("SMTP_UTF8"))
+	return enabled
+}
+
+// validateEmailAddress checks a subscriber address. Non-ASCII domains are
+// accepted and sent as punycode. Non-ASCII local parts are accepted only
+// when the provider supports SMTPUTF8.
+func validateEmailAddress(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" {
+		return fmt.Errorf("invalid email address %q", email)
+	}
+	local, domain, _ := strings.Cut(email, "@")
+	if _, err := idna.Lookup.ToASCII(domain); err != nil {
+		return fmt.Errorf("invalid email domain %q: %w", domain, err)
+	}
+	if !isASCII(local) && !smtpUTF8Enabled() {
+		return errors.New("addresses with a non-ASCII local part require a provider with SMTPUTF8 support")
+	}
+	return nil
+}
+
+// deliveryAddress returns email with its domain in punycode, so the
+// address can be delivered without SMTPUTF8 when only the domain is
+// internationalized.
+func deliveryAddress(email string) string {
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return email
+	}
+	domain, err := idna.Lookup.ToASCII(email[i+1:])
+	if err != nil {
+		return email
+	}
+	return email[:i+1] + domain
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
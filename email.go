@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+const defaultSubjectTemplate = "New Blog Post: {{.Title}}"
+
+// emailTemplateData is the context the subject and body templates are
+// rendered with.
+func emailTemplateData(sub Subscriber, article Article) map[string]interface{} {
+	toc, body := articleTOC(article)
+	return map[string]interface{}{
+		"Name":        sub.Name,
+		"Title":       article.Title,
+		"Content":     article.Content,
+		"BaseURL":     publicURL(""),
+		"URL":         articleURL(article),
+		"Authors":     article.Authors,
+		"Byline":      authorByline(article.Authors),
+		"Poll":        pollTemplateData(sub, article),
+		"Excerpt":     article.Excerpt,
+		"ReadingTime": article.ReadingTime,
+		"TOC":         toc,
+		"Body":        body,
+	}
+}
+
+// subjectTemplate returns the article's subject template, falling back to
+// EMAIL_SUBJECT_TEMPLATE and then the built-in default.
+func subjectTemplate(article Article) string {
+	if article.Subject != "" {
+		return article.Subject
+	}
+	if s := os.Getenv("EMAIL_SUBJECT_TEMPLATE"); s != "" {
+		return s
+	}
+	return defaultSubjectTemplate
+}
+
+func renderSubject(article Article, data map[string]interface{}) (string, error) {
+	t, err := texttemplate.New("subject").Option("missingkey=error").Parse(subjectTemplate(article))
+	if err != nil {
+		return "", fmt.Errorf("parsing subject template: %w", err)
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("executing subject template: %w", err)
+	}
+	// Header values must be a single line.
+	return strings.Join(strings.Fields(b.String()), " "), nil
+}
+
+// setFromHeader sets From to EMAIL_FROM with the display name persona,
+// falling back to EMAIL_FROM_NAME and then the name in EMAIL_FROM itself.
+func setFromHeader(m *gomail.Message, persona string) {
+	from := os.Getenv("EMAIL_FROM")
+	addr, err := mail.ParseAddress(from)
+	if err != nil {
+		m.SetHeader("From", from)
+		return
+	}
+	name := persona
+	if name == "" {
+		name = os.Getenv("EMAIL_FROM_NAME")
+	}
+	if name == "" {
+		name = addr.Name
+	}
+	m.SetAddressHeader("From", addr.Address, name)
+}
+
+// replyTo returns the article's Reply-To address, falling back to
+// EMAIL_REPLY_TO. An empty result means no Reply-To header is set.
+func replyTo(article Article) string {
+	if article.ReplyTo != "" {
+		return article.ReplyTo
+	}
+	return os.Getenv("EMAIL_REPLY_TO")
+}
+
+// validateArticleOverrides checks the per-article subject template,
+// Reply-To address, schedule, event and sender persona supplied at publish
+// time.
+func validateArticleOverrides(article Article) error {
+	if article.Subject != "" {
+		if err := checkTemplate(article.Subject); err != nil {
+			return fmt.Errorf("invalid subject: %w", err)
+		}
+		if _, err := renderSubject(article, emailTemplateData(Subscriber{}, article)); err != nil {
+			return fmt.Errorf("invalid subject: %w", err)
+		}
+	}
+	if article.ReplyTo != "" {
+		if _, err := mail.ParseAddress(article.ReplyTo); err != nil {
+			return fmt.Errorf("invalid reply_to: %w", err)
+		}
+	}
+	if _, _, err := articleEvent(article); err != nil {
+		return err
+	}
+	if strings.ContainsAny(article.FromName, "\r\n") {
+		return errors.New("invalid from_name: must be a single line")
+	}
+	for _, a := range article.Authors {
+		if err := a.validate(); err != nil {
+			return err
+		}
+	}
+	if err := article.Poll.validate(); err != nil {
+		return err
+	}
+	if err := article.Exclude.validate(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// renderNewsletterBody renders the HTML body of the newsletter for one
+// subscriber.
+func renderNewsletterBody(sub Subscriber, article Article) (string, error) {
+	t, err := loadEmailTemplate()
+	if err != nil {
+		return "", err
+	}
+
+	var body bytes.Buffer
+	if err := t.Execute(&body, emailTemplateData(sub, article)); err != nil {
+		return "", fmt.Errorf("executing template: %w", err)
+	}
+	return body.String(), nil
+}
+
+// buildNewsletterMessage renders the newsletter for one subscriber.
+func buildNewsletterMessage(sub Subscriber, article Article) (*gomail.Message, error) {
+	body, err := renderNewsletterBody(sub, article)
+	if err != nil {
+		return nil, err
+	}
+
+	subject, err := renderSubject(article, emailTemplateData(sub, article))
+	if err != nil {
+		return nil, err
+	}
+
+	m := gomail.NewMessage()
+	setFromHeader(m, article.FromName)
+	// An address header, so a non-ASCII local part is sent as UTF-8
+	// (RFC 6532) rather than as an encoded word.
+	m.SetAddressHeader("To", deliveryAddress(sub.Email), "")
+	m.SetHeader("Subject", subject)
+	if addr := replyTo(article); addr != "" {
+		setAddressHeader(m, "Reply-To", addr)
+	}
+	if err := setBulkHeaders(m); err != nil {
+		return nil, err
+	}
+	// Only real sends are tracked, not previews or the archive copy, and
+	// not for subscribers who opted out.
+	if sub.ID != 0 && !sub.TrackingOptOut && trackingEnabled() && flagEnabled(flagTracking) {
+		body = addTracking(body, sub.ID, article.ID, article.shortLinks)
+	}
+	// The footer is added after tracking so the unsubscribe link is not
+	// rewritten through the click tracker.
+	footer, err := renderFooter(sub.ID, article.ID)
+	if err != nil {
+		return nil, err
+	}
+	body = injectFooter(body, footer)
+	if u := unsubscribeURL(sub.ID, article.ID); u != "" {
+		m.SetHeader("List-Unsubscribe", "<"+u+">")
+		m.SetHeader("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
+	// AMP-capable clients expect the AMP part before the HTML one, which
+	// stays last as the fallback. Tracking is not added to the AMP part.
+	amp, ok, err := renderAMPBody(sub, article)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		m.SetBody("text/x-amp-html", injectFooter(amp, footer))
+		m.AddAlternative("text/html", body)
+	} else {
+		m.SetBody("text/html", body)
+	}
+	if err := attachEvent(m, article); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// setAddressHeader sets an address header such as Reply-To. gomail encodes
+// non-ASCII header values as a whole, which garbles the address part of
+// `"Name" <addr>`, so only the display name is RFC 2047 encoded.
+func setAddressHeader(m *gomail.Message, field, value string) {
+	addr, err := mail.ParseAddress(value)
+	if err != nil {
+		m.SetHeader(field, value)
+		return
+	}
+	m.SetAddressHeader(field, addr.Address, addr.Name)
+}
+
+// protectedHeaders may not be replaced through EMAIL_EXTRA_HEADERS.
+var protectedHeaders = map[string]bool{
+	"From":       true,
+	"To":         true,
+	"Cc":         true,
+	"Bcc":        true,
+	"Subject":    true,
+	"Message-Id": true,
+}
+
+// senderDomain returns the domain of the EMAIL_FROM address.
+func senderDomain() string {
+	from := os.Getenv("EMAIL_FROM")
+	if addr, err := mail.ParseAddress(from); err == nil {
+		from = addr.Address
+	}
+	if i := strings.LastIndex(from, "@"); i >= 0 {
+		return from[i+1:]
+	}
+	return "localhost"
+}
+
+// newMessageID returns a unique Message-ID in MESSAGE_ID_DOMAIN, which
+// defaults to the sender's domain.
+func newMessageID() (string, error) {
+	domain := os.Getenv("MESSAGE_ID_DOMAIN")
+	if domain == "" {
+		domain = senderDomain()
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(b), domain), nil
+}
+
+// extraHeaders parses EMAIL_EXTRA_HEADERS, a JSON object of header names to
+// values added to every newsletter.
+func extraHeaders() (map[string]string, error) {
+	raw := os.Getenv("EMAIL_EXTRA_HEADERS")
+	if raw == "" {
+		return nil, nil
+	}
+	var headers map[string]string
+	if err := json.Unmarshal([]byte(raw), &headers); err != nil {
+		return nil, fmt.Errorf("parsing EMAIL_EXTRA_HEADERS: %w", err)
+	}
+	return headers, nil
+}
+
+// setBulkHeaders adds the headers mailbox providers expect on list mail:
+// Message-ID, List-ID, Precedence and any configured extra headers.
+func setBulkHeaders(m *gomail.Message) error {
+	id, err := newMessageID()
+	if err != nil {
+		return err
+	}
+	m.SetHeader("Message-ID", id)
+
+	listID := os.Getenv("LIST_ID")
+	if listID == "" {
+		listID = "<newsletter." + senderDomain() + ">"
+	}
+	m.SetHeader("List-ID", listID)
+	m.SetHeader("Precedence", "bulk")
+
+	headers, err := extraHeaders()
+	if err != nil {
+		return err
+	}
+	for name, value := range headers {
+		name = textproto.CanonicalMIMEHeaderKey(name)
+		if protectedHeaders[name] {
+			continue
+		}
+		m.SetHeader(name, value)
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"mime"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildNewsletterMessageOverrides(t *testing.T) {
+	t.Setenv("EMAIL_REPLY_TO", "default@example.com")
+	t.Setenv("EMAIL_SUBJECT_TEMPLATE", "")
+
+	sub := Subscriber{Email: "ada@example.com", Name: "Ada"}
+
+	m, err := buildNewsletterMessage(sub, Article{Title: "Hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.GetHeader("Subject"); len(got) != 1 || got[0] != "New Blog Post: Hello" {
+		t.Errorf("default Subject = %v", got)
+	}
+	if got := m.GetHeader("Reply-To"); len(got) != 1 || got[0] != "default@example.com" {
+		t.Errorf("default Reply-To = %v", got)
+	}
+
+	m, err = buildNewsletterMessage(sub, Article{
+		Title:   "Hello",
+		Subject: "{{.Name}}, read {{.Title}}",
+		ReplyTo: "me@example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.GetHeader("Subject"); len(got) != 1 || got[0] != "Ada, read Hello" {
+		t.Errorf("override Subject = %v", got)
+	}
+	if got := m.GetHeader("Reply-To"); len(got) != 1 || got[0] != "me@example.com" {
+		t.Errorf("override Reply-To = %v", got)
+	}
+}
+
+func TestValidateArticleOverrides(t *testing.T) {
+	tests := []struct {
+		name    string
+		article Article
+		wantErr bool
+	}{
+		{"empty", Article{Title: "Hi"}, false},
+		{"valid", Article{Title: "Hi", Subject: "{{.Title}}!", ReplyTo: "Me <me@example.com>"}, false},
+		{"bad template", Article{Title: "Hi", Subject: "{{.Title"}, true},
+		{"unknown field", Article{Title: "Hi", Subject: "{{.Titel}}"}, true},
+		{"bad reply-to", Article{Title: "Hi", ReplyTo: "not an address"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateArticleOverrides(tt.article)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBulkHeaders(t *testing.T) {
+	t.Setenv("EMAIL_FROM", "The Blog <news@example.com>")
+	t.Setenv("MESSAGE_ID_DOMAIN", "")
+	t.Setenv("LIST_ID", "")
+	t.Setenv("EMAIL_EXTRA_HEADERS", `{"x-campaign":"weekly","subject":"ignored"}`)
+
+	m, err := buildNewsletterMessage(Subscriber{Email: "ada@example.com"}, Article{Title: "Hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := m.GetHeader("List-ID"); len(got) != 1 || got[0] != "<newsletter.example.com>" {
+		t.Errorf("List-ID = %v", got)
+	}
+	if got := m.GetHeader("Precedence"); len(got) != 1 || got[0] != "bulk" {
+		t.Errorf("Precedence = %v", got)
+	}
+	if got := m.GetHeader("Message-ID"); len(got) != 1 || !strings.HasSuffix(got[0], "@example.com>") {
+		t.Errorf("Message-ID = %v", got)
+	}
+	if got := m.GetHeader("X-Campaign"); len(got) != 1 || got[0] != "weekly" {
+		t.Errorf("X-Campaign = %v", got)
+	}
+	if got := m.GetHeader("Subject"); got[0] != "New Blog Post: Hello" {
+		t.Errorf("Subject was overridden by extra headers: %v", got)
+	}
+}
+
+func TestPublicURL(t *testing.T) {
+	tests := []struct {
+		base, path, want string
+	}{
+		{"", "unsubscribe", ""},
+		{"not a url", "unsubscribe", ""},
+		{"ftp://links.example.com", "unsubscribe", ""},
+		{"https://links.example.com", "unsubscribe", "https://links.example.com/unsubscribe"},
+		{"https://links.example.com/", "/unsubscribe", "https://links.example.com/unsubscribe"},
+		{"https://example.com/news/", "", "https://example.com/news/"},
+	}
+	for _, tt := range tests {
+		t.Setenv("PUBLIC_BASE_URL", tt.base)
+		if got := publicURL(tt.path); got != tt.want {
+			t.Errorf("publicURL(%q) with base %q = %q, want %q", tt.path, tt.base, got, tt.want)
+		}
+	}
+}
+
+func TestEventArticleAttachesInvite(t *testing.T) {
+	t.Setenv("EMAIL_FROM", "news@example.com")
+	article := Article{ID: 7, Title: "Meetup", EventStart: "2026-05-01T18:00:00Z", EventEnd: "2026-05-01T20:00:00Z", EventLocation: "Room 1, Town Hall"}
+
+	m, err := buildNewsletterMessage(Subscriber{Email: "ada@example.com"}, article)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if _, err := m.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`filename="invite.ics"`, "text/calendar"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("message missing %q", want)
+		}
+	}
+
+	for _, bad := range []Article{
+		{EventStart: "tomorrow"},
+		{EventStart: "2026-05-01T18:00:00Z", EventEnd: "2026-05-01T17:00:00Z"},
+		{EventLocation: "Town Hall"},
+	} {
+		if err := validateArticleOverrides(bad); err == nil {
+			t.Errorf("validateArticleOverrides(%+v) = nil", bad)
+		}
+	}
+}
+
+func TestLayoutAndPartials(t *testing.T) {
+	body, err := renderNewsletterBody(Subscriber{Name: "Ada"}, Article{Title: "Hello", Content: "Body text"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"<title>New Blog Post: Hello</title>", "Hello, Ada!", "Body text", "Visit our blog"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body missing %q:\n%s", want, body)
+		}
+	}
+
+	// The shipped email template only fills the layout's blocks, so
+	// without a layout it must fail rather than send a blank email.
+	t.Setenv("EMAIL_LAYOUT", "missing.html")
+	if body, err := renderNewsletterBody(Subscriber{}, Article{Title: "Hello"}); err == nil {
+		t.Errorf("rendered without a layout:\n%s", body)
+	}
+}
+
+func TestLayoutDarkMode(t *testing.T) {
+	body, err := renderNewsletterBody(Subscriber{}, Article{Title: "Hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`name="color-scheme" content="light dark"`, "prefers-color-scheme: dark", "<!--[if mso]>", "<![endif]-->"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body missing %q", want)
+		}
+	}
+}
+
+func TestAMPPart(t *testing.T) {
+	sub := Subscriber{Email: "ada@example.com", Name: "Ada"}
+	render := func() string {
+		m, err := buildNewsletterMessage(sub, Article{Title: "Hello"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b strings.Builder
+		if _, err := m.WriteTo(&b); err != nil {
+			t.Fatal(err)
+		}
+		return b.String()
+	}
+
+	if strings.Contains(render(), "text/x-amp-html") {
+		t.Fatal("AMP part sent while disabled")
+	}
+
+	t.Setenv("AMP_ENABLED", "true")
+	msg := render()
+	amp, html := strings.Index(msg, "Content-Type: text/x-amp-html"), strings.Index(msg, "Content-Type: text/html")
+	if amp < 0 || html < 0 || amp > html {
+		t.Fatalf("want AMP part before HTML part:\n%s", msg)
+	}
+}
+
+func TestNonASCIIHeaders(t *testing.T) {
+	t.Setenv("EMAIL_FROM", `"Rähim 🚀" <news@example.com>`)
+	t.Setenv("EMAIL_REPLY_TO", "Zoë <editor@example.com>")
+	t.Setenv("EMAIL_SUBJECT_TEMPLATE", "")
+
+	title := "Launch day 🚀 — ünïcödé edition with a title long enough to need folding"
+	m, err := buildNewsletterMessage(Subscriber{Email: "ada@example.com"}, Article{Title: title})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if _, err := m.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dec := new(mime.WordDecoder)
+	subject, err := dec.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "New Blog Post: "+title {
+		t.Errorf("Subject = %q, %v", subject, err)
+	}
+	for field, want := range map[string]mail.Address{
+		"From":     {Name: "Rähim 🚀", Address: "news@example.com"},
+		"Reply-To": {Name: "Zoë", Address: "editor@example.com"},
+	} {
+		addr, err := msg.Header.AddressList(field)
+		if err != nil || len(addr) != 1 || *addr[0] != want {
+			t.Errorf("%s = %v, %v", field, addr, err)
+		}
+	}
+}
+
+func TestInternationalizedAddresses(t *testing.T) {
+	for _, email := range []string{"ada@example.com", "ada@пример.рф", "ada@例え.jp"} {
+		if err := validateEmailAddress(email); err != nil {
+			t.Errorf("validateEmailAddress(%q) = %v", email, err)
+		}
+	}
+	for _, email := range []string{"", "not-an-address", "Ada <ada@example.com>", "ада@пример.рф"} {
+		if err := validateEmailAddress(email); err == nil {
+			t.Errorf("validateEmailAddress(%q) = nil", email)
+		}
+	}
+	t.Setenv("SMTP_UTF8", "true")
+	if err := validateEmailAddress("ада@пример.рф"); err != nil {
+		t.Errorf("with SMTPUTF8: %v", err)
+	}
+
+	m, err := buildNewsletterMessage(Subscriber{Email: "ада@пример.рф"}, Article{Title: "Hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.GetHeader("To"); len(got) != 1 || got[0] != "ада@xn--e1afmkfd.xn--p1ai" {
+		t.Errorf("To = %q", got)
+	}
+}
+
+func TestFromPersona(t *testing.T) {
+	t.Setenv("EMAIL_FROM", "news@example.com")
+	from := func(article Article) string {
+		m, err := buildNewsletterMessage(Subscriber{Email: "ada@example.com"}, article)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m.GetHeader("From")[0]
+	}
+
+	if got := from(Article{Title: "Hello"}); got != "news@example.com" {
+		t.Errorf("bare From = %q", got)
+	}
+	t.Setenv("EMAIL_FROM_NAME", "Rahim from The Blog")
+	if got := from(Article{Title: "Hello"}); got != `"Rahim from The Blog" <news@example.com>` {
+		t.Errorf("configured From = %q", got)
+	}
+	if got := from(Article{Title: "Hello", FromName: "The Editors"}); got != `"The Editors" <news@example.com>` {
+		t.Errorf("persona From = %q", got)
+	}
+	if err := validateArticleOverrides(Article{FromName: "Evil\r\nBcc: x@example.com"}); err == nil {
+		t.Error("multi-line from_name accepted")
+	}
+}
+
+func TestEmailTemplateWithoutLayout(t *testing.T) {
+	wd, _ := os.Getwd()
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	t.Setenv("EMAIL_LAYOUT", "missing.html")
+	if err := os.Mkdir("partials", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join("partials", "footer.html"), []byte("<footer>Bye</footer>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(emailTemplateFile, []byte(`<h1>{{.Title}}</h1>{{template "footer" .}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The partials are parsed first, but the email template is rendered.
+	body, err := renderNewsletterBody(Subscriber{}, Article{Title: "Hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "<h1>Hello</h1><footer>Bye</footer>" {
+		t.Errorf("body = %q", body)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"time"
+)
+
+// engagementScore rates a subscriber from 0 to 100 by how often they opened
+// and clicked their recent sends and how recently they last engaged. Half
+// the score comes from frequency, where a click counts as much as an open,
+// and half from recency, which halves every ENGAGEMENT_HALF_LIFE.
+func engagementScore(sends, opened, clicked int, lastEngaged, now time.Time) float64 {
+	if sends == 0 {
+		return 0
+	}
+	frequency := float64(opened+clicked) / float64(2*sends)
+	recency := 0.0
+	if !lastEngaged.IsZero() {
+		halfLife := getEnvDuration("ENGAGEMENT_HALF_LIFE", 30*24*time.Hour)
+		recency = math.Pow(0.5, now.Sub(lastEngaged).Hours()/halfLife.Hours())
+	}
+	return math.Round(100*(frequency+recency)/2*10) / 10
+}
+
+// updateEngagementScores recomputes every subscriber's score from their
+// last ENGAGEMENT_WINDOW sends (default 10). Imported sends are ignored, as
+// they were never tracked. Subscribers without sends keep a NULL score.
+func updateEngagementScores(db *sql.DB, now time.Time) (int, error) {
+	return updateEngagementScoresWhere(db, now, "1")
+}
+
+// updateEngagementScoresSentBetween recomputes the scores of subscribers
+// who were sent something between from and to, inclusive.
+func updateEngagementScoresSentBetween(db *sql.DB, now, from, to time.Time) (int, error) {
+	return updateEngagementScoresWhere(db, now,
+		"subscriber_id IN (SELECT subscriber_id FROM sent_emails WHERE sent_at BETWEEN ? AND ?)",
+		from.UTC().Format(sqliteTimeFormat), to.UTC().Format(sqliteTimeFormat))
+}
+
+// updateEngagementScoresWhere recomputes the scores of the subscribers
+// matched by cond.
+func updateEngagementScoresWhere(db *sql.DB, now time.Time, cond string, args ...interface{}) (int, error) {
+	rows, err := db.Query(`
+		SELECT subscriber_id, COUNT(*), COUNT(opened_at), COUNT(clicked_at), COALESCE(MAX(last_engaged_at), '')
+		FROM (
+			SELECT subscriber_id, opened_at, clicked_at, last_engaged_at,
+				ROW_NUMBER() OVER (PARTITION BY subscriber_id ORDER BY id DESC) AS n
+			FROM sent_emails
+			WHERE delivery_status != ?
+		)
+		WHERE n <= ? AND `+cond+`
+		GROUP BY subscriber_id`, append([]interface{}{deliveryImported, getEnvInt("ENGAGEMENT_WINDOW", 10)}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	scores := map[int]float64{}
+	for rows.Next() {
+		var id, sends, opened, clicked int
+		var last string
+		if err := rows.Scan(&id, &sends, &opened, &clicked, &last); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		lastEngaged, _ := time.Parse(sqliteTimeFormat, last)
+		scores[id] = engagementScore(sends, opened, clicked, lastEngaged, now)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	// Without tracking a subscriber's sends all look unopened, so their
+	// score is left as it was when they opted out.
+	for id, score := range scores {
+		if _, err := tx.Exec("UPDATE subscribers SET engagement_score = ? WHERE id = ? AND tracking_opt_out = 0", score, id); err != nil {
+			return 0, err
+		}
+	}
+	return len(scores), tx.Commit()
+}
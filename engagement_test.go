@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAddTracking(t *testing.T) {
+	t.Setenv("PUBLIC_BASE_URL", "https://links.example.com")
+	t.Setenv("TRACKING_SECRET", "secret")
+
+	body := addTracking(`<html><body><a href="https://blog.example.com/post">Read</a> <a href="#top">Top</a></body></html>`, 3, 7, nil)
+
+	if !strings.Contains(body, `<img src="https://links.example.com/t/o/3.7.`) || !strings.Contains(body, `</body>`) {
+		t.Errorf("no open pixel before </body>: %s", body)
+	}
+	if !strings.Contains(body, `href="https://links.example.com/t/c/3.7.`) || !strings.Contains(body, `u=https%3A%2F%2Fblog.example.com%2Fpost`) {
+		t.Errorf("link not rewritten: %s", body)
+	}
+	if !strings.Contains(body, `href="#top"`) {
+		t.Errorf("fragment link was rewritten: %s", body)
+	}
+}
+
+func TestEngagementTrackingAndSegment(t *testing.T) {
+	t.Setenv("TRACKING_SECRET", "secret")
+	db := newTestDB(t)
+	sender := newMockSender("")
+	srv := newTestServer(t, db, sender)
+	t.Setenv("PUBLIC_BASE_URL", srv.URL)
+
+	if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES ('ada@example.com', 'Ada'), ('grace@example.com', 'Grace')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO articles (title, content) VALUES ('Hello', '')"); err != nil {
+		t.Fatal(err)
+	}
+	sendNewsletterForArticle(context.Background(), db, sender, 1)
+
+	// Ada opens her email.
+	var pixel string
+	for _, m := range sender.Messages() {
+		if m.GetHeader("To")[0] == "ada@example.com" {
+			var b strings.Builder
+			m.WriteTo(&b)
+			pixel = regexp.MustCompile(`/t/o/[^"]+`).FindString(strings.ReplaceAll(b.String(), "=\r\n", ""))
+		}
+	}
+	if pixel == "" {
+		t.Fatal("no open pixel in Ada's email")
+	}
+	resp, err := http.Get(srv.URL + strings.ReplaceAll(pixel, "=3D", "="))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "image/gif" {
+		t.Fatalf("pixel Content-Type = %q", resp.Header.Get("Content-Type"))
+	}
+
+	// A click link whose target was changed is rejected.
+	token := trackingToken(1, 1, "https://blog.example.com/")
+	resp, err = http.Get(srv.URL + "/t/c/" + token + "?u=" + url.QueryEscape("https://evil.example.com/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("tampered click: status %d", resp.StatusCode)
+	}
+
+	if _, err := updateEngagementScores(db, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+	subs, err := getSubscribers(context.Background(), db, Article{MinEngagement: 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 1 || subs[0].Email != "ada@example.com" {
+		t.Fatalf("engaged subscribers = %+v", subs)
+	}
+}
+
+func TestSegmentPreview(t *testing.T) {
+	db := newTestDB(t)
+	srv := newTestServer(t, db, newMockSender(""))
+
+	if _, err := db.Exec(`INSERT INTO subscribers (email, name, tier, source, engagement_score) VALUES
+		('a@example.com', '', 'premium', 'twitter', 80),
+		('b@example.com', '', 'premium', 'twitter', 10),
+		('c@example.com', '', 'free', 'twitter', 90),
+		('d@example.com', '', 'premium', '', 95)`); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Post(srv.URL+"/api/segments/preview", "application/json",
+		strings.NewReader(`{"tier": "premium", "min_engagement": 50, "source": "Twitter"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var preview SegmentPreview
+	if err := json.NewDecoder(resp.Body).Decode(&preview); err != nil {
+		t.Fatal(err)
+	}
+	if preview.Count != 1 || len(preview.Sample) != 1 || preview.Sample[0].Email != "a@example.com" {
+		t.Fatalf("preview = %+v", preview)
+	}
+}
+
+func TestTrackingBufferFlush(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := db.Exec("INSERT INTO sent_emails (subscriber_id, article_id) VALUES (1, 1)"); err != nil {
+		t.Fatal(err)
+	}
+	buf := newTrackingBuffer(db)
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		buf.add(trackingHit{subscriberID: 1, articleID: 1, at: at.Add(time.Duration(i) * time.Minute)})
+	}
+	buf.add(trackingHit{subscriberID: 1, articleID: 1, click: true, target: "https://example.com/", at: at})
+
+	var opens int
+	db.QueryRow("SELECT open_count FROM sent_emails").Scan(&opens)
+	if opens != 0 {
+		t.Fatalf("hits written before flush: %d opens", opens)
+	}
+
+	buf.flush()
+	var clicks int
+	var opened, lastEngaged string
+	if err := db.QueryRow("SELECT open_count, click_count, opened_at, last_engaged_at FROM sent_emails").Scan(&opens, &clicks, &opened, &lastEngaged); err != nil {
+		t.Fatal(err)
+	}
+	if opens != 3 || clicks != 1 || !strings.HasPrefix(opened, "2026-03-01T12:00:00") || !strings.HasPrefix(lastEngaged, "2026-03-01T12:02:00") {
+		t.Fatalf("opens=%d clicks=%d opened=%s last=%s", opens, clicks, opened, lastEngaged)
+	}
+	var events int
+	db.QueryRow("SELECT COUNT(*) FROM events").Scan(&events)
+	if events != 4 {
+		t.Fatalf("%d events", events)
+	}
+}
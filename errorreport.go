@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// errorReportingEnabled is set when SENTRY_DSN configures an error tracker.
+var errorReportingEnabled bool
+
+// initErrorReporting configures Sentry from SENTRY_DSN and
+// SENTRY_ENVIRONMENT. Without a DSN, errors are only logged. The returned
+// function flushes pending reports and should run before exit.
+func initErrorReporting() func() {
+	dsn := os.Getenv("SENTRY_DSN")
+	if dsn == "" {
+		return func() {}
+	}
+	err := sentry.Init(sentry.ClientOptions{
+		Dsn:           dsn,
+		Environment:   os.Getenv("SENTRY_ENVIRONMENT"),
+		HTTPTransport: outboundTransport,
+	})
+	if err != nil {
+		log.Printf("Error initializing Sentry, errors will only be logged: %v", err)
+		return func() {}
+	}
+	errorReportingEnabled = true
+	return func() { sentry.Flush(2 * time.Second) }
+}
+
+// reportPanic sends a recovered panic to the error tracker, tagged with the
+// request ID.
+func reportPanic(ctx context.Context, recovered interface{}) {
+	if !errorReportingEnabled {
+		return
+	}
+	hub := sentry.CurrentHub().Clone()
+	hub.ConfigureScope(func(scope *sentry.Scope) {
+		scope.SetTag("request_id", requestID(ctx))
+	})
+	hub.RecoverWithContext(ctx, recovered)
+}
+
+// reportError sends err to the error tracker with tags such as the article
+// and subscriber it concerns. Callers still log the error themselves.
+func reportError(ctx context.Context, err error, tags map[string]string) {
+	if !errorReportingEnabled || err == nil {
+		return
+	}
+	hub := sentry.CurrentHub().Clone()
+	hub.ConfigureScope(func(scope *sentry.Scope) {
+		if id := requestID(ctx); id != "" {
+			scope.SetTag("request_id", id)
+		}
+		scope.SetTags(tags)
+	})
+	hub.CaptureException(err)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"gopkg.in/gomail.v2"
+)
+
+type recordingTransport struct {
+	mu     sync.Mutex
+	events []*sentry.Event
+}
+
+func (t *recordingTransport) Configure(sentry.ClientOptions) {}
+func (t *recordingTransport) Flush(time.Duration) bool       { return true }
+func (t *recordingTransport) SendEvent(e *sentry.Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = append(t.events, e)
+}
+
+type failingSender struct{}
+
+func (failingSender) Send(context.Context, *gomail.Message) (string, error) {
+	return "", errors.New("535 authentication failed")
+}
+
+func TestRepeatedSendFailuresReportedOnce(t *testing.T) {
+	transport := &recordingTransport{}
+	if err := sentry.Init(sentry.ClientOptions{Dsn: "https://key@sentry.invalid/1", Transport: transport}); err != nil {
+		t.Fatal(err)
+	}
+	errorReportingEnabled = true
+	t.Cleanup(func() { errorReportingEnabled = false })
+	t.Setenv("ERROR_REPORT_SEND_FAILURES", "2")
+	t.Setenv("SEND_RETRY_BACKOFF", "0")
+
+	db := newTestDB(t)
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES (?, '')", email); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("INSERT INTO articles (title, content) VALUES ('Hello', '')"); err != nil {
+		t.Fatal(err)
+	}
+
+	sendNewsletterForArticle(context.Background(), db, failingSender{}, 1)
+
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+	stages := map[string]int{}
+	for _, e := range transport.events {
+		if e.Tags["article_id"] != "1" {
+			t.Errorf("tags = %v", e.Tags)
+		}
+		stages[e.Tags["stage"]]++
+	}
+	// One report for the run of send failures, one for the failed job.
+	if stages["send"] != 1 || stages["job"] != 1 || len(transport.events) != 2 {
+		t.Fatalf("reported stages %v", stages)
+	}
+}
+
+// selectiveSender fails every send except to the addresses in ok.
+type selectiveSender struct{ ok map[string]bool }
+
+func (s selectiveSender) Send(_ context.Context, m *gomail.Message) (string, error) {
+	if to := m.GetHeader("To"); len(to) > 0 && s.ok[to[0]] {
+		return "<ok@example.com>", nil
+	}
+	return "", errors.New("535 authentication failed")
+}
+
+func TestSendFailureStreaksReportedOncePerJob(t *testing.T) {
+	transport := &recordingTransport{}
+	if err := sentry.Init(sentry.ClientOptions{Dsn: "https://key@sentry.invalid/1", Transport: transport}); err != nil {
+		t.Fatal(err)
+	}
+	errorReportingEnabled = true
+	t.Cleanup(func() { errorReportingEnabled = false })
+	t.Setenv("ERROR_REPORT_SEND_FAILURES", "2")
+	t.Setenv("SEND_RETRY_BACKOFF", "0")
+
+	db := newTestDB(t)
+	// Two failures, a success, then two more failures.
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com", "d@example.com", "e@example.com"} {
+		if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES (?, '')", email); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("INSERT INTO articles (title, content) VALUES ('Hello', '')"); err != nil {
+		t.Fatal(err)
+	}
+
+	sendNewsletterForArticle(context.Background(), db, selectiveSender{ok: map[string]bool{"c@example.com": true}}, 1)
+
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+	sends := 0
+	for _, e := range transport.events {
+		if e.Tags["stage"] == "send" {
+			sends++
+		}
+	}
+	if sends != 1 {
+		t.Fatalf("%d send failure reports, want 1", sends)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// tableFingerprint summarizes a table's contents cheaply: its row count,
+// highest id, most recent value of each given timestamp column and the
+// total of each given expression. It changes whenever rows are inserted,
+// deleted, have those timestamps updated or change a totalled value.
+func tableFingerprint(db *sql.DB, table string, timeColumns, totals []string) (string, time.Time, error) {
+	cols := []string{"COUNT(*)", "COALESCE(MAX(id), 0)"}
+	for _, c := range timeColumns {
+		cols = append(cols, "COALESCE(MAX("+c+"), '')")
+	}
+	for _, e := range totals {
+		cols = append(cols, "TOTAL("+e+")")
+	}
+
+	values := make([]interface{}, len(cols))
+	strs := make([]string, len(cols))
+	for i := range values {
+		values[i] = &strs[i]
+	}
+	if err := db.QueryRow("SELECT " + strings.Join(cols, ", ") + " FROM " + table).Scan(values...); err != nil {
+		return "", time.Time{}, err
+	}
+
+	var latest time.Time
+	for _, s := range strs[2 : 2+len(timeColumns)] {
+		if t, err := time.Parse(sqliteTimeFormat, s); err == nil && t.After(latest) {
+			latest = t
+		}
+	}
+	return table + ":" + strings.Join(strs, ","), latest, nil
+}
+
+// computeETag combines fingerprints into a strong ETag value.
+func computeETag(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "|")))
+	return fmt.Sprintf("%q", hex.EncodeToString(sum[:16]))
+}
+
+// checkNotModified sets the ETag and Last-Modified headers and, when the
+// request's preconditions show the client already has this version, writes
+// 304 Not Modified and returns true.
+func checkNotModified(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				w.WriteHeader(http.StatusNotModified)
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		if t, err := http.ParseTime(ims); err == nil && !lastModified.Truncate(time.Second).After(t) {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// statsVersion returns the ETag and Last-Modified time for /api/stats
+// without loading the underlying rows.
+func statsVersion(db *sql.DB, includeSubscribers bool) (string, time.Time, error) {
+	parts := []string{fmt.Sprintf("subscribers-visible:%t", includeSubscribers)}
+	var latest time.Time
+	// Tier and engagement changes carry no timestamp, so they are totalled
+	// instead: weighting the premium flag by id makes any single change of
+	// tier move the total.
+	for _, t := range []struct {
+		table   string
+		columns []string
+		totals  []string
+	}{
+		{"subscribers", []string{"subscribed_at", "unsubscribed_at", "deleted_at"}, []string{"id * (tier = '" + tierPremium + "')", "engagement_score"}},
+		{"articles", []string{"published_at", "deleted_at", "updated_at"}, []string{"version"}},
+		{"sent_emails", []string{"sent_at", "status_updated_at"}, nil},
+	} {
+		fp, modified, err := tableFingerprint(db, t.table, t.columns, t.totals)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		parts = append(parts, fp)
+		if modified.After(latest) {
+			latest = modified
+		}
+	}
+	return computeETag(parts...), latest, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestStatsVersionTracksUpdates(t *testing.T) {
+	db := newTestDB(t)
+	for _, stmt := range []string{
+		"INSERT INTO subscribers (email, name) VALUES ('a@example.com', ''), ('b@example.com', '')",
+		"INSERT INTO articles (title, content) VALUES ('Hello', '')",
+		"INSERT INTO sent_emails (subscriber_id, article_id) VALUES (1, 1)",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	version := func() string {
+		etag, _, err := statsVersion(db, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return etag
+	}
+
+	prev := version()
+	for _, stmt := range []string{
+		"UPDATE subscribers SET tier = 'premium' WHERE id = 1",
+		"UPDATE subscribers SET tier = 'free' WHERE id = 1",
+		"UPDATE subscribers SET engagement_score = 0.5 WHERE id = 2",
+		"UPDATE articles SET title = 'Edited', version = version + 1 WHERE id = 1",
+		"UPDATE sent_emails SET delivery_status = 'bounced', status_updated_at = '2099-01-01 00:00:00' WHERE id = 1",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+		if v := version(); v == prev {
+			t.Errorf("ETag unchanged after %s", stmt)
+		} else {
+			prev = v
+		}
+	}
+}
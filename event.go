@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+// articleEvent returns the calendar event described by the article's event
+// fields. ok is false when the article is not an event.
+func articleEvent(a Article) (e icalEvent, ok bool, err error) {
+	if a.EventStart == "" {
+		if a.EventEnd != "" || a.EventLocation != "" {
+			return e, false, errors.New("event_end and event_location require event_start")
+		}
+		return e, false, nil
+	}
+	start, err := time.Parse(time.RFC3339, a.EventStart)
+	if err != nil {
+		return e, false, fmt.Errorf("invalid event_start, want RFC 3339: %w", err)
+	}
+	var end time.Time
+	if a.EventEnd != "" {
+		if end, err = time.Parse(time.RFC3339, a.EventEnd); err != nil {
+			return e, false, fmt.Errorf("invalid event_end, want RFC 3339: %w", err)
+		}
+		if !end.After(start) {
+			return e, false, errors.New("event_end must be after event_start")
+		}
+	}
+	return icalEvent{
+		UID:      fmt.Sprintf("article-%d@%s", a.ID, senderDomain()),
+		Start:    start,
+		End:      end,
+		Summary:  a.Title,
+		Location: a.EventLocation,
+		URL:      publicURL(""),
+	}, true, nil
+}
+
+// attachEvent attaches an invite.ics for event-style articles so readers
+// can add the event to their calendar.
+func attachEvent(m *gomail.Message, article Article) error {
+	e, ok, err := articleEvent(article)
+	if err != nil || !ok {
+		return err
+	}
+	ics := renderICal("PUBLISH", []icalEvent{e})
+	m.Attach("invite.ics",
+		gomail.SetHeader(map[string][]string{"Content-Type": {"text/calendar; charset=utf-8; method=PUBLISH"}}),
+		gomail.SetCopyFunc(func(w io.Writer) error {
+			_, err := io.WriteString(w, ics)
+			return err
+		}))
+	return nil
+}
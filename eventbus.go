@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// natsConn is a publish-only connection speaking the NATS client protocol,
+// which is line based: the server sends INFO, the client sends CONNECT and
+// then PUB commands, and PING/PONG confirms the server has processed them.
+type natsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// dialNATS connects to a nats:// or tls:// URL. Credentials in the URL
+// are sent as user and pass, or a lone user as an auth token.
+func dialNATS(rawURL string, timeout time.Duration) (*natsConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	switch u.Scheme {
+	case "nats":
+		conn, err = dialer.Dial("tcp", host)
+	case "tls":
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: u.Hostname()})
+	default:
+		return nil, fmt.Errorf("unsupported event bus scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &natsConn{conn: conn, r: bufio.NewReader(conn)}
+	conn.SetDeadline(time.Now().Add(timeout))
+	defer conn.SetDeadline(time.Time{})
+
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected greeting %q", strings.TrimSpace(line))
+	}
+	opts := map[string]interface{}{"verbose": false, "pedantic": false, "name": "blog-emailing"}
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			opts["user"], opts["pass"] = u.User.Username(), pass
+		} else {
+			opts["auth_token"] = u.User.Username()
+		}
+	}
+	b, _ := json.Marshal(opts)
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\n", b); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := c.flush(timeout); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *natsConn) publish(subject string, data []byte) error {
+	_, err := fmt.Fprintf(c.conn, "PUB %s %d\r\n%s\r\n", subject, len(data), data)
+	return err
+}
+
+// flush waits for the server to acknowledge everything sent so far,
+// answering its own pings on the way so an idle connection stays open.
+func (c *natsConn) flush(timeout time.Duration) error {
+	c.conn.SetDeadline(time.Now().Add(timeout))
+	defer c.conn.SetDeadline(time.Time{})
+	if _, err := c.conn.Write([]byte("PING\r\n")); err != nil {
+		return err
+	}
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := c.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New("event bus: " + strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+func (c *natsConn) close() error {
+	return c.conn.Close()
+}
+
+// eventPublisher relays rows from the events table to NATS. Reading the
+// table rather than hooking recordEvent means events from rolled-back
+// transactions are never published, and a broker outage only delays
+// delivery: the publisher resumes after the last event the broker
+// acknowledged. Events recorded before startup are not published.
+type eventPublisher struct {
+	db      *sql.DB
+	url     string
+	prefix  string
+	timeout time.Duration
+
+	conn   *natsConn
+	lastID int
+}
+
+// newEventPublisher returns a publisher for EVENT_BUS_URL, or nil when it
+// is not set. Events go to EVENT_BUS_SUBJECT (default newsletter.events)
+// followed by the event type, e.g. newsletter.events.opened.
+func newEventPublisher(db *sql.DB) (*eventPublisher, error) {
+	busURL := credential("EVENT_BUS_URL")
+	if busURL == "" {
+		return nil, nil
+	}
+	p := &eventPublisher{
+		db:      db,
+		url:     busURL,
+		prefix:  os.Getenv("EVENT_BUS_SUBJECT"),
+		timeout: getEnvDuration("EVENT_BUS_TIMEOUT", 5*time.Second),
+	}
+	if p.prefix == "" {
+		p.prefix = "newsletter.events"
+	}
+	if err := db.QueryRow("SELECT COALESCE(MAX(id), 0) FROM events").Scan(&p.lastID); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// run publishes new events every interval until the process exits.
+func (p *eventPublisher) run(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := p.publishPending(); err != nil {
+			log.Printf("Error publishing events: %v", err)
+		}
+	}
+}
+
+// publishPending sends events recorded since the last acknowledged one.
+// On error the connection is dropped and redialled on the next call.
+func (p *eventPublisher) publishPending() error {
+	if p.conn == nil {
+		conn, err := dialNATS(p.url, p.timeout)
+		if err != nil {
+			return err
+		}
+		p.conn = conn
+	}
+	err := p.publishBatch()
+	if err != nil {
+		p.conn.close()
+		p.conn = nil
+	}
+	return err
+}
+
+func (p *eventPublisher) publishBatch() error {
+	rows, err := p.db.Query(`
+		SELECT id, subscriber_id, type, article_id, detail, created_at
+		FROM events
+		WHERE id > ?
+		ORDER BY id
+		LIMIT 500`, p.lastID)
+	if err != nil {
+		return err
+	}
+	var events []Event
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		events = append(events, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err := p.conn.publish(p.prefix+"."+e.Type, data); err != nil {
+			return err
+		}
+	}
+	if err := p.conn.flush(p.timeout); err != nil {
+		return err
+	}
+	if len(events) > 0 {
+		p.lastID = events[len(events)-1].ID
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNATS accepts one connection and sends each published message on msgs.
+func fakeNATS(t *testing.T) (string, chan [2]string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	msgs := make(chan [2]string, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("INFO {}\r\n"))
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			switch {
+			case len(fields) == 0:
+			case fields[0] == "PING":
+				conn.Write([]byte("PONG\r\n"))
+			case fields[0] == "PUB" && len(fields) == 3:
+				n, _ := strconv.Atoi(fields[2])
+				payload := make([]byte, n+2)
+				if _, err := io.ReadFull(r, payload); err != nil {
+					return
+				}
+				msgs <- [2]string{fields[1], string(payload[:n])}
+			}
+		}
+	}()
+	return "nats://" + ln.Addr().String(), msgs
+}
+
+func TestEventPublisher(t *testing.T) {
+	db := newTestDB(t)
+	busURL, msgs := fakeNATS(t)
+	recordEvent(db, 1, eventSubscribed, 0, "")
+
+	t.Setenv("EVENT_BUS_URL", busURL)
+	p, err := newEventPublisher(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordEvent(db, 1, eventOpened, 7, "")
+	if err := p.publishPending(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-msgs:
+		if msg[0] != "newsletter.events.opened" {
+			t.Errorf("subject = %q", msg[0])
+		}
+		var e Event
+		if err := json.Unmarshal([]byte(msg[1]), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Type != eventOpened || e.ArticleID == nil || *e.ArticleID != 7 {
+			t.Errorf("event = %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no event published")
+	}
+	select {
+	case msg := <-msgs:
+		t.Errorf("unexpected second message %v; events before startup are not published", msg)
+	default:
+	}
+
+	if err := p.publishPending(); err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 0 {
+		t.Error("event published twice")
+	}
+}
+
+func TestEventPublisherDisabled(t *testing.T) {
+	t.Setenv("EVENT_BUS_URL", "")
+	if p, err := newEventPublisher(newTestDB(t)); p != nil || err != nil {
+		t.Fatalf("newEventPublisher = %v, %v", p, err)
+	}
+}
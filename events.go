@@ -0,0 +1,95 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	eventSubscribed   = "subscribed"
+	eventConfirmed    = "confirmed"
+	eventSent         = "sent"
+	eventOpened       = "opened"
+	eventClicked      = "clicked"
+	eventBounced      = "bounced"
+	eventUnsubscribed = "unsubscribed"
+	eventUpdated      = "updated"
+	eventReplied      = "replied"
+)
+
+// Event is one entry in a subscriber's activity feed.
+type Event struct {
+	ID           int       `json:"id"`
+	SubscriberID int       `json:"subscriber_id"`
+	Type         string    `json:"type"`
+	ArticleID    *int      `json:"article_id,omitempty"`
+	Detail       string    `json:"detail,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// recordEvent appends to a subscriber's activity feed. articleID 0 means
+// the event is not about an article. Failures are logged, not returned:
+// the feed must never break the action it records.
+func recordEvent(db execer, subscriberID int, eventType string, articleID int, detail string) {
+	var article interface{}
+	if articleID != 0 {
+		article = articleID
+	}
+	_, err := db.Exec("INSERT INTO events (subscriber_id, type, article_id, detail) VALUES (?, ?, ?, ?)",
+		subscriberID, eventType, article, detail)
+	if err != nil {
+		log.Printf("Error recording %s event for subscriber %d: %v", eventType, subscriberID, err)
+	}
+}
+
+func scanEvent(rows *sql.Rows) (Event, error) {
+	var e Event
+	var articleID sql.NullInt64
+	err := rows.Scan(&e.ID, &e.SubscriberID, &e.Type, &articleID, &e.Detail, scanTime(&e.CreatedAt))
+	if articleID.Valid {
+		id := int(articleID.Int64)
+		e.ArticleID = &id
+	}
+	return e, err
+}
+
+// handleSubscriberEvents returns a subscriber's activity feed in
+// chronological order, a page at a time.
+func handleSubscriberEvents(db *sql.DB) http.HandlerFunc {
+	const query = `
+		SELECT id, subscriber_id, type, article_id, detail, created_at
+		FROM events
+		WHERE subscriber_id = ? AND id > ?
+		ORDER BY id
+		LIMIT ?`
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid subscriber id", http.StatusBadRequest)
+			return
+		}
+		writePage(w, r, db, query, scanEvent, func(e Event) int { return e.ID }, id)
+	}
+}
+
+// deleteOldEvents removes activity older than cutoff.
+func deleteOldEvents(db *sql.DB, cutoff time.Time) (int64, error) {
+	result, err := db.Exec("DELETE FROM events WHERE created_at < ?", cutoff.Format(sqliteTimeFormat))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// eventDetail encodes structured event details as JSON.
+func eventDetail(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestSubscriberActivityFeed(t *testing.T) {
+	t.Setenv("DELIVERY_WEBHOOK_SECRET", "s3cret")
+	db := newTestDB(t)
+	sender := newMockSender("")
+	srv := newTestServer(t, db, sender)
+
+	postJSON(t, srv.URL+"/api/subscribe?ref=newsletter-swap", `{"email": "ada@example.com", "name": "Ada"}`)
+	if _, err := db.Exec("INSERT INTO articles (title, content) VALUES ('Hello', '')"); err != nil {
+		t.Fatal(err)
+	}
+	sendNewsletterForArticle(context.Background(), db, sender, 1)
+	postJSON(t, srv.URL+"/api/webhooks/delivery?token=s3cret", `{"sg_message_id": "mock-1", "event": "bounce"}`)
+	if _, err := setSubscriberTier(db, 1, tierPremium); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get(srv.URL + "/api/subscribers/1/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var page Page[Event]
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{eventSubscribed, eventSent, eventBounced, eventUpdated}
+	if len(page.Items) != len(want) {
+		t.Fatalf("events = %+v", page.Items)
+	}
+	for i, e := range page.Items {
+		if e.Type != want[i] {
+			t.Errorf("event %d = %s, want %s", i, e.Type, want[i])
+		}
+	}
+	if page.Items[1].ArticleID == nil || *page.Items[1].ArticleID != 1 {
+		t.Errorf("sent event article = %v", page.Items[1].ArticleID)
+	}
+}
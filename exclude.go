@@ -0,0 +1,111 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// Exclusion removes subscribers from an article's audience after its
+// normal targeting. A subscriber is left out if they are listed, have any
+// of the tags or match any of the segments.
+type Exclusion struct {
+	SubscriberIDs []int     `json:"subscriber_ids,omitempty"`
+	Tags          []string  `json:"tags,omitempty"`
+	Segments      []Segment `json:"segments,omitempty"`
+}
+
+func (e *Exclusion) empty() bool {
+	return e == nil || len(e.SubscriberIDs) == 0 && len(e.Tags) == 0 && len(e.Segments) == 0
+}
+
+func (e *Exclusion) validate() error {
+	if e == nil {
+		return nil
+	}
+	for _, tag := range e.Tags {
+		if normalizeTag(tag) == "" {
+			return errors.New("invalid exclude: tags must not be empty")
+		}
+	}
+	for _, s := range e.Segments {
+		if s.Tier != "" && !validTier(s.Tier) {
+			return errors.New("invalid exclude: tier must be free or premium")
+		}
+		if s == (Segment{}) {
+			return errors.New("invalid exclude: a segment must filter on something")
+		}
+	}
+	return nil
+}
+
+// where returns the SQL condition keeping the subscribers not excluded,
+// or "" if nothing is excluded.
+func (e *Exclusion) where() (string, []interface{}) {
+	if e.empty() {
+		return "", nil
+	}
+	var conds []string
+	var args []interface{}
+	if len(e.SubscriberIDs) > 0 {
+		conds = append(conds, "id NOT IN ("+placeholders(len(e.SubscriberIDs))+")")
+		for _, id := range e.SubscriberIDs {
+			args = append(args, id)
+		}
+	}
+	if len(e.Tags) > 0 {
+		conds = append(conds, "NOT EXISTS (SELECT 1 FROM subscriber_tags t WHERE t.subscriber_id = subscribers.id AND t.tag IN ("+placeholders(len(e.Tags))+"))")
+		for _, tag := range e.Tags {
+			args = append(args, normalizeTag(tag))
+		}
+	}
+	for _, s := range e.Segments {
+		where, segArgs := s.where()
+		conds = append(conds, "NOT ("+where+")")
+		args = append(args, segArgs...)
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// placeholders returns n comma-separated SQL placeholders.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// encodeExclusion returns the stored form of an exclusion: JSON, or "" if
+// it excludes nothing.
+func encodeExclusion(e *Exclusion) (string, error) {
+	if e.empty() {
+		return "", nil
+	}
+	b, err := json.Marshal(e)
+	return string(b), err
+}
+
+func decodeExclusion(s string) (*Exclusion, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var e Exclusion
+	if err := json.Unmarshal([]byte(s), &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// setArticleExclusion replaces an article's exclusion. Sends already made
+// are unaffected. It reports false if there is no such article.
+func setArticleExclusion(db *sql.DB, articleID int, e *Exclusion) (bool, error) {
+	encoded, err := encodeExclusion(e)
+	if err != nil {
+		return false, err
+	}
+	result, err := db.Exec("UPDATE articles SET exclude = ? WHERE id = ? AND deleted_at IS NULL", encoded, articleID)
+	if err != nil {
+		return false, err
+	}
+	invalidateArticle(db, articleID)
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestArticleExclusion(t *testing.T) {
+	db := newTestDB(t)
+	sender := newMockSender("")
+	srv := newTestServer(t, db, sender)
+
+	if _, err := db.Exec(`INSERT INTO subscribers (email, name, tier) VALUES
+		('ada@example.com', 'Ada', 'free'), ('grace@example.com', 'Grace', 'free'),
+		('linus@example.com', 'Linus', 'free'), ('ken@example.com', 'Ken', 'premium')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tagSubscriber(db, 1, "competitor"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO articles (title, content) VALUES ('We are hiring', '')"); err != nil {
+		t.Fatal(err)
+	}
+
+	postJSON(t, srv.URL+"/api/send-newsletter", `{"article_id":1,"exclude":{"subscriber_ids":[2],"tags":["Competitor"],"segments":[{"tier":"premium"}]}}`)
+	waitForMessages(t, sender, 1)
+	activeSends.Wait()
+	msgs := sender.Messages()
+	if len(msgs) != 1 || msgs[0].GetHeader("To")[0] != "linus@example.com" {
+		t.Fatalf("sent to %d subscribers; want only Linus", len(msgs))
+	}
+
+	article, err := getArticle(context.Background(), db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if article.Exclude == nil || len(article.Exclude.Tags) != 1 {
+		t.Fatalf("exclusion not stored: %+v", article.Exclude)
+	}
+}
+
+func TestExclusionValidation(t *testing.T) {
+	for _, e := range []*Exclusion{
+		{Tags: []string{" "}},
+		{Segments: []Segment{{}}},
+		{Segments: []Segment{{Tier: "gold"}}},
+	} {
+		if err := validateArticleOverrides(Article{Exclude: e}); err == nil {
+			t.Errorf("exclusion %+v accepted", e)
+		}
+	}
+}
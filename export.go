@@ -0,0 +1,74 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// exportFlushEvery is how many rows are written between flushes, so the
+// client sees progress without a flush per row.
+const exportFlushEvery = 500
+
+// streamNDJSON writes each row of query as one JSON line as it is scanned,
+// so exports use constant memory however large the table. Errors after the
+// first row can no longer change the status code, so they are reported as
+// a final {"error": ...} line.
+func streamNDJSON[T any](w http.ResponseWriter, r *http.Request, db *sql.DB, query string, scan func(*sql.Rows) (T, error)) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rows, err := db.QueryContext(r.Context(), query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	n := 0
+	for rows.Next() {
+		item, err := scan(rows)
+		if err == nil {
+			err = enc.Encode(item)
+		}
+		if err != nil {
+			log.Printf("Error exporting row %d: %v", n, err)
+			enc.Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		if n++; n%exportFlushEvery == 0 && flusher != nil {
+			flusher.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error exporting rows: %v", err)
+		enc.Encode(map[string]string{"error": err.Error()})
+	}
+}
+
+func handleExportSubscribers(db *sql.DB) http.HandlerFunc {
+	const query = "SELECT " + subscriberColumns + " FROM subscribers WHERE deleted_at IS NULL ORDER BY id"
+	return func(w http.ResponseWriter, r *http.Request) {
+		streamNDJSON(w, r, db, query, scanSubscriber)
+	}
+}
+
+func handleExportArticles(db *sql.DB) http.HandlerFunc {
+	const query = "SELECT " + articleColumns + " FROM articles WHERE deleted_at IS NULL ORDER BY id"
+	return func(w http.ResponseWriter, r *http.Request) {
+		streamNDJSON(w, r, db, query, scanArticle)
+	}
+}
+
+func handleExportSentEmails(db *sql.DB) http.HandlerFunc {
+	const query = "SELECT " + sentEmailColumns + " FROM sent_emails ORDER BY id"
+	return func(w http.ResponseWriter, r *http.Request) {
+		streamNDJSON(w, r, db, query, scanSentEmail)
+	}
+}
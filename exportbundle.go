@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportBundleReadme documents the bundle written by `export`.
+const exportBundleReadme = `# Newsletter export
+
+subscribers.csv
+  One row per subscriber: id, email, name, status (active or
+  unsubscribed), tier (free or premium), subscribed_at, unsubscribed_at
+  and source. Times are UTC, "YYYY-MM-DD HH:MM:SS".
+
+articles/<id>-<slug>.md
+  One file per article. YAML front matter holds id, title, published_at,
+  subject, series, premium, authors and excerpt; the body is the article
+  content.
+
+sends.ndjson
+  One JSON object per line for each email sent: id, subscriber_id,
+  article_id, sent_at, delivery_status, opened_at, open_count, clicked_at,
+  click_count and cost, the estimated delivery price. subscriber_id and article_id refer to the ids above.
+
+send_summaries.ndjson
+  Per-article totals (sent, imported, opened, clicked, bounced, cost) for sends
+  that were archived and are no longer listed individually in
+  sends.ndjson.
+`
+
+// runExport implements `export`, which writes the subscribers, articles and
+// send history to a directory in formats other tools can read.
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	dbPath := fs.String("db", databasePath(), "database to export")
+	out := fs.String("out", "", "directory to write the bundle to, must not exist")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *out == "" {
+		return errors.New("--out is required")
+	}
+	if _, err := os.Stat(*out); err == nil {
+		return fmt.Errorf("%s already exists", *out)
+	}
+
+	db := openDB(*dbPath)
+	defer db.Close()
+	if err := writeExportBundle(db, *out); err != nil {
+		os.RemoveAll(*out)
+		return err
+	}
+	log.Printf("Export written to %s", *out)
+	return nil
+}
+
+// writeExportBundle writes the bundle described by exportBundleReadme to
+// dir. Soft-deleted subscribers and articles are left out.
+func writeExportBundle(db *sql.DB, dir string) error {
+	if err := os.MkdirAll(filepath.Join(dir, "articles"), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte(exportBundleReadme), 0o644); err != nil {
+		return err
+	}
+	for _, step := range []struct {
+		name  string
+		write func(*sql.DB, string) error
+	}{
+		{"subscribers", exportSubscribersCSV},
+		{"articles", exportArticlesMarkdown},
+		{"sends", exportSendsNDJSON},
+		{"send summaries", exportSendSummariesNDJSON},
+	} {
+		if err := step.write(db, dir); err != nil {
+			return fmt.Errorf("exporting %s: %w", step.name, err)
+		}
+	}
+	return nil
+}
+
+func exportSubscribersCSV(db *sql.DB, dir string) error {
+	f, err := os.Create(filepath.Join(dir, "subscribers.csv"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rows, err := db.Query(`
+		SELECT id, email, COALESCE(name, ''), tier, source,
+			COALESCE(strftime('%Y-%m-%d %H:%M:%S', subscribed_at), ''),
+			COALESCE(strftime('%Y-%m-%d %H:%M:%S', unsubscribed_at), '')
+		FROM subscribers
+		WHERE deleted_at IS NULL
+		ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"id", "email", "name", "status", "tier", "subscribed_at", "unsubscribed_at", "source"})
+	for rows.Next() {
+		var id int
+		var email, name, tier, source, subscribedAt, unsubscribedAt string
+		if err := rows.Scan(&id, &email, &name, &tier, &source, &subscribedAt, &unsubscribedAt); err != nil {
+			return err
+		}
+		status := "active"
+		if unsubscribedAt != "" {
+			status = "unsubscribed"
+		}
+		w.Write([]string{strconv.Itoa(id), email, name, status, tier, subscribedAt, unsubscribedAt, source})
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// frontMatterString quotes s for YAML. JSON strings are valid YAML
+// double-quoted scalars.
+func frontMatterString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// articleMarkdown renders an article as Markdown with YAML front matter.
+func articleMarkdown(a Article, authors []Author) string {
+	var b strings.Builder
+	b.WriteString("---\n")
+	fmt.Fprintf(&b, "id: %d\n", a.ID)
+	fmt.Fprintf(&b, "title: %s\n", frontMatterString(a.Title))
+	fmt.Fprintf(&b, "published_at: %s\n", frontMatterString(a.PublishedAt.Format(time.RFC3339)))
+	if a.Subject != "" {
+		fmt.Fprintf(&b, "subject: %s\n", frontMatterString(a.Subject))
+	}
+	if a.Series != "" {
+		fmt.Fprintf(&b, "series: %s\n", frontMatterString(a.Series))
+	}
+	fmt.Fprintf(&b, "premium: %t\n", a.Premium)
+	if len(authors) > 0 {
+		b.WriteString("authors:\n")
+		for _, author := range authors {
+			fmt.Fprintf(&b, "  - %s\n", frontMatterString(author.Name))
+		}
+	}
+	if a.Excerpt != "" {
+		fmt.Fprintf(&b, "excerpt: %s\n", frontMatterString(a.Excerpt))
+	}
+	b.WriteString("---\n\n")
+	b.WriteString(a.Content)
+	if !strings.HasSuffix(a.Content, "\n") {
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func exportArticlesMarkdown(db *sql.DB, dir string) error {
+	rows, err := db.Query(`
+		SELECT id, title, content, published_at, subject, series, premium, excerpt
+		FROM articles
+		WHERE deleted_at IS NULL
+		ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	var articles []Article
+	for rows.Next() {
+		var a Article
+		if err := rows.Scan(&a.ID, &a.Title, &a.Content, scanTime(&a.PublishedAt), &a.Subject, &a.Series, &a.Premium, &a.Excerpt); err != nil {
+			rows.Close()
+			return err
+		}
+		articles = append(articles, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, a := range articles {
+		authors, err := getArticleAuthors(context.Background(), db, a.ID)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, "articles", articleSlug(a)+".md")
+		if err := os.WriteFile(path, []byte(articleMarkdown(a, authors)), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportedSend is one line of sends.ndjson.
+type ExportedSend struct {
+	ID             int     `json:"id"`
+	SubscriberID   int     `json:"subscriber_id"`
+	ArticleID      int     `json:"article_id"`
+	SentAt         string  `json:"sent_at"`
+	DeliveryStatus string  `json:"delivery_status"`
+	OpenedAt       string  `json:"opened_at,omitempty"`
+	OpenCount      int     `json:"open_count"`
+	ClickedAt      string  `json:"clicked_at,omitempty"`
+	ClickCount     int     `json:"click_count"`
+	Cost           float64 `json:"cost"`
+}
+
+// writeNDJSON writes the rows of query to name in dir, one JSON object per
+// line.
+func writeNDJSON[T any](db *sql.DB, dir, name, query string, scan func(*sql.Rows) (T, error)) error {
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	enc := json.NewEncoder(f)
+	for rows.Next() {
+		item, err := scan(rows)
+		if err != nil {
+			return err
+		}
+		if err := enc.Encode(item); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func exportSendsNDJSON(db *sql.DB, dir string) error {
+	const query = `
+		SELECT id, subscriber_id, article_id,
+			COALESCE(strftime('%Y-%m-%d %H:%M:%S', sent_at), ''), delivery_status,
+			COALESCE(strftime('%Y-%m-%d %H:%M:%S', opened_at), ''), open_count,
+			COALESCE(strftime('%Y-%m-%d %H:%M:%S', clicked_at), ''), click_count, cost
+		FROM sent_emails
+		ORDER BY id`
+	return writeNDJSON(db, dir, "sends.ndjson", query, func(rows *sql.Rows) (ExportedSend, error) {
+		var s ExportedSend
+		err := rows.Scan(&s.ID, &s.SubscriberID, &s.ArticleID, &s.SentAt, &s.DeliveryStatus,
+			&s.OpenedAt, &s.OpenCount, &s.ClickedAt, &s.ClickCount, &s.Cost)
+		return s, err
+	})
+}
+
+// ExportedSendSummary is one line of send_summaries.ndjson.
+type ExportedSendSummary struct {
+	ArticleID int     `json:"article_id"`
+	Sent      int     `json:"sent"`
+	Imported  int     `json:"imported"`
+	Opened    int     `json:"opened"`
+	Clicked   int     `json:"clicked"`
+	Bounced   int     `json:"bounced"`
+	Cost      float64 `json:"cost"`
+}
+
+func exportSendSummariesNDJSON(db *sql.DB, dir string) error {
+	const query = "SELECT article_id, sent, imported, opened, clicked, bounced, cost FROM sent_email_summaries ORDER BY article_id"
+	return writeNDJSON(db, dir, "send_summaries.ndjson", query, func(rows *sql.Rows) (ExportedSendSummary, error) {
+		var s ExportedSendSummary
+		err := rows.Scan(&s.ArticleID, &s.Sent, &s.Imported, &s.Opened, &s.Clicked, &s.Bounced, &s.Cost)
+		return s, err
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteExportBundle(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := db.Exec(`INSERT INTO subscribers (email, name, unsubscribed_at) VALUES
+		('ada@example.com', 'Ada', NULL),
+		('gone@example.com', '', CURRENT_TIMESTAMP)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO articles (title, content, series) VALUES ('Hello: "World"', 'Body text', 'weekly')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO sent_emails (subscriber_id, article_id, opened_at, open_count) VALUES (1, 1, CURRENT_TIMESTAMP, 2)"); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "bundle")
+	if err := writeExportBundle(db, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "subscribers.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[1][1] != "ada@example.com" || records[1][3] != "active" || records[2][3] != "unsubscribed" {
+		t.Errorf("subscribers.csv = %q", records)
+	}
+
+	md, err := os.ReadFile(filepath.Join(dir, "articles", "1-hello-world.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"---\nid: 1\n", `title: "Hello: \"World\""`, `series: "weekly"`, "---\n\nBody text\n"} {
+		if !strings.Contains(string(md), want) {
+			t.Errorf("article markdown missing %q:\n%s", want, md)
+		}
+	}
+
+	sends, err := os.ReadFile(filepath.Join(dir, "sends.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var send ExportedSend
+	if err := json.Unmarshal(sends, &send); err != nil {
+		t.Fatal(err)
+	}
+	if send.SubscriberID != 1 || send.OpenCount != 2 || send.OpenedAt == "" {
+		t.Errorf("send = %+v", send)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "README.md")); err != nil {
+		t.Error(err)
+	}
+}
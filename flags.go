@@ -0,0 +1,148 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// featureFlag is a behavior that can be switched at runtime from the admin
+// API. Flags gate features that are otherwise configured, so turning one
+// on does nothing unless the feature's own settings are present.
+type featureFlag struct {
+	description string
+	def         bool
+}
+
+const (
+	flagTracking       = "tracking_enabled"
+	flagAMP            = "amp_enabled"
+	flagScheduledSends = "scheduled_sends_enabled"
+)
+
+var featureFlags = map[string]featureFlag{
+	flagTracking:       {"Add open and click tracking to newsletters", true},
+	flagAMP:            {"Send the AMP part of newsletters", true},
+	flagScheduledSends: {"Send scheduled newsletters when they fall due", true},
+}
+
+// flagValues caches the stored flag values. Flags without a stored value
+// use their default.
+var flagValues = struct {
+	sync.RWMutex
+	m map[string]bool
+}{m: map[string]bool{}}
+
+// loadFeatureFlags reads the stored flag values into the cache.
+func loadFeatureFlags(db *sql.DB) error {
+	rows, err := db.Query("SELECT name, enabled FROM feature_flags")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	m := map[string]bool{}
+	for rows.Next() {
+		var name string
+		var enabled bool
+		if err := rows.Scan(&name, &enabled); err != nil {
+			return err
+		}
+		m[name] = enabled
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	flagValues.Lock()
+	flagValues.m = m
+	flagValues.Unlock()
+	return nil
+}
+
+// flagEnabled reports whether the named flag is on.
+func flagEnabled(name string) bool {
+	flagValues.RLock()
+	enabled, ok := flagValues.m[name]
+	flagValues.RUnlock()
+	if !ok {
+		return featureFlags[name].def
+	}
+	return enabled
+}
+
+// setFeatureFlag stores a flag value and updates the cache.
+func setFeatureFlag(db *sql.DB, name string, enabled bool) error {
+	_, err := db.Exec(`
+		INSERT INTO feature_flags (name, enabled) VALUES (?, ?)
+		ON CONFLICT (name) DO UPDATE SET enabled = excluded.enabled, updated_at = CURRENT_TIMESTAMP`,
+		name, enabled)
+	if err != nil {
+		return err
+	}
+	flagValues.Lock()
+	flagValues.m[name] = enabled
+	flagValues.Unlock()
+	return nil
+}
+
+// FeatureFlag is a flag as listed by the admin API.
+type FeatureFlag struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Enabled     bool   `json:"enabled"`
+	Default     bool   `json:"default"`
+}
+
+func listFeatureFlags() []FeatureFlag {
+	flags := make([]FeatureFlag, 0, len(featureFlags))
+	for name, f := range featureFlags {
+		flags = append(flags, FeatureFlag{name, f.description, flagEnabled(name), f.def})
+	}
+	sort.Slice(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+	return flags
+}
+
+// handleFeatureFlags lists the flags (GET) or sets one (POST with
+// {"name": ..., "enabled": ...}).
+func handleFeatureFlags(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var req struct {
+				Name    string `json:"name"`
+				Enabled *bool  `json:"enabled"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if _, ok := featureFlags[req.Name]; !ok {
+				http.Error(w, "Unknown flag", http.StatusBadRequest)
+				return
+			}
+			if req.Enabled == nil {
+				http.Error(w, "enabled is required", http.StatusBadRequest)
+				return
+			}
+			before := flagEnabled(req.Name)
+			if err := setFeatureFlag(db, req.Name, *req.Enabled); err != nil {
+				log.Printf("Error setting feature flag %s: %v", req.Name, err)
+				http.Error(w, "Error setting flag", http.StatusInternalServerError)
+				return
+			}
+			recordAudit(db, r, "set", "feature_flag", 0, map[string]interface{}{
+				"name":    req.Name,
+				"enabled": map[string]bool{"from": before, "to": *req.Enabled},
+			})
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(listFeatureFlags())
+	}
+}
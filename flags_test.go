@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestFeatureFlagToggle(t *testing.T) {
+	db := newTestDB(t)
+	srv := newTestServer(t, db, &mockSender{})
+
+	if !flagEnabled(flagTracking) {
+		t.Fatal("tracking flag should default to on")
+	}
+	postJSON(t, srv.URL+"/api/admin/flags", `{"name":"tracking_enabled","enabled":false}`)
+	if flagEnabled(flagTracking) {
+		t.Fatal("tracking flag still on after disabling")
+	}
+
+	// The stored value survives a reload of the cache.
+	if err := loadFeatureFlags(db); err != nil {
+		t.Fatal(err)
+	}
+	if flagEnabled(flagTracking) {
+		t.Fatal("tracking flag on after reload")
+	}
+
+	resp, err := http.Get(srv.URL + "/api/admin/flags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var flags []FeatureFlag
+	if err := json.NewDecoder(resp.Body).Decode(&flags); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range flags {
+		if f.Name == flagTracking && (f.Enabled || !f.Default) {
+			t.Fatalf("listed %+v", f)
+		}
+	}
+
+	resp, err = http.Post(srv.URL+"/api/admin/flags", "application/json", strings.NewReader(`{"name":"nope","enabled":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unknown flag: status %d", resp.StatusCode)
+	}
+}
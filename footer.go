@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// footerTemplate is the compliance footer added to every newsletter after
+// its template is rendered, so a template edit cannot drop it.
+var footerTemplate = template.Must(template.New("footer").Parse(`
+<div class="email-footer" style="margin-top:32px;padding-top:16px;border-top:1px solid #d0d7de;font-size:12px;line-height:1.5">
+{{- if .Reason}}<p>{{.Reason}}</p>{{end}}
+{{- if .Forward}}<p><a href="{{.Forward}}">{{.ForwardText}}</a></p>{{end}}
+{{- if .Unsubscribe}}<p><a href="{{.Preferences}}">Manage preferences</a> | <a href="{{.Unsubscribe}}">Unsubscribe</a></p>{{end}}
+{{- if .Address}}<p>{{.Address}}</p>{{end}}
+</div>
+`))
+
+// footerReason returns the "why you're receiving this" line
+// (FOOTER_REASON).
+func footerReason() string {
+	if reason := os.Getenv("FOOTER_REASON"); reason != "" {
+		return reason
+	}
+	if name := os.Getenv("NEWSLETTER_NAME"); name != "" {
+		return "You are receiving this email because you subscribed to " + name + "."
+	}
+	return "You are receiving this email because you subscribed to our newsletter."
+}
+
+// renderFooter renders the compliance footer: the reason line, the
+// optional "forward to a friend" link, the subscriber's preference center
+// and unsubscribe links and the sender's physical mailing address
+// (FOOTER_ADDRESS), which CAN-SPAM requires.
+func renderFooter(subscriberID, articleID int) (string, error) {
+	var b bytes.Buffer
+	err := footerTemplate.Execute(&b, map[string]string{
+		"Reason":      footerReason(),
+		"Unsubscribe": unsubscribeURL(subscriberID, articleID),
+		"Preferences": preferencesURL(subscriberID, articleID),
+		"Forward":     forwardURL(subscriberID, articleID),
+		"ForwardText": forwardLinkText(),
+		"Address":     os.Getenv("FOOTER_ADDRESS"),
+	})
+	return b.String(), err
+}
+
+// checkFooter fails the send when the compliance footer would be missing
+// the mailing address or unsubscribe link. It only runs with
+// FOOTER_REQUIRED=true.
+func checkFooter() (PreflightResult, bool) {
+	if required, _ := strconv.ParseBool(os.Getenv("FOOTER_REQUIRED")); !required {
+		return PreflightResult{}, false
+	}
+	result := PreflightResult{Check: "footer", Status: checkOK}
+	var missing []string
+	if os.Getenv("FOOTER_ADDRESS") == "" {
+		missing = append(missing, "FOOTER_ADDRESS is not set")
+	}
+	if !trackingEnabled() {
+		missing = append(missing, "unsubscribe links need PUBLIC_BASE_URL and TRACKING_SECRET or TRACKING_KEYS")
+	}
+	if len(missing) > 0 {
+		result.Status, result.Detail = checkFail, strings.Join(missing, "; ")
+	}
+	return result, true
+}
+
+// injectFooter inserts footer before the closing </body> tag, or appends it
+// when the body has none.
+func injectFooter(body, footer string) string {
+	if i := strings.LastIndex(strings.ToLower(body), "</body>"); i >= 0 {
+		return body[:i] + footer + body[i:]
+	}
+	return body + footer
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestFooterAndUnsubscribe(t *testing.T) {
+	t.Setenv("TRACKING_SECRET", "secret")
+	t.Setenv("FOOTER_ADDRESS", "1 Main St, Springfield")
+	db := newTestDB(t)
+	sender := newMockSender("")
+	srv := newTestServer(t, db, sender)
+	t.Setenv("PUBLIC_BASE_URL", srv.URL)
+
+	if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES ('ada@example.com', 'Ada')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO articles (title, content) VALUES ('Hello', '')"); err != nil {
+		t.Fatal(err)
+	}
+	sendNewsletterForArticle(context.Background(), db, sender, 1)
+
+	m := sender.Messages()[0]
+	var b strings.Builder
+	m.WriteTo(&b)
+	msg := strings.ReplaceAll(b.String(), "=\r\n", "")
+	for _, want := range []string{"1 Main St, Springfield", "You are receiving this email because"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("footer missing %q", want)
+		}
+	}
+	unsub := m.GetHeader("List-Unsubscribe")
+	if len(unsub) != 1 || !strings.Contains(msg, "/u/1.1.") {
+		t.Fatalf("List-Unsubscribe = %v", unsub)
+	}
+	link := strings.Trim(unsub[0], "<>")
+
+	// Opening the link does not unsubscribe; the one-click POST does.
+	resp, err := http.Get(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if activeCount(t, db) != 1 {
+		t.Fatal("GET unsubscribed")
+	}
+	resp, err = http.Post(link, "application/x-www-form-urlencoded", strings.NewReader("List-Unsubscribe=One-Click"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || activeCount(t, db) != 0 {
+		t.Fatalf("POST: status %d, still subscribed", resp.StatusCode)
+	}
+
+	resp, err = http.Post(srv.URL+"/u/1.1.forged", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("forged token: status %d", resp.StatusCode)
+	}
+}
+
+func activeCount(t *testing.T, db *sql.DB) int {
+	t.Helper()
+	n, err := activeSubscriberCount(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// forwardSource is the acquisition source of friends who subscribe from a
+// forwarded newsletter.
+const forwardSource = "forward"
+
+// forwardEnabled reports whether newsletters carry a "forward to a friend"
+// link (FORWARD_TO_FRIEND_ENABLED).
+func forwardEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("FORWARD_TO_FRIEND_ENABLED"))
+	return enabled
+}
+
+// forwardURL returns the subscriber's "forward to a friend" link for the
+// article, or "" when the feature is off or links cannot be signed.
+func forwardURL(subscriberID, articleID int) string {
+	if !forwardEnabled() || !trackingEnabled() || subscriberID == 0 {
+		return ""
+	}
+	return publicURL("f/" + trackingToken(subscriberID, articleID, "forward"))
+}
+
+// forwardLinkText is the footer link's text (FORWARD_LINK_TEXT).
+func forwardLinkText() string {
+	if text := os.Getenv("FORWARD_LINK_TEXT"); text != "" {
+		return text
+	}
+	return "Forward to a friend"
+}
+
+// handleForward serves the page a forwarded newsletter links to. It shows
+// the article and a signup form (GET); a signup (POST) is attributed to
+// the subscriber who forwarded it.
+func handleForward(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !forwardEnabled() {
+			http.NotFound(w, r)
+			return
+		}
+		referrerID, articleID, ok := parseTrackingToken(r.PathValue("token"), "forward")
+		if !ok {
+			http.Error(w, "Invalid link", http.StatusBadRequest)
+			return
+		}
+		article, err := getArticle(r.Context(), db, articleID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error loading article %d: %v", articleID, err)
+			http.Error(w, "Error loading article", http.StatusInternalServerError)
+			return
+		}
+
+		data := map[string]interface{}{
+			"SiteName": os.Getenv("NEWSLETTER_NAME"),
+			"Title":    article.Title,
+			"Excerpt":  article.Excerpt,
+		}
+		if article.ID != 0 && !article.Premium {
+			data["URL"] = articleURL(article)
+		}
+		if r.Method == http.MethodPost {
+			if err := r.ParseForm(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			sub := Subscriber{
+				Email: strings.TrimSpace(r.PostForm.Get("email")),
+				Name:  strings.TrimSpace(r.PostForm.Get("name")),
+			}
+			if err := validateEmailAddress(sub.Email); err != nil {
+				data["Error"] = err.Error()
+			} else if _, err := subscribe(db, r, sub, forwardSource, referrerID); err != nil {
+				// The friend may already be subscribed. Either way, do not
+				// reveal whether an address is on the list.
+				log.Printf("Error subscribing forwarded reader: %v", err)
+				data["Subscribed"] = true
+			} else {
+				data["Subscribed"] = true
+			}
+		}
+
+		var page bytes.Buffer
+		if err := renderPage(r.Context(), &page, "forward.html", data); err != nil {
+			log.Printf("Error rendering forward page: %v", err)
+			http.Error(w, "Error rendering page", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(page.Bytes())
+	}
+}
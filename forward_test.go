@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestForwardToFriend(t *testing.T) {
+	t.Setenv("TRACKING_SECRET", "secret")
+	t.Setenv("FORWARD_TO_FRIEND_ENABLED", "true")
+	db := newTestDB(t)
+	sender := newMockSender("")
+	srv := newTestServer(t, db, sender)
+	t.Setenv("PUBLIC_BASE_URL", srv.URL)
+
+	if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES ('ada@example.com', 'Ada')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO articles (title, content) VALUES ('Hello', 'Worth sharing')"); err != nil {
+		t.Fatal(err)
+	}
+	sendNewsletterForArticle(context.Background(), db, sender, 1)
+	msgs := sender.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("sent %d emails; want 1", len(msgs))
+	}
+	var b strings.Builder
+	msgs[0].WriteTo(&b)
+	if !strings.Contains(b.String(), "Forward to a friend") {
+		t.Fatal("newsletter lacks the forward link")
+	}
+
+	link := forwardURL(1, 1)
+	resp, err := http.Get(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(page), "Hello") {
+		t.Fatalf("forward page = %d:\n%s", resp.StatusCode, page)
+	}
+
+	resp, err = http.PostForm(link, url.Values{"email": {"grace@example.com"}, "name": {"Grace"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("subscribing from forward page = %d", resp.StatusCode)
+	}
+
+	var source string
+	var referredBy int
+	if err := db.QueryRow("SELECT source, referred_by FROM subscribers WHERE email = 'grace@example.com'").Scan(&source, &referredBy); err != nil {
+		t.Fatal(err)
+	}
+	if source != forwardSource || referredBy != 1 {
+		t.Fatalf("source %q, referred_by %d; want %q, 1", source, referredBy, forwardSource)
+	}
+	referrers, err := getTopReferrers(db, maxTopReferrers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(referrers) != 1 || referrers[0].Email != "ada@example.com" || referrers[0].Referrals != 1 {
+		t.Fatalf("referrers = %+v", referrers)
+	}
+}
+
+func TestStatsHideReferrerEmailsWithoutSubscribersPermission(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := db.Exec("INSERT INTO subscribers (id, email, name) VALUES (1, 'ada@example.com', 'Ada')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO subscribers (email, name, referred_by) VALUES ('grace@example.com', 'Grace', 1)"); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/stats", nil)
+	req = req.WithContext(context.WithValue(req.Context(), principalContextKey, principal{Name: "ci", Permissions: map[permission]bool{permRead: true}}))
+	rec := httptest.NewRecorder()
+	handleGetAllData(db)(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("stats = %d: %s", rec.Code, rec.Body.String())
+	}
+	body := rec.Body.String()
+	if strings.Contains(body, "ada@example.com") {
+		t.Fatalf("read-only stats leaked a referrer email: %s", body)
+	}
+	if !strings.Contains(body, `"subscriber_id":1,"referrals":1`) {
+		t.Fatalf("read-only stats lost the referrer counts: %s", body)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// icalEvent is one VEVENT of an iCalendar document.
+type icalEvent struct {
+	UID         string
+	Start, End  time.Time
+	Summary     string
+	Description string
+	Location    string
+	URL         string
+}
+
+const icalTimeFormat = "20060102T150405Z"
+
+// icalEscape escapes a TEXT value (RFC 5545, section 3.3.11).
+func icalEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// icalLine writes a content line, folded at 75 octets without splitting
+// UTF-8 sequences.
+func icalLine(b *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		limit = 74 // continuation lines start with a space
+	}
+	b.WriteString(line + "\r\n")
+}
+
+// renderICal returns an iCalendar document containing events.
+func renderICal(method string, events []icalEvent) string {
+	var b strings.Builder
+	icalLine(&b, "BEGIN:VCALENDAR")
+	icalLine(&b, "VERSION:2.0")
+	icalLine(&b, "PRODID:-//blog-emailing//EN")
+	icalLine(&b, "CALSCALE:GREGORIAN")
+	if method != "" {
+		icalLine(&b, "METHOD:"+method)
+	}
+	stamp := time.Now().UTC().Format(icalTimeFormat)
+	for _, e := range events {
+		icalLine(&b, "BEGIN:VEVENT")
+		icalLine(&b, "UID:"+e.UID)
+		icalLine(&b, "DTSTAMP:"+stamp)
+		icalLine(&b, "DTSTART:"+e.Start.UTC().Format(icalTimeFormat))
+		if !e.End.IsZero() {
+			icalLine(&b, "DTEND:"+e.End.UTC().Format(icalTimeFormat))
+		}
+		icalLine(&b, "SUMMARY:"+icalEscape(e.Summary))
+		if e.Description != "" {
+			icalLine(&b, "DESCRIPTION:"+icalEscape(e.Description))
+		}
+		if e.Location != "" {
+			icalLine(&b, "LOCATION:"+icalEscape(e.Location))
+		}
+		if e.URL != "" {
+			icalLine(&b, "URL:"+e.URL)
+		}
+		icalLine(&b, "END:VEVENT")
+	}
+	icalLine(&b, "END:VCALENDAR")
+	return b.String()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// markArticleSent records article as already sent to every subscriber who
+// has not received it, so the send pipeline never emails it. It returns the
+// number of subscribers marked.
+func markArticleSent(db *sql.DB, articleID int) (int64, error) {
+	result, err := db.Exec(`
+		INSERT INTO sent_emails (subscriber_id, article_id, delivery_status)
+		SELECT s.id, ?, ?
+		FROM subscribers s
+		WHERE NOT EXISTS (
+			SELECT 1 FROM sent_emails e
+			WHERE e.subscriber_id = s.id AND e.article_id = ?
+		)
+		ON CONFLICT (subscriber_id, article_id) DO NOTHING`, articleID, deliveryImported, articleID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// handleMarkSent marks an existing article as already sent to all current
+// subscribers, for back-catalog posts that were published without
+// suppress_send or mark_sent.
+func handleMarkSent(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid article id", http.StatusBadRequest)
+			return
+		}
+		if _, err := getArticle(r.Context(), db, id); errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Article not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		n, err := markArticleSent(db, id)
+		if err != nil {
+			http.Error(w, "Error marking article sent", http.StatusInternalServerError)
+			return
+		}
+		recordAudit(db, r, "mark_sent", "article", id, map[string]int64{"subscribers": n})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int64{"marked": n})
+	}
+}
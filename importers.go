@@ -0,0 +1,228 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// importedSubscriber is one row of a platform export, mapped onto this
+// service's fields. A zero UnsubscribedAt means the subscriber is active.
+type importedSubscriber struct {
+	Email          string
+	Name           string
+	Tier           string
+	SubscribedAt   time.Time
+	UnsubscribedAt time.Time
+}
+
+// subscriberImporter maps one CSV row, keyed by header, to a subscriber.
+// It returns false for rows that should be skipped.
+type subscriberImporter func(row map[string]string) (importedSubscriber, bool)
+
+// subscriberImporters are the supported export formats.
+var subscriberImporters = map[string]subscriberImporter{
+	"mailchimp":  importMailchimpRow,
+	"buttondown": importButtondownRow,
+	"substack":   importSubstackRow,
+}
+
+// parseExportTime parses the timestamp formats the platforms export.
+func parseExportTime(s string) time.Time {
+	t, _ := parseTimestamp(s)
+	return t
+}
+
+// importMailchimpRow reads Mailchimp's audience export. Subscribed,
+// unsubscribed and cleaned members come in separate files with the same
+// columns plus UNSUB_TIME or CLEAN_TIME; cleaned addresses bounced, so
+// they are imported as unsubscribed.
+func importMailchimpRow(row map[string]string) (importedSubscriber, bool) {
+	s := importedSubscriber{
+		Email:        row["Email Address"],
+		Name:         strings.TrimSpace(row["First Name"] + " " + row["Last Name"]),
+		Tier:         tierFree,
+		SubscribedAt: parseExportTime(row["OPTIN_TIME"]),
+	}
+	if t := parseExportTime(row["CONFIRM_TIME"]); !t.IsZero() {
+		s.SubscribedAt = t
+	}
+	for _, col := range []string{"UNSUB_TIME", "CLEAN_TIME"} {
+		if t := parseExportTime(row[col]); !t.IsZero() {
+			s.UnsubscribedAt = t
+		}
+	}
+	return s, s.Email != ""
+}
+
+// importButtondownRow reads Buttondown's subscriber export. Unactivated
+// subscribers never confirmed and are skipped.
+func importButtondownRow(row map[string]string) (importedSubscriber, bool) {
+	s := importedSubscriber{
+		Email:        row["email"],
+		Tier:         tierFree,
+		SubscribedAt: parseExportTime(row["creation_date"]),
+	}
+	switch row["subscriber_type"] {
+	case "unactivated", "spammy":
+		return s, false
+	case "premium", "gifted", "churning", "past_due":
+		s.Tier = tierPremium
+	case "unsubscribed", "removed":
+		// The export does not say when; the import time stands in.
+		s.UnsubscribedAt = time.Now().UTC()
+	}
+	return s, s.Email != ""
+}
+
+// importSubstackRow reads Substack's email_list export. Paid and comped
+// subscriptions with active_subscription set become premium; a disabled
+// email means the reader unsubscribed.
+func importSubstackRow(row map[string]string) (importedSubscriber, bool) {
+	s := importedSubscriber{
+		Email:        row["email"],
+		Tier:         tierFree,
+		SubscribedAt: parseExportTime(row["created_at"]),
+	}
+	if active, _ := strconv.ParseBool(row["active_subscription"]); active && row["plan"] != "free" {
+		s.Tier = tierPremium
+	}
+	if disabled, _ := strconv.ParseBool(row["email_disabled"]); disabled {
+		s.UnsubscribedAt = time.Now().UTC()
+	}
+	return s, s.Email != ""
+}
+
+// ImportSummary counts the outcome of an import.
+type ImportSummary struct {
+	Imported     int
+	Unsubscribed int
+	Existing     int
+	Invalid      int
+	Skipped      int
+}
+
+// importSubscribers reads a platform's CSV export and inserts its
+// subscribers in one transaction, keeping their signup dates. Addresses
+// already present are left alone. Each imported subscriber gets a
+// subscribed event with the platform as its source.
+func importSubscribers(db *sql.DB, format string, in io.Reader) (ImportSummary, error) {
+	var summary ImportSummary
+	mapRow, ok := subscriberImporters[format]
+	if !ok {
+		return summary, fmt.Errorf("unknown import format %q", format)
+	}
+	r := csv.NewReader(in)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return summary, fmt.Errorf("reading header: %w", err)
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff"))
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return summary, err
+	}
+	defer tx.Rollback()
+
+	source := "import:" + format
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return summary, fmt.Errorf("line %d: %w", line, err)
+		}
+		row := make(map[string]string, len(header))
+		for i, v := range record {
+			if i < len(header) {
+				row[header[i]] = strings.TrimSpace(v)
+			}
+		}
+		s, ok := mapRow(row)
+		if !ok {
+			summary.Skipped++
+			continue
+		}
+		if err := validateEmailAddress(s.Email); err != nil {
+			summary.Invalid++
+			continue
+		}
+		if s.SubscribedAt.IsZero() {
+			s.SubscribedAt = time.Now().UTC()
+		}
+		var unsubscribedAt interface{}
+		if !s.UnsubscribedAt.IsZero() {
+			unsubscribedAt = s.UnsubscribedAt.Format(sqliteTimeFormat)
+		}
+		result, err := tx.Exec(`
+			INSERT INTO subscribers (email, name, tier, source, subscribed_at, unsubscribed_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (email) DO NOTHING`,
+			s.Email, s.Name, s.Tier, source, s.SubscribedAt.Format(sqliteTimeFormat), unsubscribedAt)
+		if err != nil {
+			return summary, fmt.Errorf("line %d: %w", line, err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			summary.Existing++
+			continue
+		}
+		id, _ := result.LastInsertId()
+		recordEvent(tx, int(id), eventSubscribed, 0, eventDetail(map[string]string{"source": source}))
+		summary.Imported++
+		if unsubscribedAt != nil {
+			summary.Unsubscribed++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return summary, err
+	}
+	invalidateSubscriberCount(db)
+	return summary, nil
+}
+
+// runImport implements `import`, which loads subscribers from another
+// newsletter platform's CSV export.
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	dbPath := fs.String("db", databasePath(), "database to import into")
+	format := fs.String("format", "", "export format: mailchimp, buttondown or substack")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("usage: import --format FORMAT FILE.csv...")
+	}
+	if _, ok := subscriberImporters[*format]; !ok {
+		return fmt.Errorf("--format must be mailchimp, buttondown or substack")
+	}
+
+	db := openDB(*dbPath)
+	defer db.Close()
+	for _, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		summary, err := importSubscribers(db, *format, f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		log.Printf("%s: imported %d subscribers (%d unsubscribed), %d already present, %d invalid, %d skipped",
+			path, summary.Imported, summary.Unsubscribed, summary.Existing, summary.Invalid, summary.Skipped)
+	}
+	return nil
+}
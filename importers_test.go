@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestImportSubscribers(t *testing.T) {
+	tests := []struct {
+		format string
+		csv    string
+	}{
+		{"mailchimp", "\ufeffEmail Address,First Name,Last Name,OPTIN_TIME,CONFIRM_TIME,UNSUB_TIME\n" +
+			"ada@example.com,Ada,Lovelace,2020-01-02 03:04:05,2020-01-02 03:05:00,\n" +
+			"gone@example.com,Gone,,2020-01-02 03:04:05,,2021-06-01 00:00:00\n" +
+			"not-an-email,,,,,\n"},
+		{"buttondown", "email,creation_date,subscriber_type\n" +
+			"ada@example.com,2020-01-02T03:05:00.123456Z,premium\n" +
+			"gone@example.com,2020-01-02T03:04:05Z,unsubscribed\n" +
+			"pending@example.com,2020-01-02T03:04:05Z,unactivated\n"},
+		{"substack", "email,active_subscription,plan,email_disabled,created_at\n" +
+			"ada@example.com,true,yearly,false,2020-01-02T03:05:00.000Z\n" +
+			"gone@example.com,false,free,true,2020-01-02T03:04:05.000Z\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			db := newTestDB(t)
+			summary, err := importSubscribers(db, tt.format, strings.NewReader(tt.csv))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if summary.Imported != 2 || summary.Unsubscribed != 1 {
+				t.Fatalf("summary = %+v", summary)
+			}
+
+			var subscribedAt, source string
+			var unsubscribed bool
+			err = db.QueryRow("SELECT strftime('%Y-%m-%d %H:%M:%S', subscribed_at), source, unsubscribed_at IS NOT NULL FROM subscribers WHERE email = 'ada@example.com'").
+				Scan(&subscribedAt, &source, &unsubscribed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if subscribedAt != "2020-01-02 03:05:00" || source != "import:"+tt.format || unsubscribed {
+				t.Errorf("ada = %s, %s, unsubscribed %v", subscribedAt, source, unsubscribed)
+			}
+			if err := db.QueryRow("SELECT unsubscribed_at IS NOT NULL FROM subscribers WHERE email = 'gone@example.com'").Scan(&unsubscribed); err != nil || !unsubscribed {
+				t.Errorf("gone@example.com unsubscribed = %v, %v", unsubscribed, err)
+			}
+
+			again, err := importSubscribers(db, tt.format, strings.NewReader(tt.csv))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again.Imported != 0 || again.Existing != 2 {
+				t.Errorf("reimport summary = %+v", again)
+			}
+		})
+	}
+}
+
+func TestImportSubscribersTier(t *testing.T) {
+	db := newTestDB(t)
+	csv := "email,active_subscription,plan,email_disabled,created_at\n" +
+		"paid@example.com,true,monthly,false,\n" +
+		"comp@example.com,false,comp,false,\n"
+	if _, err := importSubscribers(db, "substack", strings.NewReader(csv)); err != nil {
+		t.Fatal(err)
+	}
+	var premium int
+	if err := db.QueryRow("SELECT COUNT(*) FROM subscribers WHERE tier = 'premium'").Scan(&premium); err != nil {
+		t.Fatal(err)
+	}
+	if premium != 1 {
+		t.Errorf("premium subscribers = %d, want 1", premium)
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+// newTestDB returns a migrated in-memory database. A single connection is
+// used so every query sees the same in-memory database.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	createTables(db)
+	migrateTables(db)
+	normalizeTimestamps(db)
+	migratePushOnly(db)
+	if err := initCounters(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadFeatureFlags(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadMaintenance(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadCredentials(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newTestServer(t *testing.T, db *sql.DB, sender EmailSender) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(newMux(db, sender, &authenticator{db: db}, periodicTasks(db, sender)))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func postJSON(t *testing.T, url, body string) {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST %s: status %d", url, resp.StatusCode)
+	}
+}
+
+func waitForMessages(t *testing.T, sender *mockSender, n int) []*gomail.Message {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if msgs := sender.Messages(); len(msgs) >= n {
+			return msgs
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d messages, got %d", n, len(sender.Messages()))
+	return nil
+}
+
+func countSentEmails(t *testing.T, db *sql.DB, articleID int) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sent_emails WHERE article_id = ?", articleID).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestSubscribePublishSendFlow(t *testing.T) {
+	db := newTestDB(t)
+	sender := newMockSender("")
+	srv := newTestServer(t, db, sender)
+
+	postJSON(t, srv.URL+"/api/subscribe", `{"email":"ada@example.com","name":"Ada"}`)
+	postJSON(t, srv.URL+"/api/subscribe", `{"email":"grace@example.com","name":"Grace"}`)
+	postJSON(t, srv.URL+"/api/publish", `{"title":"Hello","content":"First post"}`)
+
+	msgs := waitForMessages(t, sender, 2)
+	recipients := map[string]bool{}
+	for _, m := range msgs {
+		recipients[m.GetHeader("To")[0]] = true
+		if got := m.GetHeader("Subject")[0]; got != "New Blog Post: Hello" {
+			t.Errorf("Subject = %q", got)
+		}
+	}
+	if !recipients["ada@example.com"] || !recipients["grace@example.com"] {
+		t.Errorf("recipients = %v", recipients)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for countSentEmails(t, db, 1) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := countSentEmails(t, db, 1); n != 2 {
+		t.Fatalf("sent_emails rows = %d, want 2", n)
+	}
+}
+
+func TestSendNewsletterSkipsPreviousRecipients(t *testing.T) {
+	db := newTestDB(t)
+	sender := newMockSender("")
+
+	if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES ('ada@example.com', 'Ada')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO articles (title, content) VALUES ('Hello', 'First post')"); err != nil {
+		t.Fatal(err)
+	}
+
+	sendNewsletterForArticle(context.Background(), db, sender, 1)
+	sendNewsletterForArticle(context.Background(), db, sender, 1)
+
+	if n := len(sender.Messages()); n != 1 {
+		t.Fatalf("sent %d messages, want 1", n)
+	}
+}
+
+func TestSendNewsletterSkipsUnsubscribed(t *testing.T) {
+	db := newTestDB(t)
+	sender := newMockSender("")
+
+	if _, err := db.Exec("INSERT INTO subscribers (email, name, unsubscribed_at) VALUES ('ada@example.com', 'Ada', CURRENT_TIMESTAMP)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO articles (title, content) VALUES ('Hello', 'First post')"); err != nil {
+		t.Fatal(err)
+	}
+
+	sendNewsletterForArticle(context.Background(), db, sender, 1)
+
+	if n := len(sender.Messages()); n != 0 {
+		t.Fatalf("sent %d messages, want 0", n)
+	}
+}
+
+func TestPremiumArticleSkipsFreeSubscribers(t *testing.T) {
+	db := newTestDB(t)
+	sender := newMockSender("")
+
+	if _, err := db.Exec(`INSERT INTO subscribers (email, name, tier) VALUES
+		('free@example.com', 'Free', 'free'),
+		('paid@example.com', 'Paid', 'premium')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO articles (title, content, premium) VALUES ('Hello', 'Members only', 1)"); err != nil {
+		t.Fatal(err)
+	}
+
+	sendNewsletterForArticle(context.Background(), db, sender, 1)
+
+	msgs := sender.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(msgs))
+	}
+	if to := msgs[0].GetHeader("To"); len(to) != 1 || to[0] != "paid@example.com" {
+		t.Errorf("sent to %v, want paid@example.com", to)
+	}
+}
+
+func TestMockSenderWritesEML(t *testing.T) {
+	dir := t.TempDir()
+	sender := newMockSender(dir)
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", "news@example.com")
+	m.SetHeader("To", "ada@example.com")
+	m.SetHeader("Subject", "Hello")
+	m.SetBody("text/html", "<p>Hi</p>")
+	if _, err := sender.Send(context.Background(), m); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("found %d .eml files, want 1", len(files))
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "To: ada@example.com") {
+		t.Errorf("eml file missing To header:\n%s", data)
+	}
+}
+
+func TestArchiveCopySentOncePerArticle(t *testing.T) {
+	t.Setenv("BCC_ARCHIVE", "archive@example.com")
+	db := newTestDB(t)
+	sender := newMockSender("")
+
+	for _, email := range []string{"ada@example.com", "grace@example.com"} {
+		if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES (?, '')", email); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("INSERT INTO articles (title, content) VALUES ('Hello', 'First post')"); err != nil {
+		t.Fatal(err)
+	}
+
+	sendNewsletterForArticle(context.Background(), db, sender, 1)
+	if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES ('late@example.com', '')"); err != nil {
+		t.Fatal(err)
+	}
+	sendNewsletterForArticle(context.Background(), db, sender, 1)
+
+	archived := 0
+	for _, m := range sender.Messages() {
+		if m.GetHeader("To")[0] == "archive@example.com" {
+			archived++
+		}
+	}
+	if archived != 1 {
+		t.Fatalf("archive copies = %d, want 1", archived)
+	}
+	if n := len(sender.Messages()); n != 4 {
+		t.Fatalf("messages = %d, want 4", n)
+	}
+}
+
+func TestSeriesIssuesThread(t *testing.T) {
+	db := newTestDB(t)
+	sender := newMockSender("")
+
+	if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES ('ada@example.com', 'Ada')"); err != nil {
+		t.Fatal(err)
+	}
+	for _, title := range []string{"Part 1", "Part 2", "Part 3"} {
+		if _, err := db.Exec("INSERT INTO articles (title, content, series) VALUES (?, '', 'go-tour')", title); err != nil {
+			t.Fatal(err)
+		}
+		var id int
+		if err := db.QueryRow("SELECT MAX(id) FROM articles").Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		sendNewsletterForArticle(context.Background(), db, sender, id)
+	}
+
+	msgs := sender.Messages()
+	if len(msgs) != 3 {
+		t.Fatalf("messages = %d, want 3", len(msgs))
+	}
+	first, second := msgs[0].GetHeader("Message-ID")[0], msgs[1].GetHeader("Message-ID")[0]
+	if got := msgs[0].GetHeader("In-Reply-To"); len(got) != 0 {
+		t.Errorf("first issue In-Reply-To = %v", got)
+	}
+	if got := msgs[2].GetHeader("In-Reply-To"); len(got) != 1 || got[0] != second {
+		t.Errorf("third issue In-Reply-To = %v, want %s", got, second)
+	}
+	if got := msgs[2].GetHeader("References"); len(got) != 1 || got[0] != first+" "+second {
+		t.Errorf("third issue References = %v", got)
+	}
+}
+
+func TestRecipientPreview(t *testing.T) {
+	db := newTestDB(t)
+	sender := newMockSender("")
+	srv := newTestServer(t, db, sender)
+
+	if _, err := db.Exec(`INSERT INTO subscribers (email, name, tier, unsubscribed_at) VALUES
+		('free@example.com', '', 'free', NULL),
+		('paid@example.com', '', 'premium', NULL),
+		('paid2@example.com', '', 'premium', NULL),
+		('gone@example.com', '', 'premium', CURRENT_TIMESTAMP)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO subscribers (email, name, tier, channels) VALUES ('push@example.com', '', 'premium', 'push')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO articles (title, content, premium) VALUES ('Hello', '', 1)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO sent_emails (subscriber_id, article_id) VALUES (3, 1)"); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get(srv.URL + "/api/articles/1/recipients")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var preview RecipientPreview
+	if err := json.NewDecoder(resp.Body).Decode(&preview); err != nil {
+		t.Fatal(err)
+	}
+	if preview.Count != 1 || preview.Recipients[0].Email != "paid@example.com" {
+		t.Fatalf("recipients = %+v", preview.Recipients)
+	}
+	if preview.Unsubscribed != 1 || preview.OutsideTier != 1 || preview.AlreadySent != 1 || preview.OtherChannels != 1 {
+		t.Fatalf("preview = %+v", preview)
+	}
+	if n := len(sender.Messages()); n != 0 {
+		t.Fatalf("preview sent %d messages", n)
+	}
+
+	// A key without the subscribers permission only sees the counts.
+	req := httptest.NewRequest(http.MethodGet, "/api/articles/1/recipients", nil)
+	req.SetPathValue("id", "1")
+	req = req.WithContext(context.WithValue(req.Context(), principalContextKey, principal{Name: "ci", Permissions: map[permission]bool{permRead: true}}))
+	rec := httptest.NewRecorder()
+	handleGetRecipients(db)(rec, req)
+	if body := rec.Body.String(); rec.Code != http.StatusOK || strings.Contains(body, "@example.com") || !strings.Contains(body, `"count":1`) {
+		t.Fatalf("read-only preview = %d %s", rec.Code, body)
+	}
+}
+
+func TestBatchPublishSuppressSend(t *testing.T) {
+	db := newTestDB(t)
+	sender := newMockSender("")
+	srv := newTestServer(t, db, sender)
+
+	if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES ('ada@example.com', 'Ada')"); err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"suppress_send": true, "articles": [
+		{"title": "One", "content": "First"},
+		{"title": "Bad", "reply_to": "not an address"},
+		{"title": "Two", "content": "Second"}]}`
+	resp, err := http.Post(srv.URL+"/api/articles/batch", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var results []BatchPublishResult
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 || results[0].ID == 0 || results[1].Error == "" || results[2].ID == 0 {
+		t.Fatalf("results = %+v", results)
+	}
+
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM articles").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("articles = %d, want 2", n)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := len(sender.Messages()); n != 0 {
+		t.Fatalf("sent %d messages with suppress_send", n)
+	}
+}
+
+func TestBatchPublishMarkSent(t *testing.T) {
+	db := newTestDB(t)
+	sender := newMockSender("")
+	srv := newTestServer(t, db, sender)
+
+	if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES ('ada@example.com', 'Ada'), ('grace@example.com', 'Grace')"); err != nil {
+		t.Fatal(err)
+	}
+	postJSON(t, srv.URL+"/api/articles/batch", `{"mark_sent": true, "articles": [{"title": "Old", "content": "Back catalog"}]}`)
+
+	if n := countSentEmails(t, db, 1); n != 2 {
+		t.Fatalf("sent_emails rows = %d, want 2", n)
+	}
+	sendNewsletterForArticle(context.Background(), db, sender, 1)
+	if n := len(sender.Messages()); n != 0 {
+		t.Fatalf("sent %d messages for an imported article", n)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	runSucceeded = "succeeded"
+	// runFailed and runRunning reuse the newsletter job statuses.
+	runFailed  = jobFailed
+	runRunning = jobRunning
+
+	triggerSchedule = "schedule"
+	triggerManual   = "manual"
+)
+
+// maxRunLogBytes caps the log excerpt stored with each run.
+const maxRunLogBytes = 4096
+
+// JobRun is one run of a periodic task.
+type JobRun struct {
+	ID         int        `json:"id"`
+	Task       string     `json:"task"`
+	Trigger    string     `json:"trigger"`
+	Status     string     `json:"status"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	Log        string     `json:"log,omitempty"`
+}
+
+// runLog collects the lines a task logs through jobLogf, up to
+// maxRunLogBytes.
+type runLog struct {
+	mu        sync.Mutex
+	b         strings.Builder
+	truncated bool
+}
+
+func (l *runLog) add(line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.b.Len()+len(line)+1 > maxRunLogBytes {
+		l.truncated = true
+		return
+	}
+	l.b.WriteString(line + "\n")
+}
+
+func (l *runLog) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.truncated {
+		return l.b.String() + "… (truncated)\n"
+	}
+	return l.b.String()
+}
+
+const runLogContextKey contextKey = "run-log"
+
+// jobLogf logs like log.Printf and, inside a task run, adds the line to the
+// run's stored log excerpt.
+func jobLogf(ctx context.Context, format string, args ...interface{}) {
+	line := fmt.Sprintf(format, args...)
+	log.Print(line)
+	if l, ok := ctx.Value(runLogContextKey).(*runLog); ok {
+		l.add(line)
+	}
+}
+
+// runningTasks keeps a task from overlapping itself, whether it was started
+// by the scheduler or by hand.
+var runningTasks = struct {
+	sync.Mutex
+	m map[string]bool
+}{m: map[string]bool{}}
+
+// startTask runs t in the background and records the run in job_runs. It
+// returns false if t is already running.
+func startTask(db *sql.DB, t periodicTask, trigger string) (int, bool) {
+	runningTasks.Lock()
+	if runningTasks.m[t.name] {
+		runningTasks.Unlock()
+		return 0, false
+	}
+	runningTasks.m[t.name] = true
+	runningTasks.Unlock()
+
+	result, err := db.Exec("INSERT INTO job_runs (task, trigger, status) VALUES (?, ?, ?)", t.name, trigger, runRunning)
+	var id int64
+	if err != nil {
+		log.Printf("Error recording run of task %s: %v", t.name, err)
+	} else {
+		id, _ = result.LastInsertId()
+	}
+
+	// Counted before the goroutine starts, so a shutdown already waiting
+	// cannot miss it.
+	activeSends.Add(1)
+	go func() {
+		defer activeSends.Done()
+		defer func() {
+			runningTasks.Lock()
+			delete(runningTasks.m, t.name)
+			runningTasks.Unlock()
+		}()
+		logs := &runLog{}
+		ctx, cancel := untilShutdown(context.WithValue(context.Background(), runLogContextKey, logs))
+		defer cancel()
+		err := t.run(ctx)
+		status, errText := runSucceeded, ""
+		if err != nil {
+			log.Printf("Error running task %s: %v", t.name, err)
+			status, errText = runFailed, err.Error()
+		}
+		if id == 0 {
+			return
+		}
+		_, err = db.Exec("UPDATE job_runs SET status = ?, error = ?, log = ?, finished_at = CURRENT_TIMESTAMP WHERE id = ?",
+			status, errText, logs.String(), id)
+		if err != nil {
+			log.Printf("Error finishing run %d of task %s: %v", id, t.name, err)
+		}
+	}()
+	return int(id), true
+}
+
+func deleteOldJobRuns(db *sql.DB, cutoff time.Time) (int64, error) {
+	result, err := db.Exec("DELETE FROM job_runs WHERE started_at < ? AND status != ?", cutoff.Format(sqliteTimeFormat), runRunning)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func getJobRuns(db *sql.DB, task, status string) ([]JobRun, error) {
+	query := "SELECT id, task, trigger, status, started_at, finished_at, error, log FROM job_runs WHERE 1 = 1"
+	var args []interface{}
+	if task != "" {
+		query += " AND task = ?"
+		args = append(args, task)
+	}
+	if status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+	rows, err := db.Query(query+" ORDER BY id DESC LIMIT 100", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []JobRun{}
+	for rows.Next() {
+		var run JobRun
+		if err := rows.Scan(&run.ID, &run.Task, &run.Trigger, &run.Status, scanTime(&run.StartedAt), scanNullTime(&run.FinishedAt), &run.Error, &run.Log); err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// handleJobRuns lists the latest task runs, optionally filtered by ?task=
+// and ?status= (GET), or starts a task now (POST with {"task": name}).
+func handleJobRuns(db *sql.DB, tasks []periodicTask) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			runs, err := getJobRuns(db, r.URL.Query().Get("task"), r.URL.Query().Get("status"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(runs)
+
+		case http.MethodPost:
+			if !requestCan(r, permAdmin) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			var req struct {
+				Task string `json:"task"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			for _, t := range tasks {
+				if t.name != req.Task {
+					continue
+				}
+				id, ok := startTask(db, t, triggerManual)
+				if !ok {
+					http.Error(w, "Task is already running", http.StatusConflict)
+					return
+				}
+				recordAudit(db, r, "run", "task", id, map[string]string{"task": t.name})
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusAccepted)
+				json.NewEncoder(w).Encode(map[string]int{"id": id})
+				return
+			}
+			http.Error(w, "Unknown task", http.StatusBadRequest)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func waitForRun(t *testing.T, db *sql.DB, id int) JobRun {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		runs, err := getJobRuns(db, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, run := range runs {
+			if run.ID == id && run.Status != runRunning {
+				return run
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("run %d did not finish", id)
+	return JobRun{}
+}
+
+func TestJobRunsRecorded(t *testing.T) {
+	db := newTestDB(t)
+	failing := periodicTask{name: "digest", run: func(ctx context.Context) error {
+		jobLogf(ctx, "building digest for %d subscribers", 3)
+		return errors.New("template: bad field")
+	}}
+
+	id, ok := startTask(db, failing, triggerSchedule)
+	if !ok {
+		t.Fatal("task did not start")
+	}
+	run := waitForRun(t, db, id)
+	if run.Task != "digest" || run.Status != runFailed || run.Error != "template: bad field" || run.FinishedAt == nil {
+		t.Fatalf("run = %+v", run)
+	}
+	if !strings.Contains(run.Log, "building digest for 3 subscribers") {
+		t.Fatalf("log = %q", run.Log)
+	}
+}
+
+func TestJobRunsTriggeredByHand(t *testing.T) {
+	db := newTestDB(t)
+	srv := newTestServer(t, db, &mockSender{})
+
+	resp, err := http.Post(srv.URL+"/api/jobs/runs", "application/json", strings.NewReader(`{"task":"engagement"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var started struct{ ID int }
+	json.NewDecoder(resp.Body).Decode(&started)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted || started.ID == 0 {
+		t.Fatalf("trigger = %d, %+v", resp.StatusCode, started)
+	}
+	waitForRun(t, db, started.ID)
+
+	resp, err = http.Get(srv.URL + "/api/jobs/runs?task=engagement&status=" + runSucceeded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var runs []JobRun
+	json.NewDecoder(resp.Body).Decode(&runs)
+	resp.Body.Close()
+	if len(runs) != 1 || runs[0].Trigger != triggerManual {
+		t.Fatalf("runs = %+v", runs)
+	}
+}
+
+func TestJobRunsTriggerSharedTasks(t *testing.T) {
+	db := newTestDB(t)
+	var runs atomic.Int32
+	tasks := []periodicTask{{name: "monitor", defaultSchedule: "@every 1h", run: func(context.Context) error {
+		runs.Add(1)
+		return nil
+	}}}
+	srv := httptest.NewServer(newMux(db, &mockSender{}, &authenticator{db: db}, tasks))
+	t.Cleanup(srv.Close)
+
+	resp, err := http.Post(srv.URL+"/api/jobs/runs", "application/json", strings.NewReader(`{"task":"monitor"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var started struct{ ID int }
+	json.NewDecoder(resp.Body).Decode(&started)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("trigger = %d", resp.StatusCode)
+	}
+	waitForRun(t, db, started.ID)
+	if runs.Load() != 1 {
+		t.Fatalf("the given task ran %d times", runs.Load())
+	}
+}
+
+func TestRunLogTruncated(t *testing.T) {
+	var l runLog
+	for i := 0; i < 1000; i++ {
+		l.add(strings.Repeat("x", 20))
+	}
+	if s := l.String(); len(s) > maxRunLogBytes+32 || !strings.HasSuffix(s, "(truncated)\n") {
+		t.Fatalf("log is %d bytes", len(s))
+	}
+}
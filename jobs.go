@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	jobRunning   = "running"
+	jobCompleted = "completed"
+	jobBlocked   = "blocked"
+	jobFailed    = "failed"
+	// jobDeferred means the warm-up cap stopped the run; it is resumed
+	// when the next day's quota is available.
+	jobDeferred = "deferred"
+	// jobCanary means only the canary share was sent; the rest waits for
+	// the canary to be judged.
+	jobCanary = "canary"
+	// jobAborted means a canary failed its thresholds and the rest of the
+	// audience was not sent to.
+	jobAborted = "aborted"
+)
+
+// NewsletterJob is one run of sendNewsletterForArticle.
+type NewsletterJob struct {
+	ID         int        `json:"id"`
+	ArticleID  int        `json:"article_id"`
+	Status     string     `json:"status"`
+	Sent       int        `json:"sent"`
+	Failed     int        `json:"failed"`
+	Report     JobReport  `json:"report"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// JobReport collects everything worth knowing about a run beyond its
+// counts.
+type JobReport struct {
+	Preflight []PreflightResult `json:"preflight,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	// Deferred is the number of recipients left for a later day by the
+	// warm-up cap.
+	Deferred int `json:"deferred,omitempty"`
+	// RenderFailed counts recipients the newsletter could not be rendered
+	// for. The first maxRenderFailures are listed in RenderFailures.
+	RenderFailed   int             `json:"render_failed,omitempty"`
+	RenderFailures []RenderFailure `json:"render_failures,omitempty"`
+	// CanaryHeld is the number of recipients held back until the canary
+	// send is judged. Canary is the verdict, once made.
+	CanaryHeld int            `json:"canary_held,omitempty"`
+	Canary     *CanaryVerdict `json:"canary,omitempty"`
+	// Channels counts deliveries on channels other than email.
+	Channels map[string]ChannelCounts `json:"channels,omitempty"`
+}
+
+// maxRenderFailures caps the render failures listed in a job report.
+const maxRenderFailures = 50
+
+// RenderFailure is a recipient the newsletter could not be rendered for.
+// Job reports are stored and shown to any reader, so the recipient is
+// identified by id only.
+type RenderFailure struct {
+	SubscriberID int    `json:"subscriber_id"`
+	Error        string `json:"error"`
+}
+
+func (r *JobReport) addRenderFailure(sub Subscriber, err error) {
+	r.RenderFailed++
+	if len(r.RenderFailures) < maxRenderFailures {
+		r.RenderFailures = append(r.RenderFailures, RenderFailure{SubscriberID: sub.ID, Error: err.Error()})
+	}
+}
+
+func startJob(ctx context.Context, db *sql.DB, articleID int) (int, error) {
+	result, err := db.ExecContext(ctx, "INSERT INTO newsletter_jobs (article_id, status) VALUES (?, ?)", articleID, jobRunning)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+func finishJob(ctx context.Context, db *sql.DB, job NewsletterJob) {
+	report, err := json.Marshal(job.Report)
+	if err != nil {
+		log.Printf("Error encoding report for job %d: %v", job.ID, err)
+		report = []byte("{}")
+	}
+	_, err = db.ExecContext(ctx, `
+		UPDATE newsletter_jobs
+		SET status = ?, sent = ?, failed = ?, report = ?, finished_at = CURRENT_TIMESTAMP
+		WHERE id = ?`,
+		job.Status, job.Sent, job.Failed, string(report), job.ID)
+	if err != nil {
+		log.Printf("Error finishing job %d: %v", job.ID, err)
+	}
+}
+
+const jobColumns = "id, article_id, status, sent, failed, COALESCE(report, '{}'), started_at, finished_at"
+
+func scanJob(row interface{ Scan(...interface{}) error }) (NewsletterJob, error) {
+	var j NewsletterJob
+	var report string
+	if err := row.Scan(&j.ID, &j.ArticleID, &j.Status, &j.Sent, &j.Failed, &report, scanTime(&j.StartedAt), scanNullTime(&j.FinishedAt)); err != nil {
+		return j, err
+	}
+	if err := json.Unmarshal([]byte(report), &j.Report); err != nil {
+		return j, err
+	}
+	return j, nil
+}
+
+func getJob(db *sql.DB, id int) (NewsletterJob, error) {
+	return scanJob(db.QueryRow("SELECT "+jobColumns+" FROM newsletter_jobs WHERE id = ?", id))
+}
+
+func getJobs(db *sql.DB, articleID int) ([]NewsletterJob, error) {
+	query := "SELECT " + jobColumns + " FROM newsletter_jobs"
+	var args []interface{}
+	if articleID > 0 {
+		query += " WHERE article_id = ?"
+		args = append(args, articleID)
+	}
+	rows, err := db.Query(query+" ORDER BY id DESC LIMIT 100", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []NewsletterJob{}
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// handleGetJobs lists recent newsletter jobs, optionally for one article.
+func handleGetJobs(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var articleID int
+		if v := r.URL.Query().Get("article_id"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				http.Error(w, "Invalid article_id", http.StatusBadRequest)
+				return
+			}
+			articleID = n
+		}
+
+		jobs, err := getJobs(db, articleID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(jobs)
+	}
+}
+
+func handleGetJob(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid job id", http.StatusBadRequest)
+			return
+		}
+		job, err := getJob(db, id)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Job not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(job)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template/parse"
+)
+
+// emailTemplateFile is the per-newsletter template. With a layout it
+// defines the layout's blocks ("title", "content"); without one it is the
+// whole document.
+const emailTemplateFile = "email_template.html"
+
+// emailTemplateSource is one file the newsletter body is composed from.
+type emailTemplateSource struct {
+	name, path string
+}
+
+// emailTemplateSources returns the files the newsletter body is built
+// from: the optional base layout (EMAIL_LAYOUT, default email_layout.html),
+// the named partials in EMAIL_PARTIALS_DIR (default partials, one
+// template per .html file, named after the file) and the email template.
+func emailTemplateSources() ([]emailTemplateSource, error) {
+	var sources []emailTemplateSource
+	layout := os.Getenv("EMAIL_LAYOUT")
+	if layout == "" {
+		layout = "email_layout.html"
+	}
+	if _, err := os.Stat(layout); err == nil {
+		sources = append(sources, emailTemplateSource{"layout", layout})
+	}
+
+	dir := os.Getenv("EMAIL_PARTIALS_DIR")
+	if dir == "" {
+		dir = "partials"
+	}
+	partials, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range partials {
+		name := strings.TrimSuffix(filepath.Base(path), ".html")
+		sources = append(sources, emailTemplateSource{name, path})
+	}
+
+	return append(sources, emailTemplateSource{"email", emailTemplateFile}), nil
+}
+
+// emailTemplateFuncs are the functions available in the newsletter
+// templates, in addition to the html/template builtins.
+func emailTemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		// mso wraps markup in an Outlook conditional comment, so only
+		// Outlook's Word renderer sees it. html/template strips comments
+		// written directly in a template.
+		"mso": func(markup string) template.HTML {
+			return template.HTML("<!--[if mso]>" + markup + "<![endif]-->")
+		},
+		// notMSO hides markup from Outlook's Word renderer.
+		"notMSO": func(markup string) template.HTML {
+			return template.HTML("<!--[if !mso]><!-->" + markup + "<!--<![endif]-->")
+		},
+	}
+}
+
+// loadEmailTemplate parses the layout, partials and email template into
+// one set. The returned template is the layout if there is one, else the
+// email template. The parsed set is cached until one of its files changes.
+func loadEmailTemplate() (*template.Template, error) {
+	sources, err := emailTemplateSources()
+	if err != nil {
+		return nil, err
+	}
+	cachedEmailTemplate.Lock()
+	defer cachedEmailTemplate.Unlock()
+	if emailTemplateUnchanged(sources) {
+		return cachedEmailTemplate.t, nil
+	}
+
+	files := make(map[string]fileVersion, len(sources))
+	for _, src := range sources {
+		// Stat before reading, so a write during parsing is noticed
+		// next time.
+		if v, ok := statFileVersion(src.path); ok {
+			files[src.path] = v
+		}
+	}
+	t, err := parseEmailTemplate(sources)
+	if err != nil {
+		return nil, err
+	}
+	if cacheTTL() > 0 {
+		cachedEmailTemplate.t, cachedEmailTemplate.files = t, files
+	}
+	return t, nil
+}
+
+// parseEmailTemplate parses sources into one template set and returns the
+// layout, or the email template if there is no layout. Without a layout
+// the email template must have content outside its define blocks;
+// otherwise every newsletter would be sent blank.
+func parseEmailTemplate(sources []emailTemplateSource) (*template.Template, error) {
+	var root *template.Template
+	for _, src := range sources {
+		content, err := os.ReadFile(src.path)
+		if err != nil {
+			return nil, fmt.Errorf("reading email template file: %w", err)
+		}
+		var t *template.Template
+		if root == nil {
+			root = template.New(src.name).Funcs(emailTemplateFuncs())
+			t = root
+		} else {
+			t = root.New(src.name)
+		}
+		if _, err := t.Parse(string(content)); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", src.path, err)
+		}
+	}
+	if root.Name() == "layout" {
+		return root, nil
+	}
+	email := root.Lookup("email")
+	if email.Tree == nil || parse.IsEmptyTree(email.Tree.Root) {
+		return nil, fmt.Errorf("%s only defines blocks and no layout was found; set EMAIL_LAYOUT or give it a top-level body", emailTemplateFile)
+	}
+	return email, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tursodatabase/libsql-client-go/libsql"
+)
+
+// remoteDBSchemes are the DB_PATH prefixes that select a remote libSQL
+// (Turso) database instead of a local SQLite file.
+var remoteDBSchemes = []string{"libsql://", "https://", "http://", "wss://", "ws://"}
+
+// unsupportedDBSchemes are DB_PATH prefixes for databases the service
+// cannot run on. MySQL and MariaDB are deliberately not supported: every
+// query and migration is written in SQLite's dialect (ON CONFLICT upserts,
+// RETURNING, datetime(), pragma_table_info, ALTER TABLE ADD COLUMN
+// migrations), and storage goes straight through *sql.DB with no layer a
+// second dialect could sit behind. Supporting them means porting every
+// query, which is not worth it while SQLite and libSQL cover deployments.
+var unsupportedDBSchemes = []string{"mysql://", "mariadb://"}
+
+// checkDBPath rejects a DB_PATH naming an unsupported database, which
+// sqlite3 would otherwise take as the name of a new local file.
+func checkDBPath(dbPath string) error {
+	for _, scheme := range unsupportedDBSchemes {
+		if strings.HasPrefix(dbPath, scheme) {
+			return fmt.Errorf("DB_PATH %s: MySQL and MariaDB are not supported; use a SQLite file or a libsql:// URL", scheme)
+		}
+	}
+	return nil
+}
+
+// isRemoteDB reports whether dbPath names a remote libSQL database.
+func isRemoteDB(dbPath string) bool {
+	for _, scheme := range remoteDBSchemes {
+		if strings.HasPrefix(dbPath, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// openLibSQL connects to the remote libSQL database at url, authenticating
+// with LIBSQL_AUTH_TOKEN when set. libSQL speaks SQLite's dialect, so the
+// schema and queries are shared with the local database.
+func openLibSQL(url string) (*sql.DB, error) {
+	var opts []libsql.Option
+	if token := os.Getenv("LIBSQL_AUTH_TOKEN"); token != "" {
+		opts = append(opts, libsql.WithAuthToken(token))
+	}
+	connector, err := libsql.NewConnector(url, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(connector), nil
+}
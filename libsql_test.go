@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestIsRemoteDB(t *testing.T) {
+	for path, want := range map[string]bool{
+		"/data/blog-emailing.db":         false,
+		"file:test.db?cache=shared":      false,
+		":memory:":                       false,
+		"libsql://news-acme.turso.io":    true,
+		"https://news-acme.turso.io":     true,
+		"ws://localhost:8080":            true,
+		"relative/libsql://not-a-url.db": false,
+	} {
+		if got := isRemoteDB(path); got != want {
+			t.Errorf("isRemoteDB(%q) = %v; want %v", path, got, want)
+		}
+	}
+}
+
+func TestCheckDBPathRejectsMySQL(t *testing.T) {
+	for _, path := range []string{"mysql://user:pw@db/news", "mariadb://db/news"} {
+		if err := checkDBPath(path); err == nil {
+			t.Errorf("checkDBPath(%q) accepted an unsupported database", path)
+		}
+	}
+	for _, path := range []string{"/data/blog-emailing.db", "libsql://news-acme.turso.io"} {
+		if err := checkDBPath(path); err != nil {
+			t.Errorf("checkDBPath(%q): %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// extractLinks returns the distinct http(s) URLs referenced by href and src
+// attributes in an HTML document, in document order.
+func extractLinks(doc string) []string {
+	seen := map[string]bool{}
+	var links []string
+	z := html.NewTokenizer(strings.NewReader(doc))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return links
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+		for _, attr := range z.Token().Attr {
+			if attr.Key != "href" && attr.Key != "src" {
+				continue
+			}
+			u := strings.TrimSpace(attr.Val)
+			if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+				continue
+			}
+			if !seen[u] {
+				seen[u] = true
+				links = append(links, u)
+			}
+		}
+	}
+}
+
+// checkLink requests u with HEAD, retrying with GET for servers that do not
+// support HEAD, and returns a description of the problem if it is broken.
+func checkLink(ctx context.Context, client *http.Client, u string) string {
+	status := 0
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequestWithContext(ctx, method, u, nil)
+		if err != nil {
+			return err.Error()
+		}
+		req.Header.Set("User-Agent", "blog-emailing link checker")
+		resp, err := client.Do(req)
+		if err != nil {
+			return err.Error()
+		}
+		resp.Body.Close()
+		status = resp.StatusCode
+		if status != http.StatusMethodNotAllowed && status != http.StatusNotImplemented {
+			break
+		}
+	}
+	if status >= 400 {
+		return fmt.Sprintf("HTTP %d", status)
+	}
+	return ""
+}
+
+// errPrivateAddress is returned for links the checker refuses to fetch.
+var errPrivateAddress = errors.New("refusing to fetch a loopback, private or link-local address")
+
+// publicAddress reports whether the link checker may connect to ip.
+func publicAddress(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() && !ip.IsUnspecified()
+}
+
+// linkCheckProxies records the proxies publicOnlyTransport has chosen, by
+// host:port, so dialing them is not refused.
+var linkCheckProxies sync.Map
+
+// publicOnlyTransport is outboundTransport restricted to public addresses,
+// so a link in an article cannot make the server probe its own network.
+// Direct connections are checked as they are dialed, after DNS resolution,
+// so a name that resolves differently on a second lookup cannot slip past.
+// A proxy connects on our behalf, so for proxied links the host is
+// resolved and checked before the request is handed over.
+var publicOnlyTransport = func() *http.Transport {
+	t := outboundTransport.Clone()
+	t.Proxy = func(req *http.Request) (*url.URL, error) {
+		proxy, err := outboundProxy(req)
+		if err != nil || proxy == nil {
+			return proxy, err
+		}
+		ips, err := net.DefaultResolver.LookupIP(req.Context(), "ip", req.URL.Hostname())
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			if !publicAddress(ip) {
+				return nil, fmt.Errorf("%w: %s", errPrivateAddress, ip)
+			}
+		}
+		linkCheckProxies.Store(proxyAddress(proxy), true)
+		return proxy, nil
+	}
+	direct := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	guarded := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicAddress(ip) {
+				return fmt.Errorf("%w: %s", errPrivateAddress, host)
+			}
+			return nil
+		},
+	}
+	t.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if _, ok := linkCheckProxies.Load(address); ok {
+			return direct.DialContext(ctx, network, address)
+		}
+		return guarded.DialContext(ctx, network, address)
+	}
+	return t
+}()
+
+// proxyAddress returns the host:port the transport dials for proxy.
+func proxyAddress(proxy *url.URL) string {
+	if proxy.Port() != "" {
+		return proxy.Host
+	}
+	port := map[string]string{"http": "80", "https": "443", "socks5": "1080", "socks5h": "1080"}[proxy.Scheme]
+	return net.JoinHostPort(proxy.Hostname(), port)
+}
+
+// linkCheckClient returns the client links are fetched with. It only
+// connects to public addresses unless LINK_CHECK_ALLOW_PRIVATE is set, for
+// newsletters that link to an intranet.
+func linkCheckClient(timeout time.Duration) *http.Client {
+	if allow, _ := strconv.ParseBool(os.Getenv("LINK_CHECK_ALLOW_PRIVATE")); allow {
+		return outboundClient(timeout)
+	}
+	return &http.Client{Transport: publicOnlyTransport, Timeout: timeout}
+}
+
+// checkLinks runs the link preflight when LINK_CHECK_MODE is warn or block.
+// Every link in the rendered body is fetched, LINK_CHECK_CONCURRENCY at a
+// time, each within LINK_CHECK_TIMEOUT.
+func checkLinks(ctx context.Context, body string) (PreflightResult, bool) {
+	mode := os.Getenv("LINK_CHECK_MODE")
+	if mode != "warn" && mode != "block" {
+		return PreflightResult{}, false
+	}
+	result := PreflightResult{Check: "links", Status: checkOK}
+
+	links := extractLinks(body)
+	client := linkCheckClient(getEnvDuration("LINK_CHECK_TIMEOUT", 10*time.Second))
+	sem := make(chan struct{}, max(getEnvInt("LINK_CHECK_CONCURRENCY", 5), 1))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	broken := map[string]string{}
+	for _, u := range links {
+		wg.Add(1)
+		go func(u string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if problem := checkLink(ctx, client, u); problem != "" {
+				mu.Lock()
+				broken[u] = problem
+				mu.Unlock()
+			}
+		}(u)
+	}
+	wg.Wait()
+
+	if len(broken) == 0 {
+		result.Detail = fmt.Sprintf("%d links checked", len(links))
+		return result, true
+	}
+	var problems []string
+	for u, p := range broken {
+		problems = append(problems, u+": "+p)
+	}
+	sort.Strings(problems)
+	result.BrokenLinks = problems
+	result.Detail = fmt.Sprintf("%d of %d links broken", len(broken), len(links))
+	if mode == "block" {
+		result.Status = checkFail
+	} else {
+		result.Status = checkWarn
+	}
+	return result, true
+}
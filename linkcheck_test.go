@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestExtractLinks(t *testing.T) {
+	doc := `<p><a href="https://example.com/a">a</a> <a href="mailto:me@example.com">mail</a>
+		<img src="http://example.com/i.png"> <a href="https://example.com/a">again</a> <a href="/relative">r</a></p>`
+	want := []string{"https://example.com/a", "http://example.com/i.png"}
+	if got := extractLinks(doc); !reflect.DeepEqual(got, want) {
+		t.Errorf("extractLinks = %v, want %v", got, want)
+	}
+}
+
+func TestCheckLinks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+		case "/no-head":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	body := `<a href="` + srv.URL + `/ok">ok</a><a href="` + srv.URL + `/no-head">x</a><a href="` + srv.URL + `/gone">gone</a>`
+
+	t.Setenv("LINK_CHECK_MODE", "block")
+	t.Setenv("LINK_CHECK_ALLOW_PRIVATE", "true")
+	result, ok := checkLinks(context.Background(), body)
+	if !ok {
+		t.Fatal("check did not run")
+	}
+	if result.Status != checkFail {
+		t.Errorf("status = %s, want %s", result.Status, checkFail)
+	}
+	want := []string{srv.URL + "/gone: HTTP 404"}
+	if !reflect.DeepEqual(result.BrokenLinks, want) {
+		t.Errorf("broken = %v, want %v", result.BrokenLinks, want)
+	}
+
+	t.Setenv("LINK_CHECK_MODE", "")
+	if _, ok := checkLinks(context.Background(), body); ok {
+		t.Error("check ran with LINK_CHECK_MODE unset")
+	}
+}
+
+func TestCheckLinksRefusesPrivateAddresses(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer srv.Close()
+	t.Setenv("LINK_CHECK_MODE", "block")
+	t.Setenv("OUTBOUND_PROXY", "")
+
+	result, _ := checkLinks(context.Background(), `<a href="`+srv.URL+`/admin">x</a>`)
+	if result.Status != checkFail || len(result.BrokenLinks) != 1 || !strings.Contains(result.BrokenLinks[0], errPrivateAddress.Error()) {
+		t.Errorf("result = %+v", result)
+	}
+	if n := hits.Load(); n != 0 {
+		t.Errorf("private address fetched %d times", n)
+	}
+
+	// Through a proxy, the proxy itself may be private but the link may not.
+	proxied := map[string]bool{}
+	var mu sync.Mutex
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		proxied[r.URL.String()] = true
+		mu.Unlock()
+	}))
+	defer proxy.Close()
+	t.Setenv("OUTBOUND_PROXY", proxy.URL)
+	result, _ = checkLinks(context.Background(), `<a href="http://10.0.0.1/admin">x</a><a href="http://93.184.216.34/ok">ok</a>`)
+	if len(result.BrokenLinks) != 1 || !strings.HasPrefix(result.BrokenLinks[0], "http://10.0.0.1/admin: ") {
+		t.Errorf("broken = %v", result.BrokenLinks)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !proxied["http://93.184.216.34/ok"] || proxied["http://10.0.0.1/admin"] {
+		t.Errorf("proxied %v", proxied)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// activeSends tracks running send jobs and periodic tasks so shutdown can
+// let them finish. Add must be called before the goroutine doing the work
+// starts; goSendNewsletter, goSendSystemEmail and startTask do so.
+var activeSends sync.WaitGroup
+
+// shutdownSends is cancelled when shutdown gives up waiting for
+// activeSends; the work they track runs under untilShutdown.
+var shutdownSends, cancelSends = context.WithCancel(context.Background())
+
+// untilShutdown returns a copy of ctx that is also cancelled when shutdown
+// gives up waiting for running sends.
+func untilShutdown(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(shutdownSends, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// waitForSends waits up to timeout for activeSends. If they are still
+// running it cancels them and waits up to grace more, so each can record
+// the messages it already handed to the provider before the process exits.
+func waitForSends(timeout, grace time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		activeSends.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return
+	case <-time.After(timeout):
+	}
+	log.Println("Timed out waiting for send jobs to finish, cancelling them")
+	cancelSends()
+	select {
+	case <-done:
+	case <-time.After(grace):
+		log.Println("Send jobs did not stop after being cancelled")
+	}
+}
+
+// listen returns the listener for addr. A socket passed by systemd socket
+// activation (LISTEN_FDS) is used when present. An addr of the form
+// "unix:/path" listens on a unix domain socket. Otherwise, with
+// LISTEN_REUSEPORT=true, the socket is opened with SO_REUSEPORT so a new
+// process can bind the same port while the old one drains.
+func listen(addr string) (net.Listener, error) {
+	if ln, err := activationListener(); ln != nil || err != nil {
+		return ln, err
+	}
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		return listenUnix(path)
+	}
+	if reuse, _ := strconv.ParseBool(os.Getenv("LISTEN_REUSEPORT")); reuse {
+		lc := net.ListenConfig{Control: reusePortControl}
+		return lc.Listen(context.Background(), "tcp", addr)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// listenUnix listens on a unix domain socket at path, replacing a stale
+// socket left by a previous process. LISTEN_SOCKET_MODE (octal, default
+// 0660) sets its permissions so a reverse proxy in the same group can
+// connect. The socket file is removed when the listener closes.
+func listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("removing stale socket: %w", err)
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	mode := uint64(0o660)
+	if m := os.Getenv("LISTEN_SOCKET_MODE"); m != "" {
+		if mode, err = strconv.ParseUint(m, 8, 32); err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("invalid LISTEN_SOCKET_MODE %q", m)
+		}
+	}
+	if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// activationListener returns the first socket passed by systemd, or nil if
+// the process was not socket activated.
+func activationListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	// Passed descriptors start at 3, after stdin, stdout and stderr.
+	f := os.NewFile(3, "systemd-socket")
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("using systemd socket: %w", err)
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	log.Printf("Using socket-activated listener on %s", ln.Addr())
+	return ln, nil
+}
+
+// servedListener pairs a listener with the server that answers on it.
+type servedListener struct {
+	srv   *http.Server
+	ln    net.Listener
+	admin bool
+}
+
+// adminListenersOnly is set when ADMIN_LISTEN_ADDRS is configured. The admin
+// API and login are then only served on those listeners.
+var adminListenersOnly bool
+
+const (
+	adminListenerContextKey contextKey = "admin-listener"
+	unixSocketContextKey    contextKey = "unix-socket"
+)
+
+// listenAddrs splits a comma-separated list of addresses such as
+// "0.0.0.0:8080,[::]:8080" or "unix:/run/blog-emailing.sock".
+func listenAddrs(list string) []string {
+	var addrs []string
+	for _, a := range strings.Split(list, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs
+}
+
+// openListeners binds LISTEN_ADDRS (or LISTEN; default ":" + PORT, or
+// HTTPS_PORT with TLS_DOMAIN set) for public traffic and
+// ADMIN_LISTEN_ADDRS, if set, for the admin API, typically on localhost. A
+// socket passed by systemd replaces the first public address.
+func openListeners(handler http.Handler) ([]servedListener, error) {
+	public := listenAddrs(os.Getenv("LISTEN_ADDRS"))
+	if len(public) == 0 {
+		public = listenAddrs(os.Getenv("LISTEN"))
+	}
+	if len(public) == 0 {
+		port := os.Getenv("PORT")
+		if len(tlsDomains()) > 0 {
+			port = httpsPort()
+		} else if port == "" {
+			port = "8080"
+		}
+		public = []string{":" + port}
+	}
+	admin := listenAddrs(os.Getenv("ADMIN_LISTEN_ADDRS"))
+	adminListenersOnly = len(admin) > 0
+
+	var served []servedListener
+	bind := func(addr string, isAdmin bool) error {
+		ln, err := listen(addr)
+		if err != nil {
+			for _, s := range served {
+				s.ln.Close()
+			}
+			return err
+		}
+		srv := newServer(addr, handler)
+		_, isUnix := ln.(*net.UnixListener)
+		srv.BaseContext = func(net.Listener) context.Context {
+			ctx := context.WithValue(context.Background(), adminListenerContextKey, isAdmin)
+			return context.WithValue(ctx, unixSocketContextKey, isUnix)
+		}
+		served = append(served, servedListener{srv: srv, ln: ln, admin: isAdmin})
+		return nil
+	}
+	for _, addr := range public {
+		if err := bind(addr, false); err != nil {
+			return nil, err
+		}
+	}
+	for _, addr := range admin {
+		if err := bind(addr, true); err != nil {
+			return nil, err
+		}
+	}
+	return served, nil
+}
+
+// onAdminListener reports whether r may reach the admin API: always,
+// unless ADMIN_LISTEN_ADDRS restricts it to the admin listeners.
+func onAdminListener(r *http.Request) bool {
+	if !adminListenersOnly {
+		return true
+	}
+	admin, _ := r.Context().Value(adminListenerContextKey).(bool)
+	return admin
+}
+
+// fromUnixSocket reports whether r arrived on a unix domain socket, which
+// only local processes such as a reverse proxy can reach.
+func fromUnixSocket(r *http.Request) bool {
+	unix, _ := r.Context().Value(unixSocketContextKey).(bool)
+	return unix
+}
+
+// adminOnly answers 404 for requests that arrive on a public listener when
+// the admin API has its own listeners.
+func adminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !onAdminListener(r) {
+			http.NotFound(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// serveUntilSignalled serves on every listener until SIGTERM or SIGINT. It
+// then stops the scheduler and accepting connections, finishes in-flight
+// requests within SHUTDOWN_TIMEOUT (default 30s) and waits up to
+// SHUTDOWN_SEND_TIMEOUT (default 10m) for running send jobs, so a
+// replacement process can take over the port without dropping publishes or
+// half-sent newsletters. Send jobs still running then are cancelled and
+// given SHUTDOWN_TIMEOUT to record what they sent.
+func serveUntilSignalled(served []servedListener, sched *scheduler) error {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+
+	errc := make(chan error, len(served))
+	for _, s := range served {
+		go func(s servedListener) { errc <- s.srv.Serve(s.ln) }(s)
+	}
+
+	select {
+	case err := <-errc:
+		return err
+	case sig := <-stop:
+		log.Printf("Received %s, shutting down", sig)
+		sdNotify("STOPPING=1")
+	}
+	// A replacement process runs its own scheduler; both starting the
+	// same due send would email its recipients twice.
+	sched.shutdown()
+
+	shutdownTimeout := getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, s := range served {
+		if err := s.srv.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down HTTP server on %s: %v", s.ln.Addr(), err)
+		}
+	}
+	for range served {
+		if err := <-errc; err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("HTTP server: %v", err)
+		}
+	}
+
+	waitForSends(getEnvDuration("SHUTDOWN_SEND_TIMEOUT", 10*time.Minute), shutdownTimeout)
+	if trackingHits != nil {
+		trackingHits.flush()
+	}
+	log.Println("Shutdown complete")
+	return nil
+}
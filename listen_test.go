@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+func TestListenReusePortAllowsSecondListener(t *testing.T) {
+	t.Setenv("LISTEN_REUSEPORT", "true")
+	first, err := listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	second, err := listen(first.Addr().String())
+	if err != nil {
+		t.Fatalf("second listener on %s: %v", first.Addr(), err)
+	}
+	second.Close()
+}
+
+func TestActivationListenerIgnoresOtherProcess(t *testing.T) {
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	ln, err := activationListener()
+	if ln != nil || err != nil {
+		t.Fatalf("activationListener() = %v, %v; want nil, nil", ln, err)
+	}
+}
+
+func TestAdminListenerSeparation(t *testing.T) {
+	t.Setenv("LISTEN_ADDRS", "127.0.0.1:0,[::1]:0")
+	t.Setenv("ADMIN_LISTEN_ADDRS", "127.0.0.1:0")
+	t.Cleanup(func() { adminListenersOnly = false })
+
+	db := newTestDB(t)
+	served, err := openListeners(newMux(db, &mockSender{}, &authenticator{db: db}, nil))
+	if err != nil {
+		// The host may have no IPv6 loopback.
+		t.Skipf("opening listeners: %v", err)
+	}
+	if len(served) != 3 || !served[2].admin {
+		t.Fatalf("listeners = %+v", served)
+	}
+	for _, s := range served {
+		go s.srv.Serve(s.ln)
+		defer s.srv.Close()
+	}
+
+	status := func(s servedListener, path string) int {
+		resp, err := http.Get("http://" + s.ln.Addr().String() + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if got := status(served[0], "/api/stats"); got != http.StatusNotFound {
+		t.Errorf("admin API on public listener = %d", got)
+	}
+	if got := status(served[1], "/api/subscribe"); got != http.StatusMethodNotAllowed {
+		t.Errorf("public route on IPv6 listener = %d", got)
+	}
+	if got := status(served[2], "/api/stats"); got != http.StatusOK {
+		t.Errorf("admin API on admin listener = %d", got)
+	}
+}
+
+func TestUnixSocketListener(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blog-emailing.sock")
+	t.Setenv("LISTEN", "unix:"+path)
+
+	var gotIP string
+	served, err := openListeners(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotIP = clientIP(r)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := served[0]
+	go s.srv.Serve(s.ln)
+	defer s.srv.Close()
+
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o660 {
+		t.Fatalf("socket mode = %v, %v", fi, err)
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	req, _ := http.NewRequest(http.MethodGet, "http://blog/", nil)
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	// The reverse proxy on the socket is trusted to report the client.
+	if gotIP != "203.0.113.7" {
+		t.Errorf("clientIP = %q", gotIP)
+	}
+}
+
+// stallingSender delivers the first message and then blocks until the send
+// is cancelled.
+type stallingSender struct {
+	mock *mockSender
+}
+
+func (s stallingSender) Send(ctx context.Context, m *gomail.Message) (string, error) {
+	if len(s.mock.Messages()) == 0 {
+		return s.mock.Send(ctx, m)
+	}
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func TestWaitForSendsRecordsCancelledSends(t *testing.T) {
+	t.Cleanup(func() { shutdownSends, cancelSends = context.WithCancel(context.Background()) })
+	db := newTestDB(t)
+	if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES ('ada@example.com', ''), ('grace@example.com', '')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO articles (title, content) VALUES ('Hello', '')"); err != nil {
+		t.Fatal(err)
+	}
+
+	goSendNewsletter(context.Background(), db, stallingSender{newMockSender("")}, 1)
+	waitForSends(100*time.Millisecond, 5*time.Second)
+
+	var messageID string
+	if err := db.QueryRow("SELECT message_id FROM sent_emails WHERE article_id = 1").Scan(&messageID); err != nil || messageID == "" {
+		t.Fatalf("recorded message id %q, %v; want the delivered send recorded and the stalled one released", messageID, err)
+	}
+	jobs, err := getJobs(db, 1)
+	if err != nil || len(jobs) != 1 || jobs[0].Status != jobFailed || jobs[0].Sent != 1 {
+		t.Fatalf("jobs = %+v, %v", jobs, err)
+	}
+}
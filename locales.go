@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"gopkg.in/gomail.v2"
+)
+
+// localeFiles holds the built-in message catalog, one JSON object of
+// message key to text/template per locale.
+//
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// messageCatalog is the built-in catalog keyed by locale, then message key.
+var messageCatalog = loadMessageCatalog()
+
+func loadMessageCatalog() map[string]map[string]string {
+	catalog := map[string]map[string]string{}
+	files, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, f := range files {
+		data, err := localeFiles.ReadFile("locales/" + f.Name())
+		if err != nil {
+			log.Fatal(err)
+		}
+		messages := map[string]string{}
+		if err := json.Unmarshal(data, &messages); err != nil {
+			log.Fatalf("locales/%s: %v", f.Name(), err)
+		}
+		catalog[strings.TrimSuffix(f.Name(), path.Ext(f.Name()))] = messages
+	}
+	return catalog
+}
+
+// defaultLocale is the locale used for subscribers without one and for
+// messages missing from theirs (DEFAULT_LOCALE, default en).
+func defaultLocale() string {
+	if l := os.Getenv("DEFAULT_LOCALE"); l != "" {
+		return normalizeLocale(l)
+	}
+	return "en"
+}
+
+// normalizeLocale lowercases a language tag and uses "-" as separator, so
+// "pt_BR" and "pt-br" match.
+func normalizeLocale(tag string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+}
+
+// availableLocales lists the locales with a built-in catalog or with
+// overrides stored in message_overrides.
+func availableLocales(db *sql.DB) ([]string, error) {
+	seen := map[string]bool{}
+	for l := range messageCatalog {
+		seen[l] = true
+	}
+	rows, err := db.Query("SELECT DISTINCT locale FROM message_overrides")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l string
+		if err := rows.Scan(&l); err != nil {
+			return nil, err
+		}
+		seen[l] = true
+	}
+	locales := make([]string, 0, len(seen))
+	for l := range seen {
+		locales = append(locales, l)
+	}
+	sort.Strings(locales)
+	return locales, rows.Err()
+}
+
+// matchLocale returns the best available locale for a language tag: an
+// exact match, then the tag's base language ("es" for "es-MX"), or "".
+func matchLocale(available []string, tag string) string {
+	tag = normalizeLocale(tag)
+	base, _, _ := strings.Cut(tag, "-")
+	match := ""
+	for _, l := range available {
+		if l == tag {
+			return l
+		}
+		if l == base {
+			match = l
+		}
+	}
+	return match
+}
+
+// negotiateLocale picks the available locale the Accept-Language header
+// prefers most, or "" if it names none of them.
+func negotiateLocale(available []string, header string) string {
+	type choice struct {
+		tag string
+		q   float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if q > 0 {
+			choices = append(choices, choice{tag, q})
+		}
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].q > choices[j].q })
+	for _, c := range choices {
+		if l := matchLocale(available, c.tag); l != "" {
+			return l
+		}
+	}
+	return ""
+}
+
+// signupLocale is the locale stored for a new subscriber: the one given in
+// the request if available, otherwise the best match for Accept-Language,
+// otherwise "" so the default applies.
+func signupLocale(db *sql.DB, r *http.Request, requested string) string {
+	available, err := availableLocales(db)
+	if err != nil {
+		log.Printf("Error listing locales: %v", err)
+		return ""
+	}
+	if requested != "" {
+		if l := matchLocale(available, requested); l != "" {
+			return l
+		}
+	}
+	return negotiateLocale(available, r.Header.Get("Accept-Language"))
+}
+
+// localizedMessage returns the text for key in locale, preferring a stored
+// override to the built-in catalog and falling back to the default locale.
+func localizedMessage(db *sql.DB, locale, key string) (string, error) {
+	locales := []string{defaultLocale()}
+	if locale != "" && locale != locales[0] {
+		locales = []string{locale, locales[0]}
+	}
+	for _, l := range locales {
+		var text string
+		err := db.QueryRow("SELECT text FROM message_overrides WHERE locale = ? AND key = ?", l, key).Scan(&text)
+		if err == nil {
+			return text, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return "", err
+		}
+		if text, ok := messageCatalog[l][key]; ok {
+			return text, nil
+		}
+	}
+	return "", errors.New("no message for " + key)
+}
+
+// renderMessage executes the localized template for key with data.
+func renderMessage(db *sql.DB, locale, key string, data interface{}) (string, error) {
+	text, err := localizedMessage(db, locale, key)
+	if err != nil {
+		return "", err
+	}
+	t, err := template.New(key).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// System emails, each with a <kind>.subject and <kind>.body message and
+// turned on by its own env var.
+const (
+	systemEmailWelcome            = "welcome"
+	systemEmailUnsubscribeReceipt = "unsubscribe_receipt"
+)
+
+var systemEmailEnv = map[string]string{
+	systemEmailWelcome:            "WELCOME_EMAIL_ENABLED",
+	systemEmailUnsubscribeReceipt: "UNSUBSCRIBE_RECEIPT_ENABLED",
+}
+
+// buildSystemMessage renders a system email in the subscriber's locale.
+func buildSystemMessage(db *sql.DB, sub Subscriber, kind string) (*gomail.Message, error) {
+	locale := sub.Locale
+	if locale == "" {
+		locale = defaultLocale()
+	}
+	name := os.Getenv("NEWSLETTER_NAME")
+	if name == "" {
+		name = "our newsletter"
+	}
+	data := map[string]string{"Name": sub.Name, "Newsletter": name}
+	subject, err := renderMessage(db, locale, kind+".subject", data)
+	if err != nil {
+		return nil, err
+	}
+	body, err := renderMessage(db, locale, kind+".body", data)
+	if err != nil {
+		return nil, err
+	}
+
+	m := gomail.NewMessage()
+	setFromHeader(m, "")
+	m.SetAddressHeader("To", deliveryAddress(sub.Email), "")
+	m.SetHeader("Subject", strings.TrimSpace(subject))
+	m.SetHeader("Content-Language", locale)
+	m.SetBody("text/plain", body)
+	return m, nil
+}
+
+// goSendSystemEmail sends a system email in the background, counted in
+// activeSends so shutdown waits for it. The request that caused it returns
+// at once: sending blocks while maintenance mode is on, and a subscriber
+// clicking unsubscribe must not wait for that.
+func goSendSystemEmail(ctx context.Context, db *sql.DB, sender EmailSender, subscriberID int, kind string) {
+	activeSends.Add(1)
+	go func() {
+		defer activeSends.Done()
+		ctx, cancel := untilShutdown(ctx)
+		defer cancel()
+		sendSystemEmail(ctx, db, sender, subscriberID, kind)
+	}()
+}
+
+// sendSystemEmail sends a system email to a subscriber if that kind is
+// enabled. Failures are logged; they never fail the request that caused
+// them.
+func sendSystemEmail(ctx context.Context, db *sql.DB, sender EmailSender, subscriberID int, kind string) {
+	if enabled, _ := strconv.ParseBool(os.Getenv(systemEmailEnv[kind])); !enabled {
+		return
+	}
+	sub, err := getSubscriber(db, subscriberID)
+	if err != nil {
+		log.Printf("Error loading subscriber %d for %s email: %v", subscriberID, kind, err)
+		return
+	}
+	m, err := buildSystemMessage(db, sub, kind)
+	if err != nil {
+		log.Printf("Error building %s email: %v", kind, err)
+		return
+	}
+	if _, err := sender.Send(ctx, m); err != nil {
+		log.Printf("Error sending %s email to subscriber %d: %v", kind, subscriberID, err)
+	}
+}
+
+// handleMessages lists the effective message catalog per locale (GET) or
+// overrides one message (POST {"locale", "key", "text"}). An empty text
+// removes the override, restoring the built-in message.
+func handleMessages(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var req struct {
+				Locale string `json:"locale"`
+				Key    string `json:"key"`
+				Text   string `json:"text"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			req.Locale = normalizeLocale(req.Locale)
+			if req.Locale == "" || req.Key == "" {
+				http.Error(w, "locale and key are required", http.StatusBadRequest)
+				return
+			}
+			if _, ok := messageCatalog["en"][req.Key]; !ok {
+				http.Error(w, "Unknown message key", http.StatusBadRequest)
+				return
+			}
+			if _, err := template.New(req.Key).Parse(req.Text); err != nil {
+				http.Error(w, "Invalid template: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			var err error
+			if req.Text == "" {
+				_, err = db.Exec("DELETE FROM message_overrides WHERE locale = ? AND key = ?", req.Locale, req.Key)
+			} else {
+				_, err = db.Exec(`
+					INSERT INTO message_overrides (locale, key, text) VALUES (?, ?, ?)
+					ON CONFLICT (locale, key) DO UPDATE SET text = excluded.text, updated_at = CURRENT_TIMESTAMP`,
+					req.Locale, req.Key, req.Text)
+			}
+			if err != nil {
+				log.Printf("Error saving message override: %v", err)
+				http.Error(w, "Error saving message", http.StatusInternalServerError)
+				return
+			}
+			recordAudit(db, r, "set", "message", 0, req)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		locales, err := availableLocales(db)
+		if err != nil {
+			log.Printf("Error listing locales: %v", err)
+			http.Error(w, "Error listing messages", http.StatusInternalServerError)
+			return
+		}
+		catalog := map[string]map[string]string{}
+		for _, l := range locales {
+			catalog[l] = map[string]string{}
+			for key := range messageCatalog["en"] {
+				if text, err := localizedMessage(db, l, key); err == nil {
+					catalog[l][key] = text
+				}
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"default": defaultLocale(), "messages": catalog})
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateLocale(t *testing.T) {
+	available := []string{"de", "en", "es", "pt-br"}
+	tests := map[string]string{
+		"es-MX,es;q=0.9,en;q=0.8": "es",
+		"fr-FR, de;q=0.5":         "de",
+		"en;q=0.2, pt-BR":         "pt-br",
+		"fr, *;q=0.1":             "",
+		"es;q=0":                  "",
+	}
+	for header, want := range tests {
+		if got := negotiateLocale(available, header); got != want {
+			t.Errorf("negotiateLocale(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestLocalizedSystemEmails(t *testing.T) {
+	t.Setenv("TRACKING_SECRET", "secret")
+	t.Setenv("NEWSLETTER_NAME", "Weekly")
+	t.Setenv("WELCOME_EMAIL_ENABLED", "true")
+	t.Setenv("UNSUBSCRIBE_RECEIPT_ENABLED", "true")
+	db := newTestDB(t)
+	sender := newMockSender("")
+	srv := newTestServer(t, db, sender)
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/subscribe", strings.NewReader(`{"email": "ana@example.com", "name": "Ana"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept-Language", "es-ES,es;q=0.9,en;q=0.5")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	activeSends.Wait()
+	postJSON(t, srv.URL+"/api/subscribe", `{"email": "ben@example.com", "name": "Ben", "locale": "de-AT"}`)
+	activeSends.Wait()
+
+	msgs := sender.Messages()
+	if len(msgs) != 2 {
+		t.Fatalf("sent %d messages, want 2 welcome emails", len(msgs))
+	}
+	if got := msgs[0].GetHeader("Subject")[0]; got != "Te damos la bienvenida a Weekly" {
+		t.Errorf("Spanish welcome subject = %q", got)
+	}
+	if got := msgs[1].GetHeader("Content-Language")[0]; got != "de" {
+		t.Errorf("second welcome language = %q, want de", got)
+	}
+
+	postJSON(t, srv.URL+"/api/admin/messages", `{"locale": "es", "key": "unsubscribe_receipt.subject", "text": "Hasta pronto, {{.Name}}"}`)
+	resp, err = http.PostForm(srv.URL+"/u/"+trackingToken(1, 0, "unsubscribe"), url.Values{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	activeSends.Wait()
+
+	msgs = sender.Messages()
+	if len(msgs) != 3 {
+		t.Fatalf("sent %d messages, want an unsubscribe receipt", len(msgs))
+	}
+	if got := msgs[2].GetHeader("Subject")[0]; got != "Hasta pronto, Ana" {
+		t.Errorf("overridden receipt subject = %q", got)
+	}
+	var body bytes.Buffer
+	msgs[2].WriteTo(&body)
+	if !strings.Contains(body.String(), "Te has dado de baja de Weekly") {
+		t.Errorf("receipt body is not the built-in Spanish text:\n%s", body.String())
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// loginFailures counts recent failed admin logins and two-factor codes per
+// client IP and per account. Once a key has used up LOGIN_FREE_ATTEMPTS
+// (default 5), each further failure doubles how long it must wait before
+// trying again, up to LOGIN_MAX_BACKOFF (default 15m). A key is forgotten
+// after it succeeds or has not failed for LOGIN_MAX_BACKOFF.
+var loginFailures = struct {
+	sync.Mutex
+	m map[string]loginFailure
+}{m: map[string]loginFailure{}}
+
+type loginFailure struct {
+	count int
+	last  time.Time
+}
+
+// loginKeys are the keys a login attempt for username from r counts
+// against.
+func loginKeys(r *http.Request, username string) []string {
+	return []string{"ip:" + clientIP(r), "account:" + strings.ToLower(strings.TrimSpace(username))}
+}
+
+// loginBackoff is how long a key with count failures waits after the last.
+func loginBackoff(count int) time.Duration {
+	maxBackoff := getEnvDuration("LOGIN_MAX_BACKOFF", 15*time.Minute)
+	over := count - getEnvInt("LOGIN_FREE_ATTEMPTS", 5) - 1
+	if over < 0 {
+		return 0
+	}
+	if over > 30 {
+		return maxBackoff
+	}
+	backoff := time.Second << over
+	if backoff > maxBackoff {
+		return maxBackoff
+	}
+	return backoff
+}
+
+// loginRetryAfter returns how long the caller must wait before another
+// attempt on any of keys, or 0.
+func loginRetryAfter(keys []string, now time.Time) time.Duration {
+	loginFailures.Lock()
+	defer loginFailures.Unlock()
+	var wait time.Duration
+	for _, k := range keys {
+		f, ok := loginFailures.m[k]
+		if !ok {
+			continue
+		}
+		if w := f.last.Add(loginBackoff(f.count)).Sub(now); w > wait {
+			wait = w
+		}
+	}
+	return wait
+}
+
+// recordLoginFailure counts a failed attempt against keys and forgets
+// keys that have been quiet for LOGIN_MAX_BACKOFF.
+func recordLoginFailure(keys []string, now time.Time) {
+	maxBackoff := getEnvDuration("LOGIN_MAX_BACKOFF", 15*time.Minute)
+	loginFailures.Lock()
+	defer loginFailures.Unlock()
+	for k, f := range loginFailures.m {
+		if now.Sub(f.last) > maxBackoff {
+			delete(loginFailures.m, k)
+		}
+	}
+	for _, k := range keys {
+		f := loginFailures.m[k]
+		loginFailures.m[k] = loginFailure{count: f.count + 1, last: now}
+	}
+}
+
+// resetLoginFailures forgets keys after a successful login.
+func resetLoginFailures(keys []string) {
+	loginFailures.Lock()
+	defer loginFailures.Unlock()
+	for _, k := range keys {
+		delete(loginFailures.m, k)
+	}
+}
+
+// tooManyLoginAttempts answers 429 with a Retry-After header and reports
+// true if keys must still wait before another attempt.
+func tooManyLoginAttempts(w http.ResponseWriter, keys []string) bool {
+	wait := loginRetryAfter(keys, time.Now())
+	if wait <= 0 {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too many failed attempts; try again later", http.StatusTooManyRequests)
+	return true
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func resetLoginLimits(t *testing.T) {
+	t.Cleanup(func() {
+		loginFailures.Lock()
+		loginFailures.m = map[string]loginFailure{}
+		loginFailures.Unlock()
+	})
+}
+
+func TestLoginBackoffDoubles(t *testing.T) {
+	t.Setenv("LOGIN_FREE_ATTEMPTS", "2")
+	t.Setenv("LOGIN_MAX_BACKOFF", "3s")
+	resetLoginLimits(t)
+	keys := []string{"ip:192.0.2.1", "account:ada"}
+	now := time.Now()
+
+	for i, want := range []time.Duration{0, 0, time.Second, 2 * time.Second, 3 * time.Second} {
+		recordLoginFailure(keys, now)
+		if got := loginRetryAfter(keys, now); got != want {
+			t.Fatalf("after %d failures wait %v, want %v", i+1, got, want)
+		}
+	}
+	if got := loginRetryAfter([]string{"ip:192.0.2.2", "account:ada"}, now); got != 3*time.Second {
+		t.Fatalf("same account from another address waits %v", got)
+	}
+	resetLoginFailures(keys)
+	if got := loginRetryAfter(keys, now); got != 0 {
+		t.Fatalf("after reset wait %v", got)
+	}
+}
+
+func TestLoginAttemptsLimited(t *testing.T) {
+	t.Setenv("LOGIN_FREE_ATTEMPTS", "1")
+	resetLoginLimits(t)
+	db := newTestDB(t)
+	srv := newTestServer(t, db, newMockSender(""))
+	if err := createAdminUser(db, "ada", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	login := func(username, password string) *http.Response {
+		t.Helper()
+		resp, err := http.Post(srv.URL+"/admin/login", "application/json",
+			strings.NewReader(`{"username":"`+username+`","password":"`+password+`"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	for i := 0; i < 2; i++ {
+		if resp := login("ada", "wrong"); resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("wrong password %d = %d", i+1, resp.StatusCode)
+		}
+	}
+	resp := login("ada", "correct horse")
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "1" {
+		t.Fatalf("login while backing off = %d, Retry-After %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	if resp := login("grace", "anything"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("other account from the same address = %d", resp.StatusCode)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	if resp := login("ada", "correct horse"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("login after backoff = %d", resp.StatusCode)
+	}
+	if resp := login("ada", "wrong"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("a success resets the count, got %d", resp.StatusCode)
+	}
+}
@@ -1,38 +1,115 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
-	"html/template"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/joho/godotenv"
 	_ "github.com/mattn/go-sqlite3"
-	"gopkg.in/gomail.v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Subscriber struct {
-	ID           int    `json:"id"`
-	Email        string `json:"email"`
-	Name         string `json:"name"`
-	SubscribedAt string `json:"subscribed_at"`
+	ID           int       `json:"id"`
+	Email        string    `json:"email"`
+	Name         string    `json:"name"`
+	SubscribedAt time.Time `json:"subscribed_at"`
+	// Source is the referral code the subscriber signed up with.
+	Source string `json:"source,omitempty"`
+	// Tier is "free" or "premium". It cannot be set when subscribing.
+	Tier string `json:"tier,omitempty"`
+	// EngagementScore rates recent opens and clicks from 0 to 100. It is
+	// nil until the subscriber has been sent something.
+	EngagementScore *float64 `json:"engagement_score,omitempty"`
+	// ConsentVersion is the version of the consent text shown on the
+	// signup form. It is only read from subscribe requests.
+	ConsentVersion string `json:"consent_version,omitempty"`
+	// TrackingOptOut stops opens and clicks being tracked in the
+	// subscriber's emails. It is set from the preference center.
+	TrackingOptOut bool `json:"tracking_opt_out,omitempty"`
+	// Locale picks the language of system emails. At signup it is taken
+	// from this field or from Accept-Language.
+	Locale string `json:"locale,omitempty"`
+	// Channels are where the subscriber takes newsletters: email, push
+	// and telegram. They are chosen in the preference center.
+	Channels []string `json:"channels,omitempty"`
 }
 
 type Article struct {
-	ID          int    `json:"id"`
-	Title       string `json:"title"`
-	Content     string `json:"content"`
-	PublishedAt string `json:"published_at"`
+	ID          int       `json:"id"`
+	Title       string    `json:"title"`
+	Content     string    `json:"content"`
+	PublishedAt time.Time `json:"published_at"`
+	// Subject is a text/template for the email subject, overriding
+	// EMAIL_SUBJECT_TEMPLATE for this article.
+	Subject string `json:"subject,omitempty"`
+	// ReplyTo overrides EMAIL_REPLY_TO for this article.
+	ReplyTo string `json:"reply_to,omitempty"`
+	// Series groups articles whose emails thread together in mail clients.
+	Series string `json:"series,omitempty"`
+	// Premium articles are only sent to premium subscribers.
+	Premium bool `json:"premium,omitempty"`
+	// MinEngagement limits the send to subscribers whose engagement score
+	// is at least this. Subscribers without a score are excluded.
+	MinEngagement float64 `json:"min_engagement,omitempty"`
+	// ScheduledAt (RFC 3339, any offset) delays the newsletter until that
+	// time.
+	ScheduledAt *time.Time `json:"scheduled_at,omitempty"`
+	// EventStart, EventEnd (RFC 3339) and EventLocation describe an event
+	// the article announces. The newsletter then carries an .ics invite.
+	EventStart    string `json:"event_start,omitempty"`
+	EventEnd      string `json:"event_end,omitempty"`
+	EventLocation string `json:"event_location,omitempty"`
+	// FromName is the sender persona shown for this article, overriding
+	// EMAIL_FROM_NAME.
+	FromName string `json:"from_name,omitempty"`
+	// Authors are credited in the newsletter, in order.
+	Authors []Author `json:"authors,omitempty"`
+	// Poll is an optional one-click question shown in the newsletter.
+	Poll *Poll `json:"poll,omitempty"`
+	// Exclude leaves subscribers out of the send after normal targeting.
+	Exclude *Exclusion `json:"exclude,omitempty"`
+	// Excerpt summarizes the article. It defaults to the start of the
+	// content.
+	Excerpt string `json:"excerpt,omitempty"`
+	// TOC adds a table of contents linking to the content's Markdown
+	// headings to the email and the hosted page.
+	TOC bool `json:"toc,omitempty"`
+	// WordCount and ReadingTime (minutes) are computed at publish.
+	WordCount   int `json:"word_count,omitempty"`
+	ReadingTime int `json:"reading_time,omitempty"`
+	// Version counts edits and UpdatedAt is when the last one was made.
+	// They are set by the server.
+	Version   int        `json:"version,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+
+	// shortLinks maps the article's URLs to short link codes during a
+	// send. It is not stored.
+	shortLinks map[string]string
 }
 
 type SentEmail struct {
-	ID           int    `json:"id"`
-	SubscriberID int    `json:"subscriber_id"`
-	ArticleID    int    `json:"article_id"`
-	SentAt       string `json:"sent_at"`
+	ID           int       `json:"id"`
+	SubscriberID int       `json:"subscriber_id"`
+	ArticleID    int       `json:"article_id"`
+	SentAt       time.Time `json:"sent_at"`
+	MessageID    string    `json:"message_id,omitempty"`
+	// ProviderMessageID is the delivery provider's identifier for the
+	// send, used to match delivery webhooks.
+	ProviderMessageID string `json:"provider_message_id,omitempty"`
+	// DeliveryStatus is "accepted" until the provider reports delivered,
+	// deferred, bounced or dropped.
+	DeliveryStatus string `json:"delivery_status"`
 }
 
 type AllData struct {
@@ -42,40 +119,262 @@ type AllData struct {
 	ArticleCount    int          `json:"article_count"`
 	SentEmails      []SentEmail  `json:"sent_emails"`
 	SentEmailCount  int          `json:"sent_email_count"`
+	// ArchivedSentEmailCount counts sends moved out of sent_emails by the
+	// archival retention policy.
+	ArchivedSentEmailCount int `json:"archived_sent_email_count"`
+	// SubscribersBySource counts signups per referral source.
+	SubscribersBySource map[string]int `json:"subscribers_by_source"`
+	// TopReferrers are the subscribers whose forwarded newsletters brought
+	// in the most signups.
+	TopReferrers []Referrer `json:"top_referrers"`
 }
 
-const dbPath = "/data/blog.db"
+const defaultDBPath = "/data/blog.db"
+
+// sqliteTimeFormat matches the format SQLite uses for CURRENT_TIMESTAMP.
+const sqliteTimeFormat = "2006-01-02 15:04:05"
 
 func main() {
 	// Load environment variables
-	err := godotenv.Load()
+	err := loadEnvFile()
 	if err != nil {
 		log.Println("Error loading .env file, using environment variables")
 	}
+	if err := checkOutboundProxy(); err != nil {
+		log.Fatalf("Invalid OUTBOUND_PROXY: %v", err)
+	}
+
+	shutdownTracing := initTracing(context.Background())
+	defer shutdownTracing(context.Background())
+	flushErrors := initErrorReporting()
+	defer flushErrors()
+
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1], os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	db := openDB(databasePath())
+	defer db.Close()
+	if err := checkSigningKeys(); err != nil {
+		log.Fatal(err)
+	}
+	replica, err := openReadReplica()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if replica != nil {
+		readReplica = replica
+		defer replica.Close()
+	}
+
+	setTrustedProxies()
+
+	emailSender, err := newEmailSender(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sender := &reloadableSender{sender: emailSender}
+	go reloadOnSIGHUP(db, sender)
+	// One task list, so a run started from the admin API shares the
+	// scheduler's monitors and their alert state.
+	tasks := periodicTasks(db, sender)
+	sched := newScheduler(db, tasks)
+	go sched.run()
+
+	// TRACKING_FLUSH_INTERVAL=0 writes opens and clicks immediately.
+	if interval := getEnvDuration("TRACKING_FLUSH_INTERVAL", time.Second); interval > 0 {
+		trackingHits = newTrackingBuffer(db)
+		go trackingHits.run(interval)
+	}
+
+	publisher, err := newEventPublisher(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if publisher != nil {
+		go publisher.run(getEnvDuration("EVENT_BUS_INTERVAL", time.Second))
+	}
+
+	bootstrapAdminUser(db)
+	auth := &authenticator{db: db, keys: loadAPIKeys()}
+
+	handler := newHandler(db, sender, auth, tasks)
+
+	served, err := openListeners(handler)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if domains := tlsDomains(); len(domains) > 0 {
+		if served, err = useAutocertTLS(served, domains); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for _, s := range served {
+		if s.admin {
+			log.Printf("Starting admin server on %s", s.ln.Addr())
+		} else {
+			log.Printf("Starting server on %s", s.ln.Addr())
+		}
+	}
+	if err := sdNotify("READY=1"); err != nil {
+		log.Printf("Error notifying systemd: %v", err)
+	}
+	if interval := watchdogInterval(); interval > 0 {
+		go runWatchdog(db, interval)
+	}
+	if err := serveUntilSignalled(served, sched); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// databasePath returns DB_PATH, or the default path under /data.
+func databasePath() string {
+	if p := os.Getenv("DB_PATH"); p != "" {
+		return p
+	}
+	return defaultDBPath
+}
 
+// openDB opens the database at dbPath and brings its schema up to date.
+// A libsql:// (or https://, wss://) URL connects to a remote libSQL
+// database instead of a local file.
+func openDB(dbPath string) *sql.DB {
 	log.Printf("Attempting to open database at: %s", dbPath)
+	if err := checkDBPath(dbPath); err != nil {
+		log.Fatal(err)
+	}
 	// Set up database
-	db, err := sql.Open("sqlite3", dbPath)
+	var db *sql.DB
+	var err error
+	if isRemoteDB(dbPath) {
+		db, err = openLibSQL(dbPath)
+	} else {
+		db, err = sql.Open("sqlite3", dbPath)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.Close()
 
 	// dropTables(db)
 	// Create tables if not exist
 	createTables(db)
+	migrateTables(db)
+	normalizeTimestamps(db)
+	migratePushOnly(db)
+	if err := initCounters(db); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadFeatureFlags(db); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadMaintenance(db); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadCredentials(db); err != nil {
+		log.Fatal(err)
+	}
+	return db
+}
 
-	http.HandleFunc("/api/subscribe", handleSubscribe(db))
-	http.HandleFunc("/api/publish", handlePublish(db))
-	http.HandleFunc("/api/send-newsletter", handleSendNewsletter(db))
-	http.HandleFunc("/api/stats", handleGetAllData(db))
+// newHandler is newMux wrapped in the middleware every request passes
+// through.
+func newHandler(db *sql.DB, sender EmailSender, auth *authenticator, tasks []periodicTask) http.Handler {
+	mux := newMux(db, sender, auth, tasks)
+	return withRequestID(withTracing(mux, withServerTiming(withRecovery(withTimeout(withCompression(mux))))))
+}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-	log.Printf("Starting server on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+// newMux registers the service's routes. tasks are the scheduler's
+// periodic tasks, listed and started by the admin API.
+func newMux(db *sql.DB, sender EmailSender, auth *authenticator, tasks []periodicTask) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/subscribe", unlessMaintenance(handleSubscribe(db, sender)))
+	mux.HandleFunc("/api/push/key", unlessMaintenance(handlePushKey()))
+	mux.HandleFunc("/api/push/subscriptions", unlessMaintenance(handlePushSubscriptions(db)))
+	mux.HandleFunc("/push-sw.js", handlePushServiceWorker())
+	mux.HandleFunc("/theme/{path...}", handleThemeAsset())
+	mux.HandleFunc("/api/publish", auth.require(permPublish, handlePublish(db, sender)))
+	mux.HandleFunc("/api/send-newsletter", auth.require(permPublish, handleSendNewsletter(db, sender)))
+	mux.HandleFunc("/api/stats", auth.require(permRead, handleGetAllData(analyticsDB(db))))
+	mux.HandleFunc("/api/stats/summary", auth.require(permRead, handleStatsSummary(analyticsDB(db))))
+	mux.HandleFunc("/api/stats/costs", auth.require(permRead, handleGetCosts(analyticsDB(db))))
+	mux.HandleFunc("/api/subscribers", auth.require(permSubscribers, handleListSubscribers(db)))
+	mux.HandleFunc("/api/subscribers/bulk", auth.require(permSubscribers, handleBulkSubscribers(db)))
+	mux.HandleFunc("/api/subscribers/{id}/deliveries", auth.require(permSubscribers, handleSubscriberDeliveries(db)))
+	mux.HandleFunc("/api/subscribers/duplicates", auth.require(permSubscribers, handleFindDuplicates(db)))
+	mux.HandleFunc("/api/subscribers/merge", auth.require(permSubscribers, handleMergeSubscribers(db)))
+	mux.HandleFunc("/api/sent-emails", auth.require(permRead, handleListSentEmails(db)))
+	mux.HandleFunc("/api/export/subscribers.ndjson", auth.require(permSubscribers, handleExportSubscribers(db)))
+	mux.HandleFunc("/api/export/articles.ndjson", auth.require(permRead, handleExportArticles(db)))
+	mux.HandleFunc("/api/export/sent-emails.ndjson", auth.require(permRead, handleExportSentEmails(db)))
+	mux.HandleFunc("/api/segments/preview", auth.require(permSubscribers, handleSegmentPreview(db)))
+	mux.HandleFunc("/api/authors", auth.require(permPublish, handleAuthors(db)))
+	mux.HandleFunc("/api/articles/batch", auth.require(permPublish, handleBatchPublish(db, sender)))
+	mux.HandleFunc("/api/articles/{id}/mark-sent", auth.require(permPublish, handleMarkSent(db)))
+	mux.HandleFunc("/api/articles/{id}/poll", auth.require(permRead, handlePollResults(db)))
+	mux.HandleFunc("/api/articles/{id}/recipients", auth.require(permRead, handleGetRecipients(db)))
+	mux.HandleFunc("/api/articles/{id}/sample", auth.require(permSubscribers, handleSamplePreview(db)))
+	mux.HandleFunc("/api/articles/{id}", auth.require(permPublish, handleArticle(db)))
+	mux.HandleFunc("/api/articles/{id}/revisions", auth.require(permRead, handleArticleRevisions(db)))
+	mux.HandleFunc("/api/articles/{id}/revisions/{rev}", auth.require(permRead, handleArticleRevision(db)))
+	mux.HandleFunc("/api/articles/{id}/revisions/{rev}/diff", auth.require(permRead, handleArticleRevisionDiff(db)))
+	mux.HandleFunc("/api/articles/{id}/revisions/{rev}/restore", auth.require(permPublish, handleRestoreArticleRevision(db)))
+	mux.HandleFunc("/api/articles/{id}/restore", auth.require(permPublish, handleSoftDelete(db, "article", true)))
+	mux.HandleFunc("/api/subscribers/{id}", auth.require(permSubscribers, handleSoftDelete(db, "subscriber", false)))
+	mux.HandleFunc("/api/subscribers/{id}/restore", auth.require(permSubscribers, handleSoftDelete(db, "subscriber", true)))
+	mux.HandleFunc("/api/subscribers/{id}/tier", auth.require(permSubscribers, handleSetTier(db)))
+	mux.HandleFunc("/api/subscribers/{id}/events", auth.require(permSubscribers, handleSubscriberEvents(db)))
+	mux.HandleFunc("/api/subscribers/{id}/notes", auth.require(permSubscribers, handleSubscriberNotes(db)))
+	mux.HandleFunc("/api/subscribers/{id}/consent", auth.require(permSubscribers, handleGetConsent(db)))
+	mux.HandleFunc("/api/schedule", auth.require(permRead, handleGetSchedule(db)))
+	mux.HandleFunc("/api/queue", auth.require(permRead, handleGetQueue(db)))
+	mux.HandleFunc("/api/links", auth.require(permRead, handleGetLinks(analyticsDB(db))))
+	mux.HandleFunc("/api/dead-letters", auth.require(permPublish, handleDeadLetters(db, sender)))
+	mux.HandleFunc("/api/audit", auth.require(permAdmin, handleGetAudit(db)))
+	mux.HandleFunc("/api/webhooks/stripe", handleStripeWebhook(db))
+	mux.HandleFunc("/api/webhooks/delivery", handleDeliveryWebhook(db))
+	mux.HandleFunc("/api/webhooks/inbound", handleInboundReply(db, sender))
+	mux.HandleFunc("/api/replies", auth.require(permSubscribers, handleGetReplies(db)))
+	mux.HandleFunc("/api/templates/lint", auth.require(permPublish, handleLintTemplate()))
+	mux.HandleFunc("/api/jobs", auth.require(permRead, handleGetJobs(db)))
+	mux.HandleFunc("/api/jobs/{id}", auth.require(permRead, handleGetJob(db)))
+	mux.HandleFunc("/api/jobs/runs", auth.require(permRead, handleJobRuns(db, tasks)))
+	mux.HandleFunc("/api/admin/deliverability", auth.require(permAdmin, handleDeliverability()))
+	mux.HandleFunc("/api/admin/apply", auth.require(permAdmin, handleApplyConfig(db)))
+	mux.HandleFunc("/api/admin/flags", auth.require(permAdmin, handleFeatureFlags(db)))
+	mux.HandleFunc("/api/admin/messages", auth.require(permAdmin, handleMessages(db)))
+	mux.HandleFunc("/api/admin/maintenance", auth.require(permAdmin, handleMaintenance(db)))
+	mux.HandleFunc("/api/admin/schedules", auth.require(permAdmin, handleTaskSchedules(db, tasks)))
+	mux.HandleFunc("/api/admin/webhook-deliveries", auth.require(permAdmin, handleWebhookDeliveries(db)))
+	mux.HandleFunc("/api/admin/webhook-deliveries/{id}/replay", auth.require(permAdmin, handleReplayWebhookDelivery(db)))
+	mux.HandleFunc("/api/admin/credentials", auth.require(permAdmin, handleCredentials(db, sender)))
+	mux.HandleFunc("/api/admin/reprocess", auth.require(permAdmin, handleReprocess(db)))
+	mux.HandleFunc("/api/admin/reload", auth.require(permAdmin, handleReload(db, sender)))
+	mux.HandleFunc("/stats", unlessMaintenance(handlePublicStats(analyticsDB(db))))
+	mux.HandleFunc("/sitemap.xml", unlessMaintenance(handleSitemap(db)))
+	mux.HandleFunc("/robots.txt", handleRobotsTxt())
+	mux.HandleFunc("/articles/{slug}", unlessMaintenance(handleArticlePage(db)))
+	mux.HandleFunc("/articles/{slug}/og.png", unlessMaintenance(handleArticleOGImage(db)))
+	mux.HandleFunc("/badge/subscribers", unlessMaintenance(handleSubscriberBadge(db, false)))
+	mux.HandleFunc("/badge/subscribers.svg", unlessMaintenance(handleSubscriberBadge(db, true)))
+	mux.HandleFunc("/t/o/{token}", handleTrackOpen(db))
+	mux.HandleFunc("/t/c/{token}", handleTrackClick(db))
+	mux.HandleFunc("/u/{token}", handleUnsubscribe(db, sender))
+	mux.HandleFunc("/preferences/{token}", unlessMaintenance(handlePreferences(db)))
+	mux.HandleFunc("/p/{token}", unlessMaintenance(handlePollResponse(db)))
+	mux.HandleFunc("/f/{token}", unlessMaintenance(handleForward(db)))
+	mux.HandleFunc("/l/{code}", handleShortLink(db))
+	mux.HandleFunc("/l/{code}/{token}", handleShortLink(db))
+	mux.HandleFunc("/admin/login", adminOnly(handleLogin(db)))
+	mux.HandleFunc("/admin/logout", adminOnly(handleLogout(db)))
+	mux.HandleFunc("/admin/session", auth.require(permRead, handleGetSession(db)))
+	mux.HandleFunc("/admin/totp/enroll", auth.require(permAdmin, handleTOTPEnroll(db)))
+	mux.HandleFunc("/admin/totp/confirm", auth.require(permAdmin, handleTOTPConfirm(db)))
+	mux.HandleFunc("/admin/totp/disable", auth.require(permAdmin, handleTOTPDisable(db)))
+	registerDebugHandlers(mux, auth)
+	return mux
 }
 
 func createTables(db *sql.DB) {
@@ -103,6 +402,281 @@ func createTables(db *sql.DB) {
 			FOREIGN KEY (subscriber_id) REFERENCES subscribers(id),
 			FOREIGN KEY (article_id) REFERENCES articles(id)
 		);
+
+		CREATE TABLE IF NOT EXISTS sent_email_summaries (
+			article_id INTEGER PRIMARY KEY,
+			sent INTEGER NOT NULL DEFAULT 0,
+			imported INTEGER NOT NULL DEFAULT 0,
+			opened INTEGER NOT NULL DEFAULT 0,
+			clicked INTEGER NOT NULL DEFAULT 0,
+			bounced INTEGER NOT NULL DEFAULT 0,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (article_id) REFERENCES articles(id)
+		);
+
+		CREATE TABLE IF NOT EXISTS newsletter_jobs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			article_id INTEGER NOT NULL,
+			status TEXT NOT NULL,
+			sent INTEGER NOT NULL DEFAULT 0,
+			failed INTEGER NOT NULL DEFAULT 0,
+			report TEXT,
+			started_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			finished_at DATETIME,
+			FOREIGN KEY (article_id) REFERENCES articles(id)
+		);
+
+		CREATE TABLE IF NOT EXISTS dead_letters (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			subscriber_id INTEGER NOT NULL,
+			article_id INTEGER NOT NULL,
+			attempts INTEGER NOT NULL,
+			errors TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (subscriber_id, article_id)
+		);
+
+		CREATE TABLE IF NOT EXISTS events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			subscriber_id INTEGER NOT NULL,
+			type TEXT NOT NULL,
+			article_id INTEGER,
+			detail TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_events_subscriber ON events (subscriber_id, id);
+
+		CREATE TABLE IF NOT EXISTS subscriber_notes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			subscriber_id INTEGER NOT NULL,
+			author TEXT NOT NULL,
+			body TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (subscriber_id) REFERENCES subscribers(id)
+		);
+
+		CREATE TABLE IF NOT EXISTS webhook_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			source TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			received_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_webhook_events_received ON webhook_events (source, received_at);
+
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			source TEXT NOT NULL,
+			delivery_id TEXT NOT NULL,
+			received_at DATETIME NOT NULL,
+			PRIMARY KEY (source, delivery_id)
+		);
+
+		CREATE TABLE IF NOT EXISTS roundups (
+			period TEXT PRIMARY KEY,
+			article_id INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (article_id) REFERENCES articles(id)
+		);
+
+		CREATE TABLE IF NOT EXISTS polls (
+			article_id INTEGER PRIMARY KEY,
+			question TEXT NOT NULL,
+			options TEXT NOT NULL,
+			FOREIGN KEY (article_id) REFERENCES articles(id)
+		);
+
+		CREATE TABLE IF NOT EXISTS poll_responses (
+			article_id INTEGER NOT NULL,
+			subscriber_id INTEGER NOT NULL,
+			option INTEGER NOT NULL,
+			responded_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (article_id, subscriber_id),
+			FOREIGN KEY (article_id) REFERENCES articles(id),
+			FOREIGN KEY (subscriber_id) REFERENCES subscribers(id)
+		);
+
+		CREATE TABLE IF NOT EXISTS replies (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			subscriber_id INTEGER,
+			article_id INTEGER,
+			from_address TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			body TEXT NOT NULL,
+			received_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (subscriber_id) REFERENCES subscribers(id),
+			FOREIGN KEY (article_id) REFERENCES articles(id)
+		);
+
+		CREATE TABLE IF NOT EXISTS job_runs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			task TEXT NOT NULL,
+			trigger TEXT NOT NULL,
+			status TEXT NOT NULL,
+			started_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			finished_at DATETIME,
+			error TEXT NOT NULL DEFAULT '',
+			log TEXT NOT NULL DEFAULT ''
+		);
+		CREATE INDEX IF NOT EXISTS idx_job_runs_task ON job_runs(task, id);
+
+		CREATE TABLE IF NOT EXISTS task_schedules (
+			name TEXT PRIMARY KEY,
+			expression TEXT,
+			enabled INTEGER NOT NULL DEFAULT 1,
+			last_run_at DATETIME
+		);
+
+		CREATE TABLE IF NOT EXISTS outbound_webhook_deliveries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			endpoint TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			status_code INTEGER NOT NULL DEFAULT 0,
+			response TEXT NOT NULL DEFAULT '',
+			error TEXT NOT NULL DEFAULT '',
+			replay_of INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS provider_usage (
+			provider TEXT NOT NULL,
+			day TEXT NOT NULL,
+			sent INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (provider, day)
+		);
+
+		CREATE TABLE IF NOT EXISTS push_subscriptions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			subscriber_id INTEGER,
+			endpoint TEXT NOT NULL UNIQUE,
+			p256dh TEXT NOT NULL,
+			auth TEXT NOT NULL,
+			instead_of_email INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (subscriber_id) REFERENCES subscribers(id)
+		);
+
+		CREATE TABLE IF NOT EXISTS channel_deliveries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			channel TEXT NOT NULL,
+			article_id INTEGER NOT NULL,
+			recipient TEXT NOT NULL,
+			subscriber_id INTEGER,
+			status TEXT NOT NULL,
+			error TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (channel, article_id, recipient)
+		);
+
+		CREATE TABLE IF NOT EXISTS message_overrides (
+			locale TEXT NOT NULL,
+			key TEXT NOT NULL,
+			text TEXT NOT NULL,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (locale, key)
+		);
+
+		CREATE TABLE IF NOT EXISTS article_revisions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			article_id INTEGER NOT NULL,
+			title TEXT NOT NULL,
+			content TEXT NOT NULL,
+			subject TEXT NOT NULL DEFAULT '',
+			excerpt TEXT NOT NULL DEFAULT '',
+			actor TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_article_revisions_article ON article_revisions (article_id);
+
+		CREATE TABLE IF NOT EXISTS credentials (
+			name TEXT PRIMARY KEY,
+			value TEXT NOT NULL,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS maintenance (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			enabled INTEGER NOT NULL DEFAULT 0,
+			message TEXT NOT NULL DEFAULT '',
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS links (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			code TEXT NOT NULL UNIQUE,
+			url TEXT NOT NULL UNIQUE,
+			clicks INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS authors (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			avatar_url TEXT NOT NULL DEFAULT '',
+			bio_url TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS article_authors (
+			article_id INTEGER NOT NULL,
+			author_id INTEGER NOT NULL,
+			position INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (article_id, author_id),
+			FOREIGN KEY (article_id) REFERENCES articles(id),
+			FOREIGN KEY (author_id) REFERENCES authors(id)
+		);
+
+		CREATE TABLE IF NOT EXISTS feature_flags (
+			name TEXT PRIMARY KEY,
+			enabled BOOLEAN NOT NULL,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS subscriber_tags (
+			subscriber_id INTEGER NOT NULL,
+			tag TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (subscriber_id, tag),
+			FOREIGN KEY (subscriber_id) REFERENCES subscribers(id)
+		);
+
+		CREATE TABLE IF NOT EXISTS consent_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			subscriber_id INTEGER NOT NULL,
+			action TEXT NOT NULL,
+			ip TEXT NOT NULL,
+			user_agent TEXT NOT NULL,
+			consent_version TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (subscriber_id) REFERENCES subscribers(id)
+		);
+
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			actor TEXT NOT NULL,
+			action TEXT NOT NULL,
+			target_type TEXT NOT NULL,
+			target_id INTEGER,
+			diff TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS admin_users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT NOT NULL UNIQUE,
+			password_hash TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS admin_sessions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			token_hash TEXT NOT NULL UNIQUE,
+			user_id INTEGER NOT NULL,
+			csrf_token TEXT NOT NULL,
+			expires_at DATETIME NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES admin_users(id)
+		);
 	`)
 	if err != nil {
 		log.Fatal(err)
@@ -110,6 +684,93 @@ func createTables(db *sql.DB) {
 	log.Println("Tables successfully created!")
 }
 
+// migrateTables adds columns introduced after the initial schema to
+// databases created by older versions.
+func migrateTables(db *sql.DB) {
+	migrations := []struct {
+		table, column, definition string
+	}{
+		{"subscribers", "unsubscribed_at", "DATETIME"},
+		{"admin_users", "totp_secret", "TEXT"},
+		{"admin_users", "totp_enabled", "INTEGER NOT NULL DEFAULT 0"},
+		{"admin_users", "totp_last_counter", "INTEGER NOT NULL DEFAULT 0"},
+		{"audit_log", "ip", "TEXT"},
+		{"articles", "subject", "TEXT NOT NULL DEFAULT ''"},
+		{"articles", "reply_to", "TEXT NOT NULL DEFAULT ''"},
+		{"articles", "archive_sent_at", "DATETIME"},
+		{"articles", "series", "TEXT NOT NULL DEFAULT ''"},
+		{"articles", "premium", "INTEGER NOT NULL DEFAULT 0"},
+		{"subscribers", "tier", "TEXT NOT NULL DEFAULT 'free'"},
+		{"subscribers", "stripe_customer_id", "TEXT"},
+		{"subscribers", "deleted_at", "DATETIME"},
+		{"articles", "deleted_at", "DATETIME"},
+		{"articles", "min_engagement", "REAL NOT NULL DEFAULT 0"},
+		{"articles", "scheduled_at", "DATETIME"},
+		{"articles", "event_start", "TEXT NOT NULL DEFAULT ''"},
+		{"articles", "event_end", "TEXT NOT NULL DEFAULT ''"},
+		{"articles", "event_location", "TEXT NOT NULL DEFAULT ''"},
+		{"articles", "from_name", "TEXT NOT NULL DEFAULT ''"},
+		{"subscribers", "engagement_score", "REAL"},
+		{"sent_emails", "opened_at", "DATETIME"},
+		{"sent_emails", "open_count", "INTEGER NOT NULL DEFAULT 0"},
+		{"sent_emails", "clicked_at", "DATETIME"},
+		{"sent_emails", "click_count", "INTEGER NOT NULL DEFAULT 0"},
+		{"sent_emails", "last_engaged_at", "DATETIME"},
+		{"sent_emails", "message_id", "TEXT"},
+		{"sent_emails", "provider_message_id", "TEXT"},
+		{"sent_emails", "delivery_status", "TEXT NOT NULL DEFAULT 'accepted'"},
+		{"sent_emails", "status_updated_at", "DATETIME"},
+		{"subscribers", "source", "TEXT NOT NULL DEFAULT ''"},
+		{"subscribers", "tracking_opt_out", "INTEGER NOT NULL DEFAULT 0"},
+		{"subscribers", "snoozed_at", "DATETIME"},
+		{"subscribers", "snoozed_until", "DATETIME"},
+		{"articles", "exclude", "TEXT NOT NULL DEFAULT ''"},
+		{"articles", "excerpt", "TEXT NOT NULL DEFAULT ''"},
+		{"articles", "word_count", "INTEGER NOT NULL DEFAULT 0"},
+		{"articles", "reading_time", "INTEGER NOT NULL DEFAULT 0"},
+		{"articles", "toc", "INTEGER NOT NULL DEFAULT 0"},
+		{"subscribers", "referred_by", "INTEGER"},
+		{"sent_emails", "cost", "REAL NOT NULL DEFAULT 0"},
+		{"sent_email_summaries", "cost", "REAL NOT NULL DEFAULT 0"},
+		{"subscribers", "locale", "TEXT NOT NULL DEFAULT ''"},
+		{"subscribers", "channels", "TEXT NOT NULL DEFAULT 'email'"},
+		{"articles", "version", "INTEGER NOT NULL DEFAULT 1"},
+		{"articles", "updated_at", "DATETIME"},
+		{"consent_log", "source", "TEXT NOT NULL DEFAULT ''"},
+	}
+	for _, m := range migrations {
+		if err := addColumnIfMissing(db, m.table, m.column, m.definition); err != nil {
+			log.Fatal(err)
+		}
+	}
+	migrateSentEmailsUnique(db)
+}
+
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	log.Printf("adding column %s.%s", table, column)
+	_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
 // func dropTables(db *sql.DB) {
 // 	log.Println("dropping tables...")
 // 	_, err := db.Exec(`
@@ -123,7 +784,38 @@ func createTables(db *sql.DB) {
 // 	log.Println("All tables dropped!")
 // }
 
-func handleSubscribe(db *sql.DB) http.HandlerFunc {
+// subscribe adds a subscriber with their consent record. referredBy is the
+// subscriber who referred them, or 0.
+func subscribe(db *sql.DB, r *http.Request, sub Subscriber, source string, referredBy int) (int, error) {
+	locale := signupLocale(db, r, sub.Locale)
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO subscribers (email, name, source, referred_by, locale) VALUES (?, ?, ?, ?, ?)", sub.Email, sub.Name, source, nullableID(referredBy), locale)
+	if err != nil {
+		return 0, err
+	}
+	subscriberID, _ := result.LastInsertId()
+	if err := recordConsent(tx, r, int(subscriberID), consentActionSubscribe, sub.ConsentVersion); err != nil {
+		log.Printf("Error recording consent: %v", err)
+		return 0, err
+	}
+	detail := map[string]string{"source": source}
+	if referredBy != 0 {
+		detail["referred_by"] = strconv.Itoa(referredBy)
+	}
+	recordEvent(tx, int(subscriberID), eventSubscribed, 0, eventDetail(detail))
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	invalidateSubscriberCount(db)
+	return int(subscriberID), nil
+}
+
+func handleSubscribe(db *sql.DB, sender EmailSender) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -136,19 +828,24 @@ func handleSubscribe(db *sql.DB) http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		if err := validateEmailAddress(sub.Email); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-		_, err = db.Exec("INSERT INTO subscribers (email, name) VALUES (?, ?)", sub.Email, sub.Name)
+		id, err := subscribe(db, r, sub, subscribeSource(r, sub), 0)
 		if err != nil {
 			http.Error(w, "Error subscribing", http.StatusInternalServerError)
 			return
 		}
+		goSendSystemEmail(context.WithoutCancel(r.Context()), db, sender, id, systemEmailWelcome)
 
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("Subscribed successfully"))
 	}
 }
 
-func handlePublish(db *sql.DB) http.HandlerFunc {
+func handlePublish(db *sql.DB, sender EmailSender) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -162,23 +859,88 @@ func handlePublish(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		result, err := db.Exec("INSERT INTO articles (title, content) VALUES (?, ?)", article.Title, article.Content)
+		if err := validateArticleOverrides(article); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		articleID, err := insertArticle(db, r, article)
 		if err != nil {
 			http.Error(w, "Error publishing article", http.StatusInternalServerError)
 			return
 		}
 
-		articleID, _ := result.LastInsertId()
-
-		// Trigger newsletter sending
-		go sendNewsletterForArticle(db, int(articleID))
+		// Trigger newsletter sending, unless it is scheduled for later
+		if sendsOnPublish(article, time.Now()) {
+			goSendNewsletter(context.WithoutCancel(r.Context()), db, sender, articleID)
+		}
 
+		// API clients ask for JSON to learn the new article's id.
+		if strings.Contains(r.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]int{"id": articleID})
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("Article published successfully"))
 	}
 }
 
-func handleSendNewsletter(db *sql.DB) http.HandlerFunc {
+// insertArticle stores a validated article and records it in the audit log.
+func insertArticle(db *sql.DB, r *http.Request, article Article) (int, error) {
+	var scheduledAt interface{}
+	if t := article.scheduledTime(); !t.IsZero() {
+		scheduledAt = t.Format(sqliteTimeFormat)
+	}
+	exclude, err := encodeExclusion(article.Exclude)
+	if err != nil {
+		return 0, err
+	}
+	setReadingStats(&article)
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec("INSERT INTO articles (title, content, subject, reply_to, series, premium, min_engagement, scheduled_at, event_start, event_end, event_location, from_name, exclude, excerpt, word_count, reading_time, toc) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		article.Title, article.Content, article.Subject, article.ReplyTo, article.Series, article.Premium, article.MinEngagement, scheduledAt,
+		article.EventStart, article.EventEnd, article.EventLocation, article.FromName, exclude, article.Excerpt, article.WordCount, article.ReadingTime, article.TOC)
+	if err != nil {
+		return 0, err
+	}
+	articleID, _ := result.LastInsertId()
+	if err := setArticleAuthors(tx, int(articleID), article.Authors); err != nil {
+		return 0, err
+	}
+	if err := setArticlePoll(tx, int(articleID), article.Poll); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	recordAudit(db, r, "publish", "article", int(articleID), map[string]string{
+		"title":          article.Title,
+		"content":        article.Content,
+		"subject":        article.Subject,
+		"reply_to":       article.ReplyTo,
+		"series":         article.Series,
+		"premium":        strconv.FormatBool(article.Premium),
+		"min_engagement": strconv.FormatFloat(article.MinEngagement, 'f', -1, 64),
+		"scheduled_at":   formatOptionalTime(article.ScheduledAt),
+		"event_start":    article.EventStart,
+		"event_end":      article.EventEnd,
+		"event_location": article.EventLocation,
+		"from_name":      article.FromName,
+		"authors":        authorByline(article.Authors),
+		"exclude":        exclude,
+		"excerpt":        article.Excerpt,
+		"toc":            strconv.FormatBool(article.TOC),
+	})
+	return int(articleID), nil
+}
+
+func handleSendNewsletter(db *sql.DB, sender EmailSender) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -187,6 +949,8 @@ func handleSendNewsletter(db *sql.DB) http.HandlerFunc {
 
 		var req struct {
 			ArticleID int `json:"article_id"`
+			// Exclude, if given, replaces the article's exclusion.
+			Exclude *Exclusion `json:"exclude"`
 		}
 		err := json.NewDecoder(r.Body).Decode(&req)
 		if err != nil {
@@ -194,122 +958,401 @@ func handleSendNewsletter(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		go sendNewsletterForArticle(db, req.ArticleID)
+		var diff map[string]interface{}
+		if req.Exclude != nil {
+			if err := req.Exclude.validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			found, err := setArticleExclusion(db, req.ArticleID, req.Exclude)
+			if err != nil {
+				log.Printf("Error setting exclusion of article %d: %v", req.ArticleID, err)
+				http.Error(w, "Error setting exclusion", http.StatusInternalServerError)
+				return
+			}
+			if !found {
+				http.Error(w, "Article not found", http.StatusNotFound)
+				return
+			}
+			diff = map[string]interface{}{"exclude": req.Exclude}
+		}
+		recordAudit(db, r, "send", "article", req.ArticleID, diff)
+		goSendNewsletter(context.WithoutCancel(r.Context()), db, sender, req.ArticleID)
 
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("Newsletter sending triggered"))
 	}
 }
 
-func sendNewsletterForArticle(db *sql.DB, articleID int) {
+// goSendNewsletter sends the article's newsletter in the background,
+// counted in activeSends so shutdown waits for it.
+func goSendNewsletter(ctx context.Context, db *sql.DB, sender EmailSender, articleID int) {
+	activeSends.Add(1)
+	go func() {
+		defer activeSends.Done()
+		ctx, cancel := untilShutdown(ctx)
+		defer cancel()
+		sendNewsletterForArticle(ctx, db, sender, articleID)
+	}()
+}
+
+func sendNewsletterForArticle(ctx context.Context, db *sql.DB, sender EmailSender, articleID int) {
+	ctx, span := tracer.Start(ctx, "newsletter.send", trace.WithAttributes(attribute.Int("article.id", articleID)))
+	defer span.End()
+	unlock := lockArticleSend(articleID)
+	defer unlock()
+
+	jobID, err := startJob(ctx, db, articleID)
+	if err != nil {
+		log.Printf("Error starting job for article %d: %v", articleID, err)
+	}
+	job := NewsletterJob{ID: jobID, ArticleID: articleID, Status: jobCompleted}
+	defer func() {
+		// The pipeline runs outside any HTTP handler, so a panic here would
+		// otherwise take the whole process down.
+		if rec := recover(); rec != nil {
+			log.Printf("Panic sending article %d: %v\n%s", articleID, rec, debug.Stack())
+			reportPanic(ctx, rec)
+			job.Status, job.Report.Error = jobFailed, fmt.Sprint("panic: ", rec)
+		} else if job.Status == jobFailed {
+			reportError(ctx, errors.New(job.Report.Error), map[string]string{
+				"stage":      "job",
+				"article_id": strconv.Itoa(articleID),
+				"job_id":     strconv.Itoa(jobID),
+			})
+		}
+		if jobID != 0 {
+			finishJob(context.WithoutCancel(ctx), db, job)
+		}
+		if job.Status == jobFailed || job.Status == jobBlocked {
+			notifyJobFailure(ctx, db, sender, job)
+		}
+	}()
+
 	log.Println("sending blog post")
-	article, err := getArticle(db, articleID)
+	article, err := getArticle(ctx, db, articleID)
 	if err != nil {
 		log.Printf("Error getting article: %v", err)
+		endSpan(span, err)
+		job.Status, job.Report.Error = jobFailed, err.Error()
+		return
+	}
+
+	// The rows that prevent duplicate sends are gone once archived.
+	if archived, err := sendHistoryArchived(ctx, db, articleID); err != nil || archived {
+		if err == nil {
+			err = fmt.Errorf("send history of article %d has been archived", articleID)
+		}
+		log.Printf("Error sending article %d: %v", articleID, err)
+		endSpan(span, err)
+		job.Status, job.Report.Error = jobFailed, err.Error()
 		return
 	}
 
-	subscribers, err := getSubscribers(db)
+	preflight, blocked := runPreflight(ctx, article)
+	job.Report.Preflight = preflight
+	if blocked {
+		job.Status = jobBlocked
+		return
+	}
+
+	if shortLinksEnabled() && trackingEnabled() && flagEnabled(flagTracking) {
+		if article.shortLinks, err = registerShortLinks(ctx, db, article); err != nil {
+			// Without codes every link uses the long redirect.
+			log.Printf("Error creating short links for article %d: %v", articleID, err)
+		}
+	}
+
+	subscribers, err := getSubscribers(ctx, db, article)
 	if err != nil {
 		log.Printf("Error getting subscribers: %v", err)
+		endSpan(span, err)
+		job.Status, job.Report.Error = jobFailed, err.Error()
+		return
+	}
+
+	remaining, limited, err := warmupRemaining(ctx, db, time.Now())
+	if err != nil {
+		log.Printf("Error computing warm-up quota: %v", err)
+		endSpan(span, err)
+		job.Status, job.Report.Error = jobFailed, err.Error()
+		return
+	}
+
+	received, err := receivedArticle(ctx, db, articleID)
+	if err != nil {
+		log.Printf("Error checking sent emails: %v", err)
+		endSpan(span, err)
+		job.Status, job.Report.Error = jobFailed, err.Error()
 		return
 	}
+	// Only a first send is split: once anyone has received the article a
+	// canary has already been sent or deliberately skipped.
+	canary := 0
+	if len(received) == 0 {
+		if canary = canaryRecipients(len(subscribers)); canary > 0 {
+			subscribers = append([]Subscriber(nil), subscribers...)
+			shuffleSubscribers(subscribers)
+		}
+	}
+	batch := newSentEmailBatch(db, articleID)
+	defer func() {
+		// Messages already handed to the provider must be recorded even
+		// when the send was cancelled, or a resend would duplicate them.
+		if err := batch.flush(context.WithoutCancel(ctx)); err != nil {
+			log.Printf("Error marking emails as sent: %v", err)
+		}
+	}()
 
+	// A run of failures usually means the provider is down or rejecting
+	// our credentials; report the first such run, once per job.
+	failureThreshold := getEnvInt("ERROR_REPORT_SEND_FAILURES", 3)
+	consecutiveFailures := 0
+	reportedFailures := false
 	for _, sub := range subscribers {
-		if !hasReceivedArticle(db, sub.ID, articleID) {
-			if sendEmail(sub, article) {
-				markEmailSent(db, sub.ID, articleID)
+		if ctx.Err() != nil {
+			break
+		}
+		if !received[sub.ID] && sub.wants(channelEmail) {
+			if canary > 0 && job.Sent+job.Failed >= canary {
+				job.Report.CanaryHeld++
+				continue
+			}
+			if limited && remaining <= 0 {
+				job.Report.Deferred++
+				continue
 			}
+			claimed, err := claimRecipient(ctx, db, sub.ID, articleID)
+			if err != nil {
+				log.Printf("Error claiming subscriber %d for article %d: %v", sub.ID, articleID, err)
+				job.Failed++
+				continue
+			}
+			if !claimed {
+				// Sent meanwhile by another process.
+				continue
+			}
+			remaining--
+			messageID, providerID, err := sendEmail(ctx, db, sender, sub, article)
+			if err != nil {
+				releaseRecipient(context.WithoutCancel(ctx), db, sub.ID, articleID)
+			}
+			switch {
+			case err == nil:
+				if err := batch.add(ctx, sub.ID, messageID, providerID); err != nil {
+					log.Printf("Error marking emails as sent: %v", err)
+				}
+				job.Sent++
+				consecutiveFailures = 0
+			case errors.Is(err, errRender):
+				// Bad subscriber data, not a provider problem: note it
+				// and carry on with the batch.
+				job.Failed++
+				job.Report.addRenderFailure(sub, err)
+			default:
+				job.Failed++
+				if consecutiveFailures++; consecutiveFailures >= failureThreshold && !reportedFailures {
+					reportedFailures = true
+					reportError(ctx, fmt.Errorf("%d consecutive sends failed for article %d", consecutiveFailures, articleID), map[string]string{
+						"stage":         "send",
+						"article_id":    strconv.Itoa(articleID),
+						"subscriber_id": strconv.Itoa(sub.ID),
+					})
+				}
+			}
+		}
+	}
+	span.SetAttributes(attribute.Int("newsletter.subscribers", len(subscribers)), attribute.Int("newsletter.sent", job.Sent))
+	if job.Sent == 0 && job.Failed > 0 {
+		job.Status, job.Report.Error = jobFailed, fmt.Sprintf("all %d sends failed; see /api/dead-letters", job.Failed)
+		if job.Report.RenderFailed == job.Failed {
+			job.Report.Error = fmt.Sprintf("the newsletter could not be rendered for any of %d recipients", job.Failed)
 		}
 	}
+	if err := ctx.Err(); err != nil {
+		// Cancelled at shutdown. The claims let a later send of the
+		// article pick up where this one stopped.
+		job.Status, job.Report.Error = jobFailed, fmt.Sprintf("interrupted after %d sends: %v", job.Sent, err)
+	}
+	if n := job.Report.RenderFailed; n > 0 {
+		log.Printf("Could not render article %d for %d recipients; see job %d", articleID, n, job.ID)
+	}
+	if job.Report.Deferred > 0 && job.Status != jobFailed {
+		log.Printf("Warm-up cap reached, deferring %d recipients of article %d", job.Report.Deferred, articleID)
+		job.Status = jobDeferred
+	}
+	if job.Report.CanaryHeld > 0 && job.Status != jobFailed {
+		log.Printf("Canary sent for article %d, holding back %d recipients", articleID, job.Report.CanaryHeld)
+		job.Status = jobCanary
+	}
+
+	// Push and Telegram cannot be recalled, so they wait until every email
+	// recipient has been sent to: a held-back canary or a warm-up deferral
+	// fans out when evaluateCanaries or the resume task finishes the send.
+	if job.Status == jobCompleted {
+		sent, failed := sendPushNotifications(ctx, db, article, subscribers)
+		job.Report.addChannel(channelPush, sent, failed)
+		sent, failed = broadcastToTelegram(ctx, db, article)
+		job.Report.addChannel(channelTelegram, sent, failed)
+	}
+	if job.Sent > 0 {
+		sendArchiveCopy(ctx, db, sender, article)
+	}
 }
 
-func getArticle(db *sql.DB, id int) (Article, error) {
+func getArticle(ctx context.Context, db *sql.DB, id int) (Article, error) {
+	if article, ok := articleCache.get(articleKey{db, id}); ok {
+		return article, nil
+	}
+	const query = "SELECT id, title, content, published_at, subject, reply_to, series, premium, min_engagement, scheduled_at, event_start, event_end, event_location, from_name, exclude, excerpt, word_count, reading_time, toc, version, updated_at FROM articles WHERE id = ? AND deleted_at IS NULL"
+	ctx, span := startDBSpan(ctx, "db.getArticle", query)
 	var article Article
-	err := db.QueryRow("SELECT id, title, content, published_at FROM articles WHERE id = ?", id).Scan(
-		&article.ID, &article.Title, &article.Content, &article.PublishedAt)
-	return article, err
+	var exclude string
+	err := db.QueryRowContext(ctx, query, id).Scan(
+		&article.ID, &article.Title, &article.Content, scanTime(&article.PublishedAt), &article.Subject, &article.ReplyTo, &article.Series, &article.Premium, &article.MinEngagement, scanNullTime(&article.ScheduledAt),
+		&article.EventStart, &article.EventEnd, &article.EventLocation, &article.FromName, &exclude, &article.Excerpt, &article.WordCount, &article.ReadingTime, &article.TOC, &article.Version, scanNullTime(&article.UpdatedAt))
+	endSpan(span, err)
+	if err != nil {
+		return article, err
+	}
+	if article.Exclude, err = decodeExclusion(exclude); err != nil {
+		return article, err
+	}
+	if article.WordCount == 0 {
+		// Published before reading stats were stored.
+		setReadingStats(&article)
+	}
+	if article.Authors, err = getArticleAuthors(ctx, db, id); err != nil {
+		return article, err
+	}
+	if article.Poll, err = getArticlePoll(ctx, db, id); err != nil {
+		return article, err
+	}
+	articleCache.set(articleKey{db, id}, article)
+	return article, nil
 }
 
-func getSubscribers(db *sql.DB) ([]Subscriber, error) {
-	rows, err := db.Query("SELECT id, email, name FROM subscribers")
+// audienceWhere returns the condition selecting an article's audience:
+// its segment less any exclusions.
+func audienceWhere(article Article) (string, []interface{}) {
+	where, args := articleSegment(article).where()
+	if cond, excludeArgs := article.Exclude.where(); cond != "" {
+		where += " AND " + cond
+		args = append(args, excludeArgs...)
+	}
+	return where, args
+}
+
+// getSubscribers returns the active subscribers who may receive article.
+func getSubscribers(ctx context.Context, db *sql.DB, article Article) (subscribers []Subscriber, err error) {
+	where, args := audienceWhere(article)
+	query := "SELECT id, email, name, tier, tracking_opt_out, channels FROM subscribers WHERE " + where
+	ctx, span := startDBSpan(ctx, "db.getSubscribers", query)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var subscribers []Subscriber
 	for rows.Next() {
 		var s Subscriber
-		if err := rows.Scan(&s.ID, &s.Email, &s.Name); err != nil {
+		var channels string
+		if err := rows.Scan(&s.ID, &s.Email, &s.Name, &s.Tier, &s.TrackingOptOut, &channels); err != nil {
 			return nil, err
 		}
+		s.Channels = parseChannels(channels)
 		subscribers = append(subscribers, s)
 	}
 	return subscribers, nil
 }
 
-func hasReceivedArticle(db *sql.DB, subscriberID, articleID int) bool {
-	var count int
-	err := db.QueryRow("SELECT COUNT(*) FROM sent_emails WHERE subscriber_id = ? AND article_id = ?",
-		subscriberID, articleID).Scan(&count)
-	if err != nil {
-		log.Printf("Error checking sent email: %v", err)
-		return false
-	}
-	return count > 0
-}
-
-func markEmailSent(db *sql.DB, subscriberID, articleID int) {
-	_, err := db.Exec("INSERT INTO sent_emails (subscriber_id, article_id) VALUES (?, ?)",
-		subscriberID, articleID)
+// markEmailSent fills in a claimed send's message ids.
+func markEmailSent(ctx context.Context, db *sql.DB, subscriberID, articleID int, messageID, providerID string) {
+	const query = "UPDATE sent_emails SET message_id = ?, provider_message_id = ?, cost = ? WHERE subscriber_id = ? AND article_id = ?"
+	ctx, span := startDBSpan(ctx, "db.markEmailSent", query)
+	_, err := db.ExecContext(ctx, query, messageID, providerID, sendCostPerEmail(), subscriberID, articleID)
+	endSpan(span, err)
 	if err != nil {
 		log.Printf("Error marking email as sent: %v", err)
+		return
 	}
+	recordEvent(db, subscriberID, eventSent, articleID, "")
 }
 
-func sendEmail(sub Subscriber, article Article) bool {
-	// Read the email template file
-	templateContent, err := os.ReadFile("email_template.html")
+// errRender marks a newsletter that could not be rendered for one
+// subscriber, usually because of their data rather than the provider.
+var errRender = errors.New("rendering failed")
+
+// sendEmail delivers the article to sub and returns the Message-ID it was
+// sent with and the provider's identifier for it. Render failures wrap
+// errRender.
+func sendEmail(ctx context.Context, db *sql.DB, sender EmailSender, sub Subscriber, article Article) (string, string, error) {
+	ctx, span := tracer.Start(ctx, "email.send", trace.WithAttributes(attribute.Int("subscriber.id", sub.ID)))
+	defer span.End()
+
+	m, err := buildNewsletterMessage(sub, article)
 	if err != nil {
-		log.Printf("Error reading email template file: %v", err)
-		return false
+		log.Printf("Error building email for %s: %v", sub.Email, err)
+		reportError(ctx, err, map[string]string{
+			"stage":         "render",
+			"article_id":    strconv.Itoa(article.ID),
+			"subscriber_id": strconv.Itoa(sub.ID),
+		})
+		endSpan(span, err)
+		return "", "", fmt.Errorf("%w: %v", errRender, err)
+	}
+	if err := setThreadingHeaders(ctx, db, m, sub, article); err != nil {
+		// Threading is cosmetic; send the issue unthreaded.
+		log.Printf("Error looking up series history for %s: %v", sub.Email, err)
 	}
 
-	t, err := template.New("email").Parse(string(templateContent))
+	sendCtx, sendSpan := tracer.Start(ctx, "sender.Send", trace.WithSpanKind(trace.SpanKindClient))
+	providerID, failures, err := sendWithRetry(sendCtx, sender, m)
+	endSpan(sendSpan, err)
 	if err != nil {
-		log.Printf("Error parsing email template: %v", err)
-		return false
+		log.Printf("Error sending email to %s after %d attempts: %v", sub.Email, len(failures), err)
+		if err := recordDeadLetter(ctx, db, sub.ID, article.ID, failures); err != nil {
+			log.Printf("Error recording dead letter for %s: %v", sub.Email, err)
+		}
+		endSpan(span, err)
+		return "", "", err
 	}
 
-	var body bytes.Buffer
-	if err := t.Execute(&body, map[string]interface{}{
-		"Name":    sub.Name,
-		"Title":   article.Title,
-		"Content": article.Content,
-	}); err != nil {
-		log.Printf("Error executing template: %v", err)
-		return false
-	}
+	return messageIDOf(m), providerID, nil
+}
 
-	m := gomail.NewMessage()
-	m.SetHeader("From", os.Getenv("EMAIL_FROM"))
-	m.SetHeader("To", sub.Email)
-	m.SetHeader("Subject", "New Blog Post: "+article.Title)
-	m.SetBody("text/html", body.String())
+// subscriberColumns are the columns scanSubscriber reads, in order.
+const subscriberColumns = "id, email, name, subscribed_at, source, tier, engagement_score, tracking_opt_out, locale, channels"
 
-	d := gomail.NewDialer(os.Getenv("SMTP_HOST"), 587, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"))
+func scanSubscriber(rows *sql.Rows) (Subscriber, error) {
+	var s Subscriber
+	var channels string
+	err := rows.Scan(&s.ID, &s.Email, &s.Name, scanTime(&s.SubscribedAt), &s.Source, &s.Tier, &s.EngagementScore, &s.TrackingOptOut, &s.Locale, &channels)
+	s.Channels = parseChannels(channels)
+	return s, err
+}
 
-	if err := d.DialAndSend(m); err != nil {
-		log.Printf("Error sending email to %s: %v", sub.Email, err)
-		return false
+// getSubscriber returns a subscriber that has not been deleted, or
+// sql.ErrNoRows.
+func getSubscriber(db *sql.DB, id int) (Subscriber, error) {
+	rows, err := db.Query("SELECT "+subscriberColumns+" FROM subscribers WHERE id = ? AND deleted_at IS NULL", id)
+	if err != nil {
+		return Subscriber{}, err
 	}
-
-	return true
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return Subscriber{}, err
+		}
+		return Subscriber{}, sql.ErrNoRows
+	}
+	return scanSubscriber(rows)
 }
 
 func getAllSubscribers(db *sql.DB) ([]Subscriber, error) {
-	rows, err := db.Query("SELECT id, email, name, subscribed_at FROM subscribers")
+	rows, err := db.Query("SELECT " + subscriberColumns + " FROM subscribers WHERE deleted_at IS NULL")
 	if err != nil {
 		return nil, err
 	}
@@ -317,8 +1360,8 @@ func getAllSubscribers(db *sql.DB) ([]Subscriber, error) {
 
 	var subscribers []Subscriber
 	for rows.Next() {
-		var s Subscriber
-		if err := rows.Scan(&s.ID, &s.Email, &s.Name, &s.SubscribedAt); err != nil {
+		s, err := scanSubscriber(rows)
+		if err != nil {
 			return nil, err
 		}
 		subscribers = append(subscribers, s)
@@ -326,8 +1369,18 @@ func getAllSubscribers(db *sql.DB) ([]Subscriber, error) {
 	return subscribers, nil
 }
 
+// articleColumns are the columns scanArticle reads, in order.
+const articleColumns = "id, title, content, published_at, subject, reply_to, series, premium, min_engagement, scheduled_at, event_start, event_end, event_location, from_name"
+
+func scanArticle(rows *sql.Rows) (Article, error) {
+	var a Article
+	err := rows.Scan(&a.ID, &a.Title, &a.Content, scanTime(&a.PublishedAt), &a.Subject, &a.ReplyTo, &a.Series, &a.Premium, &a.MinEngagement, scanNullTime(&a.ScheduledAt),
+		&a.EventStart, &a.EventEnd, &a.EventLocation, &a.FromName)
+	return a, err
+}
+
 func getAllArticles(db *sql.DB) ([]Article, error) {
-	rows, err := db.Query("SELECT id, title, content, published_at FROM articles")
+	rows, err := db.Query("SELECT " + articleColumns + " FROM articles WHERE deleted_at IS NULL")
 	if err != nil {
 		return nil, err
 	}
@@ -335,8 +1388,8 @@ func getAllArticles(db *sql.DB) ([]Article, error) {
 
 	var articles []Article
 	for rows.Next() {
-		var a Article
-		if err := rows.Scan(&a.ID, &a.Title, &a.Content, &a.PublishedAt); err != nil {
+		a, err := scanArticle(rows)
+		if err != nil {
 			return nil, err
 		}
 		articles = append(articles, a)
@@ -344,8 +1397,17 @@ func getAllArticles(db *sql.DB) ([]Article, error) {
 	return articles, nil
 }
 
+// sentEmailColumns are the columns scanSentEmail reads, in order.
+const sentEmailColumns = "id, subscriber_id, article_id, sent_at, COALESCE(message_id, ''), COALESCE(provider_message_id, ''), delivery_status"
+
+func scanSentEmail(rows *sql.Rows) (SentEmail, error) {
+	var se SentEmail
+	err := rows.Scan(&se.ID, &se.SubscriberID, &se.ArticleID, scanTime(&se.SentAt), &se.MessageID, &se.ProviderMessageID, &se.DeliveryStatus)
+	return se, err
+}
+
 func getAllSentEmails(db *sql.DB) ([]SentEmail, error) {
-	rows, err := db.Query("SELECT id, subscriber_id, article_id, sent_at FROM sent_emails")
+	rows, err := db.Query("SELECT " + sentEmailColumns + " FROM sent_emails")
 	if err != nil {
 		return nil, err
 	}
@@ -353,8 +1415,8 @@ func getAllSentEmails(db *sql.DB) ([]SentEmail, error) {
 
 	var sentEmails []SentEmail
 	for rows.Next() {
-		var se SentEmail
-		if err := rows.Scan(&se.ID, &se.SubscriberID, &se.ArticleID, &se.SentAt); err != nil {
+		se, err := scanSentEmail(rows)
+		if err != nil {
 			return nil, err
 		}
 		sentEmails = append(sentEmails, se)
@@ -378,23 +1440,57 @@ func getAllData(db *sql.DB) (*AllData, error) {
 		return nil, err
 	}
 
+	bySource, err := getSubscribersBySource(db)
+	if err != nil {
+		return nil, err
+	}
+
+	archived, err := archivedSentEmailCount(db)
+	if err != nil {
+		return nil, err
+	}
+
+	referrers, err := getTopReferrers(db, maxTopReferrers)
+	if err != nil {
+		return nil, err
+	}
+
 	return &AllData{
-		SubscriberCount: len(subscribers),
-		SentEmailCount:  len(sentEmails),
-		ArticleCount:    len(articles),
-		Subscribers:     subscribers,
-		SentEmails:      sentEmails,
-		Articles:        articles,
+		ArchivedSentEmailCount: archived,
+		SubscribersBySource:    bySource,
+		TopReferrers:           referrers,
+		SubscriberCount:        len(subscribers),
+		SentEmailCount:         len(sentEmails),
+		ArticleCount:           len(articles),
+		Subscribers:            subscribers,
+		SentEmails:             sentEmails,
+		Articles:               articles,
 	}, nil
 }
 
 func handleGetAllData(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		includeSubscribers := requestCan(r, permSubscribers)
+		etag, lastModified, err := statsVersion(db, includeSubscribers)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if checkNotModified(w, r, etag, lastModified) {
+			return
+		}
+
 		data, err := getAllData(db)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if !includeSubscribers {
+			data.Subscribers = nil
+			for i := range data.TopReferrers {
+				data.TopReferrers[i].Email = ""
+			}
+		}
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(data); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultMaintenanceMessage is shown on public pages when maintenance mode
+// is on without a message.
+const defaultMaintenanceMessage = "We are doing some maintenance. Please try again later."
+
+// maintenancePollInterval is how often paused sends check whether
+// maintenance mode has ended.
+var maintenancePollInterval = 5 * time.Second
+
+// Maintenance is the maintenance mode setting. While it is on no email is
+// sent and public endpoints answer 503 with Message.
+type Maintenance struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message,omitempty"`
+}
+
+// maintenance caches the stored setting.
+var maintenance = struct {
+	sync.RWMutex
+	m Maintenance
+}{}
+
+// loadMaintenance reads the stored setting into the cache.
+func loadMaintenance(db *sql.DB) error {
+	var m Maintenance
+	err := db.QueryRow("SELECT enabled, message FROM maintenance WHERE id = 1").Scan(&m.Enabled, &m.Message)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	maintenance.Lock()
+	maintenance.m = m
+	maintenance.Unlock()
+	return nil
+}
+
+// currentMaintenance returns the cached setting.
+func currentMaintenance() Maintenance {
+	maintenance.RLock()
+	defer maintenance.RUnlock()
+	return maintenance.m
+}
+
+// setMaintenance stores the setting and updates the cache.
+func setMaintenance(db *sql.DB, m Maintenance) error {
+	_, err := db.Exec(`
+		INSERT INTO maintenance (id, enabled, message) VALUES (1, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET enabled = excluded.enabled, message = excluded.message, updated_at = CURRENT_TIMESTAMP`,
+		m.Enabled, m.Message)
+	if err != nil {
+		return err
+	}
+	maintenance.Lock()
+	maintenance.m = m
+	maintenance.Unlock()
+	return nil
+}
+
+// waitForMaintenance blocks while maintenance mode is on, so send jobs
+// idle instead of failing. It returns early if ctx is done.
+func waitForMaintenance(ctx context.Context) error {
+	for currentMaintenance().Enabled {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(maintenancePollInterval):
+		}
+	}
+	return nil
+}
+
+// unlessMaintenance answers 503 with the maintenance message while
+// maintenance mode is on. It wraps the public endpoints; the admin API,
+// and the unsubscribe and tracking links in emails already sent, keep
+// working.
+func unlessMaintenance(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m := currentMaintenance()
+		if !m.Enabled {
+			next(w, r)
+			return
+		}
+		msg := m.Message
+		if msg == "" {
+			msg = defaultMaintenanceMessage
+		}
+		w.Header().Set("Retry-After", "300")
+		w.Header().Set("Cache-Control", "no-store")
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]string{"error": msg})
+			return
+		}
+		http.Error(w, msg, http.StatusServiceUnavailable)
+	}
+}
+
+// handleMaintenance returns (GET) or sets (POST) maintenance mode.
+func handleMaintenance(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var req Maintenance
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			before := currentMaintenance()
+			if err := setMaintenance(db, req); err != nil {
+				log.Printf("Error setting maintenance mode: %v", err)
+				http.Error(w, "Error setting maintenance mode", http.StatusInternalServerError)
+				return
+			}
+			if req.Enabled != before.Enabled {
+				log.Printf("Maintenance mode enabled=%v", req.Enabled)
+			}
+			recordAudit(db, r, "set", "maintenance", 0, map[string]interface{}{
+				"enabled": map[string]bool{"from": before.Enabled, "to": req.Enabled},
+				"message": req.Message,
+			})
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(currentMaintenance())
+	}
+}
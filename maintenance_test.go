@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	db := newTestDB(t)
+	srv := newTestServer(t, db, &mockSender{})
+	t.Cleanup(func() { setMaintenance(db, Maintenance{}) })
+
+	postJSON(t, srv.URL+"/api/admin/maintenance", `{"enabled":true,"message":"SMTP incident"}`)
+
+	resp, err := http.Post(srv.URL+"/api/subscribe", "application/json", strings.NewReader(`{"email":"ada@example.com"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("subscribe during maintenance = %d", resp.StatusCode)
+	}
+
+	// The admin API keeps working.
+	resp, err = http.Get(srv.URL + "/api/admin/maintenance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("admin API during maintenance = %d", resp.StatusCode)
+	}
+
+	// The setting survives a restart.
+	if err := loadMaintenance(db); err != nil {
+		t.Fatal(err)
+	}
+	if m := currentMaintenance(); !m.Enabled || m.Message != "SMTP incident" {
+		t.Fatalf("reloaded maintenance = %+v", m)
+	}
+
+	postJSON(t, srv.URL+"/api/admin/maintenance", `{"enabled":false}`)
+	postJSON(t, srv.URL+"/api/subscribe", `{"email":"ada@example.com"}`)
+}
+
+func TestMaintenancePausesSending(t *testing.T) {
+	db := newTestDB(t)
+	old := maintenancePollInterval
+	maintenancePollInterval = 10 * time.Millisecond
+	t.Cleanup(func() {
+		maintenancePollInterval = old
+		setMaintenance(db, Maintenance{})
+	})
+
+	mock := newMockSender("")
+	sender := &reloadableSender{sender: mock}
+	if err := setMaintenance(db, Maintenance{Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		m := gomail.NewMessage()
+		m.SetHeader("To", "ada@example.com")
+		_, err := sender.Send(context.Background(), m)
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if n := len(mock.Messages()); n != 0 {
+		t.Fatalf("%d emails sent during maintenance", n)
+	}
+
+	if err := setMaintenance(db, Maintenance{}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("send did not resume after maintenance")
+	}
+	if n := len(mock.Messages()); n != 1 {
+		t.Fatalf("%d emails sent after maintenance; want 1", n)
+	}
+}
+
+func TestUnsubscribeReturnsDuringMaintenance(t *testing.T) {
+	t.Setenv("TRACKING_SECRET", "secret")
+	t.Setenv("UNSUBSCRIBE_RECEIPT_ENABLED", "true")
+	db := newTestDB(t)
+	old := maintenancePollInterval
+	maintenancePollInterval = 10 * time.Millisecond
+	t.Cleanup(func() {
+		maintenancePollInterval = old
+		setMaintenance(db, Maintenance{})
+	})
+	mock := newMockSender("")
+	srv := newTestServer(t, db, &reloadableSender{sender: mock})
+	if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES ('ada@example.com', 'Ada')"); err != nil {
+		t.Fatal(err)
+	}
+	if err := setMaintenance(db, Maintenance{Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Timeout: time.Second}
+	resp, err := client.PostForm(srv.URL+"/u/"+trackingToken(1, 0, "unsubscribe"), url.Values{})
+	if err != nil {
+		t.Fatalf("unsubscribe during maintenance: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unsubscribe during maintenance = %d", resp.StatusCode)
+	}
+	if n := len(mock.Messages()); n != 0 {
+		t.Fatalf("%d emails sent during maintenance", n)
+	}
+
+	// The receipt goes out once maintenance ends.
+	if err := setMaintenance(db, Maintenance{}); err != nil {
+		t.Fatal(err)
+	}
+	activeSends.Wait()
+	if n := len(mock.Messages()); n != 1 {
+		t.Fatalf("%d emails sent after maintenance; want the receipt", n)
+	}
+}
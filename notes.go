@@ -0,0 +1,109 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxNoteLength = 4000
+
+// SubscriberNote is an admin annotation on a subscriber. Notes are
+// append-only: they are never edited or removed, except when the
+// subscriber is purged.
+type SubscriberNote struct {
+	ID           int       `json:"id"`
+	SubscriberID int       `json:"subscriber_id"`
+	Author       string    `json:"author"`
+	Body         string    `json:"body"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func getSubscriberNotes(db *sql.DB, subscriberID int) ([]SubscriberNote, error) {
+	rows, err := db.Query(`
+		SELECT id, subscriber_id, author, body, created_at
+		FROM subscriber_notes
+		WHERE subscriber_id = ?
+		ORDER BY id`, subscriberID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notes := []SubscriberNote{}
+	for rows.Next() {
+		var n SubscriberNote
+		if err := rows.Scan(&n.ID, &n.SubscriberID, &n.Author, &n.Body, scanTime(&n.CreatedAt)); err != nil {
+			return nil, err
+		}
+		notes = append(notes, n)
+	}
+	return notes, rows.Err()
+}
+
+// handleSubscriberNotes lists a subscriber's notes (GET) or appends one
+// (POST with {"body": ...}), authored by the caller.
+func handleSubscriberNotes(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid subscriber id", http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			notes, err := getSubscriberNotes(db, id)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(notes)
+
+		case http.MethodPost:
+			var req struct {
+				Body string `json:"body"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			req.Body = strings.TrimSpace(req.Body)
+			if req.Body == "" || len(req.Body) > maxNoteLength {
+				http.Error(w, "Note must be between 1 and 4000 characters", http.StatusBadRequest)
+				return
+			}
+
+			var exists bool
+			if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM subscribers WHERE id = ? AND deleted_at IS NULL)", id).Scan(&exists); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !exists {
+				http.Error(w, "Subscriber not found", http.StatusNotFound)
+				return
+			}
+
+			var note SubscriberNote
+			err := db.QueryRow(`
+				INSERT INTO subscriber_notes (subscriber_id, author, body)
+				VALUES (?, ?, ?)
+				RETURNING id, subscriber_id, author, body, created_at`,
+				id, requestActor(r), req.Body).Scan(&note.ID, &note.SubscriberID, &note.Author, &note.Body, scanTime(&note.CreatedAt))
+			if err != nil {
+				http.Error(w, "Error adding note", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(note)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
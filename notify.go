@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+// Alert kinds. Targets can subscribe to some of them only.
+const (
+	alertJobFailure     = "job_failure"
+	alertQueue          = "queue"
+	alertDeliverability = "deliverability"
+)
+
+// Alert is an operational notification for the admins.
+type Alert struct {
+	Kind    string `json:"kind"`
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+}
+
+// notifier delivers an alert to one target URL.
+type notifier func(ctx context.Context, sender EmailSender, target *url.URL, alert Alert) error
+
+// notifiers maps target URL schemes to the code that delivers to them.
+// Supporting another service means adding a scheme here.
+var notifiers = map[string]notifier{
+	"mailto":  notifyEmail,
+	"slack":   notifySlack,
+	"discord": notifyDiscord,
+	"json":    notifyJSON,
+	"jsons":   notifyJSON,
+}
+
+// notifyTarget is one configured alert destination.
+type notifyTarget struct {
+	url *url.URL
+	// kinds limits the alerts sent to the target; empty means all.
+	kinds []string
+}
+
+func (t notifyTarget) wants(kind string) bool {
+	return len(t.kinds) == 0 || containsString(t.kinds, kind)
+}
+
+// String is the target with its secrets removed, for logs.
+func (t notifyTarget) String() string {
+	return t.url.Scheme + "://" + t.url.Host
+}
+
+// parseNotifyTarget parses an alert target URL:
+//
+//	mailto:ops@example.com
+//	slack://T000/B000/XXXX              (a Slack incoming webhook)
+//	discord://<webhook id>/<token>
+//	json://host/path, jsons://host/path (POST the alert as JSON over HTTP(S))
+//
+// An events query parameter, e.g. ?events=job_failure,queue, limits the
+// target to those alert kinds.
+func parseNotifyTarget(raw string) (notifyTarget, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return notifyTarget{}, fmt.Errorf("invalid alert target: %w", err)
+	}
+	if _, ok := notifiers[u.Scheme]; !ok {
+		return notifyTarget{}, fmt.Errorf("unsupported alert target scheme %q", u.Scheme)
+	}
+	t := notifyTarget{url: u}
+	q := u.Query()
+	if events := q.Get("events"); events != "" {
+		for _, kind := range strings.Split(events, ",") {
+			kind = strings.TrimSpace(kind)
+			if kind != alertJobFailure && kind != alertQueue && kind != alertDeliverability {
+				return notifyTarget{}, fmt.Errorf("unknown alert kind %q", kind)
+			}
+			t.kinds = append(t.kinds, kind)
+		}
+		q.Del("events")
+		u.RawQuery = q.Encode()
+	}
+	return t, nil
+}
+
+// notifyTargets returns the targets in ALERT_TARGETS, a credential
+// holding target URLs separated by whitespace or commas. Invalid targets
+// are logged and skipped, so one typo does not silence every alert.
+func notifyTargets() []notifyTarget {
+	var targets []notifyTarget
+	for _, raw := range strings.FieldsFunc(credential("ALERT_TARGETS"), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\t'
+	}) {
+		t, err := parseNotifyTarget(raw)
+		if err != nil {
+			log.Printf("Error in ALERT_TARGETS: %v", err)
+			continue
+		}
+		targets = append(targets, t)
+	}
+	return targets
+}
+
+// dispatchAlert delivers the alert to every target that wants it.
+// Failures are logged; one failing target does not stop the others.
+func dispatchAlert(ctx context.Context, sender EmailSender, alert Alert) {
+	for _, t := range notifyTargets() {
+		if !t.wants(alert.Kind) {
+			continue
+		}
+		if err := notifiers[t.url.Scheme](ctx, sender, t.url, alert); err != nil {
+			log.Printf("Error sending alert to %s: %v", t, err)
+		}
+	}
+}
+
+func notifyEmail(ctx context.Context, sender EmailSender, target *url.URL, alert Alert) error {
+	to := target.Opaque
+	if to == "" && target.User != nil {
+		// mailto://user@host
+		to = target.User.Username() + "@" + target.Host
+	}
+	if to == "" {
+		return fmt.Errorf("mailto target has no address")
+	}
+	m := gomail.NewMessage()
+	setFromHeader(m, "")
+	m.SetHeader("To", to)
+	m.SetHeader("Subject", alert.Subject)
+	m.SetBody("text/plain", alert.Text)
+	_, err := sender.Send(ctx, m)
+	return err
+}
+
+// slackWebhookURL is the incoming webhook a slack:// target names.
+func slackWebhookURL(target *url.URL) string {
+	return "https://hooks.slack.com/services/" + target.Host + target.EscapedPath()
+}
+
+func notifySlack(ctx context.Context, _ EmailSender, target *url.URL, alert Alert) error {
+	return postNotification(ctx, slackWebhookURL(target), map[string]string{
+		"text": "*" + alert.Subject + "*\n\n" + alert.Text,
+	})
+}
+
+// maxDiscordMessage is the longest message content Discord accepts.
+const maxDiscordMessage = 2000
+
+// discordWebhookURL is the webhook a discord:// target names.
+func discordWebhookURL(target *url.URL) string {
+	return "https://discord.com/api/webhooks/" + target.Host + target.EscapedPath()
+}
+
+func notifyDiscord(ctx context.Context, _ EmailSender, target *url.URL, alert Alert) error {
+	content := "**" + alert.Subject + "**\n\n" + alert.Text
+	if r := []rune(content); len(r) > maxDiscordMessage {
+		content = string(r[:maxDiscordMessage-1]) + "…"
+	}
+	return postNotification(ctx, discordWebhookURL(target), map[string]string{"content": content})
+}
+
+func notifyJSON(ctx context.Context, _ EmailSender, target *url.URL, alert Alert) error {
+	u := *target
+	u.Scheme = "http"
+	if target.Scheme == "jsons" {
+		u.Scheme = "https"
+	}
+	return postNotification(ctx, u.String(), alert)
+}
+
+// postNotification POSTs payload as JSON and fails on a non-2xx reply.
+func postNotification(ctx context.Context, endpoint string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := outboundClient(0).Do(req)
+	if err != nil {
+		// The URL embeds the target's secret; keep it out of logs.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, maxWebhookResponse))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, snippet)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseNotifyTarget(t *testing.T) {
+	target, err := parseNotifyTarget("slack://T000/B000/XXXX?events=job_failure,queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := slackWebhookURL(target.url); got != "https://hooks.slack.com/services/T000/B000/XXXX" {
+		t.Errorf("slack URL = %q", got)
+	}
+	if !target.wants(alertQueue) || target.wants(alertDeliverability) {
+		t.Errorf("kinds = %v", target.kinds)
+	}
+	if target.String() != "slack://T000" {
+		t.Errorf("String() = %q", target)
+	}
+
+	target, _ = parseNotifyTarget("discord://1234/tok")
+	if got := discordWebhookURL(target.url); got != "https://discord.com/api/webhooks/1234/tok" {
+		t.Errorf("discord URL = %q", got)
+	}
+	if !target.wants(alertJobFailure) {
+		t.Error("a target without events should get every alert")
+	}
+
+	for _, bad := range []string{"ftp://example.com", "json://example.com?events=nope"} {
+		if _, err := parseNotifyTarget(bad); err == nil {
+			t.Errorf("parseNotifyTarget(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestNotifyAdminDispatchesToTargets(t *testing.T) {
+	var mu sync.Mutex
+	got := map[string]Alert{}
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a Alert
+		json.NewDecoder(r.Body).Decode(&a)
+		mu.Lock()
+		got[r.URL.Path] = a
+		mu.Unlock()
+	}))
+	defer hook.Close()
+	host := strings.TrimPrefix(hook.URL, "http://")
+
+	t.Setenv("ALERT_TARGETS", strings.Join([]string{
+		"json://" + host + "/all",
+		"json://" + host + "/queue?events=queue",
+		"mailto:ops@example.com?events=job_failure",
+		"bogus://ignored",
+	}, ", "))
+	db := newTestDB(t)
+	sender := newMockSender("")
+
+	notifyAdmin(context.Background(), db, sender, alertJobFailure, "Job failed", "details")
+
+	if a := got["/all"]; a.Kind != alertJobFailure || a.Subject != "Job failed" || a.Text != "details" {
+		t.Errorf("json target got %+v", a)
+	}
+	if _, ok := got["/queue"]; ok {
+		t.Error("queue-only target got a job failure alert")
+	}
+	if msgs := sender.Messages(); len(msgs) != 1 || msgs[0].GetHeader("To")[0] != "ops@example.com" {
+		t.Errorf("mailto target: %d messages", len(msgs))
+	}
+}
+
+func TestDeliverabilityMonitor(t *testing.T) {
+	t.Setenv("EMAIL_FROM", "news@example.com")
+	t.Setenv("ADMIN_EMAIL", "admin@example.com")
+	db := newTestDB(t)
+	sender := newMockSender("")
+	var m deliverabilityMonitor
+
+	// Nothing published: SPF and DMARC fail.
+	for i := 0; i < 2; i++ {
+		if err := m.check(context.Background(), db, sender, fakeResolver{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msgs := sender.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("sent %d alerts while failing, want 1", len(msgs))
+	}
+	if subject := msgs[0].GetHeader("Subject"); subject[0] != "Deliverability checks failing for example.com" {
+		t.Errorf("Subject = %v", subject)
+	}
+}
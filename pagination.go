@@ -0,0 +1,120 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// Page is one page of a keyset-paginated listing. NextCursor is empty on
+// the last page.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// encodeCursor returns an opaque cursor for the row after id. Clients must
+// not rely on its format.
+func encodeCursor(id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("id:" + strconv.Itoa(id)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil && len(b) > 3 && string(b[:3]) == "id:" {
+		if id, err := strconv.Atoi(string(b[3:])); err == nil && id >= 0 {
+			return id, nil
+		}
+	}
+	return 0, errors.New("invalid cursor")
+}
+
+// pageParams reads the cursor and limit query parameters.
+func pageParams(r *http.Request) (after, limit int, err error) {
+	limit = defaultPageSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return 0, 0, errors.New("invalid limit")
+		}
+		limit = min(limit, maxPageSize)
+	}
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		if after, err = decodeCursor(v); err != nil {
+			return 0, 0, err
+		}
+	}
+	return after, limit, nil
+}
+
+// queryPage runs a query that selects rows with id > after ordered by id,
+// and returns up to limit of them. The query's last two arguments are after
+// and the row limit; args fill any placeholders before them.
+func queryPage[T any](db *sql.DB, query string, after, limit int, scan func(*sql.Rows) (T, error), id func(T) int, args ...interface{}) (Page[T], error) {
+	page := Page[T]{Items: []T{}}
+	rows, err := db.Query(query, append(args, after, limit+1)...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item, err := scan(rows)
+		if err != nil {
+			return page, err
+		}
+		page.Items = append(page.Items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return page, err
+	}
+	if len(page.Items) > limit {
+		page.Items = page.Items[:limit]
+		page.NextCursor = encodeCursor(id(page.Items[limit-1]))
+	}
+	return page, nil
+}
+
+// writePage serves a listing page from query.
+func writePage[T any](w http.ResponseWriter, r *http.Request, db *sql.DB, query string, scan func(*sql.Rows) (T, error), id func(T) int, args ...interface{}) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	after, limit, err := pageParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := queryPage(db, query, after, limit, scan, id, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// handleListSubscribers lists subscribers in id order, a page at a time.
+func handleListSubscribers(db *sql.DB) http.HandlerFunc {
+	const query = "SELECT " + subscriberColumns + " FROM subscribers WHERE deleted_at IS NULL AND id > ? ORDER BY id LIMIT ?"
+	return func(w http.ResponseWriter, r *http.Request) {
+		writePage(w, r, db, query, scanSubscriber, func(s Subscriber) int { return s.ID })
+	}
+}
+
+// handleListSentEmails lists sent emails in id order, a page at a time.
+func handleListSentEmails(db *sql.DB) http.HandlerFunc {
+	const query = "SELECT " + sentEmailColumns + " FROM sent_emails WHERE id > ? ORDER BY id LIMIT ?"
+	return func(w http.ResponseWriter, r *http.Request) {
+		writePage(w, r, db, query, scanSentEmail, func(se SentEmail) int { return se.ID })
+	}
+}
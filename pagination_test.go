@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestSubscriberPagination(t *testing.T) {
+	db := newTestDB(t)
+	srv := newTestServer(t, db, newMockSender(""))
+
+	for i := 0; i < 5; i++ {
+		if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES (?, '')", fmt.Sprintf("s%d@example.com", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var emails []string
+	url := srv.URL + "/api/subscribers?limit=2"
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("pagination did not terminate")
+		}
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var page Page[Subscriber]
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range page.Items {
+			emails = append(emails, s.Email)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		url = srv.URL + "/api/subscribers?limit=2&cursor=" + page.NextCursor
+	}
+	if len(emails) != 5 || emails[0] != "s0@example.com" || emails[4] != "s4@example.com" {
+		t.Fatalf("emails = %v", emails)
+	}
+
+	resp, err := http.Get(srv.URL + "/api/subscribers?cursor=bogus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("bad cursor: status %d", resp.StatusCode)
+	}
+}
+
+func TestExportSentEmailsNDJSON(t *testing.T) {
+	db := newTestDB(t)
+	srv := newTestServer(t, db, newMockSender(""))
+
+	if _, err := db.Exec("INSERT INTO sent_emails (subscriber_id, article_id) VALUES (1, 1), (2, 1)"); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get(srv.URL + "/api/export/sent-emails.ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	dec := json.NewDecoder(resp.Body)
+	var ids []int
+	for dec.More() {
+		var se SentEmail
+		if err := dec.Decode(&se); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, se.SubscriberID)
+	}
+	if len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Fatalf("exported subscriber ids = %v", ids)
+	}
+}
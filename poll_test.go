@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"testing"
+)
+
+func TestPollResponses(t *testing.T) {
+	t.Setenv("TRACKING_SECRET", "secret")
+	db := newTestDB(t)
+	srv := newTestServer(t, db, &mockSender{})
+	t.Setenv("PUBLIC_BASE_URL", srv.URL)
+
+	postJSON(t, srv.URL+"/api/subscribe", `{"email":"a@example.com","name":"A"}`)
+	postJSON(t, srv.URL+"/api/publish", `{"title":"Hello","content":"Hi","scheduled_at":"2999-01-01T00:00:00Z",
+		"poll":{"question":"More Go?","options":["Yes","No"]}}`)
+
+	article, err := getArticle(context.Background(), db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := renderNewsletterBody(Subscriber{ID: 1}, article)
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := regexp.MustCompile(`href="(`+regexp.QuoteMeta(srv.URL)+`/p/[^"]+)"`).FindAllStringSubmatch(body, -1)
+	if len(links) != 2 {
+		t.Fatalf("found %d poll links in:\n%s", len(links), body)
+	}
+
+	// Answering twice keeps only the last answer.
+	for _, link := range []string{links[0][1], links[1][1]} {
+		resp, err := http.Get(link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s = %d", link, resp.StatusCode)
+		}
+	}
+
+	resp, err := http.Get(srv.URL + "/api/articles/1/poll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var results PollResults
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	if results.Responses != 1 || results.Options[0].Votes != 0 || results.Options[1].Votes != 1 {
+		t.Fatalf("results = %+v", results)
+	}
+}
+
+func TestPollLinkTampering(t *testing.T) {
+	t.Setenv("TRACKING_SECRET", "secret")
+	db := newTestDB(t)
+	srv := newTestServer(t, db, &mockSender{})
+
+	token := trackingToken(1, 1, pollTarget(0))
+	resp, err := http.Get(srv.URL + "/p/" + token + "?o=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("tampered option = %d; want 400", resp.StatusCode)
+	}
+}
+
+func TestPollValidation(t *testing.T) {
+	for _, p := range []*Poll{
+		{Question: "", Options: []string{"a", "b"}},
+		{Question: "Q", Options: []string{"a"}},
+		{Question: "Q", Options: []string{"a", " "}},
+	} {
+		if err := validateArticleOverrides(Article{Poll: p}); err == nil {
+			t.Errorf("poll %+v accepted", p)
+		}
+	}
+}
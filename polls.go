@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxPollOptions caps the number of answers a poll may offer.
+const maxPollOptions = 10
+
+// Poll is a one-click question embedded in an article's newsletter. Each
+// option is a signed link that records the subscriber's answer.
+type Poll struct {
+	Question string   `json:"question"`
+	Options  []string `json:"options"`
+}
+
+func (p *Poll) validate() error {
+	if p == nil {
+		return nil
+	}
+	if strings.TrimSpace(p.Question) == "" {
+		return errors.New("invalid poll: question is required")
+	}
+	if len(p.Options) < 2 || len(p.Options) > maxPollOptions {
+		return errors.New("invalid poll: needs 2 to " + strconv.Itoa(maxPollOptions) + " options")
+	}
+	for _, o := range p.Options {
+		if strings.TrimSpace(o) == "" {
+			return errors.New("invalid poll: options must not be empty")
+		}
+	}
+	return nil
+}
+
+// pollTarget is what a poll link's signature covers, so the option in the
+// link cannot be changed.
+func pollTarget(option int) string {
+	return "poll:" + strconv.Itoa(option)
+}
+
+// PollOption is an answer as shown in the newsletter. URL is empty when
+// links cannot be signed, e.g. in previews or without a signing key.
+type PollOption struct {
+	Label string
+	URL   string
+}
+
+// pollTemplateData returns the poll for the templates' .Poll, with one
+// answer link per option for this subscriber.
+func pollTemplateData(sub Subscriber, article Article) map[string]interface{} {
+	if article.Poll == nil {
+		return nil
+	}
+	options := make([]PollOption, len(article.Poll.Options))
+	for i, label := range article.Poll.Options {
+		options[i].Label = label
+		if sub.ID != 0 && trackingEnabled() {
+			token := trackingToken(sub.ID, article.ID, pollTarget(i))
+			options[i].URL = publicURL("p/"+token) + "?o=" + strconv.Itoa(i)
+		}
+	}
+	return map[string]interface{}{"Question": article.Poll.Question, "Options": options}
+}
+
+// setArticlePoll stores an article's poll.
+func setArticlePoll(db execer, articleID int, poll *Poll) error {
+	if poll == nil {
+		return nil
+	}
+	options, err := json.Marshal(poll.Options)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("INSERT INTO polls (article_id, question, options) VALUES (?, ?, ?)", articleID, poll.Question, string(options))
+	return err
+}
+
+// getArticlePoll returns an article's poll, or nil if it has none.
+func getArticlePoll(ctx context.Context, db *sql.DB, articleID int) (*Poll, error) {
+	var poll Poll
+	var options string
+	err := db.QueryRowContext(ctx, "SELECT question, options FROM polls WHERE article_id = ?", articleID).Scan(&poll.Question, &options)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(options), &poll.Options); err != nil {
+		return nil, err
+	}
+	return &poll, nil
+}
+
+// recordPollResponse stores a subscriber's answer. Answering again
+// replaces the earlier answer.
+func recordPollResponse(db *sql.DB, subscriberID, articleID, option int) error {
+	_, err := db.Exec(`
+		INSERT INTO poll_responses (article_id, subscriber_id, option) VALUES (?, ?, ?)
+		ON CONFLICT (article_id, subscriber_id) DO UPDATE SET option = excluded.option, responded_at = CURRENT_TIMESTAMP`,
+		articleID, subscriberID, option)
+	return err
+}
+
+// handlePollResponse records the answer from a poll link and thanks the
+// reader. Links are one-click by design; a later click by the same
+// subscriber, including one by a link scanner, replaces the answer.
+func handlePollResponse(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		option, err := strconv.Atoi(r.URL.Query().Get("o"))
+		if err != nil {
+			http.Error(w, "Invalid link", http.StatusBadRequest)
+			return
+		}
+		subscriberID, articleID, ok := parseTrackingToken(r.PathValue("token"), pollTarget(option))
+		if !ok {
+			http.Error(w, "Invalid link", http.StatusBadRequest)
+			return
+		}
+		poll, err := getArticlePoll(r.Context(), db, articleID)
+		if err != nil || poll == nil || option < 0 || option >= len(poll.Options) {
+			http.Error(w, "This poll is no longer available", http.StatusNotFound)
+			return
+		}
+		if err := recordPollResponse(db, subscriberID, articleID, option); err != nil {
+			log.Printf("Error recording poll response: %v", err)
+			http.Error(w, "Error recording your answer", http.StatusInternalServerError)
+			return
+		}
+
+		var page bytes.Buffer
+		if err := renderPage(r.Context(), &page, "poll.html", map[string]interface{}{"Question": poll.Question, "Answer": poll.Options[option]}); err != nil {
+			log.Printf("Error rendering poll page: %v", err)
+			http.Error(w, "Error rendering page", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(page.Bytes())
+	}
+}
+
+// PollResults are the answers to an article's poll.
+type PollResults struct {
+	Question  string             `json:"question"`
+	Options   []PollOptionResult `json:"options"`
+	Responses int                `json:"responses"`
+}
+
+// PollOptionResult is the number of subscribers who chose an option.
+type PollOptionResult struct {
+	Label string `json:"label"`
+	Votes int    `json:"votes"`
+}
+
+func getPollResults(ctx context.Context, db *sql.DB, articleID int) (*PollResults, error) {
+	poll, err := getArticlePoll(ctx, db, articleID)
+	if err != nil || poll == nil {
+		return nil, err
+	}
+	results := &PollResults{Question: poll.Question, Options: make([]PollOptionResult, len(poll.Options))}
+	for i, label := range poll.Options {
+		results.Options[i].Label = label
+	}
+	rows, err := db.QueryContext(ctx, "SELECT option, COUNT(*) FROM poll_responses WHERE article_id = ? GROUP BY option", articleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var option, votes int
+		if err := rows.Scan(&option, &votes); err != nil {
+			return nil, err
+		}
+		if option >= 0 && option < len(results.Options) {
+			results.Options[option].Votes = votes
+			results.Responses += votes
+		}
+	}
+	return results, rows.Err()
+}
+
+// handlePollResults returns the results of an article's poll.
+func handlePollResults(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid article id", http.StatusBadRequest)
+			return
+		}
+		results, err := getPollResults(r.Context(), db, id)
+		if err != nil {
+			log.Printf("Error getting poll results for article %d: %v", id, err)
+			http.Error(w, "Error getting poll results", http.StatusInternalServerError)
+			return
+		}
+		if results == nil {
+			http.Error(w, "Article has no poll", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// preferencesURL returns the signed preference center link for a send, or
+// "" when tracking links cannot be signed.
+func preferencesURL(subscriberID, articleID int) string {
+	if !trackingEnabled() || subscriberID == 0 {
+		return ""
+	}
+	return publicURL("preferences/" + trackingToken(subscriberID, articleID, "preferences"))
+}
+
+// setTrackingOptOut records whether a subscriber opted out of open and
+// click tracking.
+func setTrackingOptOut(db *sql.DB, subscriberID int, optOut bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE subscribers SET tracking_opt_out = ? WHERE id = ? AND tracking_opt_out != ?", optOut, subscriberID, optOut)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		recordEvent(tx, subscriberID, eventUpdated, 0, eventDetail(map[string]string{"tracking_opt_out": strconv.FormatBool(optOut)}))
+	}
+	return tx.Commit()
+}
+
+// snoozeEnd formats a snoozed_until value for the preference center, or
+// returns "" if the subscriber is not snoozed.
+func snoozeEnd(until string) string {
+	t, err := time.Parse(sqliteTimeFormat, until)
+	if err != nil || !t.After(time.Now()) {
+		return ""
+	}
+	return t.Format("2 January 2006")
+}
+
+// handlePreferences serves the preference center linked from the
+// newsletter footer. GET shows the subscriber's settings and POST saves
+// them.
+func handlePreferences(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		subscriberID, articleID, ok := parseTrackingToken(r.PathValue("token"), "preferences")
+		if !ok {
+			http.Error(w, "Invalid link", http.StatusBadRequest)
+			return
+		}
+
+		saved := false
+		if r.Method == http.MethodPost {
+			if err := r.ParseForm(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			// An empty snooze_weeks leaves the snooze as it is.
+			weeks := -1
+			if v := r.PostForm.Get("snooze_weeks"); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 0 || n > maxSnoozeWeeks {
+					http.Error(w, "Invalid snooze", http.StatusBadRequest)
+					return
+				}
+				weeks = n
+			}
+			// The form always sends an empty channels value, so a form with
+			// every box unticked can be told from one without the field.
+			if values, ok := r.PostForm["channels"]; ok {
+				var channels []string
+				for _, c := range values {
+					if c != "" {
+						channels = append(channels, c)
+					}
+				}
+				if err := setChannels(db, subscriberID, channels); err != nil {
+					http.Error(w, "Invalid channels: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			err := setTrackingOptOut(db, subscriberID, r.PostForm.Get("tracking_opt_out") != "")
+			if err == nil && weeks >= 0 {
+				err = setSnooze(db, subscriberID, weeks, time.Now())
+			}
+			if err != nil {
+				log.Printf("Error saving preferences of subscriber %d: %v", subscriberID, err)
+				http.Error(w, "Error saving preferences", http.StatusInternalServerError)
+				return
+			}
+			saved = true
+		}
+
+		var optOut bool
+		var snoozedUntil sql.NullString
+		var channels string
+		err := db.QueryRow("SELECT tracking_opt_out, strftime('%Y-%m-%d %H:%M:%S', snoozed_until), channels FROM subscribers WHERE id = ? AND deleted_at IS NULL", subscriberID).Scan(&optOut, &snoozedUntil, &channels)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Invalid link", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error loading preferences of subscriber %d: %v", subscriberID, err)
+			http.Error(w, "Error loading preferences", http.StatusInternalServerError)
+			return
+		}
+
+		type channelOption struct {
+			Name, Label string
+			Checked     bool
+		}
+		chosen := Subscriber{Channels: parseChannels(channels)}
+		var options []channelOption
+		for _, c := range availableChannels() {
+			options = append(options, channelOption{c, channelLabels[c], chosen.wants(c)})
+		}
+		_, pushKey, _ := vapidKeys()
+		var page bytes.Buffer
+		err = renderPage(r.Context(), &page, "preferences.html", map[string]interface{}{
+			"Saved":          saved,
+			"TrackingOptOut": optOut,
+			"SnoozedUntil":   snoozeEnd(snoozedUntil.String),
+			"Unsubscribe":    unsubscribeURL(subscriberID, articleID),
+			"Channels":       options,
+			"PushKey":        pushKey,
+			"Token":          r.PathValue("token"),
+			"TelegramURL":    telegramURL(),
+		})
+		if err != nil {
+			log.Printf("Error rendering preferences page: %v", err)
+			http.Error(w, "Error rendering page", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(page.Bytes())
+	}
+}
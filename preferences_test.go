@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrackingOptOut(t *testing.T) {
+	t.Setenv("TRACKING_SECRET", "secret")
+	db := newTestDB(t)
+	sender := newMockSender("")
+	srv := newTestServer(t, db, sender)
+	t.Setenv("PUBLIC_BASE_URL", srv.URL)
+
+	if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES ('ada@example.com', 'Ada'), ('grace@example.com', 'Grace')"); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.PostForm(preferencesURL(1, 0), url.Values{"tracking_opt_out": {"1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("saving preferences = %d", resp.StatusCode)
+	}
+
+	if _, err := db.Exec("INSERT INTO articles (title, content) VALUES ('Hello', '')"); err != nil {
+		t.Fatal(err)
+	}
+	sendNewsletterForArticle(context.Background(), db, sender, 1)
+
+	msgs := sender.Messages()
+	if len(msgs) != 2 {
+		t.Fatalf("sent %d emails; want 2", len(msgs))
+	}
+	for _, m := range msgs {
+		var b strings.Builder
+		m.WriteTo(&b)
+		tracked := strings.Contains(b.String(), "/t/o/")
+		if to := m.GetHeader("To")[0]; tracked != (to == "grace@example.com") {
+			t.Errorf("email to %s tracked = %v", to, tracked)
+		}
+	}
+}
+
+func TestPreferencesInvalidToken(t *testing.T) {
+	t.Setenv("TRACKING_SECRET", "secret")
+	db := newTestDB(t)
+	srv := newTestServer(t, db, &mockSender{})
+
+	resp, err := http.Get(srv.URL + "/preferences/" + trackingToken(1, 0, "unsubscribe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unsubscribe token accepted: %d", resp.StatusCode)
+	}
+}
+
+func TestPreferencesSnooze(t *testing.T) {
+	t.Setenv("TRACKING_SECRET", "secret")
+	db := newTestDB(t)
+	srv := newTestServer(t, db, &mockSender{})
+	t.Setenv("PUBLIC_BASE_URL", srv.URL)
+
+	if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES ('ada@example.com', 'Ada')"); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.PostForm(preferencesURL(1, 0), url.Values{"snooze_weeks": {"2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	want := time.Now().UTC().AddDate(0, 0, 14).Format("2 January 2006")
+	if !strings.Contains(string(body), "Paused until "+want) {
+		t.Fatalf("page does not show the snooze:\n%s", body)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+)
+
+// PreflightResult is the outcome of one pre-send check. A failing check
+// blocks the send; a warning is only reported.
+type PreflightResult struct {
+	Check     string     `json:"check"`
+	Status    string     `json:"status"`
+	Detail    string     `json:"detail,omitempty"`
+	Score     float64    `json:"score,omitempty"`
+	SpamRules []SpamRule `json:"spam_rules,omitempty"`
+	// BrokenLinks lists "url: problem" entries from the link check.
+	BrokenLinks []string `json:"broken_links,omitempty"`
+	// AccessibilityIssues lists problems found by the accessibility check.
+	AccessibilityIssues []string `json:"accessibility_issues,omitempty"`
+}
+
+// runPreflight renders an unpersonalized copy of the newsletter and runs the
+// configured pre-send checks on it. It reports whether any check failed.
+func runPreflight(ctx context.Context, article Article) ([]PreflightResult, bool) {
+	if r := lintEmailTemplates(article); r.Status == checkFail {
+		return []PreflightResult{r}, true
+	}
+
+	sample := Subscriber{Email: "preflight@" + senderDomain()}
+	body, err := renderNewsletterBody(sample, article)
+	if err != nil {
+		return []PreflightResult{{Check: "render", Status: checkFail, Detail: err.Error()}}, true
+	}
+	m, err := buildNewsletterMessage(sample, article)
+	if err != nil {
+		return []PreflightResult{{Check: "render", Status: checkFail, Detail: err.Error()}}, true
+	}
+	var raw bytes.Buffer
+	if _, err := m.WriteTo(&raw); err != nil {
+		return []PreflightResult{{Check: "render", Status: checkFail, Detail: err.Error()}}, true
+	}
+
+	var results []PreflightResult
+	if r, ok := checkSpamScore(raw.Bytes()); ok {
+		results = append(results, r)
+	}
+	if r, ok := checkLinks(ctx, body); ok {
+		results = append(results, r)
+	}
+	if r, ok := checkAccessibility(body); ok {
+		results = append(results, r)
+	}
+	if r, ok := checkFooter(); ok {
+		results = append(results, r)
+	}
+
+	blocked := false
+	for _, r := range results {
+		if r.Status == checkFail {
+			log.Printf("Preflight check %s failed for article %d: %s", r.Check, article.ID, r.Detail)
+			blocked = true
+		} else if r.Status == checkWarn {
+			log.Printf("Preflight check %s warned for article %d: %s", r.Check, article.ID, r.Detail)
+		}
+	}
+	return results, blocked
+}
+
+// lintEmailTemplates checks the body and subject templates for references
+// to fields outside the rendering context, which would otherwise render as
+// blanks.
+func lintEmailTemplates(article Article) PreflightResult {
+	result := PreflightResult{Check: "template_lint", Status: checkOK}
+	sources, err := emailTemplateSources()
+	if err != nil {
+		result.Status, result.Detail = checkFail, err.Error()
+		return result
+	}
+	for _, src := range sources {
+		body, err := os.ReadFile(src.path)
+		if err != nil {
+			result.Status, result.Detail = checkFail, err.Error()
+			return result
+		}
+		if err := checkTemplate(string(body)); err != nil {
+			result.Status, result.Detail = checkFail, src.path+": "+err.Error()
+			return result
+		}
+	}
+	if err := checkTemplate(subjectTemplate(article)); err != nil {
+		result.Status, result.Detail = checkFail, "subject: "+err.Error()
+	}
+	return result
+}
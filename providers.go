@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+// deliveryBackend is one provider listed in EMAIL_PROVIDERS.
+type deliveryBackend struct {
+	name   string
+	sender EmailSender
+	weight int
+	// dailyCap limits sends per UTC day; 0 means no limit.
+	dailyCap int
+}
+
+// parseEmailProviders parses EMAIL_PROVIDERS, a comma-separated list of
+// name=weight or name=weight:cap entries, e.g. "ses=80:50000,relay=20".
+// Each backend is configured from PROVIDER_<NAME>_TYPE ("smtp", the
+// default, or "mock") and, for SMTP, PROVIDER_<NAME>_SMTP_HOST, _PORT,
+// _USERNAME and _PASSWORD.
+func parseEmailProviders(list string) ([]*deliveryBackend, error) {
+	var backends []*deliveryBackend
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, spec, ok := strings.Cut(entry, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("provider %q: want name=weight[:cap]", entry)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("provider %q listed twice", name)
+		}
+		seen[name] = true
+		weightStr, capStr, hasCap := strings.Cut(spec, ":")
+		weight, err := strconv.Atoi(weightStr)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("provider %q: invalid weight %q", name, weightStr)
+		}
+		b := &deliveryBackend{name: name, weight: weight}
+		if hasCap {
+			if b.dailyCap, err = strconv.Atoi(capStr); err != nil || b.dailyCap < 0 {
+				return nil, fmt.Errorf("provider %q: invalid daily cap %q", name, capStr)
+			}
+		}
+		prefix := "PROVIDER_" + strings.ToUpper(name) + "_"
+		switch typ := os.Getenv(prefix + "TYPE"); typ {
+		case "", "smtp":
+			b.sender = newSMTPSenderFrom(prefix + "SMTP_")
+		case "mock":
+			b.sender = newMockSender(os.Getenv(prefix + "MOCK_DIR"))
+		default:
+			return nil, fmt.Errorf("provider %q: unknown type %q", name, typ)
+		}
+		backends = append(backends, b)
+	}
+	if len(backends) == 0 {
+		return nil, errors.New("EMAIL_PROVIDERS lists no providers")
+	}
+	return backends, nil
+}
+
+// routingSender spreads messages across several providers by weight. A
+// provider that has reached its daily cap is skipped for the rest of the
+// UTC day, and one that fails is retried with another provider and then
+// rested for EMAIL_PROVIDER_COOLDOWN (default 1m). Weight 0 marks a
+// fallback that is only used when the others cannot take a message.
+// Daily counts are kept in provider_usage so caps hold across restarts.
+type routingSender struct {
+	db       *sql.DB
+	backends []*deliveryBackend
+	cooldown time.Duration
+
+	mu        sync.Mutex
+	day       string
+	sent      map[string]int
+	restUntil map[string]time.Time
+}
+
+func newRoutingSender(db *sql.DB, backends []*deliveryBackend) *routingSender {
+	return &routingSender{
+		db:        db,
+		backends:  backends,
+		cooldown:  getEnvDuration("EMAIL_PROVIDER_COOLDOWN", time.Minute),
+		restUntil: map[string]time.Time{},
+	}
+}
+
+// loadUsage reads today's send counts, once per UTC day. The caller holds
+// s.mu.
+func (s *routingSender) loadUsage(now time.Time) error {
+	day := now.UTC().Format("2006-01-02")
+	if day == s.day {
+		return nil
+	}
+	rows, err := s.db.Query("SELECT provider, sent FROM provider_usage WHERE day = ?", day)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	sent := map[string]int{}
+	for rows.Next() {
+		var name string
+		var n int
+		if err := rows.Scan(&name, &n); err != nil {
+			return err
+		}
+		sent[name] = n
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	s.day, s.sent = day, sent
+	return nil
+}
+
+// pick chooses a provider not in tried, by weight among those that are
+// under their cap and not resting. Zero-weight fallbacks are chosen only
+// when no weighted provider is available.
+func (s *routingSender) pick(now time.Time, tried map[string]bool) *deliveryBackend {
+	var available []*deliveryBackend
+	total := 0
+	for _, b := range s.backends {
+		if tried[b.name] || now.Before(s.restUntil[b.name]) {
+			continue
+		}
+		if b.dailyCap > 0 && s.sent[b.name] >= b.dailyCap {
+			continue
+		}
+		available = append(available, b)
+		total += b.weight
+	}
+	if len(available) == 0 {
+		return nil
+	}
+	if total == 0 {
+		return available[0]
+	}
+	n := rand.IntN(total)
+	for _, b := range available {
+		if n < b.weight {
+			return b
+		}
+		n -= b.weight
+	}
+	return available[len(available)-1]
+}
+
+func (s *routingSender) Send(ctx context.Context, m *gomail.Message) (string, error) {
+	tried := map[string]bool{}
+	var errs []error
+	for {
+		s.mu.Lock()
+		now := time.Now()
+		if err := s.loadUsage(now); err != nil {
+			s.mu.Unlock()
+			return "", fmt.Errorf("reading provider usage: %w", err)
+		}
+		b := s.pick(now, tried)
+		s.mu.Unlock()
+		if b == nil {
+			if len(errs) == 0 {
+				return "", errors.New("no email provider is available: all are at their daily cap or resting after errors")
+			}
+			return "", errors.Join(errs...)
+		}
+		tried[b.name] = true
+
+		id, err := b.sender.Send(ctx, m)
+		if err != nil {
+			log.Printf("Email provider %s failed, trying another: %v", b.name, err)
+			errs = append(errs, fmt.Errorf("%s: %w", b.name, err))
+			s.mu.Lock()
+			s.restUntil[b.name] = time.Now().Add(s.cooldown)
+			s.mu.Unlock()
+			continue
+		}
+		s.recordSend(b.name)
+		return id, nil
+	}
+}
+
+// recordSend counts a send against the provider's daily cap.
+func (s *routingSender) recordSend(name string) {
+	s.mu.Lock()
+	s.sent[name]++
+	day := s.day
+	s.mu.Unlock()
+	_, err := s.db.Exec(`
+		INSERT INTO provider_usage (provider, day, sent) VALUES (?, ?, 1)
+		ON CONFLICT (provider, day) DO UPDATE SET sent = sent + 1`, name, day)
+	if err != nil {
+		log.Printf("Error recording usage for email provider %s: %v", name, err)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gopkg.in/gomail.v2"
+)
+
+// downProvider fails every send, counting the attempts.
+type downProvider struct{ calls int }
+
+func (s *downProvider) Send(ctx context.Context, m *gomail.Message) (string, error) {
+	s.calls++
+	return "", errors.New("connection refused")
+}
+
+func TestParseEmailProviders(t *testing.T) {
+	t.Setenv("PROVIDER_BACKUP_TYPE", "mock")
+	backends, err := parseEmailProviders("ses=80:50000, backup=20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backends) != 2 || backends[0].weight != 80 || backends[0].dailyCap != 50000 || backends[1].dailyCap != 0 {
+		t.Fatalf("backends = %+v %+v", backends[0], backends[1])
+	}
+	if _, ok := backends[1].sender.(*mockSender); !ok {
+		t.Errorf("backup sender = %T", backends[1].sender)
+	}
+	for _, bad := range []string{"ses", "ses=x", "ses=1:-2", "a=1,a=2", " , "} {
+		if _, err := parseEmailProviders(bad); err == nil {
+			t.Errorf("parseEmailProviders(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestRoutingSenderCapsAndFailover(t *testing.T) {
+	db := newTestDB(t)
+	primary, fallback := newMockSender(""), newMockSender("")
+	backends := []*deliveryBackend{
+		{name: "primary", sender: primary, weight: 1, dailyCap: 2},
+		{name: "fallback", sender: fallback, weight: 0},
+	}
+	s := newRoutingSender(db, backends)
+	for i := 0; i < 3; i++ {
+		if _, err := s.Send(context.Background(), gomail.NewMessage()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(primary.Messages()) != 2 || len(fallback.Messages()) != 1 {
+		t.Fatalf("primary sent %d, fallback %d", len(primary.Messages()), len(fallback.Messages()))
+	}
+
+	// The cap holds across a restart.
+	s = newRoutingSender(db, backends)
+	if _, err := s.Send(context.Background(), gomail.NewMessage()); err != nil {
+		t.Fatal(err)
+	}
+	if len(primary.Messages()) != 2 {
+		t.Errorf("primary sent past its cap after restart")
+	}
+
+	broken := &downProvider{}
+	s = newRoutingSender(db, []*deliveryBackend{
+		{name: "broken", sender: broken, weight: 1},
+		{name: "fallback", sender: fallback, weight: 0},
+	})
+	before := len(fallback.Messages())
+	for i := 0; i < 2; i++ {
+		if _, err := s.Send(context.Background(), gomail.NewMessage()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if broken.calls != 1 || len(fallback.Messages()) != before+2 {
+		t.Errorf("broken called %d times, fallback sent %d", broken.calls, len(fallback.Messages())-before)
+	}
+
+	s = newRoutingSender(db, []*deliveryBackend{{name: "broken", sender: broken, weight: 1}})
+	if _, err := s.Send(context.Background(), gomail.NewMessage()); err == nil {
+		t.Error("send succeeded with every provider failing")
+	}
+}
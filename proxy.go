@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// outboundProxy chooses the proxy for an outbound HTTP request. With
+// OUTBOUND_PROXY set (http://, https:// or socks5:// URL) every request uses
+// it except hosts matched by NO_PROXY; otherwise the standard HTTP_PROXY,
+// HTTPS_PROXY and NO_PROXY variables apply. SMTP connections are not
+// proxied.
+func outboundProxy(req *http.Request) (*url.URL, error) {
+	p := os.Getenv("OUTBOUND_PROXY")
+	if p == "" {
+		return httpproxy.FromEnvironment().ProxyFunc()(req.URL)
+	}
+	cfg := httpproxy.Config{HTTPProxy: p, HTTPSProxy: p, NoProxy: os.Getenv("NO_PROXY")}
+	return cfg.ProxyFunc()(req.URL)
+}
+
+// checkOutboundProxy reports a malformed OUTBOUND_PROXY at startup rather
+// than on the first outbound call.
+func checkOutboundProxy() error {
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+	_, err := outboundProxy(req)
+	return err
+}
+
+// outboundTransport is the transport for every outbound HTTP call:
+// provider APIs, webhooks, link checks and error reporting.
+var outboundTransport = func() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = outboundProxy
+	return t
+}()
+
+// outboundClient returns an HTTP client using outboundTransport.
+func outboundClient(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: outboundTransport, Timeout: timeout}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestOutboundProxy(t *testing.T) {
+	t.Setenv("HTTPS_PROXY", "http://env-proxy:3128")
+	t.Setenv("OUTBOUND_PROXY", "socks5://corp-proxy:1080")
+	t.Setenv("NO_PROXY", "internal.example")
+
+	for _, tc := range []struct {
+		url, want string
+	}{
+		{"https://api.example.com/v1", "socks5://corp-proxy:1080"},
+		{"http://hooks.example.com", "socks5://corp-proxy:1080"},
+		{"https://internal.example/x", ""},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, tc.url, nil)
+		got, err := outboundProxy(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (got == nil && tc.want != "") || (got != nil && got.String() != tc.want) {
+			t.Errorf("proxy for %s = %v, want %q", tc.url, got, tc.want)
+		}
+	}
+
+	t.Setenv("OUTBOUND_PROXY", "")
+	req, _ := http.NewRequest(http.MethodGet, "https://api.example.com", nil)
+	if got, _ := outboundProxy(req); got == nil || got.Host != "env-proxy:3128" {
+		t.Errorf("proxy from environment = %v", got)
+	}
+}
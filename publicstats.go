@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// PublicStats are the figures shown on the public stats page.
+type PublicStats struct {
+	Name        string
+	Subscribers int
+	IssuesSent  int
+	// OpenRate is the percentage of sends that were opened. It is only
+	// shown when open tracking is enabled.
+	OpenRate    float64
+	HasOpenRate bool
+	GeneratedAt time.Time
+}
+
+func getPublicStats(db *sql.DB) (PublicStats, error) {
+	stats := PublicStats{Name: os.Getenv("NEWSLETTER_NAME"), GeneratedAt: time.Now()}
+	if stats.Name == "" {
+		stats.Name = "This newsletter"
+	}
+
+	n, err := activeSubscriberCount(db)
+	if err != nil {
+		return stats, err
+	}
+	stats.Subscribers = n
+
+	// Imported sends were never delivered or tracked by this service.
+	// Archived sends are counted from their summaries.
+	var sends, opened int
+	err = db.QueryRow(`
+		SELECT COUNT(DISTINCT article_id), COALESCE(SUM(sends), 0), COALESCE(SUM(opened), 0)
+		FROM (
+			SELECT article_id, COUNT(*) AS sends, COUNT(opened_at) AS opened
+			FROM sent_emails
+			WHERE delivery_status != ?
+			GROUP BY article_id
+			UNION ALL
+			SELECT article_id, sent - imported, opened
+			FROM sent_email_summaries
+			WHERE sent > imported
+		)`, deliveryImported).Scan(&stats.IssuesSent, &sends, &opened)
+	if err != nil {
+		return stats, err
+	}
+	if trackingEnabled() && sends > 0 {
+		stats.OpenRate = 100 * float64(opened) / float64(sends)
+		stats.HasOpenRate = true
+	}
+	return stats, nil
+}
+
+// handlePublicStats renders public_stats.html with the newsletter's
+// headline numbers. The page is off unless PUBLIC_STATS_ENABLED is set.
+func handlePublicStats(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if enabled, _ := strconv.ParseBool(os.Getenv("PUBLIC_STATS_ENABLED")); !enabled {
+			http.NotFound(w, r)
+			return
+		}
+
+		stats, err := getPublicStats(db)
+		if err != nil {
+			log.Printf("Error computing public stats: %v", err)
+			http.Error(w, "Error computing stats", http.StatusInternalServerError)
+			return
+		}
+		var page bytes.Buffer
+		if err := renderPage(r.Context(), &page, "public_stats.html", stats); err != nil {
+			log.Printf("Error rendering public stats: %v", err)
+			http.Error(w, "Error rendering stats", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		if !searchIndexingEnabled() {
+			w.Header().Set("X-Robots-Tag", "noindex")
+		}
+		w.Write(page.Bytes())
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"strings"
+)
+
+// publicBaseURL returns the base URL readers reach this service on, used
+// for links placed in emails. It is set with PUBLIC_BASE_URL so links can
+// point at a tracking or custom domain rather than the API host. An empty
+// result means no base URL is configured.
+func publicBaseURL() string {
+	return strings.TrimRight(os.Getenv("PUBLIC_BASE_URL"), "/")
+}
+
+// publicURL returns the absolute public URL of path, or "" when no base URL
+// is configured or it is not an absolute http(s) URL.
+func publicURL(path string) string {
+	base, err := url.Parse(publicBaseURL())
+	if err != nil || base.Host == "" || (base.Scheme != "http" && base.Scheme != "https") {
+		return ""
+	}
+	return base.String() + "/" + strings.TrimLeft(path, "/")
+}
@@ -0,0 +1,29 @@
+package main
+
+import "strings"
+
+// defaultWordsPerMinute is a typical adult silent reading speed.
+const defaultWordsPerMinute = 230
+
+// readingMinutes estimates how long words take to read at READING_WPM,
+// rounded up. Any non-empty text takes at least a minute.
+func readingMinutes(words int) int {
+	if words <= 0 {
+		return 0
+	}
+	wpm := getEnvInt("READING_WPM", defaultWordsPerMinute)
+	if wpm <= 0 {
+		wpm = defaultWordsPerMinute
+	}
+	return (words + wpm - 1) / wpm
+}
+
+// setReadingStats fills in the article's word count, reading time and, if
+// the author did not write one, an excerpt taken from the content.
+func setReadingStats(article *Article) {
+	article.WordCount = len(strings.Fields(article.Content))
+	article.ReadingTime = readingMinutes(article.WordCount)
+	if article.Excerpt == "" {
+		article.Excerpt = articleDescription(article.Content)
+	}
+}
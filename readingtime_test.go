@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestReadingStatsStoredAtPublish(t *testing.T) {
+	t.Setenv("READING_WPM", "100")
+	db := newTestDB(t)
+	srv := newTestServer(t, db, &mockSender{})
+
+	content := strings.Repeat("word ", 250)
+	postJSON(t, srv.URL+"/api/publish", `{"title":"Long read","content":"`+content+`","scheduled_at":"2999-01-01T00:00:00Z"}`)
+	postJSON(t, srv.URL+"/api/publish", `{"title":"Custom","content":"Body text","excerpt":"Hand written","scheduled_at":"2999-01-01T00:00:00Z"}`)
+
+	article, err := getArticle(context.Background(), db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if article.WordCount != 250 || article.ReadingTime != 3 {
+		t.Errorf("word count %d, reading time %d; want 250, 3", article.WordCount, article.ReadingTime)
+	}
+	if !strings.HasPrefix(article.Excerpt, "word word") || !strings.HasSuffix(article.Excerpt, "…") {
+		t.Errorf("excerpt = %q", article.Excerpt)
+	}
+
+	article, err = getArticle(context.Background(), db, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if article.Excerpt != "Hand written" {
+		t.Errorf("excerpt = %q, want the author's", article.Excerpt)
+	}
+	data := emailTemplateData(Subscriber{}, article)
+	if data["ReadingTime"] != 1 || data["Excerpt"] != "Hand written" {
+		t.Errorf("template data = %v", data)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RecipientPreview lists who would receive an article if it were sent now.
+type RecipientPreview struct {
+	ArticleID int `json:"article_id"`
+	// Unsubscribed, OutsideTier and BelowEngagement count subscribers the
+	// article's audience excludes; AlreadySent counts those it was sent to
+	// before.
+	Unsubscribed int `json:"unsubscribed"`
+	OutsideTier  int `json:"outside_tier"`
+	// BelowEngagement counts subscribers under the article's
+	// min_engagement.
+	BelowEngagement int `json:"below_engagement"`
+	AlreadySent     int `json:"already_sent"`
+	// OtherChannels counts subscribers in the audience who take
+	// newsletters on other channels only, like push.
+	OtherChannels int `json:"other_channels"`
+	// Deferred counts recipients past today's warm-up cap, who would be
+	// sent to once the cap resets.
+	Deferred int `json:"deferred"`
+	Count    int `json:"count"`
+	// Recipients is only listed for callers allowed to see subscribers;
+	// everyone else gets the counts.
+	Recipients []Subscriber `json:"recipients,omitempty"`
+}
+
+// previewRecipients applies the same filtering as sendNewsletterForArticle
+// without sending anything.
+func previewRecipients(r *http.Request, db *sql.DB, article Article) (*RecipientPreview, error) {
+	ctx := r.Context()
+	preview := &RecipientPreview{ArticleID: article.ID, Recipients: []Subscriber{}}
+
+	err := db.QueryRowContext(ctx, `
+		SELECT
+			COUNT(*) FILTER (WHERE unsubscribed_at IS NOT NULL),
+			COUNT(*) FILTER (WHERE unsubscribed_at IS NULL AND ? AND tier != ?),
+			COUNT(*) FILTER (WHERE unsubscribed_at IS NULL AND (NOT ? OR tier = ?) AND ? > 0 AND COALESCE(engagement_score, -1) < ?)
+		FROM subscribers
+		WHERE deleted_at IS NULL`,
+		article.Premium, tierPremium, article.Premium, tierPremium, article.MinEngagement, article.MinEngagement,
+	).Scan(&preview.Unsubscribed, &preview.OutsideTier, &preview.BelowEngagement)
+	if err != nil {
+		return nil, err
+	}
+
+	subscribers, err := getSubscribers(ctx, db, article)
+	if err != nil {
+		return nil, err
+	}
+	remaining, limited, err := warmupRemaining(ctx, db, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	received, err := receivedArticle(ctx, db, article.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range subscribers {
+		if received[sub.ID] {
+			preview.AlreadySent++
+			continue
+		}
+		if !sub.wants(channelEmail) {
+			preview.OtherChannels++
+			continue
+		}
+		if limited && remaining <= 0 {
+			preview.Deferred++
+			continue
+		}
+		remaining--
+		preview.Recipients = append(preview.Recipients, sub)
+	}
+	preview.Count = len(preview.Recipients)
+	return preview, nil
+}
+
+func handleGetRecipients(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid article id", http.StatusBadRequest)
+			return
+		}
+		article, err := getArticle(r.Context(), db, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Article not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		preview, err := previewRecipients(r, db, article)
+		if err != nil {
+			log.Printf("Error previewing recipients: %v", err)
+			http.Error(w, "Error previewing recipients", http.StatusInternalServerError)
+			return
+		}
+		if !requestCan(r, permSubscribers) {
+			preview.Recipients = nil
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(preview)
+	}
+}
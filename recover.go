@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// withRecovery turns a panic in a handler into a 500 JSON response, logs
+// its stack trace with the request ID and reports it, so one bad request
+// cannot take the process down.
+func withRecovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			// ErrAbortHandler is how handlers deliberately abort a response.
+			if err, ok := rec.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(rec)
+			}
+
+			id := requestID(r.Context())
+			log.Printf("Panic serving %s %s (request %s): %v\n%s", r.Method, r.URL.Path, id, rec, debug.Stack())
+			reportPanic(r.Context(), rec)
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error":      "Internal server error",
+				"request_id": id,
+			})
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
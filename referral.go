@@ -0,0 +1,91 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+)
+
+const maxSourceLength = 64
+
+// maxTopReferrers is how many referrers the stats list.
+const maxTopReferrers = 10
+
+// Referrer is a subscriber credited with signups from forwarded
+// newsletters.
+type Referrer struct {
+	SubscriberID int    `json:"subscriber_id"`
+	Email        string `json:"email,omitempty"`
+	Referrals    int    `json:"referrals"`
+}
+
+// normalizeSource cleans a referral code so variants like "Twitter" and
+// " twitter " are counted together.
+func normalizeSource(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if len(s) > maxSourceLength {
+		s = s[:maxSourceLength]
+	}
+	return s
+}
+
+// subscribeSource returns the acquisition source for a subscribe request:
+// the ?ref= query parameter, or the "source" field of the payload.
+func subscribeSource(r *http.Request, sub Subscriber) string {
+	if ref := r.URL.Query().Get("ref"); ref != "" {
+		return normalizeSource(ref)
+	}
+	return normalizeSource(sub.Source)
+}
+
+// getSubscribersBySource counts subscribers per acquisition source. Signups
+// without a source are counted under "direct".
+func getSubscribersBySource(db *sql.DB) (map[string]int, error) {
+	rows, err := db.Query(`
+		SELECT CASE WHEN source = '' THEN 'direct' ELSE source END, COUNT(*)
+		FROM subscribers
+		WHERE deleted_at IS NULL
+		GROUP BY 1`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var source string
+		var n int
+		if err := rows.Scan(&source, &n); err != nil {
+			return nil, err
+		}
+		counts[source] = n
+	}
+	return counts, rows.Err()
+}
+
+// getTopReferrers returns the subscribers who referred the most current
+// subscribers, most first.
+func getTopReferrers(db *sql.DB, limit int) ([]Referrer, error) {
+	rows, err := db.Query(`
+		SELECT r.id, r.email, COUNT(*)
+		FROM subscribers s
+		JOIN subscribers r ON r.id = s.referred_by
+		WHERE s.deleted_at IS NULL
+		GROUP BY r.id
+		ORDER BY COUNT(*) DESC, r.id
+		LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	referrers := []Referrer{}
+	for rows.Next() {
+		var ref Referrer
+		if err := rows.Scan(&ref.SubscriberID, &ref.Email, &ref.Referrals); err != nil {
+			return nil, err
+		}
+		referrers = append(referrers, ref)
+	}
+	return referrers, rows.Err()
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/gomail.v2"
+)
+
+// processEnv records which variables were set by the process environment
+// rather than .env, so a reload never overrides them.
+var (
+	envMu      sync.Mutex
+	processEnv map[string]bool
+	dotenvKeys map[string]bool
+)
+
+// loadEnvFile loads .env without overriding the process environment.
+func loadEnvFile() error {
+	envMu.Lock()
+	defer envMu.Unlock()
+	processEnv = map[string]bool{}
+	for _, kv := range os.Environ() {
+		key, _, _ := strings.Cut(kv, "=")
+		processEnv[key] = true
+	}
+	return applyEnvFile()
+}
+
+// applyEnvFile sets the variables in .env that the process environment
+// does not, and unsets those a previous load set but .env no longer has.
+func applyEnvFile() error {
+	values, err := godotenv.Read()
+	if err != nil {
+		return err
+	}
+	for key := range dotenvKeys {
+		if _, ok := values[key]; !ok {
+			os.Unsetenv(key)
+		}
+	}
+	dotenvKeys = map[string]bool{}
+	for key, value := range values {
+		if processEnv[key] {
+			continue
+		}
+		os.Setenv(key, value)
+		dotenvKeys[key] = true
+	}
+	return nil
+}
+
+// reloadableSender lets the configured sender be replaced while send jobs
+// hold on to it. A message already being sent finishes on the old sender.
+// While maintenance mode is on, Send waits for it to end.
+type reloadableSender struct {
+	mu     sync.RWMutex
+	sender EmailSender
+}
+
+func (s *reloadableSender) Send(ctx context.Context, m *gomail.Message) (string, error) {
+	if err := waitForMaintenance(ctx); err != nil {
+		return "", err
+	}
+	s.mu.RLock()
+	sender := s.sender
+	s.mu.RUnlock()
+	return sender.Send(ctx, m)
+}
+
+func (s *reloadableSender) set(sender EmailSender) {
+	s.mu.Lock()
+	s.sender = sender
+	s.mu.Unlock()
+}
+
+// reloadConfig re-reads .env and rebuilds what is derived from it at
+// startup: the email sender (SMTP credentials, provider, SEND_MODE) and
+// the trusted proxies. Everything else, including templates, rate limits
+// and webhook URLs, is read when used and picks up the new values. On
+// error the previous sender is kept.
+func reloadConfig(db *sql.DB, sender EmailSender) error {
+	envMu.Lock()
+	err := applyEnvFile()
+	envMu.Unlock()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	if rs, ok := sender.(*reloadableSender); ok {
+		next, err := newEmailSender(db)
+		if err != nil {
+			return err
+		}
+		rs.set(next)
+	}
+	setTrustedProxies()
+	clearCaches()
+	log.Println("Configuration reloaded")
+	return nil
+}
+
+// reloadOnSIGHUP reloads the configuration whenever the process receives
+// SIGHUP.
+func reloadOnSIGHUP(db *sql.DB, sender EmailSender) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := reloadConfig(db, sender); err != nil {
+			log.Printf("Error reloading configuration: %v", err)
+		}
+	}
+}
+
+// handleReload reloads the configuration, like SIGHUP.
+func handleReload(db *sql.DB, sender EmailSender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := reloadConfig(db, sender); err != nil {
+			log.Printf("Error reloading configuration: %v", err)
+			http.Error(w, "Error reloading configuration: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(db, r, "reload", "config", 0, nil)
+		w.Write([]byte("Configuration reloaded"))
+	}
+}
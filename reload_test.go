@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReloadConfig(t *testing.T) {
+	wd, _ := os.Getwd()
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	// Register every variable the test touches so it is restored.
+	for _, key := range []string{"FROM_PROCESS", "RELOAD_TEST", "EMAIL_PROVIDER", "SEND_MODE", "SEND_REDIRECT_TO"} {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+	os.Setenv("FROM_PROCESS", "process")
+	processEnv, dotenvKeys = map[string]bool{"FROM_PROCESS": true}, nil
+
+	writeEnv := func(content string) {
+		if err := os.WriteFile(filepath.Join(dir, ".env"), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeEnv("FROM_PROCESS=file\nRELOAD_TEST=one\nEMAIL_PROVIDER=mock\n")
+	db := newTestDB(t)
+	sender := &reloadableSender{sender: newMockSender("")}
+	if err := reloadConfig(db, sender); err != nil {
+		t.Fatal(err)
+	}
+	if os.Getenv("RELOAD_TEST") != "one" || os.Getenv("FROM_PROCESS") != "process" {
+		t.Fatalf("RELOAD_TEST=%q FROM_PROCESS=%q", os.Getenv("RELOAD_TEST"), os.Getenv("FROM_PROCESS"))
+	}
+
+	writeEnv("EMAIL_PROVIDER=mock\nSEND_MODE=redirect\nSEND_REDIRECT_TO=qa@example.com\n")
+	if err := reloadConfig(db, sender); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := os.LookupEnv("RELOAD_TEST"); ok {
+		t.Error("variable removed from .env is still set")
+	}
+	if _, ok := sender.sender.(*redirectSender); !ok {
+		t.Errorf("sender not rebuilt: %T", sender.sender)
+	}
+
+	// A bad configuration keeps the previous sender.
+	writeEnv("EMAIL_PROVIDER=pigeon\n")
+	if err := reloadConfig(db, sender); err == nil {
+		t.Error("reload with unknown provider succeeded")
+	}
+	if _, ok := sender.sender.(*redirectSender); !ok {
+		t.Errorf("sender replaced after failed reload: %T", sender.sender)
+	}
+}
+
+func TestReloadTrustedProxiesWhileServing(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "")
+	setTrustedProxies()
+	t.Cleanup(setTrustedProxies)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.9")
+
+	// Run under -race: requests read the proxies while a reload replaces them.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			clientIP(r)
+		}
+	}()
+	os.Setenv("TRUSTED_PROXIES", "10.0.0.0/8")
+	setTrustedProxies()
+	<-done
+
+	if got := clientIP(r); got != "203.0.113.9" {
+		t.Errorf("clientIP = %q after reload, want the forwarded address", got)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+)
+
+// readReplica serves analytics reads when DB_READ_REPLICA_PATH is set, so
+// heavy dashboard queries do not contend with sends on the primary. It is
+// nil otherwise.
+var readReplica *sql.DB
+
+// openReadReplica opens DB_READ_REPLICA_PATH, if set. A local SQLite file
+// is opened read-only; a remote libSQL URL uses the same LIBSQL_AUTH_TOKEN
+// as the primary. The replica's schema is maintained by whatever
+// replicates it, so no migrations are run.
+func openReadReplica() (*sql.DB, error) {
+	path := os.Getenv("DB_READ_REPLICA_PATH")
+	if path == "" {
+		return nil, nil
+	}
+	var db *sql.DB
+	var err error
+	if isRemoteDB(path) {
+		db, err = openLibSQL(path)
+	} else {
+		db, err = sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// analyticsDB returns the database stats and analytics endpoints read
+// from: the read replica when one is configured, otherwise db.
+func analyticsDB(db *sql.DB) *sql.DB {
+	if readReplica != nil {
+		return readReplica
+	}
+	return db
+}
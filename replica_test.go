@@ -0,0 +1,52 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadReplicaServesStats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replica.db")
+	seed, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	createTables(seed)
+	migrateTables(seed)
+	if err := initCounters(seed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := seed.Exec("UPDATE stat_counters SET value = 42 WHERE name = 'subscribers'"); err != nil {
+		t.Fatal(err)
+	}
+	seed.Close()
+
+	t.Setenv("DB_READ_REPLICA_PATH", path)
+	replica, err := openReadReplica()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replica.Close()
+	if _, err := replica.Exec("DELETE FROM subscribers"); err == nil {
+		t.Error("replica accepted a write")
+	}
+
+	readReplica = replica
+	t.Cleanup(func() { readReplica = nil })
+	srv := newTestServer(t, newTestDB(t), newMockSender(""))
+	resp, err := http.Get(srv.URL + "/api/stats/summary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var summary StatsSummary
+	if err := json.NewDecoder(resp.Body).Decode(&summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Subscribers != 42 {
+		t.Errorf("subscribers = %d, want 42 from the replica", summary.Subscribers)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+// inboundReply is a reply received by the provider's inbound parse
+// webhook. Both JSON and SendGrid's form posts are accepted. Headers is
+// the raw header block, from which In-Reply-To and References are read.
+type inboundReply struct {
+	From    string `json:"from"`
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	Headers string `json:"headers"`
+}
+
+// Reply is a reader's reply to a newsletter. SubscriberID and ArticleID
+// are nil when the reply could not be matched to a subscriber or send.
+type Reply struct {
+	ID           int       `json:"id"`
+	SubscriberID *int      `json:"subscriber_id,omitempty"`
+	ArticleID    *int      `json:"article_id,omitempty"`
+	From         string    `json:"from"`
+	Subject      string    `json:"subject"`
+	Body         string    `json:"body"`
+	ReceivedAt   time.Time `json:"received_at"`
+}
+
+// parseInboundReply decodes a webhook payload by its content type.
+func parseInboundReply(contentType string, payload []byte) (inboundReply, error) {
+	var in inboundReply
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "multipart/form-data":
+		form, err := multipart.NewReader(bytes.NewReader(payload), params["boundary"]).ReadForm(maxWebhookBody)
+		if err != nil {
+			return in, err
+		}
+		defer form.RemoveAll()
+		field := func(name string) string {
+			if v := form.Value[name]; len(v) > 0 {
+				return v[0]
+			}
+			return ""
+		}
+		in = inboundReply{From: field("from"), Subject: field("subject"), Text: field("text"), Headers: field("headers")}
+	case "application/x-www-form-urlencoded":
+		form, err := url.ParseQuery(string(payload))
+		if err != nil {
+			return in, err
+		}
+		in = inboundReply{From: form.Get("from"), Subject: form.Get("subject"), Text: form.Get("text"), Headers: form.Get("headers")}
+	default:
+		if err := json.Unmarshal(payload, &in); err != nil {
+			return in, err
+		}
+	}
+	if in.From == "" {
+		return in, errors.New("reply has no sender")
+	}
+	return in, nil
+}
+
+// referencedMessageIDs returns the Message-IDs a reply answers, from its
+// In-Reply-To and References headers, most recent first.
+func referencedMessageIDs(headers string) []string {
+	h, err := textproto.NewReader(bufio.NewReader(strings.NewReader(headers + "\r\n\r\n"))).ReadMIMEHeader()
+	if err != nil && len(h) == 0 {
+		return nil
+	}
+	ids := strings.Fields(h.Get("In-Reply-To"))
+	refs := strings.Fields(h.Get("References"))
+	for i := len(refs) - 1; i >= 0; i-- {
+		ids = append(ids, refs[i])
+	}
+	return ids
+}
+
+// matchReply finds the send a reply answers by its Message-ID, falling
+// back to the subscriber with the sender's address. Either result may be
+// zero.
+func matchReply(ctx context.Context, db *sql.DB, in inboundReply) (subscriberID, articleID int, err error) {
+	for _, id := range referencedMessageIDs(in.Headers) {
+		err := db.QueryRowContext(ctx, "SELECT subscriber_id, article_id FROM sent_emails WHERE message_id = ?", id).Scan(&subscriberID, &articleID)
+		if err == nil {
+			return subscriberID, articleID, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return 0, 0, err
+		}
+	}
+
+	addr, err := mail.ParseAddress(in.From)
+	if err != nil {
+		return 0, 0, nil
+	}
+	err = db.QueryRowContext(ctx, "SELECT id FROM subscribers WHERE email = ? COLLATE NOCASE AND deleted_at IS NULL", addr.Address).Scan(&subscriberID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, nil
+	}
+	return subscriberID, 0, err
+}
+
+// nullableID stores 0 as NULL.
+func nullableID(id int) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// storeReply saves a matched reply and records it in the subscriber's
+// activity feed.
+func storeReply(db *sql.DB, in inboundReply, subscriberID, articleID int) (int, error) {
+	var id int
+	err := db.QueryRow("INSERT INTO replies (subscriber_id, article_id, from_address, subject, body) VALUES (?, ?, ?, ?, ?) RETURNING id",
+		nullableID(subscriberID), nullableID(articleID), in.From, in.Subject, in.Text).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	if subscriberID != 0 {
+		recordEvent(db, subscriberID, eventReplied, articleID, in.Subject)
+	}
+	return id, nil
+}
+
+// forwardReply emails a reply to REPLY_FORWARD_TO, with Reply-To set to
+// the reader so answering it reaches them directly.
+func forwardReply(ctx context.Context, db *sql.DB, sender EmailSender, in inboundReply, articleID int) error {
+	to := os.Getenv("REPLY_FORWARD_TO")
+	if to == "" {
+		return nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Reply from %s", in.From)
+	if articleID != 0 {
+		if article, err := getArticle(ctx, db, articleID); err == nil {
+			fmt.Fprintf(&b, " to %q", article.Title)
+		}
+	}
+	fmt.Fprintf(&b, ":\n\n%s\n", in.Text)
+
+	m := gomail.NewMessage()
+	setFromHeader(m, "")
+	m.SetHeader("To", to)
+	if addr, err := mail.ParseAddress(in.From); err == nil {
+		setAddressHeader(m, "Reply-To", addr.Address)
+	}
+	m.SetHeader("Subject", "Fwd: "+in.Subject)
+	m.SetBody("text/plain", b.String())
+	_, err := sender.Send(ctx, m)
+	return err
+}
+
+// handleInboundReply receives replies to newsletters from the provider's
+// inbound parse webhook, verified by INBOUND_WEBHOOK_SECRET. Replies are
+// linked to the send they answer and forwarded to REPLY_FORWARD_TO.
+func handleInboundReply(db *sql.DB, sender EmailSender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if credential("INBOUND_WEBHOOK_SECRET") == "" {
+			http.Error(w, "Inbound webhook is not configured", http.StatusNotFound)
+			return
+		}
+		payload, ok := readVerifiedWebhook(db, w, r, "inbound", func(r *http.Request, _ []byte, _ time.Time) (string, error) {
+			return verifyWebhookToken(r, "INBOUND_WEBHOOK_SECRET")
+		})
+		if !ok {
+			return
+		}
+
+		in, err := parseInboundReply(r.Header.Get("Content-Type"), payload)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		subscriberID, articleID, err := matchReply(r.Context(), db, in)
+		if err != nil {
+			log.Printf("Error matching reply: %v", err)
+			http.Error(w, "Error storing reply", http.StatusInternalServerError)
+			return
+		}
+		id, err := storeReply(db, in, subscriberID, articleID)
+		if err != nil {
+			log.Printf("Error storing reply: %v", err)
+			http.Error(w, "Error storing reply", http.StatusInternalServerError)
+			return
+		}
+		if err := forwardReply(context.WithoutCancel(r.Context()), db, sender, in, articleID); err != nil {
+			log.Printf("Error forwarding reply %d: %v", id, err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"id": id})
+	}
+}
+
+func getReplies(db *sql.DB, articleID, subscriberID, limit int) ([]Reply, error) {
+	rows, err := db.Query(`
+		SELECT id, subscriber_id, article_id, from_address, subject, body, received_at
+		FROM replies
+		WHERE (? = 0 OR article_id = ?) AND (? = 0 OR subscriber_id = ?)
+		ORDER BY id DESC
+		LIMIT ?`, articleID, articleID, subscriberID, subscriberID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	replies := []Reply{}
+	for rows.Next() {
+		var reply Reply
+		if err := rows.Scan(&reply.ID, &reply.SubscriberID, &reply.ArticleID, &reply.From, &reply.Subject, &reply.Body, scanTime(&reply.ReceivedAt)); err != nil {
+			return nil, err
+		}
+		replies = append(replies, reply)
+	}
+	return replies, rows.Err()
+}
+
+// handleGetReplies lists replies, newest first, optionally filtered by
+// article_id or subscriber_id.
+func handleGetReplies(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		params := map[string]int{"article_id": 0, "subscriber_id": 0, "limit": 100}
+		for name := range params {
+			if v := r.URL.Query().Get(name); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n <= 0 {
+					http.Error(w, "Invalid "+name, http.StatusBadRequest)
+					return
+				}
+				params[name] = n
+			}
+		}
+		replies, err := getReplies(db, params["article_id"], params["subscriber_id"], params["limit"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(replies)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"testing"
+)
+
+func TestInboundReply(t *testing.T) {
+	t.Setenv("INBOUND_WEBHOOK_SECRET", "s3cret")
+	t.Setenv("REPLY_FORWARD_TO", "me@example.com")
+	db := newTestDB(t)
+	sender := newMockSender("")
+	srv := newTestServer(t, db, sender)
+
+	postJSON(t, srv.URL+"/api/subscribe", `{"email":"ada@example.com","name":"Ada"}`)
+	postJSON(t, srv.URL+"/api/publish", `{"title":"Hello","content":"Hi","scheduled_at":"2999-01-01T00:00:00Z"}`)
+	if _, err := db.Exec("INSERT INTO sent_emails (subscriber_id, article_id, message_id) VALUES (1, 1, '<abc@example.com>')"); err != nil {
+		t.Fatal(err)
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("from", "Ada <someone-else@example.com>")
+	form.WriteField("subject", "Re: Hello")
+	form.WriteField("text", "Loved it")
+	form.WriteField("headers", "Subject: Re: Hello\nIn-Reply-To: <abc@example.com>\n")
+	form.Close()
+
+	resp, err := http.Post(srv.URL+"/api/webhooks/inbound?token=wrong", form.FormDataContentType(), bytes.NewReader(body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("wrong token = %d; want 401", resp.StatusCode)
+	}
+	resp, err = http.Post(srv.URL+"/api/webhooks/inbound?token=s3cret", form.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("inbound reply = %d", resp.StatusCode)
+	}
+
+	replies, err := getReplies(db, 1, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 1 || *replies[0].SubscriberID != 1 || replies[0].Body != "Loved it" {
+		t.Fatalf("replies = %+v", replies)
+	}
+
+	msgs := waitForMessages(t, sender, 1)
+	if to := msgs[0].GetHeader("To"); len(to) != 1 || to[0] != "me@example.com" {
+		t.Fatalf("forwarded to %v", to)
+	}
+	if rt := msgs[0].GetHeader("Reply-To"); len(rt) != 1 || rt[0] != "someone-else@example.com" {
+		t.Fatalf("forward Reply-To = %v", rt)
+	}
+}
+
+func TestInboundReplyMatchesSender(t *testing.T) {
+	t.Setenv("INBOUND_WEBHOOK_SECRET", "s3cret")
+	db := newTestDB(t)
+	srv := newTestServer(t, db, &mockSender{})
+	postJSON(t, srv.URL+"/api/subscribe", `{"email":"ada@example.com","name":"Ada"}`)
+
+	postJSON(t, srv.URL+"/api/webhooks/inbound?token=s3cret", `{"from":"ADA@example.com","subject":"Hi","text":"Question"}`)
+
+	resp, err := http.Get(srv.URL + "/api/replies?subscriber_id=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var replies []Reply
+	if err := json.NewDecoder(resp.Body).Decode(&replies); err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 1 || replies[0].ArticleID != nil {
+		t.Fatalf("replies = %+v", replies)
+	}
+}
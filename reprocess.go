@@ -0,0 +1,147 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// webhookSourceDelivery marks stored delivery webhook events.
+const webhookSourceDelivery = "delivery"
+
+// storeWebhookEvent keeps a received webhook event so it can be replayed
+// after a fix to its handling. Only the parsed event is stored, not the
+// provider's full payload.
+func storeWebhookEvent(db execer, source string, event interface{}) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("INSERT INTO webhook_events (source, payload) VALUES (?, ?)", source, string(payload))
+	return err
+}
+
+func deleteOldWebhookEvents(db *sql.DB, cutoff time.Time) (int64, error) {
+	result, err := db.Exec("DELETE FROM webhook_events WHERE received_at < ?", cutoff.Format(sqliteTimeFormat))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// replayDeliveryEvents applies the delivery events received between from
+// and to, inclusive, again in the order they arrived. It returns how many
+// were replayed and how many matched a send.
+func replayDeliveryEvents(db *sql.DB, from, to time.Time) (replayed, updated int, err error) {
+	rows, err := db.Query(`
+		SELECT id, payload FROM webhook_events
+		WHERE source = ? AND received_at BETWEEN ? AND ?
+		ORDER BY id`,
+		webhookSourceDelivery, from.UTC().Format(sqliteTimeFormat), to.UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		return 0, 0, err
+	}
+	type stored struct {
+		id    int
+		event deliveryEvent
+	}
+	var events []stored
+	for rows.Next() {
+		var s stored
+		var payload string
+		if err := rows.Scan(&s.id, &payload); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		if err := json.Unmarshal([]byte(payload), &s.event); err != nil {
+			log.Printf("Skipping unreadable webhook event %d: %v", s.id, err)
+			continue
+		}
+		events = append(events, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	for _, s := range events {
+		ok, err := applyDeliveryEvent(db, s.event)
+		if err != nil {
+			return replayed, updated, fmt.Errorf("replaying webhook event %d: %w", s.id, err)
+		}
+		replayed++
+		if ok {
+			updated++
+		}
+	}
+	return replayed, updated, nil
+}
+
+// ReprocessResult reports what a reprocess run changed.
+type ReprocessResult struct {
+	Kind     string `json:"kind"`
+	Replayed int    `json:"replayed,omitempty"`
+	Updated  int    `json:"updated"`
+}
+
+// handleReprocess replays stored delivery webhook events
+// ("delivery_events") or recomputes engagement scores ("engagement") for
+// an inclusive date range, e.g. after fixing a bug in event handling. The
+// body is {"kind": ..., "from": RFC 3339 time, "to": RFC 3339 time}; to
+// defaults to now. For engagement the range selects subscribers by send
+// time.
+func handleReprocess(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req struct {
+			Kind string    `json:"kind"`
+			From time.Time `json:"from"`
+			To   time.Time `json:"to"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		now := time.Now().UTC()
+		if req.To.IsZero() {
+			req.To = now
+		}
+		if req.From.IsZero() || !req.From.Before(req.To) {
+			http.Error(w, "from must be before to", http.StatusBadRequest)
+			return
+		}
+
+		result := ReprocessResult{Kind: req.Kind}
+		var err error
+		switch req.Kind {
+		case "delivery_events":
+			result.Replayed, result.Updated, err = replayDeliveryEvents(db, req.From, req.To)
+		case "engagement":
+			result.Updated, err = updateEngagementScoresSentBetween(db, now, req.From, req.To)
+		default:
+			http.Error(w, "kind must be delivery_events or engagement", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("Error reprocessing %s: %v", req.Kind, err)
+			http.Error(w, "Error reprocessing", http.StatusInternalServerError)
+			return
+		}
+		recordAudit(db, r, "reprocess", req.Kind, 0, map[string]interface{}{
+			"from":     req.From,
+			"to":       req.To,
+			"replayed": result.Replayed,
+			"updated":  result.Updated,
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReprocessReplaysDeliveryEvents(t *testing.T) {
+	t.Setenv("DELIVERY_WEBHOOK_SECRET", "s3cret")
+	db := newTestDB(t)
+	sender := newMockSender("")
+	srv := newTestServer(t, db, sender)
+
+	if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES ('ada@example.com', '')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO articles (title, content) VALUES ('Hello', '')"); err != nil {
+		t.Fatal(err)
+	}
+	sendNewsletterForArticle(context.Background(), db, sender, 1)
+	postJSON(t, srv.URL+"/api/webhooks/delivery?token=s3cret", `{"sg_message_id":"mock-1.filter0001","event":"bounce"}`)
+
+	// Simulate the bug being fixed after the event was mishandled.
+	if _, err := db.Exec("UPDATE sent_emails SET delivery_status = ?", deliveryAccepted); err != nil {
+		t.Fatal(err)
+	}
+
+	from := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	postJSON(t, srv.URL+"/api/admin/reprocess", `{"kind":"delivery_events","from":"`+from+`"}`)
+
+	var status string
+	var bounces int
+	if err := db.QueryRow("SELECT delivery_status FROM sent_emails").Scan(&status); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM events WHERE type = ?", eventBounced).Scan(&bounces); err != nil {
+		t.Fatal(err)
+	}
+	if status != deliveryBounced || bounces != 1 {
+		t.Fatalf("status = %q, bounce events = %d; want bounced, 1", status, bounces)
+	}
+}
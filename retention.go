@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// retentionPolicy removes or scrubs personal data older than a configured
+// number of months. A policy with months <= 0 is disabled.
+type retentionPolicy struct {
+	name   string
+	months int
+	apply  func(db *sql.DB, cutoff time.Time) (int64, error)
+}
+
+func retentionPolicies() []retentionPolicy {
+	return []retentionPolicy{
+		{
+			name:   "anonymize unsubscribed subscribers",
+			months: getEnvInt("RETENTION_UNSUBSCRIBED_MONTHS", 24),
+			apply:  anonymizeUnsubscribed,
+		},
+		{
+			name:   "clear old open and click tracking",
+			months: getEnvInt("RETENTION_TRACKING_MONTHS", 12),
+			apply:  clearOldTracking,
+		},
+		{
+			name:   "purge deleted subscribers and articles",
+			months: getEnvInt("RETENTION_DELETED_MONTHS", 1),
+			apply:  purgeDeleted,
+		},
+		{
+			name:   "archive old sent emails",
+			months: getEnvInt("RETENTION_SENT_EMAILS_MONTHS", 0),
+			apply:  archiveSentEmails,
+		},
+		{
+			name:   "delete old webhook events",
+			months: getEnvInt("RETENTION_WEBHOOK_EVENTS_MONTHS", 3),
+			apply:  deleteOldWebhookEvents,
+		},
+		{
+			name:   "delete old subscriber events",
+			months: getEnvInt("RETENTION_EVENTS_MONTHS", 24),
+			apply:  deleteOldEvents,
+		},
+		{
+			name:   "delete old webhook deliveries",
+			months: getEnvInt("RETENTION_WEBHOOK_DELIVERIES_MONTHS", 3),
+			apply:  deleteOldWebhookDeliveries,
+		},
+		{
+			name:   "delete old job runs",
+			months: getEnvInt("RETENTION_JOB_RUNS_MONTHS", 3),
+			apply:  deleteOldJobRuns,
+		},
+	}
+}
+
+// enforceRetention applies every enabled retention policy. A failing policy
+// does not stop the others; their errors are returned together.
+func enforceRetention(ctx context.Context, db *sql.DB) error {
+	var errs []error
+	for _, p := range retentionPolicies() {
+		if p.months <= 0 {
+			continue
+		}
+		cutoff := time.Now().UTC().AddDate(0, -p.months, 0)
+		n, err := p.apply(db, cutoff)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("retention policy %q: %w", p.name, err))
+			continue
+		}
+		if n > 0 {
+			jobLogf(ctx, "Retention policy %q affected %d rows", p.name, n)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// anonymizeUnsubscribed replaces the email and name of subscribers who
+// unsubscribed before cutoff. The row is kept so sent_emails history and
+// counts stay intact.
+func anonymizeUnsubscribed(db *sql.DB, cutoff time.Time) (int64, error) {
+	result, err := db.Exec(`
+		UPDATE subscribers
+		SET email = 'anonymized-' || id || '@invalid', name = ''
+		WHERE unsubscribed_at IS NOT NULL
+			AND unsubscribed_at < ?
+			AND email NOT LIKE 'anonymized-%@invalid'`,
+		cutoff.Format(sqliteTimeFormat))
+	if err != nil {
+		return 0, fmt.Errorf("anonymizing subscribers: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// clearOldTracking forgets when and how often subscribers opened or
+// clicked newsletters sent before cutoff, and deletes their open and click
+// events. The sends themselves are kept, so old articles still count as
+// sent; their open and click rates drop to zero.
+func clearOldTracking(db *sql.DB, cutoff time.Time) (int64, error) {
+	before := cutoff.Format(sqliteTimeFormat)
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE sent_emails
+		SET opened_at = NULL, open_count = 0, clicked_at = NULL, click_count = 0, last_engaged_at = NULL
+		WHERE sent_at < ?
+			AND (opened_at IS NOT NULL OR clicked_at IS NOT NULL OR last_engaged_at IS NOT NULL)`, before)
+	if err != nil {
+		return 0, fmt.Errorf("clearing sent email tracking: %w", err)
+	}
+	sends, _ := result.RowsAffected()
+	result, err = tx.Exec("DELETE FROM events WHERE type IN (?, ?) AND created_at < ?", eventOpened, eventClicked, before)
+	if err != nil {
+		return 0, fmt.Errorf("deleting tracking events: %w", err)
+	}
+	events, _ := result.RowsAffected()
+	return sends + events, tx.Commit()
+}
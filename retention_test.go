@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRetentionClearsOldTrackingByDefault(t *testing.T) {
+	db := newTestDB(t)
+	old := time.Now().UTC().AddDate(0, -18, 0).Format(sqliteTimeFormat)
+	recent := time.Now().UTC().AddDate(0, -1, 0).Format(sqliteTimeFormat)
+	if _, err := db.Exec(`
+		INSERT INTO sent_emails (subscriber_id, article_id, sent_at, opened_at, open_count, clicked_at, click_count) VALUES
+			(1, 1, ?, ?, 2, ?, 1),
+			(1, 2, ?, ?, 1, NULL, 0)`, old, old, old, recent, recent); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`
+		INSERT INTO events (subscriber_id, type, article_id, created_at) VALUES
+			(1, ?, 1, ?), (1, ?, 1, ?), (1, ?, 1, ?), (1, ?, 2, ?)`,
+		eventSent, old, eventOpened, old, eventClicked, old, eventOpened, recent); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := enforceRetention(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+
+	var opens, clicks int
+	db.QueryRow("SELECT COUNT(opened_at) + SUM(open_count), COUNT(clicked_at) + SUM(click_count) FROM sent_emails WHERE article_id = 1").Scan(&opens, &clicks)
+	if opens != 0 || clicks != 0 {
+		t.Errorf("old send keeps tracking: opens %d, clicks %d", opens, clicks)
+	}
+	var recentOpen *string
+	db.QueryRow("SELECT opened_at FROM sent_emails WHERE article_id = 2").Scan(&recentOpen)
+	if recentOpen == nil {
+		t.Error("recent open cleared")
+	}
+	var events []string
+	rows, err := db.Query("SELECT type || ':' || article_id FROM events ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e string
+		rows.Scan(&e)
+		events = append(events, e)
+	}
+	if len(events) != 2 || events[0] != "sent:1" || events[1] != "opened:2" {
+		t.Errorf("events left = %v, want the old send and the recent open", events)
+	}
+}
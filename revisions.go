@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ArticleRevision is an article's content as it was before an edit.
+type ArticleRevision struct {
+	ID        int       `json:"id"`
+	ArticleID int       `json:"article_id"`
+	Title     string    `json:"title"`
+	Content   string    `json:"content,omitempty"`
+	Subject   string    `json:"subject,omitempty"`
+	Excerpt   string    `json:"excerpt,omitempty"`
+	Actor     string    `json:"actor"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ArticleEdit changes an article's content. Fields left out keep their
+// current value.
+type ArticleEdit struct {
+	Title   *string `json:"title"`
+	Content *string `json:"content"`
+	Subject *string `json:"subject"`
+	Excerpt *string `json:"excerpt"`
+}
+
+// apply returns article with the edit applied. An excerpt that was
+// derived from the old content is derived again from the new one.
+func (e ArticleEdit) apply(article Article) Article {
+	derived := article.Excerpt == articleDescription(article.Content)
+	if e.Title != nil {
+		article.Title = *e.Title
+	}
+	if e.Content != nil {
+		article.Content = *e.Content
+	}
+	if e.Subject != nil {
+		article.Subject = *e.Subject
+	}
+	if e.Excerpt != nil {
+		article.Excerpt = *e.Excerpt
+	} else if derived {
+		article.Excerpt = ""
+	}
+	setReadingStats(&article)
+	return article
+}
+
+// updateArticle applies an edit, first storing the article's current
+// content as a revision, and returns the updated article. It reports false
+// if the article does not exist. A non-empty ifMatch is an If-Match
+// header the article's ETag must match. Invalid edits wrap errInvalidEdit
+// and edits of an article that has changed in the meantime return
+// errEditConflict.
+func updateArticle(ctx context.Context, db *sql.DB, r *http.Request, id int, edit ArticleEdit, ifMatch string) (Article, bool, error) {
+	current, err := getArticle(ctx, db, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return current, false, nil
+	}
+	if err != nil {
+		return current, false, err
+	}
+	if ifMatch != "" && !etagMatches(ifMatch, articleETag(current)) {
+		return current, true, errEditConflict
+	}
+	updated := edit.apply(current)
+	if strings.TrimSpace(updated.Title) == "" {
+		return current, true, fmt.Errorf("%w: title must not be empty", errInvalidEdit)
+	}
+	if err := validateArticleOverrides(updated); err != nil {
+		return current, true, fmt.Errorf("%w: %v", errInvalidEdit, err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return current, true, err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`
+		INSERT INTO article_revisions (article_id, title, content, subject, excerpt, actor)
+		SELECT id, title, content, subject, excerpt, ? FROM articles WHERE id = ?`,
+		requestActor(r), id)
+	if err != nil {
+		return current, true, fmt.Errorf("storing revision: %w", err)
+	}
+	// The version check catches edits saved since current was read.
+	result, err := tx.Exec(`
+		UPDATE articles
+		SET title = ?, content = ?, subject = ?, excerpt = ?, word_count = ?, reading_time = ?,
+			version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND version = ?`,
+		updated.Title, updated.Content, updated.Subject, updated.Excerpt, updated.WordCount, updated.ReadingTime, id, current.Version)
+	if err != nil {
+		return current, true, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		invalidateArticle(db, id)
+		return current, true, errEditConflict
+	}
+	if err := tx.Commit(); err != nil {
+		return current, true, err
+	}
+	invalidateArticle(db, id)
+	updated.Version++
+
+	diff := map[string]string{}
+	for field, v := range map[string][2]string{
+		"title":   {current.Title, updated.Title},
+		"content": {current.Content, updated.Content},
+		"subject": {current.Subject, updated.Subject},
+		"excerpt": {current.Excerpt, updated.Excerpt},
+	} {
+		if v[0] != v[1] {
+			diff[field] = v[1]
+		}
+	}
+	recordAudit(db, r, "update", "article", id, diff)
+	return updated, true, nil
+}
+
+var (
+	// errInvalidEdit marks an edit that would leave the article invalid.
+	errInvalidEdit = errors.New("invalid edit")
+	// errEditConflict is an edit based on an outdated version of the
+	// article.
+	errEditConflict = errors.New("article has changed since it was loaded")
+)
+
+// articleETag identifies a version of an article's content.
+func articleETag(article Article) string {
+	return fmt.Sprintf(`"article-%d-v%d"`, article.ID, article.Version)
+}
+
+// etagMatches reports whether an If-Match header matches etag. If-Match
+// uses strong comparison, so weak validators never match.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// getArticleRevisions lists an article's revisions, newest first, without
+// their content.
+func getArticleRevisions(db *sql.DB, articleID int) ([]ArticleRevision, error) {
+	rows, err := db.Query("SELECT id, article_id, title, subject, excerpt, actor, created_at FROM article_revisions WHERE article_id = ? ORDER BY id DESC", articleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []ArticleRevision{}
+	for rows.Next() {
+		var rev ArticleRevision
+		if err := rows.Scan(&rev.ID, &rev.ArticleID, &rev.Title, &rev.Subject, &rev.Excerpt, &rev.Actor, scanTime(&rev.CreatedAt)); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+func getArticleRevision(db *sql.DB, articleID, id int) (ArticleRevision, error) {
+	var rev ArticleRevision
+	err := db.QueryRow("SELECT id, article_id, title, content, subject, excerpt, actor, created_at FROM article_revisions WHERE id = ? AND article_id = ?", id, articleID).
+		Scan(&rev.ID, &rev.ArticleID, &rev.Title, &rev.Content, &rev.Subject, &rev.Excerpt, &rev.Actor, scanTime(&rev.CreatedAt))
+	return rev, err
+}
+
+// maxDiffLines bounds the line diff; longer texts are shown as replaced
+// wholesale.
+const maxDiffLines = 2000
+
+// diffLines compares two texts line by line. Each output line is the
+// input line prefixed with "-" (only in a), "+" (only in b) or " " (in
+// both).
+func diffLines(a, b string) []string {
+	x, y := strings.Split(a, "\n"), strings.Split(b, "\n")
+	var out []string
+	if len(x) > maxDiffLines || len(y) > maxDiffLines {
+		for _, l := range x {
+			out = append(out, "-"+l)
+		}
+		for _, l := range y {
+			out = append(out, "+"+l)
+		}
+		return out
+	}
+
+	// lcs[i][j] is the longest common subsequence of x[i:] and y[j:].
+	lcs := make([][]int32, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			out = append(out, " "+x[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, "-"+x[i])
+			i++
+		default:
+			out = append(out, "+"+y[j])
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		out = append(out, "-"+x[i])
+	}
+	for ; j < len(y); j++ {
+		out = append(out, "+"+y[j])
+	}
+	return out
+}
+
+// handleArticle returns (GET), edits (PATCH) or deletes (DELETE) an
+// article. GET sets an ETag; sending it back in If-Match makes the edit
+// fail with 412 Precondition Failed if someone else saved the article in
+// between, instead of silently overwriting their changes.
+func handleArticle(db *sql.DB) http.HandlerFunc {
+	remove := handleSoftDelete(db, "article", false)
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodPatch:
+		case http.MethodDelete:
+			remove(w, r)
+			return
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid article id", http.StatusBadRequest)
+			return
+		}
+		if r.Method == http.MethodGet {
+			article, err := getArticle(r.Context(), db, id)
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Article not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				log.Printf("Error getting article %d: %v", id, err)
+				http.Error(w, "Error getting article", http.StatusInternalServerError)
+				return
+			}
+			lastModified := article.PublishedAt
+			if article.UpdatedAt != nil {
+				lastModified = *article.UpdatedAt
+			}
+			if checkNotModified(w, r, articleETag(article), lastModified) {
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(article)
+			return
+		}
+
+		var edit ArticleEdit
+		if err := json.NewDecoder(r.Body).Decode(&edit); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeArticleUpdate(w, r, db, id, edit)
+	}
+}
+
+// writeArticleUpdate applies an edit, honouring If-Match, and writes the
+// response with the article's new ETag.
+func writeArticleUpdate(w http.ResponseWriter, r *http.Request, db *sql.DB, id int, edit ArticleEdit) {
+	article, found, err := updateArticle(r.Context(), db, r, id, edit, r.Header.Get("If-Match"))
+	switch {
+	case errors.Is(err, errEditConflict):
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		return
+	case errors.Is(err, errInvalidEdit):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Printf("Error updating article %d: %v", id, err)
+		http.Error(w, "Error updating article", http.StatusInternalServerError)
+		return
+	case !found:
+		http.Error(w, "Article not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("ETag", articleETag(article))
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Article updated"))
+}
+
+// handleArticleRevisions lists an article's revisions.
+func handleArticleRevisions(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid article id", http.StatusBadRequest)
+			return
+		}
+		revisions, err := getArticleRevisions(db, id)
+		if err != nil {
+			log.Printf("Error listing revisions of article %d: %v", id, err)
+			http.Error(w, "Error listing revisions", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(revisions)
+	}
+}
+
+// revisionFromPath loads the revision named by the {id} and {rev} path
+// values, writing an error response if it cannot.
+func revisionFromPath(w http.ResponseWriter, r *http.Request, db *sql.DB) (ArticleRevision, bool) {
+	articleID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid article id", http.StatusBadRequest)
+		return ArticleRevision{}, false
+	}
+	revID, err := strconv.Atoi(r.PathValue("rev"))
+	if err != nil {
+		http.Error(w, "Invalid revision id", http.StatusBadRequest)
+		return ArticleRevision{}, false
+	}
+	rev, err := getArticleRevision(db, articleID, revID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return rev, false
+	}
+	if err != nil {
+		log.Printf("Error getting revision %d of article %d: %v", revID, articleID, err)
+		http.Error(w, "Error getting revision", http.StatusInternalServerError)
+		return rev, false
+	}
+	return rev, true
+}
+
+// handleArticleRevision returns one revision with its content.
+func handleArticleRevision(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		rev, ok := revisionFromPath(w, r, db)
+		if !ok {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rev)
+	}
+}
+
+// handleArticleRevisionDiff shows what changed between a revision and the
+// current article, or another revision given as ?against=, as a line
+// diff of the title and content.
+func handleArticleRevisionDiff(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		rev, ok := revisionFromPath(w, r, db)
+		if !ok {
+			return
+		}
+
+		toLabel := "current"
+		var toTitle, toContent string
+		if against := r.URL.Query().Get("against"); against != "" {
+			otherID, err := strconv.Atoi(against)
+			if err != nil {
+				http.Error(w, "Invalid against revision", http.StatusBadRequest)
+				return
+			}
+			other, err := getArticleRevision(db, rev.ArticleID, otherID)
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Revision not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				log.Printf("Error getting revision %d of article %d: %v", otherID, rev.ArticleID, err)
+				http.Error(w, "Error getting revision", http.StatusInternalServerError)
+				return
+			}
+			toLabel, toTitle, toContent = "revision "+against, other.Title, other.Content
+		} else {
+			article, err := getArticle(r.Context(), db, rev.ArticleID)
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Article not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				log.Printf("Error getting article %d: %v", rev.ArticleID, err)
+				http.Error(w, "Error getting article", http.StatusInternalServerError)
+				return
+			}
+			toTitle, toContent = article.Title, article.Content
+		}
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "--- revision %d\n+++ %s\n", rev.ID, toLabel)
+		for _, l := range diffLines(rev.Title, toTitle) {
+			b.WriteString(l + "\n")
+		}
+		b.WriteString("\n")
+		for _, l := range diffLines(rev.Content, toContent) {
+			b.WriteString(l + "\n")
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(b.String()))
+	}
+}
+
+// handleRestoreArticleRevision puts a revision's content back. The
+// content it replaces is kept as a new revision, so a restore can itself
+// be undone. Like an edit, it honours If-Match.
+func handleRestoreArticleRevision(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		rev, ok := revisionFromPath(w, r, db)
+		if !ok {
+			return
+		}
+		writeArticleUpdate(w, r, db, rev.ArticleID, ArticleEdit{
+			Title:   &rev.Title,
+			Content: &rev.Content,
+			Subject: &rev.Subject,
+			Excerpt: &rev.Excerpt,
+		})
+	}
+}
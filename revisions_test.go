@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestArticleRevisions(t *testing.T) {
+	db := newTestDB(t)
+	srv := newTestServer(t, db, newMockSender(""))
+
+	postJSON(t, srv.URL+"/api/publish", `{"title":"Draft","content":"line one\nline two","scheduled_at":"2999-01-01T00:00:00Z"}`)
+
+	do := func(method, path, body string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	if code, body := do(http.MethodPatch, "/api/articles/1", `{"content":"line one\nline 2"}`); code != http.StatusOK {
+		t.Fatalf("edit: %d %s", code, body)
+	}
+	if code, _ := do(http.MethodPatch, "/api/articles/1", `{"title":" "}`); code != http.StatusBadRequest {
+		t.Errorf("empty title: status %d, want 400", code)
+	}
+	if code, _ := do(http.MethodPatch, "/api/articles/99", `{"title":"x"}`); code != http.StatusNotFound {
+		t.Errorf("missing article: status %d, want 404", code)
+	}
+
+	article, err := getArticle(context.Background(), db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if article.Content != "line one\nline 2" || article.Excerpt != "line one line 2" {
+		t.Errorf("after edit: content %q, excerpt %q", article.Content, article.Excerpt)
+	}
+
+	_, body := do(http.MethodGet, "/api/articles/1/revisions", "")
+	var revisions []ArticleRevision
+	if err := json.Unmarshal([]byte(body), &revisions); err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 1 || revisions[0].Title != "Draft" || revisions[0].Content != "" {
+		t.Fatalf("revisions = %+v", revisions)
+	}
+
+	_, diff := do(http.MethodGet, "/api/articles/1/revisions/1/diff", "")
+	for _, want := range []string{"--- revision 1\n+++ current\n", " line one\n", "-line two\n", "+line 2\n"} {
+		if !strings.Contains(diff, want) {
+			t.Errorf("diff missing %q:\n%s", want, diff)
+		}
+	}
+
+	if code, body := do(http.MethodPost, "/api/articles/1/revisions/1/restore", ""); code != http.StatusOK {
+		t.Fatalf("restore: %d %s", code, body)
+	}
+	article, _ = getArticle(context.Background(), db, 1)
+	if article.Content != "line one\nline two" {
+		t.Errorf("after restore: content %q", article.Content)
+	}
+	// The restore kept the overwritten content as a revision.
+	if revisions, _ := getArticleRevisions(db, 1); len(revisions) != 2 {
+		t.Errorf("%d revisions after restore, want 2", len(revisions))
+	}
+	if code, _ := do(http.MethodPost, "/api/articles/2/revisions/1/restore", ""); code != http.StatusNotFound {
+		t.Errorf("revision of another article: status %d, want 404", code)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	got := diffLines("a\nb\nc", "a\nc\nd")
+	want := []string{" a", "-b", " c", "+d"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diffLines = %q, want %q", got, want)
+	}
+}
+
+func TestArticleEditIfMatch(t *testing.T) {
+	db := newTestDB(t)
+	srv := newTestServer(t, db, newMockSender(""))
+	postJSON(t, srv.URL+"/api/publish", `{"title":"Draft","content":"Hi","scheduled_at":"2999-01-01T00:00:00Z"}`)
+
+	do := func(method, ifMatch, body string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+"/api/articles/1", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	// Two editors load the same version.
+	etag := do(http.MethodGet, "", "").Header.Get("ETag")
+	if etag != `"article-1-v1"` {
+		t.Fatalf("ETag = %q", etag)
+	}
+
+	resp := do(http.MethodPatch, etag, `{"content":"First editor"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("first edit: status %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("ETag"); got != `"article-1-v2"` {
+		t.Errorf("ETag after edit = %q", got)
+	}
+	if resp := do(http.MethodPatch, etag, `{"content":"Second editor"}`); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("stale edit: status %d, want 412", resp.StatusCode)
+	}
+	article, _ := getArticle(context.Background(), db, 1)
+	if article.Content != "First editor" || article.Version != 2 {
+		t.Errorf("content %q, version %d after the stale edit", article.Content, article.Version)
+	}
+
+	// Without If-Match the edit is unconditional.
+	if resp := do(http.MethodPatch, "", `{"content":"Forced"}`); resp.StatusCode != http.StatusOK {
+		t.Errorf("unconditional edit: status %d", resp.StatusCode)
+	}
+	if resp := do(http.MethodPatch, "*", `{"content":"Any"}`); resp.StatusCode != http.StatusOK {
+		t.Errorf("If-Match *: status %d", resp.StatusCode)
+	}
+}
+
+func TestUpdateArticleDetectsConcurrentEdit(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := db.Exec("INSERT INTO articles (title, content) VALUES ('Draft', 'Hi')"); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPatch, "/api/articles/1", nil)
+	if _, err := getArticle(context.Background(), db, 1); err != nil {
+		t.Fatal(err)
+	}
+	// Another instance saves the article behind this one's cache.
+	if _, err := db.Exec("UPDATE articles SET content = 'Theirs', version = version + 1 WHERE id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	content := "Mine"
+	_, _, err := updateArticle(context.Background(), db, req, 1, ArticleEdit{Content: &content}, "")
+	if !errors.Is(err, errEditConflict) {
+		t.Fatalf("err = %v, want errEditConflict", err)
+	}
+	if revisions, _ := getArticleRevisions(db, 1); len(revisions) != 0 {
+		t.Errorf("%d revisions stored by the failed edit", len(revisions))
+	}
+}
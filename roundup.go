@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// roundupSeries threads the monthly roundups together in mail clients.
+const roundupSeries = "roundup"
+
+// RoundupArticle is an article listed in a roundup.
+type RoundupArticle struct {
+	ID     int
+	Title  string
+	Clicks int
+}
+
+// roundupEnabled reports whether monthly roundups are sent
+// (ROUNDUP_ENABLED).
+func roundupEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("ROUNDUP_ENABLED"))
+	return enabled
+}
+
+// roundupPeriod returns the calendar month before now, in UTC.
+func roundupPeriod(now time.Time) (start, end time.Time) {
+	now = now.UTC()
+	end = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return end.AddDate(0, -1, 0), end
+}
+
+// topClickedArticles returns up to limit articles published in
+// [start, end) ordered by how many subscribers clicked them, including
+// archived sends. Articles nobody clicked and earlier roundups are left
+// out.
+func topClickedArticles(ctx context.Context, db *sql.DB, start, end time.Time, limit int) ([]RoundupArticle, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT a.id, a.title, clicks.n
+		FROM articles a
+		JOIN (
+			SELECT article_id, SUM(clicked) AS n FROM (
+				SELECT article_id, COUNT(clicked_at) AS clicked FROM sent_emails GROUP BY article_id
+				UNION ALL
+				SELECT article_id, clicked FROM sent_email_summaries
+			) GROUP BY article_id
+		) clicks ON clicks.article_id = a.id
+		WHERE a.deleted_at IS NULL
+			AND a.published_at >= ? AND a.published_at < ?
+			AND a.series != ?
+			AND clicks.n > 0
+		ORDER BY clicks.n DESC, a.id
+		LIMIT ?`,
+		start.Format(sqliteTimeFormat), end.Format(sqliteTimeFormat), roundupSeries, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var articles []RoundupArticle
+	for rows.Next() {
+		var a RoundupArticle
+		if err := rows.Scan(&a.ID, &a.Title, &a.Clicks); err != nil {
+			return nil, err
+		}
+		articles = append(articles, a)
+	}
+	return articles, rows.Err()
+}
+
+// renderRoundupContent renders the roundup template (ROUNDUP_TEMPLATE,
+// default roundup_template.txt) into article content.
+func renderRoundupContent(period string, articles []RoundupArticle) (string, error) {
+	path := os.Getenv("ROUNDUP_TEMPLATE")
+	if path == "" {
+		path = "roundup_template.txt"
+	}
+	t, err := texttemplate.ParseFiles(path)
+	if err != nil {
+		return "", fmt.Errorf("parsing roundup template: %w", err)
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, map[string]interface{}{"Period": period, "Articles": articles}); err != nil {
+		return "", fmt.Errorf("executing roundup template: %w", err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// createRoundup publishes the roundup for the month before now, unless it
+// already exists. It returns the new article's id, or 0 if there was
+// nothing to do. A month with no clicked articles is recorded as skipped.
+//
+// The roundup goes to the segment given by ROUNDUP_PREMIUM and
+// ROUNDUP_MIN_ENGAGEMENT and lists the ROUNDUP_TOP (default 5) most
+// clicked articles.
+func createRoundup(ctx context.Context, db *sql.DB, now time.Time) (int, error) {
+	start, end := roundupPeriod(now)
+	period := start.Format("2006-01")
+	var done int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM roundups WHERE period = ?", period).Scan(&done); err != nil || done > 0 {
+		return 0, err
+	}
+
+	articles, err := topClickedArticles(ctx, db, start, end, getEnvInt("ROUNDUP_TOP", 5))
+	if err != nil {
+		return 0, err
+	}
+	if len(articles) == 0 {
+		_, err := db.ExecContext(ctx, "INSERT INTO roundups (period) VALUES (?)", period)
+		return 0, err
+	}
+
+	month := start.Format("January 2006")
+	content, err := renderRoundupContent(month, articles)
+	if err != nil {
+		return 0, err
+	}
+	premium, _ := strconv.ParseBool(os.Getenv("ROUNDUP_PREMIUM"))
+	minEngagement, _ := strconv.ParseFloat(os.Getenv("ROUNDUP_MIN_ENGAGEMENT"), 64)
+	article := Article{
+		Title:         "Best of " + month,
+		Content:       content,
+		Series:        roundupSeries,
+		Premium:       premium,
+		MinEngagement: minEngagement,
+	}
+	if err := validateArticleOverrides(article); err != nil {
+		return 0, err
+	}
+	// Claim the period first so a concurrent run can't publish it twice.
+	result, err := db.ExecContext(ctx, "INSERT OR IGNORE INTO roundups (period) VALUES (?)", period)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return 0, nil
+	}
+	id, err := insertArticle(db, nil, article)
+	if err != nil {
+		db.ExecContext(ctx, "DELETE FROM roundups WHERE period = ?", period)
+		return 0, err
+	}
+	_, err = db.ExecContext(ctx, "UPDATE roundups SET article_id = ? WHERE period = ?", id, period)
+	return id, err
+}
+
+// sendRoundup publishes and sends the previous month's roundup once the
+// month is over. It does nothing once the roundup exists.
+func sendRoundup(ctx context.Context, db *sql.DB, sender EmailSender) error {
+	id, err := createRoundup(ctx, db, time.Now())
+	if err != nil {
+		return fmt.Errorf("creating roundup: %w", err)
+	}
+	if id != 0 {
+		jobLogf(ctx, "Sending roundup article %d", id)
+		sendNewsletterForArticle(ctx, db, sender, id)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateRoundup(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	now := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+
+	// September's roundup is due, but nothing had been clicked yet.
+	if id, err := createRoundup(ctx, db, now); err != nil || id != 0 {
+		t.Fatalf("empty month: %d, %v", id, err)
+	}
+
+	for _, a := range []struct {
+		title, published string
+		clicks           int
+	}{
+		{"Quiet", "2026-10-03 10:00:00", 1},
+		{"Popular", "2026-10-10 10:00:00", 3},
+		{"Too old", "2026-09-20 10:00:00", 9},
+	} {
+		result, err := db.Exec("INSERT INTO articles (title, content, published_at) VALUES (?, '', ?)", a.title, a.published)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := result.LastInsertId()
+		for i := 0; i < a.clicks; i++ {
+			if _, err := db.Exec("INSERT INTO sent_emails (subscriber_id, article_id, clicked_at) VALUES (?, ?, CURRENT_TIMESTAMP)", i+1, id); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	now = now.AddDate(0, 1, 0)
+	id, err := createRoundup(ctx, db, now)
+	if err != nil || id == 0 {
+		t.Fatalf("createRoundup = %d, %v", id, err)
+	}
+	article, err := getArticle(ctx, db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if article.Title != "Best of October 2026" || article.Series != roundupSeries {
+		t.Fatalf("roundup = %+v", article)
+	}
+	if !strings.Contains(article.Content, "Popular; Quiet") || strings.Contains(article.Content, "Too old") {
+		t.Fatalf("content = %q", article.Content)
+	}
+
+	if id, err := createRoundup(ctx, db, now); err != nil || id != 0 {
+		t.Fatalf("second run: %d, %v", id, err)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	defaultSampleSize = 5
+	maxSampleSize     = 50
+)
+
+// SampleRender is an article rendered for one sampled subscriber.
+type SampleRender struct {
+	SubscriberID int    `json:"subscriber_id"`
+	Email        string `json:"email"`
+	Name         string `json:"name"`
+	Subject      string `json:"subject,omitempty"`
+	Body         string `json:"body,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// redactEmail keeps the first character of the local part and the domain,
+// which is enough to tell samples apart without exposing the address.
+func redactEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 {
+		return "***"
+	}
+	_, size := utf8.DecodeRuneInString(email)
+	return email[:size] + "***" + email[at:]
+}
+
+// sampleSubscribers picks up to n random subscribers from the article's
+// audience.
+func sampleSubscribers(ctx context.Context, db *sql.DB, article Article, n int) ([]Subscriber, error) {
+	where, args := audienceWhere(article)
+	rows, err := db.QueryContext(ctx,
+		"SELECT id, email, name, tier, tracking_opt_out FROM subscribers WHERE "+where+" ORDER BY RANDOM() LIMIT ?",
+		append(args, n)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscribers []Subscriber
+	for rows.Next() {
+		var s Subscriber
+		if err := rows.Scan(&s.ID, &s.Email, &s.Name, &s.Tier, &s.TrackingOptOut); err != nil {
+			return nil, err
+		}
+		subscribers = append(subscribers, s)
+	}
+	return subscribers, rows.Err()
+}
+
+// renderSample renders the subject and body for one subscriber. The id is
+// cleared first so no live per-subscriber links are signed into a preview.
+func renderSample(sub Subscriber, article Article) SampleRender {
+	result := SampleRender{SubscriberID: sub.ID, Email: redactEmail(sub.Email), Name: sub.Name}
+	sub.ID = 0
+	subject, err := renderSubject(article, emailTemplateData(sub, article))
+	if err != nil {
+		result.Error = "subject: " + err.Error()
+		return result
+	}
+	body, err := renderNewsletterBody(sub, article)
+	if err != nil {
+		result.Error = "body: " + err.Error()
+		return result
+	}
+	result.Subject, result.Body = subject, body
+	return result
+}
+
+// handleSamplePreview renders an article for n randomly sampled subscribers
+// from its audience (default 5, at most 50), so personalization can be
+// checked against real data. Email addresses are redacted.
+func handleSamplePreview(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid article id", http.StatusBadRequest)
+			return
+		}
+		n := defaultSampleSize
+		if v := r.URL.Query().Get("n"); v != "" {
+			n, err = strconv.Atoi(v)
+			if err != nil || n < 1 {
+				http.Error(w, "n must be a positive integer", http.StatusBadRequest)
+				return
+			}
+			n = min(n, maxSampleSize)
+		}
+		article, err := getArticle(r.Context(), db, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Article not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		subscribers, err := sampleSubscribers(r.Context(), db, article, n)
+		if err != nil {
+			log.Printf("Error sampling subscribers: %v", err)
+			http.Error(w, "Error sampling subscribers", http.StatusInternalServerError)
+			return
+		}
+		samples := make([]SampleRender, 0, len(subscribers))
+		for _, sub := range subscribers {
+			samples = append(samples, renderSample(sub, article))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(samples)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRedactEmail(t *testing.T) {
+	for in, want := range map[string]string{
+		"ada@example.com":  "a***@example.com",
+		"élise@example.fr": "é***@example.fr",
+		"not-an-address":   "***",
+	} {
+		if got := redactEmail(in); got != want {
+			t.Errorf("redactEmail(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSamplePreview(t *testing.T) {
+	db := newTestDB(t)
+	sender := newMockSender("")
+	srv := newTestServer(t, db, sender)
+
+	if _, err := db.Exec(`INSERT INTO subscribers (email, name, unsubscribed_at) VALUES
+		('ada@example.com', 'Ada', NULL),
+		('grace@example.com', 'Grace', NULL),
+		('gone@example.com', 'Gone', CURRENT_TIMESTAMP)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO articles (title, content, subject) VALUES ('Hello', 'Body', 'Hi {{.Name}}')`); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get(srv.URL + "/api/articles/1/sample?n=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var samples []SampleRender
+	if err := json.NewDecoder(resp.Body).Decode(&samples); err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 2 {
+		t.Fatalf("got %d samples, want 2", len(samples))
+	}
+	for _, s := range samples {
+		if s.Error != "" {
+			t.Fatalf("sample %d: %s", s.SubscriberID, s.Error)
+		}
+		if s.Subject != "Hi "+s.Name {
+			t.Errorf("subject = %q for %q", s.Subject, s.Name)
+		}
+		if strings.Contains(s.Body, "@example.com") || !strings.HasSuffix(s.Email, "***@example.com") {
+			t.Errorf("email not redacted: %+v", s)
+		}
+	}
+	if n := len(sender.Messages()); n != 0 {
+		t.Fatalf("preview sent %d messages", n)
+	}
+
+	resp, err = http.Get(srv.URL + "/api/articles/1/sample?n=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("n=0: status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// scheduledTime returns the article's scheduled send time in UTC. The zero
+// time means the newsletter is sent on publish.
+func (a Article) scheduledTime() time.Time {
+	if a.ScheduledAt == nil {
+		return time.Time{}
+	}
+	return a.ScheduledAt.UTC()
+}
+
+// sendsOnPublish reports whether publishing the article should send its
+// newsletter straight away rather than at its scheduled time.
+func sendsOnPublish(a Article, now time.Time) bool {
+	return !a.scheduledTime().After(now)
+}
+
+// dueScheduledArticles returns articles whose scheduled send time has
+// passed and that have not been sent by any job yet.
+func dueScheduledArticles(ctx context.Context, db *sql.DB, now time.Time) ([]int, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id FROM articles
+		WHERE scheduled_at IS NOT NULL AND scheduled_at <= ? AND deleted_at IS NULL
+			AND NOT EXISTS (SELECT 1 FROM newsletter_jobs WHERE article_id = articles.id)
+		ORDER BY scheduled_at`, now.UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// sendDueNewsletters sends scheduled newsletters that are due.
+func sendDueNewsletters(ctx context.Context, db *sql.DB, sender EmailSender) error {
+	if !flagEnabled(flagScheduledSends) {
+		return nil
+	}
+	ids, err := dueScheduledArticles(ctx, db, time.Now())
+	if err != nil {
+		return fmt.Errorf("finding scheduled newsletters: %w", err)
+	}
+	for _, id := range ids {
+		jobLogf(ctx, "Sending scheduled newsletter for article %d", id)
+		sendNewsletterForArticle(ctx, db, sender, id)
+	}
+	return nil
+}
+
+// ScheduleEntry is an upcoming send in the publishing calendar.
+type ScheduleEntry struct {
+	Type      string    `json:"type"`
+	ArticleID int       `json:"article_id"`
+	Title     string    `json:"title"`
+	Start     time.Time `json:"start"`
+}
+
+// getSchedule returns upcoming sends: scheduled newsletters not yet sent,
+// and newsletters deferred by the warm-up cap, which resume when the next
+// day's quota starts at midnight UTC.
+func getSchedule(ctx context.Context, db *sql.DB, now time.Time) ([]ScheduleEntry, error) {
+	entries := []ScheduleEntry{}
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, title, scheduled_at FROM articles
+		WHERE scheduled_at > ? AND deleted_at IS NULL
+			AND NOT EXISTS (SELECT 1 FROM newsletter_jobs WHERE article_id = articles.id)
+		ORDER BY scheduled_at`, now.UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		e := ScheduleEntry{Type: "send"}
+		if err := rows.Scan(&e.ArticleID, &e.Title, &e.Start); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	deferred, err := deferredArticles(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	resume := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	for _, id := range deferred {
+		article, err := getArticle(ctx, db, id)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, ScheduleEntry{Type: "warmup_resume", ArticleID: id, Title: article.Title, Start: resume})
+	}
+	return entries, nil
+}
+
+// handleGetSchedule returns upcoming sends as JSON, or as an iCalendar feed
+// with ?format=ics so the pipeline can be followed from a calendar app.
+func handleGetSchedule(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		entries, err := getSchedule(r.Context(), db, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if r.URL.Query().Get("format") == "ics" {
+			events := make([]icalEvent, len(entries))
+			for i, e := range entries {
+				summary := "Send: " + e.Title
+				if e.Type == "warmup_resume" {
+					summary = "Resume send: " + e.Title
+				}
+				events[i] = icalEvent{
+					UID:     fmt.Sprintf("%s-%d@%s", e.Type, e.ArticleID, senderDomain()),
+					Start:   e.Start,
+					End:     e.Start.Add(15 * time.Minute),
+					Summary: summary,
+				}
+			}
+			w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+			w.Write([]byte(renderICal("PUBLISH", events)))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	}
+}
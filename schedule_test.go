@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScheduledArticleSendsWhenDue(t *testing.T) {
+	db := newTestDB(t)
+	sender := newMockSender("")
+	srv := newTestServer(t, db, sender)
+
+	if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES ('ada@example.com', '')"); err != nil {
+		t.Fatal(err)
+	}
+	at := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	postJSON(t, srv.URL+"/api/publish", `{"title":"Later","content":"x","scheduled_at":"`+at.Format(time.RFC3339)+`"}`)
+
+	resp, err := http.Get(srv.URL + "/api/schedule")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []ScheduleEntry
+	json.NewDecoder(resp.Body).Decode(&entries)
+	resp.Body.Close()
+	if len(entries) != 1 || entries[0].Type != "send" || !entries[0].Start.Equal(at) {
+		t.Fatalf("schedule = %+v", entries)
+	}
+
+	resp, err = http.Get(srv.URL + "/api/schedule?format=ics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "DTSTART:"+at.Format(icalTimeFormat)+"\r\n") || !strings.Contains(string(body), "SUMMARY:Send: Later") {
+		t.Fatalf("ics = %s", body)
+	}
+
+	ctx := context.Background()
+	if ids, _ := dueScheduledArticles(ctx, db, time.Now()); len(ids) != 0 {
+		t.Fatalf("due before scheduled time: %v", ids)
+	}
+	ids, err := dueScheduledArticles(ctx, db, at.Add(time.Minute))
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("due = %v, %v", ids, err)
+	}
+	sendNewsletterForArticle(ctx, db, sender, ids[0])
+	if n := countSentEmails(t, db, ids[0]); n != 1 {
+		t.Fatalf("sent %d emails", n)
+	}
+	if ids, _ := dueScheduledArticles(ctx, db, at.Add(time.Minute)); len(ids) != 0 {
+		t.Fatalf("due after send: %v", ids)
+	}
+}
+
+func TestICalFolding(t *testing.T) {
+	var b strings.Builder
+	icalLine(&b, "SUMMARY:"+strings.Repeat("é", 120))
+	for _, line := range strings.Split(strings.TrimSuffix(b.String(), "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Fatalf("line too long: %d octets", len(line))
+		}
+	}
+	if got := icalEscape("a,b;c\nd"); got != `a\,b\;c\nd` {
+		t.Fatalf("escape = %q", got)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sdNotify sends state (e.g. "READY=1") to systemd over NOTIFY_SOCKET. It
+// does nothing when the service was not started with Type=notify.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogInterval returns the WatchdogSec= systemd configured for this
+// process, or 0 when the watchdog is off.
+func watchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// heartbeat records the last time a background loop made progress.
+type heartbeat struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+func (h *heartbeat) beat() {
+	h.mu.Lock()
+	h.last = time.Now()
+	h.mu.Unlock()
+}
+
+// stale reports whether the loop has gone longer than limit without a
+// beat.
+func (h *heartbeat) stale(limit time.Duration) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !h.last.IsZero() && time.Since(h.last) > limit
+}
+
+// schedulerHeartbeat is beaten by the task scheduler on every tick. Tasks
+// run in their own goroutines, so a long send does not hold it up.
+var schedulerHeartbeat heartbeat
+
+// serviceHealthy checks that the database answers within timeout and the
+// scheduler loop is still ticking.
+func serviceHealthy(db *sql.DB, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var one int
+	if err := db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		log.Printf("Watchdog: database check failed: %v", err)
+		return false
+	}
+	if schedulerHeartbeat.stale(3 * cronPollInterval) {
+		log.Println("Watchdog: scheduler has stopped ticking")
+		return false
+	}
+	return true
+}
+
+// runWatchdog pings the systemd watchdog at half the configured interval
+// while the service is healthy. Once it stops, systemd restarts the
+// service after WatchdogSec.
+func runWatchdog(db *sql.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !serviceHealthy(db, interval/4) {
+			continue
+		}
+		if err := sdNotify("WATCHDOG=1"); err != nil {
+			log.Printf("Error notifying systemd watchdog: %v", err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v6"
+)
+
+// runSeed implements `seed`, which fills a database with fake subscribers,
+// articles and send history for load testing and dashboard development.
+func runSeed(args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	dbPath := fs.String("db", databasePath(), "database to seed")
+	subscribers := fs.Int("subscribers", 50, "number of subscribers to create")
+	articles := fs.Int("articles", 5, "number of articles to create")
+	sentRatio := fs.Float64("sent-ratio", 0.8, "fraction of subscribers marked as having received each article")
+	seed := fs.Int64("seed", 0, "random seed, 0 for a random one")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db := openDB(*dbPath)
+	defer db.Close()
+
+	return seedFakeData(db, seedOptions{
+		Subscribers: *subscribers,
+		Articles:    *articles,
+		SentRatio:   *sentRatio,
+		Seed:        *seed,
+	})
+}
+
+type seedOptions struct {
+	Subscribers int
+	Articles    int
+	SentRatio   float64
+	Seed        int64
+}
+
+// seedFakeData inserts realistic fake rows in a single transaction.
+// Subscription and publish dates are spread over the past year.
+func seedFakeData(db *sql.DB, opts seedOptions) error {
+	faker := gofakeit.New(opts.Seed)
+	now := time.Now().UTC()
+	yearAgo := now.AddDate(-1, 0, 0)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var subscriberIDs []int64
+	for i := 0; i < opts.Subscribers; i++ {
+		first, last := faker.FirstName(), faker.LastName()
+		email := fmt.Sprintf("%s.%s.%d@%s", strings.ToLower(first), strings.ToLower(last), i, faker.DomainName())
+		result, err := tx.Exec("INSERT OR IGNORE INTO subscribers (email, name, subscribed_at) VALUES (?, ?, ?)",
+			email, first+" "+last, faker.DateRange(yearAgo, now).Format(sqliteTimeFormat))
+		if err != nil {
+			return err
+		}
+		// An ignored duplicate leaves LastInsertId at the previous insert,
+		// so only take it when a row was actually added.
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			continue
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		subscriberIDs = append(subscriberIDs, id)
+	}
+
+	for i := 0; i < opts.Articles; i++ {
+		content := strings.Join([]string{
+			faker.Paragraph(1, 4, 20, " "),
+			faker.Paragraph(1, 4, 20, " "),
+		}, "\n\n")
+		result, err := tx.Exec("INSERT INTO articles (title, content, published_at) VALUES (?, ?, ?)",
+			faker.Sentence(6), content, faker.DateRange(yearAgo, now).Format(sqliteTimeFormat))
+		if err != nil {
+			return err
+		}
+		articleID, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		for _, subID := range subscriberIDs {
+			if faker.Float64Range(0, 1) >= opts.SentRatio {
+				continue
+			}
+			if _, err := tx.Exec("INSERT INTO sent_emails (subscriber_id, article_id) VALUES (?, ?)", subID, articleID); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Seeded %d subscribers and %d articles", len(subscriberIDs), opts.Articles)
+	return nil
+}
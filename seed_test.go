@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestSeedSkipsExistingSubscribers(t *testing.T) {
+	db := newTestDB(t)
+	opts := seedOptions{Subscribers: 5, Articles: 1, SentRatio: 1, Seed: 1}
+	if err := seedFakeData(db, opts); err != nil {
+		t.Fatal(err)
+	}
+	// The same seed generates the same addresses, which are all ignored.
+	if err := seedFakeData(db, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	var subscribers, sends int
+	db.QueryRow("SELECT COUNT(*) FROM subscribers").Scan(&subscribers)
+	db.QueryRow("SELECT COUNT(*) FROM sent_emails").Scan(&sends)
+	if subscribers != 5 || sends != 5 {
+		t.Errorf("%d subscribers and %d sends, want 5 of each", subscribers, sends)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// segmentSampleSize is how many matching subscribers a segment preview
+// returns.
+const segmentSampleSize = 10
+
+// Segment selects active subscribers. Zero fields do not filter.
+type Segment struct {
+	Tier          string  `json:"tier,omitempty"`
+	MinEngagement float64 `json:"min_engagement,omitempty"`
+	Source        string  `json:"source,omitempty"`
+}
+
+// articleSegment returns the audience an article is sent to.
+func articleSegment(article Article) Segment {
+	s := Segment{MinEngagement: article.MinEngagement}
+	if article.Premium {
+		s.Tier = tierPremium
+	}
+	return s
+}
+
+// where returns the SQL condition selecting the segment's subscribers.
+func (s Segment) where() (string, []interface{}) {
+	conds := []string{"unsubscribed_at IS NULL", "deleted_at IS NULL", "(snoozed_until IS NULL OR snoozed_until <= CURRENT_TIMESTAMP)"}
+	var args []interface{}
+	if s.Tier != "" {
+		conds = append(conds, "tier = ?")
+		args = append(args, s.Tier)
+	}
+	if s.MinEngagement > 0 {
+		conds = append(conds, "engagement_score >= ?")
+		args = append(args, s.MinEngagement)
+	}
+	if s.Source != "" {
+		conds = append(conds, "source = ?")
+		args = append(args, normalizeSource(s.Source))
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// SegmentPreview is the size of a segment and a sample of its members.
+type SegmentPreview struct {
+	Count  int          `json:"count"`
+	Sample []Subscriber `json:"sample"`
+}
+
+func previewSegment(r *http.Request, db *sql.DB, s Segment) (*SegmentPreview, error) {
+	where, args := s.where()
+	preview := &SegmentPreview{Sample: []Subscriber{}}
+	if err := db.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM subscribers WHERE "+where, args...).Scan(&preview.Count); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(r.Context(),
+		"SELECT "+subscriberColumns+" FROM subscribers WHERE "+where+" ORDER BY RANDOM() LIMIT ?",
+		append(args, segmentSampleSize)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		sub, err := scanSubscriber(rows)
+		if err != nil {
+			return nil, err
+		}
+		preview.Sample = append(preview.Sample, sub)
+	}
+	return preview, rows.Err()
+}
+
+// handleSegmentPreview returns how many subscribers match a segment, with
+// a random sample, before anything is sent to it.
+func handleSegmentPreview(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var s Segment
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if s.Tier != "" && !validTier(s.Tier) {
+			http.Error(w, "Tier must be free or premium", http.StatusBadRequest)
+			return
+		}
+
+		preview, err := previewSegment(r, db, s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(preview)
+	}
+}
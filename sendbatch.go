@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+)
+
+// articleSendLocks serializes sends of one article within this process,
+// so a publish racing a scheduled or resumed send of the same article
+// waits for it instead of picking the same recipients. The sent_emails
+// claims below cover other processes.
+var articleSendLocks = struct {
+	sync.Mutex
+	m map[int]*articleSendLock
+}{m: map[int]*articleSendLock{}}
+
+type articleSendLock struct {
+	sync.Mutex
+	waiters int
+}
+
+// lockArticleSend waits until no other send of the article is running and
+// returns the function that releases it.
+func lockArticleSend(articleID int) func() {
+	articleSendLocks.Lock()
+	l := articleSendLocks.m[articleID]
+	if l == nil {
+		l = &articleSendLock{}
+		articleSendLocks.m[articleID] = l
+	}
+	l.waiters++
+	articleSendLocks.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		articleSendLocks.Lock()
+		if l.waiters--; l.waiters == 0 {
+			delete(articleSendLocks.m, articleID)
+		}
+		articleSendLocks.Unlock()
+	}
+}
+
+// migrateSentEmailsUnique makes sent_emails hold at most one row per
+// subscriber and article, which claimRecipient relies on. Sends racing
+// before the index existed could record an article twice; the first
+// record of each is kept.
+func migrateSentEmailsUnique(db *sql.DB) {
+	var exists int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'idx_sent_emails_subscriber_article'").Scan(&exists)
+	if err == nil && exists == 0 {
+		_, err = db.Exec(`
+			DELETE FROM sent_emails
+			WHERE subscriber_id IS NOT NULL AND id NOT IN (
+				SELECT MIN(id) FROM sent_emails GROUP BY subscriber_id, article_id
+			)`)
+		if err == nil {
+			_, err = db.Exec("CREATE UNIQUE INDEX idx_sent_emails_subscriber_article ON sent_emails (subscriber_id, article_id)")
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// claimRecipient records the article as sent to the subscriber before the
+// message goes out, reporting false if it already was: two sends of the
+// article, even from different processes, can then never both email the
+// same subscriber. A send that fails gives the claim back with
+// releaseRecipient.
+func claimRecipient(ctx context.Context, db *sql.DB, subscriberID, articleID int) (claimed bool, err error) {
+	const query = "INSERT INTO sent_emails (subscriber_id, article_id) VALUES (?, ?) ON CONFLICT (subscriber_id, article_id) DO NOTHING"
+	ctx, span := startDBSpan(ctx, "db.claimRecipient", query)
+	defer func() { endSpan(span, err) }()
+
+	result, err := db.ExecContext(ctx, query, subscriberID, articleID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// releaseRecipient removes a claim whose message was not sent, so a retry
+// or dead-letter redrive can send it.
+func releaseRecipient(ctx context.Context, db *sql.DB, subscriberID, articleID int) {
+	if _, err := db.ExecContext(ctx, "DELETE FROM sent_emails WHERE subscriber_id = ? AND article_id = ? AND message_id IS NULL", subscriberID, articleID); err != nil {
+		log.Printf("Error releasing subscriber %d's claim on article %d: %v", subscriberID, articleID, err)
+	}
+}
+
+// receivedArticle returns the ids of subscribers the article was already
+// sent to, in one query instead of one lookup per subscriber.
+func receivedArticle(ctx context.Context, db *sql.DB, articleID int) (received map[int]bool, err error) {
+	const query = "SELECT subscriber_id FROM sent_emails WHERE article_id = ?"
+	ctx, span := startDBSpan(ctx, "db.receivedArticle", query)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := db.QueryContext(ctx, query, articleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	received = map[int]bool{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		received[id] = true
+	}
+	return received, rows.Err()
+}
+
+// sentEmailRow is a send whose claim is waiting for its message ids.
+type sentEmailRow struct {
+	subscriberID          int
+	messageID, providerID string
+}
+
+// sentEmailBatch buffers sends of one article and writes their message ids
+// to the claimed sent_emails rows in a single transaction with a prepared
+// statement, every SEND_BATCH_SIZE sends (default 50) and when flushed. A
+// crash loses at most one batch of message ids; the claims still stop a
+// resend from emailing those subscribers again.
+type sentEmailBatch struct {
+	db        *sql.DB
+	articleID int
+	size      int
+	rows      []sentEmailRow
+}
+
+func newSentEmailBatch(db *sql.DB, articleID int) *sentEmailBatch {
+	size := getEnvInt("SEND_BATCH_SIZE", 50)
+	if size < 1 {
+		size = 1
+	}
+	return &sentEmailBatch{db: db, articleID: articleID, size: size}
+}
+
+// add records a send, writing the batch once it is full.
+func (b *sentEmailBatch) add(ctx context.Context, subscriberID int, messageID, providerID string) error {
+	b.rows = append(b.rows, sentEmailRow{subscriberID, messageID, providerID})
+	if len(b.rows) < b.size {
+		return nil
+	}
+	return b.flush(ctx)
+}
+
+// flush writes the buffered message ids and the sends' sent events.
+func (b *sentEmailBatch) flush(ctx context.Context) (err error) {
+	if len(b.rows) == 0 {
+		return nil
+	}
+	const query = "UPDATE sent_emails SET message_id = ?, provider_message_id = ?, cost = ? WHERE subscriber_id = ? AND article_id = ?"
+	ctx, span := startDBSpan(ctx, "db.markEmailsSent", query)
+	defer func() { endSpan(span, err) }()
+
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	cost := sendCostPerEmail()
+	for _, row := range b.rows {
+		if _, err := stmt.ExecContext(ctx, row.messageID, row.providerID, cost, row.subscriberID, b.articleID); err != nil {
+			return err
+		}
+		recordEvent(tx, row.subscriberID, eventSent, b.articleID, "")
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	b.rows = b.rows[:0]
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"gopkg.in/gomail.v2"
+)
+
+func TestSentEmailBatching(t *testing.T) {
+	t.Setenv("SEND_BATCH_SIZE", "2")
+	db := newTestDB(t)
+	sender := newMockSender("")
+	for i := 0; i < 5; i++ {
+		if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES (?, '')", fmt.Sprintf("sub%d@example.com", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("INSERT INTO articles (title, content) VALUES ('Hello', '')"); err != nil {
+		t.Fatal(err)
+	}
+	// Subscriber 1 already has it.
+	if _, err := db.Exec("INSERT INTO sent_emails (subscriber_id, article_id) VALUES (1, 1)"); err != nil {
+		t.Fatal(err)
+	}
+
+	sendNewsletterForArticle(context.Background(), db, sender, 1)
+
+	if n := len(sender.Messages()); n != 4 {
+		t.Fatalf("sent %d messages, want 4", n)
+	}
+	if n := countSentEmails(t, db, 1); n != 5 {
+		t.Fatalf("%d sent_emails rows, want 5", n)
+	}
+	var events int
+	db.QueryRow("SELECT COUNT(*) FROM events WHERE type = ?", eventSent).Scan(&events)
+	if events != 4 {
+		t.Fatalf("%d sent events, want 4", events)
+	}
+}
+
+func TestRenderFailuresRecordedInJobReport(t *testing.T) {
+	db := newTestDB(t)
+	sender := newMockSender("")
+	if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES ('ada@example.com', 'Ada Lovelace'), ('al@example.com', 'Al')"); err != nil {
+		t.Fatal(err)
+	}
+	// The subject only renders for empty names or ones of three or more bytes.
+	if _, err := db.Exec(`INSERT INTO articles (title, content, subject) VALUES ('Hello', '', 'Hi{{if .Name}} {{slice .Name 0 3}}{{end}}')`); err != nil {
+		t.Fatal(err)
+	}
+	sendNewsletterForArticle(context.Background(), db, sender, 1)
+
+	if n := len(sender.Messages()); n != 1 {
+		t.Fatalf("sent %d emails; want 1", n)
+	}
+	jobs, err := getJobs(db, 1)
+	if err != nil || len(jobs) != 1 {
+		t.Fatalf("jobs = %+v, %v", jobs, err)
+	}
+	job := jobs[0]
+	if job.Status != jobCompleted || job.Sent != 1 || job.Report.RenderFailed != 1 {
+		t.Fatalf("job = %+v", job)
+	}
+	if f := job.Report.RenderFailures; len(f) != 1 || f[0].SubscriberID != 2 || !strings.Contains(f[0].Error, "slice") {
+		t.Fatalf("render failures = %+v", f)
+	}
+}
+
+// cancellingSender cancels the send's context once a message has gone out,
+// like a shutdown arriving mid-send.
+type cancellingSender struct {
+	*mockSender
+	cancel context.CancelFunc
+}
+
+func (s cancellingSender) Send(ctx context.Context, m *gomail.Message) (string, error) {
+	id, err := s.mockSender.Send(ctx, m)
+	s.cancel()
+	return id, err
+}
+
+func TestSentEmailBatchFlushedAfterCancel(t *testing.T) {
+	db := newTestDB(t)
+	for i := 0; i < 3; i++ {
+		if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES (?, '')", fmt.Sprintf("sub%d@example.com", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("INSERT INTO articles (title, content) VALUES ('Hello', '')"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sender := cancellingSender{newMockSender(""), cancel}
+	sendNewsletterForArticle(ctx, db, sender, 1)
+
+	sent := len(sender.Messages())
+	if sent == 0 {
+		t.Fatal("nothing sent")
+	}
+	if n := countSentEmails(t, db, 1); n != sent {
+		t.Fatalf("%d sent_emails rows for %d messages sent", n, sent)
+	}
+}
+
+func TestClaimRecipient(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	if claimed, err := claimRecipient(ctx, db, 1, 1); err != nil || !claimed {
+		t.Fatalf("first claim = %v, %v", claimed, err)
+	}
+	if claimed, err := claimRecipient(ctx, db, 1, 1); err != nil || claimed {
+		t.Fatalf("second claim = %v, %v", claimed, err)
+	}
+	releaseRecipient(ctx, db, 1, 1)
+	if claimed, err := claimRecipient(ctx, db, 1, 1); err != nil || !claimed {
+		t.Fatalf("claim after release = %v, %v", claimed, err)
+	}
+	// A send whose message went out is not given back.
+	markEmailSent(ctx, db, 1, 1, "<m1@example.com>", "p1")
+	releaseRecipient(ctx, db, 1, 1)
+	if n := countSentEmails(t, db, 1); n != 1 {
+		t.Fatalf("%d sent_emails rows after releasing a sent message, want 1", n)
+	}
+}
+
+func TestConcurrentSendsEmailEachSubscriberOnce(t *testing.T) {
+	db := newTestDB(t)
+	sender := newMockSender("")
+	for i := 0; i < 10; i++ {
+		if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES (?, '')", fmt.Sprintf("sub%d@example.com", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("INSERT INTO articles (title, content) VALUES ('Hello', '')"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		goSendNewsletter(context.Background(), db, sender, 1)
+	}
+	activeSends.Wait()
+
+	if n := len(sender.Messages()); n != 10 {
+		t.Fatalf("sent %d messages to 10 subscribers", n)
+	}
+}
+
+func TestMigrateSentEmailsUniqueKeepsFirstSend(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := db.Exec("DROP INDEX idx_sent_emails_subscriber_article"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO sent_emails (subscriber_id, article_id, message_id) VALUES (1, 1, 'first'), (1, 1, 'second'), (2, 1, 'other')"); err != nil {
+		t.Fatal(err)
+	}
+
+	migrateSentEmailsUnique(db)
+
+	var messageID string
+	if err := db.QueryRow("SELECT message_id FROM sent_emails WHERE subscriber_id = 1").Scan(&messageID); err != nil || messageID != "first" {
+		t.Fatalf("kept %q, %v; want the first send", messageID, err)
+	}
+	if n := countSentEmails(t, db, 1); n != 2 {
+		t.Fatalf("%d sent_emails rows, want 2", n)
+	}
+	if _, err := db.Exec("INSERT INTO sent_emails (subscriber_id, article_id) VALUES (2, 1)"); err == nil {
+		t.Fatal("duplicate send recorded after migration")
+	}
+}
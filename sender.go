@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+// EmailSender delivers a fully built message and returns the identifier the
+// provider assigned to it, which delivery webhooks refer to.
+type EmailSender interface {
+	Send(ctx context.Context, m *gomail.Message) (string, error)
+}
+
+// newEmailSender returns the sender selected by EMAIL_PROVIDER: "smtp"
+// (the default) or "mock", wrapped according to SEND_MODE. When
+// EMAIL_PROVIDERS is set, messages are routed across the providers it
+// lists instead.
+func newEmailSender(db *sql.DB) (EmailSender, error) {
+	var sender EmailSender
+	if list := os.Getenv("EMAIL_PROVIDERS"); list != "" {
+		backends, err := parseEmailProviders(list)
+		if err != nil {
+			return nil, err
+		}
+		return applySendMode(newRoutingSender(db, backends))
+	}
+	switch provider := os.Getenv("EMAIL_PROVIDER"); provider {
+	case "", "smtp":
+		sender = newSMTPSender()
+	case "mock":
+		log.Println("Using mock email sender, no emails will be delivered")
+		sender = newMockSender(os.Getenv("MOCK_EMAIL_DIR"))
+	default:
+		return nil, fmt.Errorf("unknown EMAIL_PROVIDER %q", provider)
+	}
+	return applySendMode(sender)
+}
+
+type smtpSender struct {
+	dialer *gomail.Dialer
+}
+
+// isDevMode reports whether ENV=dev, which points the SMTP sender at a local
+// MailHog or smtp4dev instance.
+func isDevMode() bool {
+	return os.Getenv("ENV") == "dev"
+}
+
+// newSMTPSender builds a sender from SMTP_HOST, SMTP_PORT (default 587),
+// SMTP_USERNAME and SMTP_PASSWORD. In dev mode the host and port default to
+// MailHog's localhost:1025 and certificate verification is skipped.
+func newSMTPSender() *smtpSender {
+	return newSMTPSenderFrom("SMTP_")
+}
+
+// newSMTPSenderFrom is newSMTPSender reading settings named with prefix,
+// e.g. PROVIDER_RELAY_SMTP_HOST.
+func newSMTPSenderFrom(prefix string) *smtpSender {
+	host, port := os.Getenv(prefix+"HOST"), getEnvInt(prefix+"PORT", 587)
+	if isDevMode() {
+		if host == "" {
+			host = "localhost"
+		}
+		port = getEnvInt(prefix+"PORT", 1025)
+	}
+
+	d := gomail.NewDialer(host, port, credential(prefix+"USERNAME"), credential(prefix+"PASSWORD"))
+	if isDevMode() {
+		d.TLSConfig = &tls.Config{ServerName: host, InsecureSkipVerify: true}
+		log.Printf("Dev mode: sending through %s:%d without certificate verification", host, port)
+	}
+	return &smtpSender{dialer: d}
+}
+
+// Send relays m over SMTP. Relays report delivery events against the
+// Message-ID header, so that is used as the provider message ID.
+func (s *smtpSender) Send(ctx context.Context, m *gomail.Message) (string, error) {
+	if err := s.dialer.DialAndSend(m); err != nil {
+		return "", err
+	}
+	return messageIDOf(m), nil
+}
+
+// mockSender records messages in memory instead of delivering them. When dir
+// is set each message is also written there as an .eml file.
+type mockSender struct {
+	dir string
+
+	mu       sync.Mutex
+	messages []*gomail.Message
+}
+
+func newMockSender(dir string) *mockSender {
+	return &mockSender{dir: dir}
+}
+
+func (s *mockSender) Send(ctx context.Context, m *gomail.Message) (string, error) {
+	s.mu.Lock()
+	s.messages = append(s.messages, m)
+	n := len(s.messages)
+	s.mu.Unlock()
+
+	if s.dir != "" {
+		if err := writeEML(s.dir, n, m); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("mock-%d", n), nil
+}
+
+// captureSender writes each message to dir as an .eml file and delivers
+// nothing. Unlike mockSender it keeps no messages in memory, so it can
+// capture a send to the whole list.
+type captureSender struct {
+	dir string
+
+	mu sync.Mutex
+	n  int
+}
+
+func newCaptureSender(dir string) *captureSender {
+	return &captureSender{dir: dir}
+}
+
+func (s *captureSender) Send(ctx context.Context, m *gomail.Message) (string, error) {
+	s.mu.Lock()
+	s.n++
+	n := s.n
+	s.mu.Unlock()
+
+	if err := writeEML(s.dir, n, m); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("capture-%d", n), nil
+}
+
+// writeEML writes m to dir as the nth message of the run.
+func writeEML(dir string, n int, m *gomail.Message) error {
+	name := fmt.Sprintf("%s-%04d.eml", time.Now().UTC().Format("20060102T150405"), n)
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = m.WriteTo(f)
+	return err
+}
+
+// messageIDOf returns m's Message-ID header, or "" if it has none.
+func messageIDOf(m *gomail.Message) string {
+	if ids := m.GetHeader("Message-ID"); len(ids) > 0 {
+		return ids[0]
+	}
+	return ""
+}
+
+// Messages returns a copy of the messages sent so far.
+func (s *mockSender) Messages() []*gomail.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*gomail.Message(nil), s.messages...)
+}
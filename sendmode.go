@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"os"
+
+	"gopkg.in/gomail.v2"
+)
+
+// applySendMode wraps sender according to SEND_MODE, so a staging copy of
+// the production database cannot email real subscribers:
+//
+//   - live (the default) delivers normally.
+//   - capture writes every message as an .eml file to SEND_CAPTURE_DIR
+//     (default "captured") and delivers nothing.
+//   - redirect delivers every message to SEND_REDIRECT_TO instead of its
+//     recipients.
+func applySendMode(sender EmailSender) (EmailSender, error) {
+	switch mode := os.Getenv("SEND_MODE"); mode {
+	case "", "live":
+		return sender, nil
+	case "capture":
+		dir := os.Getenv("SEND_CAPTURE_DIR")
+		if dir == "" {
+			dir = "captured"
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("creating SEND_CAPTURE_DIR: %w", err)
+		}
+		log.Printf("SEND_MODE=capture: writing emails to %s, none will be delivered", dir)
+		return newCaptureSender(dir), nil
+	case "redirect":
+		to := os.Getenv("SEND_REDIRECT_TO")
+		if _, err := mail.ParseAddress(to); err != nil {
+			return nil, errors.New("SEND_MODE=redirect requires a valid SEND_REDIRECT_TO address")
+		}
+		log.Printf("SEND_MODE=redirect: delivering all emails to %s", to)
+		return &redirectSender{next: sender, to: to}, nil
+	default:
+		return nil, fmt.Errorf("unknown SEND_MODE %q", mode)
+	}
+}
+
+// liveSendMode reports whether SEND_MODE delivers to real recipients.
+// Channels that cannot be captured or redirected, like push and Telegram,
+// only deliver in live mode.
+func liveSendMode() bool {
+	mode := os.Getenv("SEND_MODE")
+	return mode == "" || mode == "live"
+}
+
+// redirectSender delivers every message to one override address. The
+// original recipients are kept in X-Original-To for inspection.
+type redirectSender struct {
+	next EmailSender
+	to   string
+}
+
+func (s *redirectSender) Send(ctx context.Context, m *gomail.Message) (string, error) {
+	var original []string
+	for _, field := range []string{"To", "Cc", "Bcc"} {
+		original = append(original, m.GetHeader(field)...)
+		m.SetHeader(field)
+	}
+	m.SetHeader("X-Original-To", original...)
+	m.SetHeader("To", s.to)
+	return s.next.Send(ctx, m)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/gomail.v2"
+)
+
+func TestSendModeRedirect(t *testing.T) {
+	t.Setenv("SEND_MODE", "redirect")
+	t.Setenv("SEND_REDIRECT_TO", "")
+	if _, err := applySendMode(newMockSender("")); err == nil {
+		t.Fatal("redirect without SEND_REDIRECT_TO accepted")
+	}
+
+	t.Setenv("SEND_REDIRECT_TO", "qa@example.com")
+	mock := newMockSender("")
+	sender, err := applySendMode(mock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := gomail.NewMessage()
+	m.SetHeader("To", "ada@example.com")
+	m.SetHeader("Cc", "grace@example.com")
+	m.SetBody("text/plain", "hi")
+	if _, err := sender.Send(context.Background(), m); err != nil {
+		t.Fatal(err)
+	}
+	sent := mock.Messages()[0]
+	if to := sent.GetHeader("To"); len(to) != 1 || to[0] != "qa@example.com" {
+		t.Errorf("To = %v", to)
+	}
+	if cc := sent.GetHeader("Cc"); len(cc) != 0 {
+		t.Errorf("Cc = %v", cc)
+	}
+	if orig := sent.GetHeader("X-Original-To"); len(orig) != 2 {
+		t.Errorf("X-Original-To = %v", orig)
+	}
+}
+
+func TestSendModeCapture(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("SEND_MODE", "capture")
+	t.Setenv("SEND_CAPTURE_DIR", dir)
+	sender, err := applySendMode(newSMTPSender())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := gomail.NewMessage()
+	m.SetHeader("To", "ada@example.com")
+	m.SetBody("text/plain", "hi")
+	if _, err := sender.Send(context.Background(), m); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("captured %d files", len(files))
+	}
+	// Capture runs against whole lists, so messages must not pile up in
+	// memory the way they do in the test mock.
+	if _, ok := sender.(*captureSender); !ok {
+		t.Errorf("capture mode uses %T", sender)
+	}
+
+	t.Setenv("SEND_MODE", "dry-run")
+	if _, err := applySendMode(newMockSender("")); err == nil {
+		t.Fatal("unknown SEND_MODE accepted")
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// archivedSentEmail is one line of a sent_emails archive file.
+type archivedSentEmail struct {
+	SentEmail
+	OpenedAt   string `json:"opened_at,omitempty"`
+	OpenCount  int    `json:"open_count"`
+	ClickedAt  string `json:"clicked_at,omitempty"`
+	ClickCount int    `json:"click_count"`
+}
+
+// sentEmailArchiveDir returns where archive files are written
+// (SENT_EMAILS_ARCHIVE_DIR, default an "archive" directory next to the
+// database, or in the working directory for a remote database).
+func sentEmailArchiveDir() string {
+	if dir := os.Getenv("SENT_EMAILS_ARCHIVE_DIR"); dir != "" {
+		return dir
+	}
+	if isRemoteDB(databasePath()) {
+		return "archive"
+	}
+	return filepath.Join(filepath.Dir(databasePath()), "archive")
+}
+
+// archiveSentEmails moves sends older than cutoff out of sent_emails: the
+// rows are written to a gzipped NDJSON file, their counts are added to
+// sent_email_summaries, and then they are deleted. Articles with archived
+// sends cannot be sent again, since the rows that prevent duplicate sends
+// are gone.
+func archiveSentEmails(db *sql.DB, cutoff time.Time) (int64, error) {
+	before := cutoff.Format(sqliteTimeFormat)
+	var maxID sql.NullInt64
+	if err := db.QueryRow("SELECT MAX(id) FROM sent_emails WHERE sent_at < ?", before).Scan(&maxID); err != nil {
+		return 0, err
+	}
+	if !maxID.Valid {
+		return 0, nil
+	}
+
+	if err := writeSentEmailArchive(db, before, maxID.Int64); err != nil {
+		return 0, fmt.Errorf("writing sent_emails archive: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`
+		INSERT INTO sent_email_summaries (article_id, sent, imported, opened, clicked, bounced, cost)
+		SELECT article_id, COUNT(*),
+			COUNT(*) FILTER (WHERE delivery_status = ?),
+			COUNT(opened_at), COUNT(clicked_at),
+			COUNT(*) FILTER (WHERE delivery_status IN (?, ?)),
+			SUM(cost)
+		FROM sent_emails
+		WHERE sent_at < ? AND id <= ?
+		GROUP BY article_id
+		ON CONFLICT (article_id) DO UPDATE SET
+			sent = sent + excluded.sent,
+			imported = imported + excluded.imported,
+			opened = opened + excluded.opened,
+			clicked = clicked + excluded.clicked,
+			bounced = bounced + excluded.bounced,
+			cost = cost + excluded.cost,
+			updated_at = CURRENT_TIMESTAMP`,
+		deliveryImported, deliveryBounced, deliveryDropped, before, maxID.Int64)
+	if err != nil {
+		return 0, fmt.Errorf("summarizing sent_emails: %w", err)
+	}
+	result, err := tx.Exec("DELETE FROM sent_emails WHERE sent_at < ? AND id <= ?", before, maxID.Int64)
+	if err != nil {
+		return 0, fmt.Errorf("deleting archived sent_emails: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func writeSentEmailArchive(db *sql.DB, before string, maxID int64) error {
+	dir := sentEmailArchiveDir()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	name := filepath.Join(dir, fmt.Sprintf("sent_emails-%s.ndjson.gz", time.Now().UTC().Format("20060102T150405")))
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rows, err := db.Query(`
+		SELECT `+sentEmailColumns+`, COALESCE(opened_at, ''), open_count, COALESCE(clicked_at, ''), click_count
+		FROM sent_emails
+		WHERE sent_at < ? AND id <= ?
+		ORDER BY id`, before, maxID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	zw := gzip.NewWriter(f)
+	enc := json.NewEncoder(zw)
+	for rows.Next() {
+		var a archivedSentEmail
+		se := &a.SentEmail
+		if err := rows.Scan(&se.ID, &se.SubscriberID, &se.ArticleID, scanTime(&se.SentAt), &se.MessageID, &se.ProviderMessageID, &se.DeliveryStatus,
+			&a.OpenedAt, &a.OpenCount, &a.ClickedAt, &a.ClickCount); err != nil {
+			return err
+		}
+		if err := enc.Encode(a); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// sendHistoryArchived reports whether some of the article's sends have been
+// archived.
+func sendHistoryArchived(ctx context.Context, db *sql.DB, articleID int) (bool, error) {
+	var archived bool
+	err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM sent_email_summaries WHERE article_id = ?)", articleID).Scan(&archived)
+	return archived, err
+}
+
+// archivedSentEmailCount returns how many sends have been archived.
+func archivedSentEmailCount(db *sql.DB) (int, error) {
+	var n int
+	err := db.QueryRow("SELECT COALESCE(SUM(sent), 0) FROM sent_email_summaries").Scan(&n)
+	return n, err
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestArchiveSentEmails(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("SENT_EMAILS_ARCHIVE_DIR", dir)
+	db := newTestDB(t)
+	sender := newMockSender("")
+
+	if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES ('ada@example.com', ''), ('grace@example.com', '')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO articles (title, content) VALUES ('Old', ''), ('New', '')"); err != nil {
+		t.Fatal(err)
+	}
+	sendNewsletterForArticle(context.Background(), db, sender, 1)
+	if _, err := db.Exec("UPDATE sent_emails SET sent_at = '2020-01-01 00:00:00', opened_at = '2020-01-02 00:00:00' WHERE subscriber_id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE sent_emails SET sent_at = '2020-01-01 00:00:00' WHERE subscriber_id = 2"); err != nil {
+		t.Fatal(err)
+	}
+	sendNewsletterForArticle(context.Background(), db, sender, 2)
+
+	n, err := archiveSentEmails(db, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil || n != 2 {
+		t.Fatalf("archived %d, %v", n, err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.ndjson.gz"))
+	if len(files) != 1 {
+		t.Fatalf("%d archive files", len(files))
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := 0
+	for s := bufio.NewScanner(zr); s.Scan(); {
+		lines++
+	}
+	if lines != 2 {
+		t.Fatalf("%d archived lines", lines)
+	}
+
+	var sent, opened int
+	db.QueryRow("SELECT sent, opened FROM sent_email_summaries WHERE article_id = 1").Scan(&sent, &opened)
+	if sent != 2 || opened != 1 {
+		t.Errorf("summary sent=%d opened=%d", sent, opened)
+	}
+	data, err := getAllData(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.SentEmailCount != 2 || data.ArchivedSentEmailCount != 2 {
+		t.Errorf("stats: %d hot, %d archived", data.SentEmailCount, data.ArchivedSentEmailCount)
+	}
+	stats, err := getPublicStats(db)
+	if err != nil || stats.IssuesSent != 2 {
+		t.Errorf("public stats issues = %d, %v", stats.IssuesSent, err)
+	}
+
+	// Resending an archived article would email everyone again.
+	before := len(sender.Messages())
+	sendNewsletterForArticle(context.Background(), db, sender, 1)
+	if len(sender.Messages()) != before {
+		t.Fatal("archived article was sent again")
+	}
+}
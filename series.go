@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"gopkg.in/gomail.v2"
+)
+
+// seriesMessageIDs returns the Message-IDs sub received for earlier issues
+// in article's series, oldest first.
+func seriesMessageIDs(ctx context.Context, db *sql.DB, subscriberID int, article Article) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT se.message_id
+		FROM sent_emails se
+		JOIN articles a ON a.id = se.article_id
+		WHERE se.subscriber_id = ?
+			AND a.series = ?
+			AND a.id < ?
+			AND se.message_id IS NOT NULL AND se.message_id != ''
+		ORDER BY a.id`,
+		subscriberID, article.Series, article.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// setThreadingHeaders makes an issue in a series reply to the previous issue
+// the subscriber received, so mail clients show the series as one thread.
+func setThreadingHeaders(ctx context.Context, db *sql.DB, m *gomail.Message, sub Subscriber, article Article) error {
+	if article.Series == "" {
+		return nil
+	}
+	ids, err := seriesMessageIDs(ctx, db, sub.ID, article)
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	m.SetHeader("In-Reply-To", ids[len(ids)-1])
+	m.SetHeader("References", strings.Join(ids, " "))
+	return nil
+}
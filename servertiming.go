@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Server-Timing metric names.
+const (
+	timingDB     = "db"
+	timingRender = "render"
+)
+
+// requestTimings accumulates where a request spent its time.
+type requestTimings struct {
+	mu      sync.Mutex
+	start   time.Time
+	queue   time.Duration
+	metrics map[string]time.Duration
+	counts  map[string]int
+}
+
+type requestTimingsKey struct{}
+
+func requestTimingsFrom(ctx context.Context) *requestTimings {
+	t, _ := ctx.Value(requestTimingsKey{}).(*requestTimings)
+	return t
+}
+
+// addTiming adds d to the request's metric, if the request is timed.
+func addTiming(ctx context.Context, metric string, d time.Duration) {
+	t := requestTimingsFrom(ctx)
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.metrics[metric] += d
+	t.counts[metric]++
+	t.mu.Unlock()
+}
+
+// header formats the timings as a Server-Timing value. Durations are in
+// milliseconds; app is the time from the request reaching us to the
+// response headers.
+func (t *requestTimings) header() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ms := func(d time.Duration) string {
+		return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 2, 64)
+	}
+	parts := []string{"app;dur=" + ms(time.Since(t.start))}
+	if t.queue > 0 {
+		parts = append(parts, "queue;dur="+ms(t.queue))
+	}
+	for _, name := range []string{timingDB, timingRender} {
+		if n := t.counts[name]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%s;dur=%s;desc=\"%d calls\"", name, ms(t.metrics[name]), n))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// requestQueueTime is how long the request waited between the load
+// balancer and us, from X-Request-Start ("t=" followed by seconds,
+// milliseconds or microseconds since the epoch, as nginx and Heroku send
+// it). It is 0 without the header.
+func requestQueueTime(r *http.Request, now time.Time) time.Duration {
+	v := strings.TrimPrefix(r.Header.Get("X-Request-Start"), "t=")
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f <= 0 {
+		return 0
+	}
+	var at time.Time
+	switch {
+	case f > 1e14:
+		at = time.UnixMicro(int64(f))
+	case f > 1e11:
+		at = time.UnixMilli(int64(f))
+	default:
+		at = time.Unix(0, int64(f*float64(time.Second)))
+	}
+	if d := now.Sub(at); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// timingResponseWriter adds the Server-Timing header just before the
+// response headers are sent. Time spent after that is not reported.
+type timingResponseWriter struct {
+	http.ResponseWriter
+	timings     *requestTimings
+	wroteHeader bool
+}
+
+func (w *timingResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.Header().Set("Server-Timing", w.timings.header())
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *timingResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *timingResponseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *timingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// withServerTiming reports per-request timings in a Server-Timing header
+// when SERVER_TIMING_ENABLED is set: total app time, load balancer queue
+// time, time in traced database queries and template rendering. It is a
+// debugging aid; leave it off in production, since it tells any client
+// how the server spends its time.
+func withServerTiming(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if enabled, _ := strconv.ParseBool(os.Getenv("SERVER_TIMING_ENABLED")); !enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+		now := time.Now()
+		t := &requestTimings{
+			start:   now,
+			queue:   requestQueueTime(r, now),
+			metrics: map[string]time.Duration{},
+			counts:  map[string]int{},
+		}
+		tw := &timingResponseWriter{ResponseWriter: w, timings: t}
+		next.ServeHTTP(tw, r.WithContext(context.WithValue(r.Context(), requestTimingsKey{}, t)))
+	})
+}
+
+// timedSpan adds a database span's duration to the request's db timing.
+type timedSpan struct {
+	trace.Span
+	ctx   context.Context
+	start time.Time
+}
+
+func (s *timedSpan) End(options ...trace.SpanEndOption) {
+	addTiming(s.ctx, timingDB, time.Since(s.start))
+	s.Span.End(options...)
+}
+
+// executeTemplate renders a page template, counting the time as render
+// time.
+func executeTemplate(ctx context.Context, t *template.Template, w io.Writer, data interface{}) error {
+	start := time.Now()
+	defer func() { addTiming(ctx, timingRender, time.Since(start)) }()
+	return t.Execute(w, data)
+}
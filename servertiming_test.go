@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithServerTimingOff(t *testing.T) {
+	h := withServerTiming(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requestTimingsFrom(r.Context()) != nil {
+			t.Error("timings collected while disabled")
+		}
+		w.Write([]byte("ok"))
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := rec.Header().Get("Server-Timing"); got != "" {
+		t.Errorf("Server-Timing = %q, want none", got)
+	}
+}
+
+func TestWithServerTimingReportsMetrics(t *testing.T) {
+	t.Setenv("SERVER_TIMING_ENABLED", "true")
+
+	h := withServerTiming(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := startDBSpan(r.Context(), "test", "SELECT 1")
+		span.End()
+		_, span = startDBSpan(r.Context(), "test", "SELECT 2")
+		span.End()
+		addTiming(r.Context(), timingRender, 5*time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Request-Start", "t="+strconv.FormatInt(time.Now().Add(-time.Second).UnixMicro(), 10))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	got := rec.Header().Get("Server-Timing")
+	for _, want := range []string{"app;dur=", "queue;dur=", `db;dur=`, `desc="2 calls"`, "render;dur=5.00"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Server-Timing = %q, missing %q", got, want)
+		}
+	}
+}
+
+func TestRequestQueueTime(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	for _, tc := range []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"garbage", 0},
+		{"t=1699999999.5", 500 * time.Millisecond},
+		{"t=1699999999750", 250 * time.Millisecond},
+		{"t=1699999999900000", 100 * time.Millisecond},
+		{"t=1700000001", 0},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Request-Start", tc.header)
+		if got := requestQueueTime(req, now); got != tc.want {
+			t.Errorf("requestQueueTime(%q) = %v, want %v", tc.header, got, tc.want)
+		}
+	}
+}
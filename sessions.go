@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const sessionCookieName = "admin_session"
+
+// adminSession is a logged-in admin account's dashboard session.
+type adminSession struct {
+	UserID    int
+	Username  string
+	CSRFToken string
+}
+
+func (s adminSession) principal() principal {
+	perms := map[permission]bool{}
+	for _, p := range rolePermissions["admin"] {
+		perms[p] = true
+	}
+	return principal{Name: "user:" + s.Username, Permissions: perms}
+}
+
+func countAdminUsers(db *sql.DB) (int, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM admin_users").Scan(&n)
+	return n, err
+}
+
+func createAdminUser(db *sql.DB, username, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("INSERT INTO admin_users (username, password_hash) VALUES (?, ?)", username, string(hash))
+	return err
+}
+
+// bootstrapAdminUser creates the account named by ADMIN_USERNAME and
+// ADMIN_PASSWORD if it does not exist yet.
+func bootstrapAdminUser(db *sql.DB) {
+	username, password := os.Getenv("ADMIN_USERNAME"), os.Getenv("ADMIN_PASSWORD")
+	if username == "" || password == "" {
+		return
+	}
+	var exists int
+	err := db.QueryRow("SELECT COUNT(*) FROM admin_users WHERE username = ?", username).Scan(&exists)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if exists > 0 {
+		return
+	}
+	if err := createAdminUser(db, username, password); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Created admin user %s", username)
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkAdminPassword returns the id of the admin account matching the
+// credentials.
+func checkAdminPassword(db *sql.DB, username, password string) (int, error) {
+	var id int
+	var hash string
+	err := db.QueryRow("SELECT id, password_hash FROM admin_users WHERE username = ?", username).Scan(&id, &hash)
+	if err != nil {
+		return 0, err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// createSession starts a session for userID and returns the session token
+// and its CSRF token. Only a hash of the session token is stored.
+func createSession(db *sql.DB, userID int) (string, string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	csrf, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	expires := time.Now().UTC().Add(getEnvDuration("SESSION_TTL", 12*time.Hour))
+	_, err = db.Exec("INSERT INTO admin_sessions (token_hash, user_id, csrf_token, expires_at) VALUES (?, ?, ?, ?)",
+		hashToken(token), userID, csrf, expires.Format(sqliteTimeFormat))
+	if err != nil {
+		return "", "", err
+	}
+	return token, csrf, nil
+}
+
+func sessionFromRequest(db *sql.DB, r *http.Request) (adminSession, error) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return adminSession{}, err
+	}
+	var s adminSession
+	err = db.QueryRow(`
+		SELECT u.id, u.username, s.csrf_token
+		FROM admin_sessions s
+		JOIN admin_users u ON u.id = s.user_id
+		WHERE s.token_hash = ? AND s.expires_at > ?`,
+		hashToken(cookie.Value), time.Now().UTC().Format(sqliteTimeFormat)).Scan(&s.UserID, &s.Username, &s.CSRFToken)
+	if err == sql.ErrNoRows {
+		return adminSession{}, errors.New("session expired or invalid")
+	}
+	return s, err
+}
+
+// validCSRFToken checks the X-CSRF-Token header or csrf_token form field
+// against the session's token.
+func validCSRFToken(s adminSession, r *http.Request) bool {
+	token := r.Header.Get("X-CSRF-Token")
+	if token == "" {
+		token = r.PostFormValue("csrf_token")
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.CSRFToken)) == 1
+}
+
+func handleLogin(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			Code     string `json:"code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		keys := loginKeys(r, req.Username)
+		if tooManyLoginAttempts(w, keys) {
+			return
+		}
+		userID, err := checkAdminPassword(db, req.Username, req.Password)
+		if err != nil {
+			recordLoginFailure(keys, time.Now())
+			http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+			return
+		}
+
+		enabled, err := totpEnabled(db, userID)
+		if err != nil {
+			http.Error(w, "Error logging in", http.StatusInternalServerError)
+			return
+		}
+		if enabled {
+			if req.Code == "" {
+				http.Error(w, "Two-factor code required", http.StatusUnauthorized)
+				return
+			}
+			if err := verifyUserTOTP(db, userID, req.Code); err != nil {
+				recordLoginFailure(keys, time.Now())
+				http.Error(w, "Invalid two-factor code", http.StatusUnauthorized)
+				return
+			}
+		}
+		resetLoginFailures(keys)
+
+		token, csrf, err := createSession(db, userID)
+		if err != nil {
+			log.Printf("Error creating session: %v", err)
+			http.Error(w, "Error logging in", http.StatusInternalServerError)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     sessionCookieName,
+			Value:    token,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"csrf_token": csrf})
+	}
+}
+
+func handleLogout(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		sess, err := sessionFromRequest(db, r)
+		if err == nil && !validCSRFToken(sess, r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if cookie, err := r.Cookie(sessionCookieName); err == nil {
+			if _, err := db.Exec("DELETE FROM admin_sessions WHERE token_hash = ?", hashToken(cookie.Value)); err != nil {
+				log.Printf("Error deleting session: %v", err)
+			}
+		}
+
+		http.SetCookie(w, &http.Cookie{Name: sessionCookieName, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Logged out"))
+	}
+}
+
+// handleGetSession lets the dashboard recover the CSRF token for an
+// existing session cookie.
+func handleGetSession(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sess, err := sessionFromRequest(db, r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"username":   sess.Username,
+			"csrf_token": sess.CSRFToken,
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// shortLinksEnabled reports whether tracked links use /l/{code} short
+// links (SHORT_LINKS_ENABLED) rather than carrying the target URL.
+func shortLinksEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("SHORT_LINKS_ENABLED"))
+	return enabled
+}
+
+// newShortCode returns a random 8-character URL-safe code.
+func newShortCode() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// shortLinkCode returns the code for target, creating it on first use. A
+// URL keeps its code across articles and sends.
+func shortLinkCode(ctx context.Context, db *sql.DB, target string) (string, error) {
+	for {
+		var code string
+		err := db.QueryRowContext(ctx, "SELECT code FROM links WHERE url = ?", target).Scan(&code)
+		if err == nil {
+			return code, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return "", err
+		}
+		if code, err = newShortCode(); err != nil {
+			return "", err
+		}
+		// A concurrent insert of the same URL, or a code collision, leaves
+		// no row; look again.
+		result, err := db.ExecContext(ctx, "INSERT OR IGNORE INTO links (code, url) VALUES (?, ?)", code, target)
+		if err != nil {
+			return "", err
+		}
+		if n, _ := result.RowsAffected(); n == 1 {
+			return code, nil
+		}
+	}
+}
+
+// registerShortLinks creates short links for the URLs in the article's
+// unpersonalized body and returns them keyed by URL. Links that only
+// appear in personalized copies keep the long redirect.
+func registerShortLinks(ctx context.Context, db *sql.DB, article Article) (map[string]string, error) {
+	body, err := renderNewsletterBody(Subscriber{}, article)
+	if err != nil {
+		return nil, err
+	}
+	codes := map[string]string{}
+	for _, u := range extractLinks(body) {
+		if codes[u], err = shortLinkCode(ctx, db, u); err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// shortLinkURL is the tracked short link for code in a subscriber's copy
+// of an article.
+func shortLinkURL(code string, subscriberID, articleID int) string {
+	return publicURL("l/" + code + "/" + trackingToken(subscriberID, articleID, "l:"+code))
+}
+
+// handleShortLink counts a click on a short link and redirects to its
+// URL. With a valid token, as in newsletters, the click is also recorded
+// for the subscriber and article; without one, as when a reader shares
+// the link, it is only counted.
+func handleShortLink(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		code := r.PathValue("code")
+		var target string
+		err := db.QueryRow("UPDATE links SET clicks = clicks + 1 WHERE code = ? RETURNING url", code).Scan(&target)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			log.Printf("Error resolving short link %s: %v", code, err)
+			http.Error(w, "Error resolving link", http.StatusInternalServerError)
+			return
+		}
+		if token := r.PathValue("token"); token != "" {
+			if subscriberID, articleID, ok := parseTrackingToken(token, "l:"+code); ok {
+				recordTrackingHit(db, trackingHit{subscriberID: subscriberID, articleID: articleID, click: true, target: target, at: time.Now()})
+			}
+		}
+		http.Redirect(w, r, target, http.StatusFound)
+	}
+}
+
+// ShortLink is a short link and the clicks it has had across all sends.
+type ShortLink struct {
+	Code      string    `json:"code"`
+	URL       string    `json:"url"`
+	Clicks    int       `json:"clicks"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// handleGetLinks lists short links, most clicked first.
+func handleGetLinks(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		rows, err := db.Query("SELECT code, url, clicks, created_at FROM links ORDER BY clicks DESC, id LIMIT 1000")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		links := []ShortLink{}
+		for rows.Next() {
+			var l ShortLink
+			if err := rows.Scan(&l.Code, &l.URL, &l.Clicks, scanTime(&l.CreatedAt)); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			links = append(links, l)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(links)
+	}
+}
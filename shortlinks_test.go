@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestShortLinks(t *testing.T) {
+	t.Setenv("TRACKING_SECRET", "secret")
+	db := newTestDB(t)
+	srv := newTestServer(t, db, &mockSender{})
+	t.Setenv("PUBLIC_BASE_URL", srv.URL)
+
+	target := srv.URL + "/stats"
+	code, err := shortLinkCode(context.Background(), db, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := shortLinkCode(context.Background(), db, target); err != nil || again != code {
+		t.Fatalf("second code = %q, %v; want %q", again, err, code)
+	}
+
+	body := addTracking(`<a href="`+target+`">Stats</a>`, 1, 1, map[string]string{target: code})
+	if !strings.Contains(body, `href="`+srv.URL+`/l/`+code+`/1.1.`) {
+		t.Fatalf("link not shortened: %s", body)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	for _, path := range []string{"/l/" + code + "/" + trackingToken(1, 1, "l:"+code), "/l/" + code} {
+		resp, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != target {
+			t.Fatalf("GET %s = %d to %q", path, resp.StatusCode, resp.Header.Get("Location"))
+		}
+	}
+
+	var clicks int
+	if err := db.QueryRow("SELECT clicks FROM links WHERE code = ?", code).Scan(&clicks); err != nil {
+		t.Fatal(err)
+	}
+	if clicks != 2 {
+		t.Fatalf("clicks = %d; want 2", clicks)
+	}
+
+	resp, err := client.Get(srv.URL + "/l/unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unknown code = %d; want 404", resp.StatusCode)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// signingKeyID restricts key ids to characters that cannot clash with the
+// token separator.
+var signingKeyID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// signingKey is a secret that signs the links in emails. The key with an
+// empty id is TRACKING_SECRET, whose tokens carry no key id.
+type signingKey struct {
+	id     string
+	secret string
+}
+
+// signingKeys returns the keys that verify links: TRACKING_KEYS, a
+// comma-separated list of id:secret pairs with the signing key first,
+// followed by TRACKING_SECRET. To rotate, put a new key first and keep the
+// old ones until emails signed with them no longer matter.
+func signingKeys() []signingKey {
+	var keys []signingKey
+	for _, entry := range strings.Split(credential("TRACKING_KEYS"), ",") {
+		id, secret, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if ok && signingKeyID.MatchString(id) && secret != "" {
+			keys = append(keys, signingKey{id, secret})
+		}
+	}
+	if secret := credential("TRACKING_SECRET"); secret != "" {
+		keys = append(keys, signingKey{"", secret})
+	}
+	return keys
+}
+
+// activeSigningKey returns the key new links are signed with.
+func activeSigningKey() (signingKey, bool) {
+	keys := signingKeys()
+	if len(keys) == 0 {
+		return signingKey{}, false
+	}
+	return keys[0], true
+}
+
+func findSigningKey(id string) (signingKey, bool) {
+	for _, k := range signingKeys() {
+		if k.id == id {
+			return k, true
+		}
+	}
+	return signingKey{}, false
+}
+
+// checkSigningKeys reports malformed TRACKING_KEYS entries, which
+// signingKeys skips, so a typo does not silently break links.
+func checkSigningKeys() error {
+	return validateSigningKeys(credential("TRACKING_KEYS"))
+}
+
+func validateSigningKeys(keys string) error {
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(keys, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, secret, ok := strings.Cut(entry, ":")
+		if !ok || !signingKeyID.MatchString(id) || secret == "" {
+			return fmt.Errorf("TRACKING_KEYS: entries must be id:secret with an id of letters, digits, - or _")
+		}
+		if seen[id] {
+			return fmt.Errorf("TRACKING_KEYS: duplicate key id %q", id)
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+// envSecrets splits a comma-separated list of secrets, so a webhook secret
+// can be rotated by accepting the old and new values for a while.
+func envSecrets(name string) []string {
+	var secrets []string
+	for _, s := range strings.Split(credential(name), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			secrets = append(secrets, s)
+		}
+	}
+	return secrets
+}
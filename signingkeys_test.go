@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSigningKeyRotation(t *testing.T) {
+	t.Setenv("TRACKING_SECRET", "old")
+	legacy := trackingToken(1, 2, "unsubscribe")
+
+	// A new key signs new links; links signed before keep working.
+	t.Setenv("TRACKING_KEYS", "k2:new")
+	token := trackingToken(1, 2, "unsubscribe")
+	if !strings.HasPrefix(token, "1.2.k2.") {
+		t.Fatalf("token %q is not signed with k2", token)
+	}
+	for _, tok := range []string{legacy, token} {
+		if sub, art, ok := parseTrackingToken(tok, "unsubscribe"); !ok || sub != 1 || art != 2 {
+			t.Errorf("parseTrackingToken(%q) = %d, %d, %v", tok, sub, art, ok)
+		}
+	}
+
+	// Claiming a different key does not carry the signature over.
+	forged := strings.Replace(token, ".k2.", ".k1.", 1)
+	if _, _, ok := parseTrackingToken(forged, "unsubscribe"); ok {
+		t.Error("token with an unknown key id accepted")
+	}
+
+	// Retiring the old secret invalidates its links only.
+	t.Setenv("TRACKING_SECRET", "")
+	if _, _, ok := parseTrackingToken(legacy, "unsubscribe"); ok {
+		t.Error("token signed with a retired key accepted")
+	}
+	if _, _, ok := parseTrackingToken(token, "unsubscribe"); !ok {
+		t.Error("token signed with the current key rejected")
+	}
+}
+
+func TestCheckSigningKeys(t *testing.T) {
+	for keys, valid := range map[string]bool{
+		"":               true,
+		"k2:new, k1:old": true,
+		"k2":             false,
+		"k.2:new":        false,
+		"k1:a,k1:b":      false,
+		"k1:":            false,
+	} {
+		t.Setenv("TRACKING_KEYS", keys)
+		if err := checkSigningKeys(); (err == nil) != valid {
+			t.Errorf("checkSigningKeys(%q) = %v", keys, err)
+		}
+	}
+}
+
+func TestWebhookTokenRotation(t *testing.T) {
+	t.Setenv("DELIVERY_WEBHOOK_SECRET", "new, old")
+	for token, valid := range map[string]bool{"new": true, "old": true, "other": false, "": false} {
+		r := httptest.NewRequest("POST", "/api/webhooks/delivery?token="+token, nil)
+		if _, err := verifyWebhookToken(r, "DELIVERY_WEBHOOK_SECRET"); (err == nil) != valid {
+			t.Errorf("token %q: %v", token, err)
+		}
+	}
+}
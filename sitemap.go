@@ -0,0 +1,143 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSitemapURLs is the most URLs one sitemap file may list.
+const maxSitemapURLs = 50000
+
+// searchIndexingEnabled reports whether search engines are invited to
+// index the hosted pages (SEARCH_INDEXING_ENABLED, default true). When it
+// is off robots.txt disallows everything, there is no sitemap and the
+// pages are sent with X-Robots-Tag: noindex, so already indexed pages drop
+// out of results.
+func searchIndexingEnabled() bool {
+	enabled, err := strconv.ParseBool(os.Getenv("SEARCH_INDEXING_ENABLED"))
+	return err != nil || enabled
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// sitemapURLs lists the hosted article pages, newest first, and the
+// public stats page when it is enabled.
+func sitemapURLs(db *sql.DB, now time.Time) ([]sitemapURL, error) {
+	rows, err := db.Query("SELECT "+articleColumns+" FROM articles WHERE deleted_at IS NULL AND premium = 0 ORDER BY id DESC LIMIT ?", maxSitemapURLs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var urls []sitemapURL
+	if enabled, _ := strconv.ParseBool(os.Getenv("PUBLIC_STATS_ENABLED")); enabled {
+		urls = append(urls, sitemapURL{Loc: publicURL("stats")})
+	}
+	for rows.Next() {
+		a, err := scanArticle(rows)
+		if err != nil {
+			return nil, err
+		}
+		if !articleIsPublic(a, now) {
+			continue
+		}
+		modified := a.PublishedAt
+		if s := a.scheduledTime(); s.After(modified) {
+			modified = s
+		}
+		u := sitemapURL{Loc: articleURL(a)}
+		if !modified.IsZero() {
+			u.LastMod = modified.UTC().Format(time.RFC3339)
+		}
+		urls = append(urls, u)
+	}
+	return urls, rows.Err()
+}
+
+// handleSitemap serves /sitemap.xml. It needs PUBLIC_BASE_URL, as sitemap
+// URLs must be absolute.
+func handleSitemap(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !searchIndexingEnabled() || publicURL("") == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		urls, err := sitemapURLs(db, time.Now())
+		if err != nil {
+			log.Printf("Error building sitemap: %v", err)
+			http.Error(w, "Error building sitemap", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		w.Write([]byte(xml.Header))
+		if err := xml.NewEncoder(w).Encode(sitemapURLSet{URLs: urls}); err != nil {
+			log.Printf("Error writing sitemap: %v", err)
+		}
+	}
+}
+
+// robotsDisallowed are the per-subscriber and private paths crawlers
+// should never follow.
+var robotsDisallowed = []string{"/api/", "/admin/", "/debug/", "/u/", "/preferences/", "/p/", "/f/", "/t/", "/l/"}
+
+// defaultRobotsTxt allows the hosted pages and points at the sitemap, or
+// disallows everything when search indexing is off.
+func defaultRobotsTxt() string {
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	if !searchIndexingEnabled() {
+		b.WriteString("Disallow: /\n")
+		return b.String()
+	}
+	for _, p := range robotsDisallowed {
+		b.WriteString("Disallow: " + p + "\n")
+	}
+	if sitemap := publicURL("sitemap.xml"); sitemap != "" {
+		b.WriteString("\nSitemap: " + sitemap + "\n")
+	}
+	return b.String()
+}
+
+// handleRobotsTxt serves /robots.txt: the contents of ROBOTS_TXT_FILE if
+// set, otherwise defaultRobotsTxt.
+func handleRobotsTxt() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body := defaultRobotsTxt()
+		if path := os.Getenv("ROBOTS_TXT_FILE"); path != "" {
+			b, err := os.ReadFile(path)
+			if err != nil {
+				log.Printf("Error reading robots.txt: %v", err)
+				http.Error(w, "Error reading robots.txt", http.StatusInternalServerError)
+				return
+			}
+			body = string(b)
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		w.Write([]byte(body))
+	}
+}
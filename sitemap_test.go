@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSitemap(t *testing.T) {
+	db := newTestDB(t)
+	srv := newTestServer(t, db, &mockSender{})
+
+	if status, _ := getBody(t, srv.URL+"/sitemap.xml"); status != http.StatusNotFound {
+		t.Errorf("sitemap without PUBLIC_BASE_URL: status %d, want 404", status)
+	}
+
+	t.Setenv("PUBLIC_BASE_URL", "https://blog.example.com")
+	postJSON(t, srv.URL+"/api/publish", `{"title":"Public","content":"Hi","scheduled_at":"2000-01-01T00:00:00Z"}`)
+	postJSON(t, srv.URL+"/api/publish", `{"title":"Paid","content":"Secret","premium":true,"scheduled_at":"2000-01-01T00:00:00Z"}`)
+	postJSON(t, srv.URL+"/api/publish", `{"title":"Later","content":"Soon","scheduled_at":"2999-01-01T00:00:00Z"}`)
+
+	status, body := getBody(t, srv.URL+"/sitemap.xml")
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %s", status, body)
+	}
+	if !strings.Contains(body, "<loc>https://blog.example.com/articles/1-public</loc>") {
+		t.Errorf("sitemap missing the public article:\n%s", body)
+	}
+	for _, hidden := range []string{"paid", "later"} {
+		if strings.Contains(body, hidden) {
+			t.Errorf("sitemap lists %q:\n%s", hidden, body)
+		}
+	}
+
+	t.Setenv("SEARCH_INDEXING_ENABLED", "false")
+	if status, _ := getBody(t, srv.URL+"/sitemap.xml"); status != http.StatusNotFound {
+		t.Errorf("sitemap with indexing off: status %d, want 404", status)
+	}
+}
+
+func TestRobotsTxt(t *testing.T) {
+	t.Setenv("PUBLIC_BASE_URL", "https://blog.example.com")
+	db := newTestDB(t)
+	srv := newTestServer(t, db, &mockSender{})
+
+	_, body := getBody(t, srv.URL+"/robots.txt")
+	for _, want := range []string{"Disallow: /u/\n", "Disallow: /api/\n", "Sitemap: https://blog.example.com/sitemap.xml\n"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("robots.txt missing %q:\n%s", want, body)
+		}
+	}
+
+	t.Setenv("SEARCH_INDEXING_ENABLED", "false")
+	if _, body := getBody(t, srv.URL+"/robots.txt"); body != "User-agent: *\nDisallow: /\n" {
+		t.Errorf("robots.txt with indexing off = %q", body)
+	}
+
+	path := filepath.Join(t.TempDir(), "robots.txt")
+	os.WriteFile(path, []byte("User-agent: *\nAllow: /\n"), 0o644)
+	t.Setenv("ROBOTS_TXT_FILE", path)
+	if _, body := getBody(t, srv.URL+"/robots.txt"); body != "User-agent: *\nAllow: /\n" {
+		t.Errorf("robots.txt from file = %q", body)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"html/template"
+	"os"
+	"strconv"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+// maxSnoozeWeeks caps how long a subscriber may pause emails.
+const maxSnoozeWeeks = 52
+
+// snoozeCatchUpEnabled reports whether subscribers get a digest of what
+// they missed when a snooze ends (SNOOZE_CATCHUP_ENABLED).
+func snoozeCatchUpEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("SNOOZE_CATCHUP_ENABLED"))
+	return enabled
+}
+
+// setSnooze pauses a subscriber's emails for weeks from now, or resumes
+// them when weeks is 0.
+func setSnooze(db *sql.DB, subscriberID, weeks int, now time.Time) error {
+	if weeks < 0 || weeks > maxSnoozeWeeks {
+		return fmt.Errorf("snooze must be between 0 and %d weeks", maxSnoozeWeeks)
+	}
+	var from, until interface{}
+	if weeks > 0 {
+		from = now.UTC().Format(sqliteTimeFormat)
+		until = now.UTC().AddDate(0, 0, 7*weeks).Format(sqliteTimeFormat)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("UPDATE subscribers SET snoozed_at = ?, snoozed_until = ? WHERE id = ?", from, until, subscriberID); err != nil {
+		return err
+	}
+	recordEvent(tx, subscriberID, eventUpdated, 0, eventDetail(map[string]int{"snooze_weeks": weeks}))
+	return tx.Commit()
+}
+
+// missedArticles returns the articles published while a subscriber was
+// snoozed that they would have received, oldest first.
+func missedArticles(ctx context.Context, db *sql.DB, sub Subscriber, from, until string) ([]Article, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, title FROM articles
+		WHERE deleted_at IS NULL
+			AND published_at BETWEEN ? AND ?
+			AND (premium = 0 OR ? = ?)
+			AND NOT EXISTS (SELECT 1 FROM sent_emails WHERE subscriber_id = ? AND article_id = articles.id)
+		ORDER BY published_at, id`,
+		from, until, sub.Tier, tierPremium, sub.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var articles []Article
+	for rows.Next() {
+		var a Article
+		if err := rows.Scan(&a.ID, &a.Title); err != nil {
+			return nil, err
+		}
+		articles = append(articles, a)
+	}
+	return articles, rows.Err()
+}
+
+// catchUpTemplate lists the articles a snoozed subscriber missed.
+var catchUpTemplate = template.Must(template.New("catchup").Parse(`<!DOCTYPE html>
+<html>
+<body>
+<p>{{if .Name}}Hi {{.Name}}, welcome{{else}}Welcome{{end}} back! Here is what you missed while your emails were paused:</p>
+<ul>
+{{- range .Articles}}
+<li>{{if .URL}}<a href="{{.URL}}">{{.Title}}</a>{{else}}{{.Title}}{{end}}</li>
+{{- end}}
+</ul>
+</body>
+</html>
+`))
+
+// buildCatchUpMessage renders the catch-up digest for a subscriber whose
+// snooze ended.
+func buildCatchUpMessage(sub Subscriber, articles []Article) (*gomail.Message, error) {
+	type item struct{ Title, URL string }
+	items := make([]item, len(articles))
+	for i, a := range articles {
+		items[i] = item{a.Title, articleURL(a)}
+	}
+	var body bytes.Buffer
+	if err := catchUpTemplate.Execute(&body, map[string]interface{}{"Name": sub.Name, "Articles": items}); err != nil {
+		return nil, err
+	}
+	footer, err := renderFooter(sub.ID, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	m := gomail.NewMessage()
+	setFromHeader(m, "")
+	m.SetAddressHeader("To", deliveryAddress(sub.Email), "")
+	m.SetHeader("Subject", fmt.Sprintf("What you missed: %d new posts", len(articles)))
+	if err := setBulkHeaders(m); err != nil {
+		return nil, err
+	}
+	if u := unsubscribeURL(sub.ID, 0); u != "" {
+		m.SetHeader("List-Unsubscribe", "<"+u+">")
+		m.SetHeader("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
+	m.SetBody("text/html", injectFooter(body.String(), footer))
+	return m, nil
+}
+
+// endSnoozes resumes subscribers whose snooze has expired, first sending
+// the catch-up digest if SNOOZE_CATCHUP_ENABLED is set. A failed digest is
+// logged and not retried.
+func endSnoozes(ctx context.Context, db *sql.DB, sender EmailSender, now time.Time) error {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, email, name, tier, strftime('%Y-%m-%d %H:%M:%S', snoozed_at), strftime('%Y-%m-%d %H:%M:%S', snoozed_until)
+		FROM subscribers
+		WHERE snoozed_until <= ? AND unsubscribed_at IS NULL AND deleted_at IS NULL`,
+		now.UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		return err
+	}
+	type snoozed struct {
+		sub         Subscriber
+		from, until string
+	}
+	var expired []snoozed
+	for rows.Next() {
+		var s snoozed
+		if err := rows.Scan(&s.sub.ID, &s.sub.Email, &s.sub.Name, &s.sub.Tier, &s.from, &s.until); err != nil {
+			rows.Close()
+			return err
+		}
+		expired = append(expired, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, s := range expired {
+		if snoozeCatchUpEnabled() {
+			if err := sendCatchUp(ctx, db, sender, s.sub, s.from, s.until); err != nil {
+				jobLogf(ctx, "Error sending catch-up digest to subscriber %d: %v", s.sub.ID, err)
+			}
+		}
+		if _, err := db.ExecContext(ctx, "UPDATE subscribers SET snoozed_at = NULL, snoozed_until = NULL WHERE id = ?", s.sub.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sendCatchUp(ctx context.Context, db *sql.DB, sender EmailSender, sub Subscriber, from, until string) error {
+	articles, err := missedArticles(ctx, db, sub, from, until)
+	if err != nil || len(articles) == 0 {
+		return err
+	}
+	m, err := buildCatchUpMessage(sub, articles)
+	if err != nil {
+		return err
+	}
+	_, _, err = sendWithRetry(ctx, sender, m)
+	return err
+}
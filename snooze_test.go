@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnooze(t *testing.T) {
+	t.Setenv("SNOOZE_CATCHUP_ENABLED", "true")
+	db := newTestDB(t)
+	sender := newMockSender("")
+
+	if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES ('ada@example.com', 'Ada'), ('grace@example.com', 'Grace')"); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if err := setSnooze(db, 1, 2, now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO articles (title, content) VALUES ('Hello', '')"); err != nil {
+		t.Fatal(err)
+	}
+	sendNewsletterForArticle(context.Background(), db, sender, 1)
+	if msgs := sender.Messages(); len(msgs) != 1 || msgs[0].GetHeader("To")[0] != "grace@example.com" {
+		t.Fatalf("snoozed subscriber was sent the newsletter: %d emails", len(msgs))
+	}
+
+	// Nothing happens before the snooze ends.
+	if err := endSnoozes(context.Background(), db, sender, now); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(sender.Messages()); n != 1 {
+		t.Fatalf("catch-up sent early: %d emails", n)
+	}
+
+	if err := endSnoozes(context.Background(), db, sender, now.AddDate(0, 0, 15)); err != nil {
+		t.Fatal(err)
+	}
+	msgs := sender.Messages()
+	if len(msgs) != 2 || msgs[1].GetHeader("To")[0] != "ada@example.com" {
+		t.Fatalf("no catch-up digest for Ada: %d emails", len(msgs))
+	}
+	var b strings.Builder
+	msgs[1].WriteTo(&b)
+	if !strings.Contains(b.String(), "Hello") {
+		t.Fatalf("catch-up digest does not list the missed article:\n%s", b.String())
+	}
+
+	var snoozed bool
+	if err := db.QueryRow("SELECT snoozed_until IS NOT NULL FROM subscribers WHERE id = 1").Scan(&snoozed); err != nil {
+		t.Fatal(err)
+	}
+	if snoozed {
+		t.Fatal("snooze not cleared")
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// softDeletable names the tables that support soft-delete, keyed by the
+// audit target type.
+var softDeletable = map[string]string{
+	"subscriber": "subscribers",
+	"article":    "articles",
+}
+
+// setDeleted marks a row deleted or restores it. It reports false if no
+// row with that id is in the opposite state.
+func setDeleted(db *sql.DB, table string, id int, deleted bool) (bool, error) {
+	query := "UPDATE " + table + " SET deleted_at = CURRENT_TIMESTAMP WHERE id = ? AND deleted_at IS NULL"
+	if !deleted {
+		query = "UPDATE " + table + " SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL"
+	}
+	result, err := db.Exec(query, id)
+	if err != nil {
+		return false, err
+	}
+	switch table {
+	case "articles":
+		invalidateArticle(db, id)
+	case "subscribers":
+		invalidateSubscriberCount(db)
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// handleSoftDelete deletes (DELETE) or, under the restore path, restores
+// (POST) a subscriber or article. Deleted rows are hidden everywhere and
+// purged after RETENTION_DELETED_MONTHS.
+func handleSoftDelete(db *sql.DB, targetType string, restore bool) http.HandlerFunc {
+	table := softDeletable[targetType]
+	method, action := http.MethodDelete, "delete"
+	if restore {
+		method, action = http.MethodPost, "restore"
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid "+targetType+" id", http.StatusBadRequest)
+			return
+		}
+		found, err := setDeleted(db, table, id, !restore)
+		if err != nil {
+			http.Error(w, "Error updating "+targetType, http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "No "+targetType+" to "+action, http.StatusNotFound)
+			return
+		}
+		recordAudit(db, r, action, targetType, id, nil)
+		if targetType == "subscriber" {
+			recordEvent(db, id, eventUpdated, 0, eventDetail(map[string]string{"action": action}))
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}
+}
+
+// purgeDeleted permanently removes subscribers and articles soft-deleted
+// before cutoff, along with their sends, events, consent records, notes,
+// tags, poll responses, replies, dead letters, push subscriptions,
+// channel deliveries and article revisions.
+func purgeDeleted(db *sql.DB, cutoff time.Time) (int64, error) {
+	before := cutoff.Format(sqliteTimeFormat)
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, q := range []string{
+		"DELETE FROM sent_emails WHERE subscriber_id IN (SELECT id FROM subscribers WHERE deleted_at < ?)",
+		"DELETE FROM consent_log WHERE subscriber_id IN (SELECT id FROM subscribers WHERE deleted_at < ?)",
+		"DELETE FROM events WHERE subscriber_id IN (SELECT id FROM subscribers WHERE deleted_at < ?)",
+		"DELETE FROM subscriber_notes WHERE subscriber_id IN (SELECT id FROM subscribers WHERE deleted_at < ?)",
+		"DELETE FROM subscriber_tags WHERE subscriber_id IN (SELECT id FROM subscribers WHERE deleted_at < ?)",
+		"DELETE FROM dead_letters WHERE subscriber_id IN (SELECT id FROM subscribers WHERE deleted_at < ?)",
+		"DELETE FROM poll_responses WHERE subscriber_id IN (SELECT id FROM subscribers WHERE deleted_at < ?)",
+		"DELETE FROM replies WHERE subscriber_id IN (SELECT id FROM subscribers WHERE deleted_at < ?)",
+		"DELETE FROM push_subscriptions WHERE subscriber_id IN (SELECT id FROM subscribers WHERE deleted_at < ?)",
+		"DELETE FROM channel_deliveries WHERE subscriber_id IN (SELECT id FROM subscribers WHERE deleted_at < ?)",
+		"DELETE FROM dead_letters WHERE article_id IN (SELECT id FROM articles WHERE deleted_at < ?)",
+		"DELETE FROM sent_emails WHERE article_id IN (SELECT id FROM articles WHERE deleted_at < ?)",
+		"DELETE FROM sent_email_summaries WHERE article_id IN (SELECT id FROM articles WHERE deleted_at < ?)",
+		"DELETE FROM poll_responses WHERE article_id IN (SELECT id FROM articles WHERE deleted_at < ?)",
+		"DELETE FROM polls WHERE article_id IN (SELECT id FROM articles WHERE deleted_at < ?)",
+		"DELETE FROM replies WHERE article_id IN (SELECT id FROM articles WHERE deleted_at < ?)",
+		"DELETE FROM channel_deliveries WHERE article_id IN (SELECT id FROM articles WHERE deleted_at < ?)",
+		"DELETE FROM article_revisions WHERE article_id IN (SELECT id FROM articles WHERE deleted_at < ?)",
+	} {
+		if _, err := tx.Exec(q, before); err != nil {
+			return 0, fmt.Errorf("purging deleted rows: %w", err)
+		}
+	}
+	var total int64
+	for _, table := range []string{"subscribers", "articles"} {
+		result, err := tx.Exec("DELETE FROM "+table+" WHERE deleted_at < ?", before)
+		if err != nil {
+			return 0, fmt.Errorf("purging deleted %s: %w", table, err)
+		}
+		n, _ := result.RowsAffected()
+		total += n
+	}
+	return total, tx.Commit()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSoftDeleteRestoreAndPurge(t *testing.T) {
+	db := newTestDB(t)
+	srv := newTestServer(t, db, newMockSender(""))
+
+	if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES ('ada@example.com', 'Ada')"); err != nil {
+		t.Fatal(err)
+	}
+	do := func(method, path string) int {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	visible := func() int {
+		t.Helper()
+		subs, err := getAllSubscribers(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(subs)
+	}
+
+	if code := do(http.MethodDelete, "/api/subscribers/1"); code != http.StatusOK {
+		t.Fatalf("delete: status %d", code)
+	}
+	if code := do(http.MethodDelete, "/api/subscribers/1"); code != http.StatusNotFound {
+		t.Fatalf("second delete: status %d", code)
+	}
+	if n := visible(); n != 0 {
+		t.Fatalf("%d subscribers visible after delete", n)
+	}
+	if code := do(http.MethodPost, "/api/subscribers/1/restore"); code != http.StatusOK {
+		t.Fatalf("restore: status %d", code)
+	}
+	if n := visible(); n != 1 {
+		t.Fatalf("%d subscribers visible after restore", n)
+	}
+
+	do(http.MethodDelete, "/api/subscribers/1")
+	if n, err := purgeDeleted(db, time.Now().UTC().Add(time.Minute)); err != nil || n != 1 {
+		t.Fatalf("purgeDeleted = %d, %v", n, err)
+	}
+	if code := do(http.MethodPost, "/api/subscribers/1/restore"); code != http.StatusNotFound {
+		t.Fatalf("restore after purge: status %d", code)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SpamRule is a SpamAssassin rule that matched a message.
+type SpamRule struct {
+	Name        string  `json:"name"`
+	Score       float64 `json:"score"`
+	Description string  `json:"description"`
+}
+
+// SpamResult is spamd's verdict on a message.
+type SpamResult struct {
+	Score     float64
+	Threshold float64
+	Rules     []SpamRule
+}
+
+var (
+	spamHeaderRe = regexp.MustCompile(`^Spam:\s*\w+\s*;\s*(-?[\d.]+)\s*/\s*(-?[\d.]+)`)
+	spamRuleRe   = regexp.MustCompile(`^\s*(-?\d+(?:\.\d+)?)\s+([A-Z0-9_]+)\s+(.*)$`)
+)
+
+// spamcReport submits msg to spamd at addr with the SPAMC REPORT command and
+// parses the score and matching rules.
+func spamcReport(addr string, msg []byte, timeout time.Duration) (SpamResult, error) {
+	var res SpamResult
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return res, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	fmt.Fprintf(conn, "REPORT SPAMC/1.5\r\nContent-length: %d\r\n\r\n", len(msg))
+	if _, err := conn.Write(msg); err != nil {
+		return res, err
+	}
+	if tc, ok := conn.(*net.TCPConn); ok {
+		tc.CloseWrite()
+	}
+
+	reader := bufio.NewReader(conn)
+	status, err := reader.ReadString('\n')
+	if err != nil {
+		return res, err
+	}
+	if fields := strings.Fields(status); len(fields) < 3 || fields[1] != "0" {
+		return res, fmt.Errorf("spamd error: %s", strings.TrimSpace(status))
+	}
+
+	gotScore := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return res, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		if m := spamHeaderRe.FindStringSubmatch(line); m != nil {
+			res.Score, _ = strconv.ParseFloat(m[1], 64)
+			res.Threshold, _ = strconv.ParseFloat(m[2], 64)
+			gotScore = true
+		}
+	}
+	if !gotScore {
+		return res, fmt.Errorf("spamd response had no Spam header")
+	}
+
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		return res, err
+	}
+	res.Rules = parseSpamRules(body)
+	return res, nil
+}
+
+// parseSpamRules extracts rule hits from the points table of a SpamAssassin
+// report.
+func parseSpamRules(report []byte) []SpamRule {
+	var rules []SpamRule
+	scanner := bufio.NewScanner(bytes.NewReader(report))
+	inTable := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(strings.TrimSpace(line), "----") {
+			inTable = true
+			continue
+		}
+		if !inTable {
+			continue
+		}
+		m := spamRuleRe.FindStringSubmatch(line)
+		if m == nil {
+			// Continuation lines of long descriptions.
+			if len(rules) > 0 && strings.TrimSpace(line) != "" {
+				rules[len(rules)-1].Description += " " + strings.TrimSpace(line)
+			}
+			continue
+		}
+		score, _ := strconv.ParseFloat(m[1], 64)
+		rules = append(rules, SpamRule{Name: m[2], Score: score, Description: strings.TrimSpace(m[3])})
+	}
+	return rules
+}
+
+// checkSpamScore runs the spam preflight when SPAMD_ADDR is set. The
+// threshold is SPAM_THRESHOLD, or spamd's own when unset. Exceeding it fails
+// the check when SPAM_CHECK_MODE=block and warns otherwise.
+func checkSpamScore(msg []byte) (PreflightResult, bool) {
+	addr := os.Getenv("SPAMD_ADDR")
+	if addr == "" {
+		return PreflightResult{}, false
+	}
+	result := PreflightResult{Check: "spam_score"}
+
+	res, err := spamcReport(addr, msg, getEnvDuration("SPAMD_TIMEOUT", 30*time.Second))
+	if err != nil {
+		result.Status = checkWarn
+		result.Detail = fmt.Sprintf("spam check unavailable: %v", err)
+		return result, true
+	}
+
+	threshold := res.Threshold
+	if v := os.Getenv("SPAM_THRESHOLD"); v != "" {
+		if t, err := strconv.ParseFloat(v, 64); err == nil {
+			threshold = t
+		}
+	}
+	result.Score = res.Score
+	result.SpamRules = res.Rules
+	result.Detail = fmt.Sprintf("score %.1f, threshold %.1f", res.Score, threshold)
+
+	switch {
+	case res.Score < threshold:
+		result.Status = checkOK
+	case os.Getenv("SPAM_CHECK_MODE") == "block":
+		result.Status = checkFail
+	default:
+		result.Status = checkWarn
+	}
+	return result, true
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const spamdReport = `Spam detection software, running on the system "spamd".
+
+Content analysis details:   (6.4 points, 5.0 required)
+
+ pts rule name              description
+---- ---------------------- --------------------------------------------------
+ 2.5 HTML_IMAGE_ONLY_08     BODY: HTML: images with 400-800 bytes of words
+ 3.9 URIBL_BLACK            Contains an URL listed in the URIBL blacklist
+                            [URIs: example.test]
+`
+
+// fakeSpamd accepts one SPAMC connection and answers with score.
+func fakeSpamd(t *testing.T, score string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		length := 0
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSpace(line)
+			if line == "" {
+				break
+			}
+			if v, ok := strings.CutPrefix(line, "Content-length: "); ok {
+				length, _ = strconv.Atoi(v)
+			}
+		}
+		io.CopyN(io.Discard, r, int64(length))
+		io.WriteString(conn, "SPAMD/1.1 0 EX_OK\r\nContent-length: "+strconv.Itoa(len(spamdReport))+"\r\nSpam: True ; "+score+" / 5.0\r\n\r\n"+spamdReport)
+	}()
+	return ln.Addr().String()
+}
+
+func TestCheckSpamScore(t *testing.T) {
+	tests := []struct {
+		score, mode, want string
+	}{
+		{"1.2", "block", checkOK},
+		{"6.4", "", checkWarn},
+		{"6.4", "block", checkFail},
+	}
+	for _, tt := range tests {
+		t.Setenv("SPAMD_ADDR", fakeSpamd(t, tt.score))
+		t.Setenv("SPAM_CHECK_MODE", tt.mode)
+		t.Setenv("SPAM_THRESHOLD", "")
+
+		result, ok := checkSpamScore([]byte("Subject: hi\r\n\r\nbody"))
+		if !ok {
+			t.Fatal("check did not run")
+		}
+		if result.Status != tt.want {
+			t.Errorf("score %s mode %q: status = %s (%s), want %s", tt.score, tt.mode, result.Status, result.Detail, tt.want)
+		}
+		if len(result.SpamRules) != 2 || result.SpamRules[1].Name != "URIBL_BLACK" || result.SpamRules[1].Score != 3.9 {
+			t.Errorf("rules = %+v", result.SpamRules)
+		}
+	}
+}
+
+func TestSpamBlockedJobSendsNothing(t *testing.T) {
+	t.Setenv("SPAMD_ADDR", fakeSpamd(t, "9.0"))
+	t.Setenv("SPAM_CHECK_MODE", "block")
+	db := newTestDB(t)
+	sender := newMockSender("")
+
+	if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES ('ada@example.com', 'Ada')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO articles (title, content) VALUES ('Hello', 'First post')"); err != nil {
+		t.Fatal(err)
+	}
+
+	sendNewsletterForArticle(context.Background(), db, sender, 1)
+
+	if n := len(sender.Messages()); n != 0 {
+		t.Fatalf("sent %d messages, want 0", n)
+	}
+	job, err := getJob(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != jobBlocked || len(job.Report.Preflight) != 1 || job.Report.Preflight[0].Check != "spam_score" {
+		t.Fatalf("job = %+v", job)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stripeEvent holds the parts of a Stripe event the webhook uses.
+type stripeEvent struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		Object struct {
+			Customer        string `json:"customer"`
+			Status          string `json:"status"`
+			CustomerEmail   string `json:"customer_email"`
+			CustomerDetails struct {
+				Email string `json:"email"`
+				Name  string `json:"name"`
+			} `json:"customer_details"`
+		} `json:"object"`
+	} `json:"data"`
+}
+
+// verifyStripeSignature checks the Stripe-Signature header of payload. The
+// header is "t=<unix time>,v1=<hex HMAC-SHA256 of t.payload>", possibly with
+// several v1 entries while a secret is being rolled. Any of secrets may
+// match. It returns the delivery's replay id.
+func verifyStripeSignature(payload []byte, header string, secrets []string, now time.Time) (string, error) {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			timestamp = v
+		case "v1":
+			signatures = append(signatures, v)
+		}
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return "", errors.New("malformed signature header")
+	}
+	if err := checkWebhookTimestamp(time.Unix(ts, 0), now); err != nil {
+		return "", err
+	}
+
+	for _, secret := range secrets {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestamp))
+		mac.Write([]byte("."))
+		mac.Write(payload)
+		expected := mac.Sum(nil)
+		for _, sig := range signatures {
+			if got, err := hex.DecodeString(sig); err == nil && hmac.Equal(got, expected) {
+				return signedContentID(timestamp, payload), nil
+			}
+		}
+	}
+	return "", errors.New("no matching signature")
+}
+
+// stripeSubscriptionTier maps a Stripe subscription status to a tier.
+// past_due keeps premium while Stripe retries the payment.
+func stripeSubscriptionTier(status string) string {
+	switch status {
+	case "active", "trialing", "past_due":
+		return tierPremium
+	}
+	return tierFree
+}
+
+// applyStripeEvent updates subscribers for a Stripe event. Event types that
+// do not affect tiers are ignored.
+func applyStripeEvent(db *sql.DB, e stripeEvent) error {
+	obj := e.Data.Object
+	switch e.Type {
+	case "checkout.session.completed":
+		email := obj.CustomerDetails.Email
+		if email == "" {
+			email = obj.CustomerEmail
+		}
+		if email == "" {
+			// Retrying will not add an email, so don't fail the delivery.
+			log.Printf("Stripe checkout event %s has no customer email", e.ID)
+			return nil
+		}
+		if err := validateEmailAddress(email); err != nil {
+			log.Printf("Stripe checkout event %s ignored: %v", e.ID, err)
+			return nil
+		}
+		return upsertStripeSubscriber(db, email, obj.CustomerDetails.Name, obj.Customer)
+	case "customer.subscription.created", "customer.subscription.updated":
+		return setStripeCustomerTier(db, obj.Customer, stripeSubscriptionTier(obj.Status))
+	case "customer.subscription.deleted":
+		return setStripeCustomerTier(db, obj.Customer, tierFree)
+	}
+	return nil
+}
+
+// upsertStripeSubscriber creates a premium subscriber for a completed
+// checkout, with its consent record, or upgrades the existing subscriber
+// with that email. email must already be validated.
+func upsertStripeSubscriber(db *sql.DB, email, name, customerID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRow(`
+		UPDATE subscribers SET tier = ?, stripe_customer_id = ?
+		WHERE email = ?
+		RETURNING id`, tierPremium, customerID, email).Scan(&id)
+	switch {
+	case err == nil:
+		recordEvent(tx, int(id), eventUpdated, 0, eventDetail(map[string]string{"tier": tierPremium, "via": "stripe"}))
+	case errors.Is(err, sql.ErrNoRows):
+		result, err := tx.Exec(`
+			INSERT INTO subscribers (email, name, source, tier, stripe_customer_id)
+			VALUES (?, ?, 'stripe', ?, ?)`, email, name, tierPremium, customerID)
+		if err != nil {
+			return err
+		}
+		id, _ = result.LastInsertId()
+		if err := recordStripeConsent(tx, int(id)); err != nil {
+			return err
+		}
+		recordEvent(tx, int(id), eventSubscribed, 0, eventDetail(map[string]string{"source": "stripe"}))
+	default:
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	invalidateSubscriberCount(db)
+	return nil
+}
+
+func setStripeCustomerTier(db *sql.DB, customerID, tier string) error {
+	if customerID == "" {
+		return nil
+	}
+	result, err := db.Exec("UPDATE subscribers SET tier = ? WHERE stripe_customer_id = ?", tier, customerID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		log.Printf("Stripe event for unknown customer %s ignored", customerID)
+		return nil
+	}
+	_, err = db.Exec(`
+		INSERT INTO events (subscriber_id, type, detail)
+		SELECT id, ?, ? FROM subscribers WHERE stripe_customer_id = ?`,
+		eventUpdated, eventDetail(map[string]string{"tier": tier, "via": "stripe"}), customerID)
+	return err
+}
+
+// handleStripeWebhook receives Stripe checkout and subscription events and
+// keeps subscriber tiers in sync. Events must be signed with
+// STRIPE_WEBHOOK_SECRET, which may list several comma-separated secrets
+// while one is being rotated.
+func handleStripeWebhook(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		secrets := envSecrets("STRIPE_WEBHOOK_SECRET")
+		if len(secrets) == 0 {
+			http.Error(w, "Stripe webhook is not configured", http.StatusNotFound)
+			return
+		}
+		payload, ok := readVerifiedWebhook(db, w, r, "stripe", func(r *http.Request, payload []byte, now time.Time) (string, error) {
+			return verifyStripeSignature(payload, r.Header.Get("Stripe-Signature"), secrets, now)
+		})
+		if !ok {
+			return
+		}
+
+		var event stripeEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := applyStripeEvent(db, event); err != nil {
+			log.Printf("Error applying Stripe event %s: %v", event.ID, err)
+			http.Error(w, "Error applying event", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}
+}
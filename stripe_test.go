@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signStripePayload(payload, secret string, at time.Time) string {
+	ts := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "." + payload))
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func postStripeEvent(t *testing.T, url, payload, signature string) int {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Stripe-Signature", signature)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestStripeWebhookSyncsTier(t *testing.T) {
+	t.Setenv("STRIPE_WEBHOOK_SECRET", "whsec_test")
+	db := newTestDB(t)
+	srv := newTestServer(t, db, newMockSender(""))
+	url := srv.URL + "/api/webhooks/stripe"
+
+	tier := func() string {
+		t.Helper()
+		var tier string
+		if err := db.QueryRow("SELECT tier FROM subscribers WHERE email = 'ada@example.com'").Scan(&tier); err != nil {
+			t.Fatal(err)
+		}
+		return tier
+	}
+
+	checkout := `{"id":"evt_1","type":"checkout.session.completed","data":{"object":{"customer":"cus_1","customer_details":{"email":"ada@example.com","name":"Ada"}}}}`
+	if code := postStripeEvent(t, url, checkout, signStripePayload(checkout, "wrong", time.Now())); code != http.StatusUnauthorized {
+		t.Fatalf("bad signature: status %d", code)
+	}
+	if code := postStripeEvent(t, url, checkout, signStripePayload(checkout, "whsec_test", time.Now().Add(-time.Hour))); code != http.StatusUnauthorized {
+		t.Fatalf("stale signature: status %d", code)
+	}
+	signature := signStripePayload(checkout, "whsec_test", time.Now())
+	if code := postStripeEvent(t, url, checkout, signature); code != http.StatusOK {
+		t.Fatalf("checkout: status %d", code)
+	}
+	if code := postStripeEvent(t, url, checkout, signature); code != http.StatusUnauthorized {
+		t.Fatalf("replayed checkout: status %d", code)
+	}
+	if code := postStripeEvent(t, url, checkout, signature+",v1=00"); code != http.StatusUnauthorized {
+		t.Fatalf("replayed checkout with an altered header: status %d", code)
+	}
+	if got := tier(); got != tierPremium {
+		t.Fatalf("tier after checkout = %q", got)
+	}
+
+	canceled := `{"id":"evt_2","type":"customer.subscription.updated","data":{"object":{"customer":"cus_1","status":"canceled"}}}`
+	if code := postStripeEvent(t, url, canceled, signStripePayload(canceled, "whsec_test", time.Now())); code != http.StatusOK {
+		t.Fatalf("update: status %d", code)
+	}
+	if got := tier(); got != tierFree {
+		t.Fatalf("tier after cancel = %q", got)
+	}
+}
+
+func TestStripeCheckoutRecordsConsentAndValidatesEmail(t *testing.T) {
+	t.Setenv("CONSENT_VERSION", "2024-01")
+	db := newTestDB(t)
+	checkout := func(email string) stripeEvent {
+		var e stripeEvent
+		e.ID, e.Type = "evt_"+email, "checkout.session.completed"
+		e.Data.Object.Customer = "cus_" + email
+		e.Data.Object.CustomerDetails.Email = email
+		return e
+	}
+
+	if err := applyStripeEvent(db, checkout("not an address")); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM subscribers").Scan(&n)
+	if n != 0 {
+		t.Fatalf("%d subscribers created from an invalid checkout email", n)
+	}
+
+	if err := applyStripeEvent(db, checkout("ada@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	records, err := getConsentRecords(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Source != "stripe" || records[0].Action != consentActionSubscribe || records[0].ConsentVersion != "2024-01" {
+		t.Fatalf("consent records = %+v", records)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import "strings"
+
+// normalizeTag trims and lowercases a tag name.
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// tagSubscriber adds tag to a subscriber. It reports false if the
+// subscriber already had it.
+func tagSubscriber(db execer, subscriberID int, tag string) (bool, error) {
+	result, err := db.Exec("INSERT OR IGNORE INTO subscriber_tags (subscriber_id, tag) VALUES (?, ?)", subscriberID, tag)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// untagSubscriber removes tag from a subscriber. It reports false if the
+// subscriber did not have it.
+func untagSubscriber(db execer, subscriberID int, tag string) (bool, error) {
+	result, err := db.Exec("DELETE FROM subscriber_tags WHERE subscriber_id = ? AND tag = ?", subscriberID, tag)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
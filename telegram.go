@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const channelTelegram = "telegram"
+
+// telegramChannel returns the chat the articles are posted to
+// (TELEGRAM_CHANNEL, e.g. "@mynewsletter"), or "" if the integration is
+// off. TELEGRAM_BOT_TOKEN must be set too; the bot has to be an admin of
+// the channel.
+func telegramChannel() string {
+	if credential("TELEGRAM_BOT_TOKEN") == "" {
+		return ""
+	}
+	return os.Getenv("TELEGRAM_CHANNEL")
+}
+
+// telegramURL is the public link to the Telegram channel, or "" if it is
+// off or not a public @channel.
+func telegramURL() string {
+	if name, ok := strings.CutPrefix(telegramChannel(), "@"); ok {
+		return "https://t.me/" + name
+	}
+	return ""
+}
+
+// telegramText formats an article as a Telegram HTML message: the title in
+// bold, the excerpt and a link to the article page.
+func telegramText(article Article) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<b>%s</b>", html.EscapeString(article.Title))
+	if article.Excerpt != "" {
+		fmt.Fprintf(&b, "\n\n%s", html.EscapeString(article.Excerpt))
+	}
+	if u := articleURL(article); u != "" {
+		fmt.Fprintf(&b, "\n\n<a href=\"%s\">Read more</a>", html.EscapeString(u))
+	}
+	return b.String()
+}
+
+// postToTelegram sends text to chat through the Bot API's sendMessage.
+func postToTelegram(ctx context.Context, chat, text string) error {
+	api := os.Getenv("TELEGRAM_API_URL")
+	if api == "" {
+		api = "https://api.telegram.org"
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"chat_id":    chat,
+		"text":       text,
+		"parse_mode": "HTML",
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		strings.TrimRight(api, "/")+"/bot"+credential("TELEGRAM_BOT_TOKEN")+"/sendMessage", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := outboundClient(getEnvDuration("TELEGRAM_TIMEOUT", 10*time.Second)).Do(req)
+	if err != nil {
+		// The URL holds the bot token; keep it out of logs.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("posting to Telegram: %w", err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("Telegram returned %s", resp.Status)
+	}
+	if !result.OK {
+		return fmt.Errorf("Telegram returned %s: %s", resp.Status, result.Description)
+	}
+	return nil
+}
+
+// broadcastToTelegram posts the article to the configured Telegram channel
+// once. Premium articles are not posted, since the channel is public, and
+// nothing is posted outside SEND_MODE=live, so staging copies cannot
+// publish to real readers.
+func broadcastToTelegram(ctx context.Context, db *sql.DB, article Article) (sent, failed int) {
+	chat := telegramChannel()
+	if chat == "" || article.Premium {
+		return 0, 0
+	}
+	if !liveSendMode() {
+		log.Printf("SEND_MODE=%s: not posting article %d to Telegram", os.Getenv("SEND_MODE"), article.ID)
+		return 0, 0
+	}
+	claimed, err := claimChannelDelivery(ctx, db, channelTelegram, article.ID, chat, 0)
+	if err != nil {
+		log.Printf("Error claiming Telegram post: %v", err)
+		return 0, 0
+	}
+	if !claimed {
+		return 0, 0
+	}
+	err = postToTelegram(ctx, chat, telegramText(article))
+	if err := finishChannelDelivery(ctx, db, channelTelegram, article.ID, chat, err); err != nil {
+		log.Printf("Error recording Telegram post: %v", err)
+	}
+	if err != nil {
+		log.Printf("Error posting article %d to Telegram: %v", article.ID, err)
+		return 0, 1
+	}
+	return 1, 0
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBroadcastToTelegram(t *testing.T) {
+	var posts []map[string]string
+	fail := true
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bot123:abc/sendMessage" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		if fail {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"ok": false, "description": "Bad Request: chat not found"}`))
+			return
+		}
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		posts = append(posts, body)
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer api.Close()
+	t.Setenv("TELEGRAM_API_URL", api.URL)
+	t.Setenv("TELEGRAM_BOT_TOKEN", "123:abc")
+	t.Setenv("TELEGRAM_CHANNEL", "@weekly")
+	t.Setenv("PUBLIC_BASE_URL", "https://news.example.com")
+	db := newTestDB(t)
+	sender := newMockSender("")
+
+	_, err := db.Exec(`INSERT INTO articles (title, content, excerpt, premium) VALUES
+		('Fish & Chips', 'Body', 'A <short> intro', 0),
+		('Members only', 'Body', '', 1)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendNewsletterForArticle(context.Background(), db, sender, 1)
+	var status, msg string
+	db.QueryRow("SELECT status, error FROM channel_deliveries WHERE channel = 'telegram'").Scan(&status, &msg)
+	if status != channelDeliveryFailed || !strings.Contains(msg, "chat not found") {
+		t.Errorf("failed post recorded as %q, %q", status, msg)
+	}
+
+	fail = false
+	sendNewsletterForArticle(context.Background(), db, sender, 1)
+	sendNewsletterForArticle(context.Background(), db, sender, 1)
+	sendNewsletterForArticle(context.Background(), db, sender, 2)
+	if len(posts) != 1 {
+		t.Fatalf("posts = %d, want the failed post retried once and the premium article skipped", len(posts))
+	}
+	if posts[0]["chat_id"] != "@weekly" || posts[0]["parse_mode"] != "HTML" {
+		t.Errorf("post = %v", posts[0])
+	}
+	if want := "<b>Fish &amp; Chips</b>\n\nA &lt;short&gt; intro\n\n<a href=\"https://news.example.com/articles/"; !strings.HasPrefix(posts[0]["text"], want) {
+		t.Errorf("text = %q", posts[0]["text"])
+	}
+}
+
+func TestBroadcastToTelegramSkippedOutsideLiveMode(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("posted to Telegram in capture mode")
+	}))
+	defer api.Close()
+	t.Setenv("TELEGRAM_API_URL", api.URL)
+	t.Setenv("TELEGRAM_BOT_TOKEN", "123:abc")
+	t.Setenv("TELEGRAM_CHANNEL", "@weekly")
+	t.Setenv("SEND_MODE", "capture")
+	db := newTestDB(t)
+
+	if sent, failed := broadcastToTelegram(context.Background(), db, Article{ID: 1, Title: "Hello"}); sent != 0 || failed != 0 {
+		t.Errorf("sent %d, failed %d in capture mode", sent, failed)
+	}
+}
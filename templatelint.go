@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"text/template/parse"
+)
+
+// templateFields documents the rendering context of the subject and body
+// templates. Keep in sync with emailTemplateData.
+var templateFields = map[string]string{
+	"Name":        "subscriber's name, may be empty",
+	"Title":       "article title",
+	"Content":     "article content",
+	"BaseURL":     "absolute PUBLIC_BASE_URL with trailing slash, empty if unset",
+	"URL":         "absolute URL of the hosted article page, empty if PUBLIC_BASE_URL is unset",
+	"Authors":     "credited authors, each with .Name, .AvatarURL and .BioURL",
+	"Byline":      `author names joined as "Ada, Grace and Linus", empty if none`,
+	"Poll":        "article poll with .Question and .Options (.Label, .URL), nil if none",
+	"Excerpt":     "short summary of the article, by default the start of its content",
+	"ReadingTime": "estimated reading time in whole minutes",
+	"TOC":         "table of contents entries (.Level, .Text, .Anchor), nil unless the article enables it",
+	"Body":        "content rendered with anchored headings, set with TOC",
+}
+
+// lintTemplate parses src and reports references to fields that are not in
+// templateFields. Fields under {{range}} and {{with}} refer to a different
+// dot and are not checked, except through $.
+func lintTemplate(src string) ([]string, error) {
+	trees, err := parse.Parse("template", src, "{{", "}}", builtinTemplateFuncs())
+	if err != nil {
+		return nil, err
+	}
+
+	undefined := map[string]bool{}
+	check := func(ident []string) {
+		if len(ident) > 0 {
+			if _, ok := templateFields[ident[0]]; !ok {
+				undefined[ident[0]] = true
+			}
+		}
+	}
+	var walk func(node parse.Node, rootDot bool)
+	walkPipe := func(pipe *parse.PipeNode, rootDot bool) {
+		if pipe == nil {
+			return
+		}
+		for _, cmd := range pipe.Cmds {
+			for _, arg := range cmd.Args {
+				walk(arg, rootDot)
+			}
+		}
+	}
+	walk = func(node parse.Node, rootDot bool) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, c := range n.Nodes {
+				walk(c, rootDot)
+			}
+		case *parse.ActionNode:
+			walkPipe(n.Pipe, rootDot)
+		case *parse.PipeNode:
+			walkPipe(n, rootDot)
+		case *parse.FieldNode:
+			if rootDot {
+				check(n.Ident)
+			}
+		case *parse.ChainNode:
+			if _, ok := n.Node.(*parse.DotNode); ok && rootDot {
+				check(n.Field)
+			}
+			walk(n.Node, rootDot)
+		case *parse.VariableNode:
+			if len(n.Ident) > 1 && n.Ident[0] == "$" {
+				check(n.Ident[1:])
+			}
+		case *parse.IfNode:
+			walkPipe(n.Pipe, rootDot)
+			walk(n.List, rootDot)
+			walk(n.ElseList, rootDot)
+		case *parse.RangeNode:
+			walkPipe(n.Pipe, rootDot)
+			walk(n.List, false)
+			walk(n.ElseList, rootDot)
+		case *parse.WithNode:
+			walkPipe(n.Pipe, rootDot)
+			walk(n.List, false)
+			walk(n.ElseList, rootDot)
+		case *parse.TemplateNode:
+			walkPipe(n.Pipe, rootDot)
+		}
+	}
+	for _, tree := range trees {
+		walk(tree.Root, true)
+	}
+
+	var names []string
+	for name := range undefined {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// builtinTemplateFuncs lists the functions available in templates,
+// including emailTemplateFuncs, so parse accepts calls to them. parse only
+// checks that a name is present, so the values are placeholders.
+func builtinTemplateFuncs() map[string]interface{} {
+	placeholder := func() {}
+	funcs := map[string]interface{}{}
+	for _, name := range []string{
+		"and", "call", "html", "index", "slice", "js", "len", "not", "or", "print", "printf", "println", "urlquery",
+		"eq", "ge", "gt", "le", "lt", "ne",
+	} {
+		funcs[name] = placeholder
+	}
+	for name := range emailTemplateFuncs() {
+		funcs[name] = placeholder
+	}
+	return funcs
+}
+
+// templateLintError describes undefined fields and lists the valid ones.
+func templateLintError(undefined []string) error {
+	var fields []string
+	for name := range templateFields {
+		fields = append(fields, "."+name)
+	}
+	sort.Strings(fields)
+	for i, name := range undefined {
+		undefined[i] = "." + name
+	}
+	return fmt.Errorf("undefined template fields %s (available: %s)",
+		strings.Join(undefined, ", "), strings.Join(fields, ", "))
+}
+
+// checkTemplate parses and lints src, returning an error describing any
+// problem.
+func checkTemplate(src string) error {
+	undefined, err := lintTemplate(src)
+	if err != nil {
+		return err
+	}
+	if len(undefined) > 0 {
+		return templateLintError(undefined)
+	}
+	return nil
+}
+
+// handleLintTemplate checks a template without saving or sending it.
+func handleLintTemplate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req struct {
+			Template string `json:"template"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resp := struct {
+			Valid  bool              `json:"valid"`
+			Error  string            `json:"error,omitempty"`
+			Fields map[string]string `json:"fields"`
+		}{Valid: true, Fields: templateFields}
+		if err := checkTemplate(req.Template); err != nil {
+			resp.Valid, resp.Error = false, err.Error()
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestLintTemplate(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{`{{.Title}} by {{.Name}}`, nil},
+		{`{{.Titel}}`, []string{"Titel"}},
+		{`{{if .Nmae}}{{.Name}}{{else}}{{.Bogus}}{{end}}`, []string{"Bogus", "Nmae"}},
+		{`{{range .Content}}{{.Anything}}{{end}}`, nil},
+		{`{{with .Name}}{{$.Missing}}{{end}}`, []string{"Missing"}},
+		{`{{printf "%s" .Title | html}}`, nil},
+	}
+	for _, tt := range tests {
+		got, err := lintTemplate(tt.src)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: undefined = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestTemplateFieldsMatchRenderingContext(t *testing.T) {
+	data := emailTemplateData(Subscriber{}, Article{})
+	for name := range data {
+		if _, ok := templateFields[name]; !ok {
+			t.Errorf("%s is rendered but not documented in templateFields", name)
+		}
+	}
+	for name := range templateFields {
+		if _, ok := data[name]; !ok {
+			t.Errorf("%s is documented but missing from emailTemplateData", name)
+		}
+	}
+}
+
+func TestShippedTemplateLints(t *testing.T) {
+	sources, err := emailTemplateSources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, src := range sources {
+		body, err := os.ReadFile(src.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := checkTemplate(string(body)); err != nil {
+			t.Errorf("%s: %v", src.path, err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+)
+
+// Hosted pages (article, preferences, unsubscribe, poll, forward and
+// stats) are rendered from the built-in templates in the working
+// directory. THEME_DIR points at a directory that overrides them:
+//
+//	THEME_DIR/page_layout.html  replaces the shared layout blocks
+//	THEME_DIR/<page>.html       replaces a whole page, e.g. article.html
+//	THEME_DIR/assets/           is served under /theme/
+//
+// page_layout.html defines theme_head, theme_header and theme_footer, which
+// every built-in page includes, so most themes only need a layout and a
+// stylesheet. A theme file that fails to parse or render is logged and the
+// built-in template is used instead, so a broken theme never takes the
+// pages down.
+const pageLayoutTemplate = "page_layout.html"
+
+func themeDir() string {
+	return os.Getenv("THEME_DIR")
+}
+
+// themeFuncs are available to page and layout templates.
+var themeFuncs = template.FuncMap{
+	"themeAsset": themeAsset,
+}
+
+// themeAsset returns the URL of a file in the theme's assets directory,
+// with its modification time as a cache buster, or "" if the theme has no
+// such file.
+func themeAsset(name string) string {
+	dir := themeDir()
+	if dir == "" || !fs.ValidPath(name) {
+		return ""
+	}
+	info, err := os.Stat(filepath.Join(dir, "assets", filepath.FromSlash(name)))
+	if err != nil || info.IsDir() {
+		return ""
+	}
+	return "/theme/" + name + "?v=" + strconv.FormatInt(info.ModTime().Unix(), 10)
+}
+
+// parsePage parses a page with its layout, taking each from dir when it
+// has them and from the built-in templates otherwise. With dir empty only
+// the built-in templates are used.
+func parsePage(dir, name string) (*template.Template, error) {
+	pick := func(file string) string {
+		if dir != "" {
+			if p := filepath.Join(dir, file); fileExists(p) {
+				return p
+			}
+		}
+		return file
+	}
+	return template.New(name).Funcs(themeFuncs).ParseFiles(pick(pageLayoutTemplate), pick(name))
+}
+
+func fileExists(p string) bool {
+	info, err := os.Stat(p)
+	return err == nil && !info.IsDir()
+}
+
+// renderPage renders the named hosted page into w, using the theme when
+// one is configured and falling back to the built-in templates if the
+// theme fails.
+func renderPage(ctx context.Context, w io.Writer, name string, data interface{}) error {
+	if dir := themeDir(); dir != "" {
+		t, err := parsePage(dir, name)
+		if err == nil {
+			var page bytes.Buffer
+			if err = executeTemplate(ctx, t, &page, data); err == nil {
+				_, err = w.Write(page.Bytes())
+				return err
+			}
+		}
+		log.Printf("Error rendering themed %s, using the built-in template: %v", name, err)
+	}
+	t, err := parsePage("", name)
+	if err != nil {
+		return err
+	}
+	return executeTemplate(ctx, t, w, data)
+}
+
+// handleThemeAsset serves the files in THEME_DIR/assets. Without a theme
+// there are none.
+func handleThemeAsset() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		dir := themeDir()
+		name := r.PathValue("path")
+		if dir == "" || !fs.ValidPath(name) {
+			http.NotFound(w, r)
+			return
+		}
+		assets := os.DirFS(filepath.Join(dir, "assets"))
+		info, err := fs.Stat(assets, name)
+		if errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			log.Printf("Error reading theme asset %s: %v", name, err)
+			http.Error(w, "Error reading asset", http.StatusInternalServerError)
+			return
+		}
+		// URLs from themeAsset change with the file, so they can be cached.
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		http.ServeFileFS(w, r, assets, path.Clean(name))
+	}
+}
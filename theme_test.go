@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func getBody(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func writeThemeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	p := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestThemeLayoutAndAssets(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("THEME_DIR", dir)
+	t.Setenv("PUBLIC_STATS_ENABLED", "true")
+	writeThemeFile(t, dir, "page_layout.html", `{{define "theme_head"}}<link rel="stylesheet" href="{{themeAsset "style.css"}}">{{end}}
+{{define "theme_header"}}<header>My Blog</header>{{end}}
+{{define "theme_footer"}}<footer>Footer</footer>{{end}}`)
+	writeThemeFile(t, dir, "assets/style.css", "body { color: red; }")
+
+	db := newTestDB(t)
+	srv := newTestServer(t, db, &mockSender{})
+
+	status, body := getBody(t, srv.URL+"/stats")
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %s", status, body)
+	}
+	for _, want := range []string{`href="/theme/style.css?v=`, "<header>My Blog</header>", "<footer>Footer</footer>"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("stats page missing %q:\n%s", want, body)
+		}
+	}
+
+	status, body = getBody(t, srv.URL+"/theme/style.css")
+	if status != http.StatusOK || body != "body { color: red; }" {
+		t.Errorf("style.css: %d %q", status, body)
+	}
+	for _, path := range []string{"/theme/missing.css", "/theme/../page_layout.html", "/theme/%2e%2e/page_layout.html"} {
+		if status, _ := getBody(t, srv.URL+path); status != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", path, status)
+		}
+	}
+}
+
+func TestThemePageOverrideAndFallback(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("THEME_DIR", dir)
+	t.Setenv("PUBLIC_STATS_ENABLED", "true")
+	writeThemeFile(t, dir, "public_stats.html", `<p>{{.Subscribers}} readers</p>`)
+
+	db := newTestDB(t)
+	srv := newTestServer(t, db, &mockSender{})
+
+	if _, body := getBody(t, srv.URL+"/stats"); body != "<p>0 readers</p>" {
+		t.Errorf("themed stats page = %q", body)
+	}
+
+	// A broken theme falls back to the built-in page.
+	writeThemeFile(t, dir, "public_stats.html", `{{.NoSuchField}}`)
+	status, body := getBody(t, srv.URL+"/stats")
+	if status != http.StatusOK || !strings.Contains(body, "<!DOCTYPE html>") {
+		t.Errorf("fallback stats page: %d %q", status, body)
+	}
+}
+
+func TestThemeAssetWithoutTheme(t *testing.T) {
+	t.Setenv("THEME_DIR", "")
+	if got := themeAsset("style.css"); got != "" {
+		t.Errorf("themeAsset without a theme = %q", got)
+	}
+}
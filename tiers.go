@@ -0,0 +1,71 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+const (
+	tierFree    = "free"
+	tierPremium = "premium"
+)
+
+func validTier(tier string) bool {
+	return tier == tierFree || tier == tierPremium
+}
+
+// setSubscriberTier changes a subscriber's tier. It reports false if no
+// subscriber has the given id.
+func setSubscriberTier(db *sql.DB, id int, tier string) (bool, error) {
+	result, err := db.Exec("UPDATE subscribers SET tier = ? WHERE id = ?", tier, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if n > 0 {
+		recordEvent(db, id, eventUpdated, 0, eventDetail(map[string]string{"tier": tier}))
+	}
+	return n > 0, err
+}
+
+func handleSetTier(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid subscriber id", http.StatusBadRequest)
+			return
+		}
+		var req struct {
+			Tier string `json:"tier"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !validTier(req.Tier) {
+			http.Error(w, "Tier must be free or premium", http.StatusBadRequest)
+			return
+		}
+
+		found, err := setSubscriberTier(db, id, req.Tier)
+		if err != nil {
+			http.Error(w, "Error updating tier", http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "Subscriber not found", http.StatusNotFound)
+			return
+		}
+		recordAudit(db, r, "set_tier", "subscriber", id, map[string]string{"tier": req.Tier})
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Tier updated"))
+	}
+}
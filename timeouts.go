@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// longRunningPrefixes are routes allowed HTTP_LONG_TIMEOUT instead of
+// HTTP_HANDLER_TIMEOUT: streaming exports and profiling.
+var longRunningPrefixes = []string{"/api/export/", "/debug/pprof/"}
+
+// newServer returns a server for handler on addr with read, write and idle
+// timeouts, so slow or stalled clients cannot hold connections open. Routes
+// that legitimately run longer extend their own write deadline through
+// withTimeout.
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: getEnvDuration("HTTP_READ_HEADER_TIMEOUT", 5*time.Second),
+		ReadTimeout:       getEnvDuration("HTTP_READ_TIMEOUT", 30*time.Second),
+		WriteTimeout:      getEnvDuration("HTTP_WRITE_TIMEOUT", 60*time.Second),
+		IdleTimeout:       getEnvDuration("HTTP_IDLE_TIMEOUT", 120*time.Second),
+	}
+}
+
+// requestTimeout returns how long a handler may spend on r.
+func requestTimeout(r *http.Request) time.Duration {
+	for _, prefix := range longRunningPrefixes {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return getEnvDuration("HTTP_LONG_TIMEOUT", 10*time.Minute)
+		}
+	}
+	return getEnvDuration("HTTP_HANDLER_TIMEOUT", 30*time.Second)
+}
+
+// withTimeout gives each request a context deadline and a matching write
+// deadline. Handlers that honour their context stop work when the client
+// would no longer get a response anyway.
+func withTimeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d := requestTimeout(r)
+		// Not every ResponseWriter supports deadlines, e.g. in tests.
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(d))
+
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
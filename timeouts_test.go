@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWithTimeoutSetsDeadline(t *testing.T) {
+	t.Setenv("HTTP_HANDLER_TIMEOUT", "2s")
+	t.Setenv("HTTP_LONG_TIMEOUT", "1h")
+
+	var remaining time.Duration
+	h := withTimeout(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, ok := r.Context().Deadline()
+		if !ok {
+			t.Fatal("no deadline on request context")
+		}
+		remaining = time.Until(deadline)
+	}))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/stats", nil))
+	if remaining <= 0 || remaining > 2*time.Second {
+		t.Errorf("/api/stats deadline in %v, want at most 2s", remaining)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/export/sent-emails.ndjson", nil))
+	if remaining < 59*time.Minute {
+		t.Errorf("export deadline in %v, want about 1h", remaining)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// timestampLayouts are the formats parseTimestamp accepts, most specific
+// first. Values without an offset are taken as UTC.
+var timestampLayouts = []string{time.RFC3339Nano, sqliteTimeFormat, "2006-01-02T15:04:05", "2006-01-02"}
+
+// parseTimestamp parses an RFC 3339 timestamp with any offset, or one of
+// the offset-less forms SQLite stores, and returns it in UTC.
+func parseTimestamp(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q, want RFC 3339", s)
+}
+
+// dbTime scans a DATETIME column into a time.Time whichever form the
+// driver returns it in. NULL leaves the time zero.
+type dbTime struct{ t *time.Time }
+
+func scanTime(t *time.Time) dbTime { return dbTime{t} }
+
+func (d dbTime) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*d.t = time.Time{}
+		return nil
+	case time.Time:
+		*d.t = v.UTC()
+		return nil
+	case []byte:
+		return d.Scan(string(v))
+	case string:
+		t, err := parseTimestamp(v)
+		if err != nil {
+			return err
+		}
+		*d.t = t
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into a timestamp", src)
+}
+
+// dbNullTime is dbTime for optional columns: NULL scans to a nil pointer.
+type dbNullTime struct{ t **time.Time }
+
+func scanNullTime(t **time.Time) dbNullTime { return dbNullTime{t} }
+
+func (d dbNullTime) Scan(src interface{}) error {
+	if src == nil {
+		*d.t = nil
+		return nil
+	}
+	var t time.Time
+	if err := scanTime(&t).Scan(src); err != nil {
+		return err
+	}
+	*d.t = &t
+	return nil
+}
+
+// normalizedTimestamps are the columns rewritten by normalizeTimestamps.
+var normalizedTimestamps = []struct{ table, column string }{
+	{"subscribers", "subscribed_at"},
+	{"subscribers", "unsubscribed_at"},
+	{"articles", "published_at"},
+	{"articles", "scheduled_at"},
+	{"sent_emails", "sent_at"},
+	{"sent_emails", "opened_at"},
+	{"sent_emails", "clicked_at"},
+}
+
+// normalizeTimestamps rewrites timestamps stored with an offset or in
+// RFC 3339 form (from older imports or hand edits) as UTC in
+// sqliteTimeFormat, so string comparisons in date filters and ORDER BY
+// agree with time order.
+func normalizeTimestamps(db *sql.DB) {
+	for _, c := range normalizedTimestamps {
+		result, err := db.Exec(fmt.Sprintf(
+			"UPDATE %[1]s SET %[2]s = datetime(%[2]s) WHERE datetime(%[2]s) IS NOT NULL AND %[2]s != datetime(%[2]s)",
+			c.table, c.column))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			log.Printf("normalized %d timestamps in %s.%s", n, c.table, c.column)
+		}
+	}
+}
+
+// formatOptionalTime formats t as RFC 3339, or "" if it is nil.
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
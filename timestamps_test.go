@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	want := time.Date(2024, 1, 2, 1, 4, 5, 0, time.UTC)
+	for _, s := range []string{"2024-01-02T03:04:05+02:00", "2024-01-02T01:04:05Z", "2024-01-02 01:04:05"} {
+		got, err := parseTimestamp(s)
+		if err != nil || !got.Equal(want) || got.Location() != time.UTC {
+			t.Errorf("parseTimestamp(%q) = %v, %v", s, got, err)
+		}
+	}
+	if _, err := parseTimestamp("yesterday"); err == nil {
+		t.Error("parseTimestamp accepted an invalid timestamp")
+	}
+}
+
+func TestNormalizeTimestamps(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.Exec(`INSERT INTO subscribers (email, name, subscribed_at) VALUES
+		('a@example.com', 'A', '2024-01-02T03:04:05+02:00'),
+		('b@example.com', 'B', '2024-01-02 02:00:00')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	normalizeTimestamps(db)
+
+	var first string
+	if err := db.QueryRow("SELECT email FROM subscribers ORDER BY subscribed_at LIMIT 1").Scan(&first); err != nil {
+		t.Fatal(err)
+	}
+	if first != "a@example.com" {
+		t.Errorf("earliest subscriber = %s, want a@example.com", first)
+	}
+
+	subscribers, err := getAllSubscribers(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 1, 2, 1, 4, 5, 0, time.UTC); !subscribers[0].SubscribedAt.Equal(want) {
+		t.Errorf("subscribed_at = %v, want %v", subscribers[0].SubscribedAt, want)
+	}
+}
+
+func TestScheduledAtAcceptsOffsets(t *testing.T) {
+	db := newTestDB(t)
+	srv := newTestServer(t, db, newMockSender(""))
+
+	postJSON(t, srv.URL+"/api/publish", `{"title":"Later","content":"x","scheduled_at":"2999-01-01T09:00:00+09:00"}`)
+	var stored time.Time
+	if err := db.QueryRow("SELECT scheduled_at FROM articles WHERE id = 1").Scan(scanTime(&stored)); err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2999, 1, 1, 0, 0, 0, 0, time.UTC); !stored.Equal(want) {
+		t.Errorf("stored scheduled_at = %v, want %v", stored, want)
+	}
+}
+
+func TestAPITimestampsAreRFC3339(t *testing.T) {
+	db := newTestDB(t)
+	srv := newTestServer(t, db, newMockSender(""))
+	if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES ('a@example.com', 'A')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO articles (title, content) VALUES ('Hello', '')"); err != nil {
+		t.Fatal(err)
+	}
+	sendNewsletterForArticle(context.Background(), db, newMockSender(""), 1)
+
+	job, err := getJob(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.StartedAt.IsZero() || job.FinishedAt == nil || job.FinishedAt.Before(job.StartedAt) {
+		t.Errorf("job started %v, finished %v", job.StartedAt, job.FinishedAt)
+	}
+
+	if _, err := db.Exec("INSERT INTO subscriber_notes (subscriber_id, author, body) VALUES (1, 'admin', 'Asked about invoices')"); err != nil {
+		t.Fatal(err)
+	}
+	var notes []struct {
+		CreatedAt string `json:"created_at"`
+	}
+	_, body := getBody(t, srv.URL+"/api/subscribers/1/notes")
+	if err := json.Unmarshal([]byte(body), &notes); err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 1 {
+		t.Fatalf("notes = %+v", notes)
+	}
+	if _, err := time.Parse(time.RFC3339, notes[0].CreatedAt); err != nil {
+		t.Errorf("created_at %q is not RFC 3339", notes[0].CreatedAt)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// tlsDomains returns the domains listed in TLS_DOMAIN. When set, the server
+// obtains certificates for them from Let's Encrypt.
+func tlsDomains() []string {
+	var domains []string
+	for _, d := range strings.Split(os.Getenv("TLS_DOMAIN"), ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			domains = append(domains, d)
+		}
+	}
+	return domains
+}
+
+// httpsPort is the default public port while TLS_DOMAIN is set
+// (HTTPS_PORT, default 443).
+func httpsPort() string {
+	if port := os.Getenv("HTTPS_PORT"); port != "" {
+		return port
+	}
+	return "443"
+}
+
+// useAutocertTLS switches the public listeners to HTTPS with certificates
+// for domains managed by autocert, and adds a plain HTTP listener on
+// HTTP_PORT (default 80) that answers ACME challenges and redirects
+// everything else to HTTPS. Admin listeners are left as they are.
+func useAutocertTLS(served []servedListener, domains []string) ([]servedListener, error) {
+	cacheDir := os.Getenv("TLS_CACHE_DIR")
+	if cacheDir == "" {
+		cacheDir = "/data/certs"
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      os.Getenv("TLS_EMAIL"),
+	}
+
+	config := m.TLSConfig()
+	for i, s := range served {
+		if s.admin {
+			continue
+		}
+		// Serve configures HTTP/2 from the server's TLSConfig.
+		s.srv.TLSConfig = config
+		served[i].ln = tls.NewListener(s.ln, config)
+	}
+
+	httpPort := os.Getenv("HTTP_PORT")
+	if httpPort == "" {
+		httpPort = "80"
+	}
+	ln, err := listen(":" + httpPort)
+	if err != nil {
+		for _, s := range served {
+			s.ln.Close()
+		}
+		return nil, err
+	}
+	log.Printf("Serving HTTPS for %s, with ACME challenges and redirects on %s", strings.Join(domains, ", "), ln.Addr())
+	return append(served, servedListener{srv: newServer(":"+httpPort, m.HTTPHandler(nil)), ln: ln}), nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAutocertTLSUsesOpenedListeners(t *testing.T) {
+	t.Setenv("TLS_DOMAIN", "news.example.com")
+	t.Setenv("TLS_CACHE_DIR", t.TempDir())
+	t.Setenv("LISTEN_ADDRS", "127.0.0.1:0")
+	t.Setenv("ADMIN_LISTEN_ADDRS", "127.0.0.1:0")
+	t.Setenv("HTTP_PORT", "0")
+	t.Cleanup(func() { adminListenersOnly = false })
+
+	served, err := openListeners(http.NotFoundHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	served, err = useAutocertTLS(served, tlsDomains())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range served {
+		defer s.ln.Close()
+	}
+	if len(served) != 3 {
+		t.Fatalf("%d listeners, want public, admin and the ACME redirect", len(served))
+	}
+	if served[0].srv.TLSConfig == nil || served[1].srv.TLSConfig != nil {
+		t.Fatal("want TLS on the public listener only")
+	}
+
+	redirect := served[2]
+	go redirect.srv.Serve(redirect.ln)
+	defer redirect.srv.Close()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	req, _ := http.NewRequest(http.MethodGet, "http://"+redirect.ln.Addr().String()+"/archive", nil)
+	req.Host = "news.example.com"
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if loc := resp.Header.Get("Location"); loc != "https://news.example.com/archive" {
+		t.Fatalf("redirect to %q (status %d)", loc, resp.StatusCode)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"html/template"
+	"strconv"
+	"strings"
+)
+
+// minTOCHeadings is the fewest headings worth a table of contents.
+const minTOCHeadings = 2
+
+// TOCEntry is one heading in an article's table of contents.
+type TOCEntry struct {
+	Level  int
+	Text   string
+	Anchor string
+}
+
+// markdownHeading parses a Markdown ATX heading ("## Text") of level 1-3.
+func markdownHeading(line string) (int, string, bool) {
+	level := 0
+	for level < len(line) && line[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 3 || level >= len(line) || line[level] != ' ' {
+		return 0, "", false
+	}
+	text := strings.TrimSpace(strings.TrimRight(line[level:], "# "))
+	return level, text, text != ""
+}
+
+// articleTOC splits content into anchored headings and paragraphs and
+// returns the table of contents with the rendered body. It returns a nil
+// table when the article did not ask for one or has fewer than
+// minTOCHeadings headings, in which case templates show Content as before.
+func articleTOC(article Article) ([]TOCEntry, template.HTML) {
+	if !article.TOC {
+		return nil, ""
+	}
+
+	var toc []TOCEntry
+	var body, para strings.Builder
+	used := map[string]bool{}
+	flush := func() {
+		if text := strings.TrimSpace(para.String()); text != "" {
+			body.WriteString("<p>" + template.HTMLEscapeString(text) + "</p>\n")
+		}
+		para.Reset()
+	}
+	for _, line := range strings.Split(article.Content, "\n") {
+		line = strings.TrimRight(line, "\r")
+		level, text, ok := markdownHeading(line)
+		if !ok {
+			if strings.TrimSpace(line) == "" {
+				flush()
+			} else {
+				para.WriteString(line + "\n")
+			}
+			continue
+		}
+		flush()
+		anchor := slugify(text)
+		if anchor == "" {
+			anchor = "section"
+		}
+		for base, i := anchor, 2; used[anchor]; i++ {
+			anchor = base + "-" + strconv.Itoa(i)
+		}
+		used[anchor] = true
+		toc = append(toc, TOCEntry{Level: level, Text: text, Anchor: anchor})
+		tag := "h" + strconv.Itoa(level+1)
+		body.WriteString("<" + tag + ` id="` + anchor + `">` + template.HTMLEscapeString(text) + "</" + tag + ">\n")
+	}
+	flush()
+
+	if len(toc) < minTOCHeadings {
+		return nil, ""
+	}
+	return toc, template.HTML(body.String())
+}
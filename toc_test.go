@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestArticleTOC(t *testing.T) {
+	article := Article{
+		TOC:     true,
+		Content: "Intro <b>text</b>\n\n# Setup\nStep one\n\n## Details ##\nMore\n\n# Setup\nAgain",
+	}
+	toc, body := articleTOC(article)
+	want := []TOCEntry{
+		{Level: 1, Text: "Setup", Anchor: "setup"},
+		{Level: 2, Text: "Details", Anchor: "details"},
+		{Level: 1, Text: "Setup", Anchor: "setup-2"},
+	}
+	if len(toc) != len(want) {
+		t.Fatalf("toc = %+v", toc)
+	}
+	for i := range want {
+		if toc[i] != want[i] {
+			t.Errorf("toc[%d] = %+v, want %+v", i, toc[i], want[i])
+		}
+	}
+	for _, s := range []string{`<p>Intro &lt;b&gt;text&lt;/b&gt;</p>`, `<h2 id="setup">Setup</h2>`, `<h3 id="details">Details</h3>`, `<h2 id="setup-2">Setup</h2>`} {
+		if !strings.Contains(string(body), s) {
+			t.Errorf("body lacks %s:\n%s", s, body)
+		}
+	}
+
+	article.TOC = false
+	if toc, _ := articleTOC(article); toc != nil {
+		t.Error("table of contents without the flag")
+	}
+	if toc, _ := articleTOC(Article{TOC: true, Content: "# Only one\ntext"}); toc != nil {
+		t.Error("table of contents for a single heading")
+	}
+}
+
+func TestNewsletterTOC(t *testing.T) {
+	article := Article{ID: 1, Title: "Long", TOC: true, Content: "# One\na\n# Two\nb"}
+	body, err := renderNewsletterBody(Subscriber{Email: "ada@example.com"}, article)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body, `<a href="#two">Two</a>`) || !strings.Contains(body, `<h2 id="two">Two</h2>`) {
+		t.Fatalf("newsletter lacks the table of contents:\n%s", body)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew is the number of periods either side of now that are
+	// accepted, to tolerate clock drift on the authenticator.
+	totpSkew = 1
+)
+
+var errTOTPInvalid = errors.New("invalid two-factor code")
+
+func newTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
+
+// totpCode computes the RFC 6238 code for secret at the given counter.
+func totpCode(secret string, counter int64) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// matchTOTP returns the counter that code is valid for at time t, allowing
+// for totpSkew periods of drift.
+func matchTOTP(secret, code string, t time.Time) (int64, bool) {
+	now := t.Unix() / totpPeriod
+	for c := now - totpSkew; c <= now+totpSkew; c++ {
+		expected, err := totpCode(secret, c)
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+// verifyUserTOTP checks code against the user's secret and records the
+// matched counter so a code cannot be replayed.
+func verifyUserTOTP(db *sql.DB, userID int, code string) error {
+	var secret sql.NullString
+	var lastCounter int64
+	err := db.QueryRow("SELECT totp_secret, totp_last_counter FROM admin_users WHERE id = ?", userID).Scan(&secret, &lastCounter)
+	if err != nil {
+		return err
+	}
+	if !secret.Valid || secret.String == "" {
+		return errTOTPInvalid
+	}
+	counter, ok := matchTOTP(secret.String, code, time.Now())
+	if !ok || counter <= lastCounter {
+		return errTOTPInvalid
+	}
+	_, err = db.Exec("UPDATE admin_users SET totp_last_counter = ? WHERE id = ?", counter, userID)
+	return err
+}
+
+func totpEnabled(db *sql.DB, userID int) (bool, error) {
+	var enabled bool
+	err := db.QueryRow("SELECT totp_enabled FROM admin_users WHERE id = ?", userID).Scan(&enabled)
+	return enabled, err
+}
+
+// handleTOTPEnroll generates a new secret for the logged-in account. The
+// secret only takes effect once confirmed with a valid code.
+func handleTOTPEnroll(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		sess, err := sessionFromRequest(db, r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		enabled, err := totpEnabled(db, sess.UserID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if enabled {
+			http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+			return
+		}
+
+		secret, err := newTOTPSecret()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if _, err := db.Exec("UPDATE admin_users SET totp_secret = ?, totp_last_counter = 0 WHERE id = ?", secret, sess.UserID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		issuer := "blog-emailing"
+		uri := url.URL{
+			Scheme: "otpauth",
+			Host:   "totp",
+			Path:   "/" + issuer + ":" + sess.Username,
+			RawQuery: url.Values{
+				"secret": {secret},
+				"issuer": {issuer},
+			}.Encode(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"secret":      secret,
+			"otpauth_uri": uri.String(),
+		})
+	}
+}
+
+// handleTOTPConfirm enables two-factor authentication once the account
+// proves it can generate codes for the enrolled secret.
+func handleTOTPConfirm(db *sql.DB) http.HandlerFunc {
+	return handleTOTPToggle(db, true)
+}
+
+// handleTOTPDisable turns two-factor authentication off; a current code is
+// required.
+func handleTOTPDisable(db *sql.DB) http.HandlerFunc {
+	return handleTOTPToggle(db, false)
+}
+
+func handleTOTPToggle(db *sql.DB, enable bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		sess, err := sessionFromRequest(db, r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			Code string `json:"code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		keys := loginKeys(r, sess.Username)
+		if tooManyLoginAttempts(w, keys) {
+			return
+		}
+		if err := verifyUserTOTP(db, sess.UserID, req.Code); err != nil {
+			recordLoginFailure(keys, time.Now())
+			http.Error(w, "Invalid two-factor code", http.StatusUnauthorized)
+			return
+		}
+
+		query := "UPDATE admin_users SET totp_enabled = 1 WHERE id = ?"
+		message := "Two-factor authentication enabled"
+		if !enable {
+			query = "UPDATE admin_users SET totp_enabled = 0, totp_secret = NULL WHERE id = ?"
+			message = "Two-factor authentication disabled"
+		}
+		if _, err := db.Exec(query, sess.UserID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(db, r, "update", "admin_user", sess.UserID, map[string]bool{"totp_enabled": enable})
+		log.Printf("%s for %s", message, sess.Username)
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(message))
+	}
+}
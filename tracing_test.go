@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracingNamesSpansByRoute(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	prev := tracer
+	tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)).Tracer("test")
+	t.Cleanup(func() { tracer = prev })
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/articles/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	h := withTracing(mux, mux)
+	for _, path := range []string{"/api/articles/1", "/api/articles/2", "/nowhere"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	var names []string
+	for _, s := range spans.Ended() {
+		names = append(names, s.Name())
+	}
+	want := []string{"GET /api/articles/{id}", "GET /api/articles/{id}", "GET"}
+	if len(names) != len(want) {
+		t.Fatalf("spans = %q, want %q", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("span %d = %q, want %q", i, names[i], want[i])
+		}
+	}
+}
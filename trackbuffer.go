@@ -0,0 +1,102 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+)
+
+// trackingHit is one open or click waiting to be written.
+type trackingHit struct {
+	subscriberID, articleID int
+	click                   bool
+	target                  string
+	at                      time.Time
+}
+
+// trackingBuffer collects opens and clicks in memory and writes them in
+// batched transactions, since a big send can produce hundreds of hits a
+// second and SQLite handles one write transaction at a time. Hits still
+// buffered when the process exits are lost.
+type trackingBuffer struct {
+	db  *sql.DB
+	max int
+
+	mu   sync.Mutex
+	hits []trackingHit
+}
+
+// trackingHits buffers tracking writes. It is set up in main; when nil,
+// hits are written immediately.
+var trackingHits *trackingBuffer
+
+// newTrackingBuffer returns a buffer that flushes early once it holds
+// TRACKING_BUFFER_MAX hits (default 10000).
+func newTrackingBuffer(db *sql.DB) *trackingBuffer {
+	return &trackingBuffer{db: db, max: getEnvInt("TRACKING_BUFFER_MAX", 10000)}
+}
+
+// recordTrackingHit stores an open or click, buffered if trackingHits is
+// set.
+func recordTrackingHit(db *sql.DB, hit trackingHit) {
+	if trackingHits == nil {
+		if err := writeTrackingHits(db, []trackingHit{hit}); err != nil {
+			log.Printf("Error recording tracking hit: %v", err)
+		}
+		return
+	}
+	trackingHits.add(hit)
+}
+
+func (b *trackingBuffer) add(hit trackingHit) {
+	b.mu.Lock()
+	b.hits = append(b.hits, hit)
+	full := len(b.hits) >= b.max
+	b.mu.Unlock()
+	if full {
+		b.flush()
+	}
+}
+
+// flush writes the buffered hits in one transaction. On error they are
+// dropped rather than retried, so a bad row cannot wedge the buffer.
+func (b *trackingBuffer) flush() {
+	b.mu.Lock()
+	hits := b.hits
+	b.hits = nil
+	b.mu.Unlock()
+	if len(hits) == 0 {
+		return
+	}
+	if err := writeTrackingHits(b.db, hits); err != nil {
+		log.Printf("Error writing %d tracking hits: %v", len(hits), err)
+	}
+}
+
+// run flushes the buffer every interval.
+func (b *trackingBuffer) run(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		b.flush()
+	}
+}
+
+func writeTrackingHits(db *sql.DB, hits []trackingHit) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, hit := range hits {
+		if err := recordEngagement(tx, hit.subscriberID, hit.articleID, hit.click, hit.at); err != nil {
+			return err
+		}
+		eventType := eventOpened
+		if hit.click {
+			eventType = eventClicked
+		}
+		recordEvent(tx, hit.subscriberID, eventType, hit.articleID, hit.target)
+	}
+	return tx.Commit()
+}
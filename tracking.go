@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// transparentGIF is a 1x1 transparent GIF served as the open pixel.
+var transparentGIF = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+// trackingEnabled reports whether open and click tracking is configured.
+// It needs PUBLIC_BASE_URL for the tracking links and a signing key
+// (TRACKING_KEYS or TRACKING_SECRET) to sign them.
+func trackingEnabled() bool {
+	return publicURL("") != "" && len(signingKeys()) > 0
+}
+
+// trackingToken identifies the send of article to a subscriber. For click
+// links the target URL is covered by the signature, so the redirect cannot
+// be pointed elsewhere. Tokens are "subscriber.article.signature", with
+// the key id before the signature unless signed with TRACKING_SECRET.
+func trackingToken(subscriberID, articleID int, target string) string {
+	key, _ := activeSigningKey()
+	payload := fmt.Sprintf("%d.%d", subscriberID, articleID)
+	if key.id != "" {
+		payload += "." + key.id
+	}
+	return payload + "." + trackingSignature(key, payload, target)
+}
+
+func trackingSignature(key signingKey, payload, target string) string {
+	mac := hmac.New(sha256.New, []byte(key.secret))
+	mac.Write([]byte(payload + "|" + target))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:12])
+}
+
+// parseTrackingToken checks token against target and returns the
+// subscriber and article it was issued for. Tokens signed with any
+// configured key are accepted.
+func parseTrackingToken(token, target string) (subscriberID, articleID int, ok bool) {
+	parts := strings.Split(token, ".")
+	var keyID string
+	switch len(parts) {
+	case 3:
+	case 4:
+		keyID = parts[2]
+	default:
+		return 0, 0, false
+	}
+	key, found := findSigningKey(keyID)
+	if !found {
+		return 0, 0, false
+	}
+	payload := strings.Join(parts[:len(parts)-1], ".")
+	if !hmac.Equal([]byte(parts[len(parts)-1]), []byte(trackingSignature(key, payload, target))) {
+		return 0, 0, false
+	}
+	subscriberID, err1 := strconv.Atoi(parts[0])
+	articleID, err2 := strconv.Atoi(parts[1])
+	return subscriberID, articleID, err1 == nil && err2 == nil
+}
+
+// addTracking rewrites the http(s) links of an HTML body through the click
+// tracker and adds an open pixel before </body>. Links with a code in
+// shortLinks become short links.
+func addTracking(body string, subscriberID, articleID int, shortLinks map[string]string) string {
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(body))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		if tt == html.StartTagToken {
+			if tok := z.Token(); tok.Data == "a" {
+				for i, attr := range tok.Attr {
+					u := strings.TrimSpace(attr.Val)
+					if attr.Key != "href" || !(strings.HasPrefix(u, "http://") || strings.HasPrefix(u, "https://")) {
+						continue
+					}
+					if code, ok := shortLinks[u]; ok {
+						tok.Attr[i].Val = shortLinkURL(code, subscriberID, articleID)
+					} else {
+						tok.Attr[i].Val = publicURL("t/c/"+trackingToken(subscriberID, articleID, u)) + "?u=" + url.QueryEscape(u)
+					}
+				}
+				b.WriteString(tok.String())
+				continue
+			}
+		}
+		if tt == html.EndTagToken {
+			if name, _ := z.TagName(); string(name) == "body" {
+				writePixel(&b, subscriberID, articleID)
+				subscriberID = 0
+			}
+		}
+		b.Write(z.Raw())
+	}
+	if subscriberID != 0 {
+		writePixel(&b, subscriberID, articleID)
+	}
+	return b.String()
+}
+
+func writePixel(b *strings.Builder, subscriberID, articleID int) {
+	fmt.Fprintf(b, `<img src="%s" width="1" height="1" alt="" style="display:block;border:0">`,
+		html.EscapeString(publicURL("t/o/"+trackingToken(subscriberID, articleID, ""))))
+}
+
+// recordEngagement counts an open or click on a send that happened at at.
+func recordEngagement(db execer, subscriberID, articleID int, click bool, at time.Time) error {
+	query := `
+		UPDATE sent_emails
+		SET open_count = open_count + 1,
+			opened_at = COALESCE(opened_at, ?1),
+			last_engaged_at = MAX(COALESCE(last_engaged_at, ?1), ?1)
+		WHERE subscriber_id = ?2 AND article_id = ?3`
+	if click {
+		// A click implies an open even when images were blocked.
+		query = `
+			UPDATE sent_emails
+			SET click_count = click_count + 1,
+				clicked_at = COALESCE(clicked_at, ?1),
+				opened_at = COALESCE(opened_at, ?1),
+				last_engaged_at = MAX(COALESCE(last_engaged_at, ?1), ?1)
+			WHERE subscriber_id = ?2 AND article_id = ?3`
+	}
+	_, err := db.Exec(query, at.UTC().Format(sqliteTimeFormat), subscriberID, articleID)
+	return err
+}
+
+// handleTrackOpen serves the open pixel. The pixel is returned even for
+// invalid tokens so mail clients never show a broken image.
+func handleTrackOpen(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if subscriberID, articleID, ok := parseTrackingToken(r.PathValue("token"), ""); ok {
+			recordTrackingHit(db, trackingHit{subscriberID: subscriberID, articleID: articleID, at: time.Now()})
+		}
+		w.Header().Set("Content-Type", "image/gif")
+		w.Header().Set("Cache-Control", "no-store, max-age=0")
+		w.Write(transparentGIF)
+	}
+}
+
+// handleTrackClick records a click and redirects to the original link.
+func handleTrackClick(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target := r.URL.Query().Get("u")
+		subscriberID, articleID, ok := parseTrackingToken(r.PathValue("token"), target)
+		if !ok {
+			http.Error(w, "Invalid link", http.StatusBadRequest)
+			return
+		}
+		recordTrackingHit(db, trackingHit{subscriberID: subscriberID, articleID: articleID, click: true, target: target, at: time.Now()})
+		http.Redirect(w, r, target, http.StatusFound)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+)
+
+const consentActionUnsubscribe = "unsubscribe"
+
+// unsubscribeURL returns the signed unsubscribe link for a send, or "" when
+// tracking links cannot be signed.
+func unsubscribeURL(subscriberID, articleID int) string {
+	if !trackingEnabled() || subscriberID == 0 {
+		return ""
+	}
+	return publicURL("u/" + trackingToken(subscriberID, articleID, "unsubscribe"))
+}
+
+// unsubscribe marks the subscriber unsubscribed. It reports whether the
+// subscriber was still subscribed.
+func unsubscribe(db *sql.DB, r *http.Request, subscriberID, articleID int) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE subscribers SET unsubscribed_at = CURRENT_TIMESTAMP WHERE id = ? AND unsubscribed_at IS NULL", subscriberID)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	if err := recordConsent(tx, r, subscriberID, consentActionUnsubscribe, ""); err != nil {
+		return false, err
+	}
+	recordEvent(tx, subscriberID, eventUnsubscribed, articleID, "")
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	invalidateSubscriberCount(db)
+	return true, nil
+}
+
+// handleUnsubscribe serves the unsubscribe link from the newsletter footer.
+// GET shows a confirmation form, so link scanners opening it don't
+// unsubscribe anyone. POST unsubscribes, which is also what mail clients
+// send for RFC 8058 one-click List-Unsubscribe. A receipt is emailed if
+// UNSUBSCRIBE_RECEIPT_ENABLED is set.
+func handleUnsubscribe(db *sql.DB, sender EmailSender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		subscriberID, articleID, ok := parseTrackingToken(r.PathValue("token"), "unsubscribe")
+		if !ok {
+			http.Error(w, "Invalid link", http.StatusBadRequest)
+			return
+		}
+
+		done := false
+		if r.Method == http.MethodPost {
+			changed, err := unsubscribe(db, r, subscriberID, articleID)
+			if err != nil {
+				log.Printf("Error unsubscribing subscriber %d: %v", subscriberID, err)
+				http.Error(w, "Error unsubscribing", http.StatusInternalServerError)
+				return
+			}
+			if changed {
+				goSendSystemEmail(context.WithoutCancel(r.Context()), db, sender, subscriberID, systemEmailUnsubscribeReceipt)
+			}
+			done = true
+		}
+
+		var page bytes.Buffer
+		if err := renderPage(r.Context(), &page, "unsubscribe.html", map[string]interface{}{"Done": done}); err != nil {
+			log.Printf("Error rendering unsubscribe page: %v", err)
+			http.Error(w, "Error rendering page", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(page.Bytes())
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// warmupSchedule parses WARMUP_SCHEDULE, a comma-separated list of daily
+// send caps starting on WARMUP_START (YYYY-MM-DD, UTC). It returns nil when
+// warm-up is not configured.
+func warmupSchedule() ([]int, time.Time, bool) {
+	raw, start := os.Getenv("WARMUP_SCHEDULE"), os.Getenv("WARMUP_START")
+	if raw == "" || start == "" {
+		return nil, time.Time{}, false
+	}
+	startDate, err := time.Parse("2006-01-02", start)
+	if err != nil {
+		log.Printf("Invalid WARMUP_START %q, warm-up disabled: %v", start, err)
+		return nil, time.Time{}, false
+	}
+	var caps []int
+	for _, part := range strings.Split(raw, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n < 0 {
+			log.Printf("Invalid WARMUP_SCHEDULE %q, warm-up disabled", raw)
+			return nil, time.Time{}, false
+		}
+		caps = append(caps, n)
+	}
+	return caps, startDate, len(caps) > 0
+}
+
+// warmupDailyCap returns the send cap for the day containing now. Before the
+// start date the first cap applies; after the last scheduled day there is no
+// cap.
+func warmupDailyCap(caps []int, start, now time.Time) (int, bool) {
+	day := int(now.UTC().Sub(start).Hours() / 24)
+	if day < 0 {
+		day = 0
+	}
+	if day >= len(caps) {
+		return 0, false
+	}
+	return caps[day], true
+}
+
+// warmupRemaining returns how many more emails may be sent today, and false
+// when warm-up does not limit sending.
+func warmupRemaining(ctx context.Context, db *sql.DB, now time.Time) (int, bool, error) {
+	caps, start, ok := warmupSchedule()
+	if !ok {
+		return 0, false, nil
+	}
+	limit, limited := warmupDailyCap(caps, start, now)
+	if !limited {
+		return 0, false, nil
+	}
+
+	dayStart := now.UTC().Truncate(24 * time.Hour)
+	var sentToday int
+	// Imported rows record sends made by another platform, not today's
+	// traffic from this server, so they don't count against the cap.
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sent_emails WHERE sent_at >= ? AND delivery_status != ?",
+		dayStart.Format(sqliteTimeFormat), deliveryImported).Scan(&sentToday)
+	if err != nil {
+		return 0, true, err
+	}
+	return max(limit-sentToday, 0), true, nil
+}
+
+// deferredArticles returns articles whose latest newsletter job was
+// deferred by the warm-up cap.
+func deferredArticles(ctx context.Context, db *sql.DB) ([]int, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT j.article_id
+		FROM newsletter_jobs j
+		WHERE j.status = ?
+			AND j.id = (SELECT MAX(id) FROM newsletter_jobs WHERE article_id = j.article_id)
+		ORDER BY j.id`, jobDeferred)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// resumeDeferredNewsletters resumes newsletters deferred by the warm-up cap
+// once the day's quota allows more sends. Subscribers who already received
+// an article are skipped, so only the remainder is sent.
+func resumeDeferredNewsletters(ctx context.Context, db *sql.DB, sender EmailSender) error {
+	if _, _, ok := warmupSchedule(); !ok {
+		return nil
+	}
+	ids, err := deferredArticles(ctx, db)
+	if err != nil {
+		return fmt.Errorf("finding deferred newsletters: %w", err)
+	}
+	for _, id := range ids {
+		remaining, limited, err := warmupRemaining(ctx, db, time.Now())
+		if err != nil {
+			return fmt.Errorf("computing warm-up quota: %w", err)
+		}
+		if limited && remaining == 0 {
+			break
+		}
+		jobLogf(ctx, "Resuming deferred newsletter for article %d", id)
+		sendNewsletterForArticle(ctx, db, sender, id)
+	}
+	return nil
+}
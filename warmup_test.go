@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWarmupDailyCap(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	caps := []int{50, 100, 250}
+	tests := []struct {
+		now     time.Time
+		want    int
+		limited bool
+	}{
+		{start.AddDate(0, 0, -3), 50, true},
+		{start.Add(5 * time.Hour), 50, true},
+		{start.AddDate(0, 0, 1), 100, true},
+		{start.AddDate(0, 0, 2).Add(23 * time.Hour), 250, true},
+		{start.AddDate(0, 0, 3), 0, false},
+	}
+	for _, tt := range tests {
+		got, limited := warmupDailyCap(caps, start, tt.now)
+		if got != tt.want || limited != tt.limited {
+			t.Errorf("%s: cap = %d, %t; want %d, %t", tt.now, got, limited, tt.want, tt.limited)
+		}
+	}
+}
+
+func TestWarmupDefersRemainder(t *testing.T) {
+	t.Setenv("WARMUP_SCHEDULE", "2,10")
+	t.Setenv("WARMUP_START", time.Now().UTC().Format("2006-01-02"))
+	db := newTestDB(t)
+	sender := newMockSender("")
+
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES (?, '')", email); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("INSERT INTO articles (title, content) VALUES ('Hello', '')"); err != nil {
+		t.Fatal(err)
+	}
+
+	sendNewsletterForArticle(context.Background(), db, sender, 1)
+
+	if n := len(sender.Messages()); n != 2 {
+		t.Fatalf("sent %d messages, want 2", n)
+	}
+	job, err := getJob(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != jobDeferred || job.Report.Deferred != 1 {
+		t.Fatalf("job = %+v", job)
+	}
+	ids, err := deferredArticles(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []int{1}) {
+		t.Fatalf("deferred articles = %v", ids)
+	}
+}
+
+func TestWarmupIgnoresImportedSends(t *testing.T) {
+	t.Setenv("WARMUP_SCHEDULE", "2,10")
+	t.Setenv("WARMUP_START", time.Now().UTC().Format("2006-01-02"))
+	db := newTestDB(t)
+
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES (?, '')", email); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("INSERT INTO articles (title, content) VALUES ('Back catalog', '')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := markArticleSent(db, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	remaining, limited, err := warmupRemaining(context.Background(), db, time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !limited || remaining != 2 {
+		t.Fatalf("remaining = %d, %t; want 2, true", remaining, limited)
+	}
+}
+
+func TestWarmupKeepsFailedStatus(t *testing.T) {
+	t.Setenv("WARMUP_SCHEDULE", "2,10")
+	t.Setenv("WARMUP_START", time.Now().UTC().Format("2006-01-02"))
+	t.Setenv("SEND_RETRY_BACKOFF", "0")
+	db := newTestDB(t)
+
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		if _, err := db.Exec("INSERT INTO subscribers (email, name) VALUES (?, '')", email); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("INSERT INTO articles (title, content) VALUES ('Hello', '')"); err != nil {
+		t.Fatal(err)
+	}
+
+	sendNewsletterForArticle(context.Background(), db, failingSender{}, 1)
+
+	job, err := getJob(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != jobFailed || job.Report.Deferred != 1 {
+		t.Fatalf("job = %+v, want failed with 1 deferred", job)
+	}
+}
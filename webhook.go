@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// maxWebhookBody caps the size of an inbound webhook request.
+const maxWebhookBody = 1 << 20
+
+// webhookVerifier checks the signature of an inbound webhook. It returns an
+// id unique to this delivery, derived from the signed content, which is
+// used to reject replays. Verifiers that cannot tell deliveries apart
+// return "".
+type webhookVerifier func(r *http.Request, payload []byte, now time.Time) (string, error)
+
+// webhookTolerance is how far a signed webhook's timestamp may be from now
+// (WEBHOOK_TOLERANCE, default 5m). Older deliveries are rejected, so
+// replay ids only need to be remembered this long.
+func webhookTolerance() time.Duration {
+	return getEnvDuration("WEBHOOK_TOLERANCE", 5*time.Minute)
+}
+
+// checkWebhookTimestamp rejects a signature made outside the tolerance.
+func checkWebhookTimestamp(signedAt, now time.Time) error {
+	tolerance := webhookTolerance()
+	if age := now.Sub(signedAt); age > tolerance || age < -tolerance {
+		return errors.New("signature timestamp outside tolerance")
+	}
+	return nil
+}
+
+// readVerifiedWebhook reads the request body and checks it with verify and
+// against earlier deliveries from source. On failure it responds 401 with
+// the reason and returns false.
+func readVerifiedWebhook(db *sql.DB, w http.ResponseWriter, r *http.Request, source string, verify webhookVerifier) ([]byte, bool) {
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	now := time.Now()
+	id, err := verify(r, payload, now)
+	if err == nil && id != "" {
+		err = claimWebhookDelivery(db, source, id, now)
+	}
+	if err != nil {
+		reportError(r.Context(), fmt.Errorf("%s webhook: %w", source, err), map[string]string{
+			"stage": "webhook",
+			"ip":    clientIP(r),
+		})
+		http.Error(w, "Webhook rejected: "+err.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+	return payload, true
+}
+
+// signedContentID identifies a delivery by what its signature covers, the
+// timestamp and payload, rather than by the signature itself: ECDSA
+// signatures are malleable, so a replay could carry a different but equally
+// valid signature over the same content.
+func signedContentID(timestamp string, payload []byte) string {
+	h := sha256.New()
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(payload)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// claimWebhookDelivery records a delivery id, failing if it was already
+// seen. Ids older than twice the tolerance are dropped, as their
+// signatures no longer verify.
+func claimWebhookDelivery(db *sql.DB, source, id string, now time.Time) error {
+	sum := sha256.Sum256([]byte(id))
+	cutoff := now.Add(-2 * webhookTolerance()).UTC().Format(sqliteTimeFormat)
+	if _, err := db.Exec("DELETE FROM webhook_deliveries WHERE received_at < ?", cutoff); err != nil {
+		return err
+	}
+	result, err := db.Exec("INSERT OR IGNORE INTO webhook_deliveries (source, delivery_id, received_at) VALUES (?, ?, ?)",
+		source, hex.EncodeToString(sum[:]), now.UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errors.New("delivery already received")
+	}
+	return nil
+}
+
+// verifyDeliveryWebhook checks a delivery status webhook. With
+// SENDGRID_WEBHOOK_PUBLIC_KEY set it verifies SendGrid's signed event
+// webhook; otherwise the request must carry DELIVERY_WEBHOOK_SECRET as a
+// token query parameter or X-Webhook-Token header.
+func verifyDeliveryWebhook(r *http.Request, payload []byte, now time.Time) (string, error) {
+	if key := os.Getenv("SENDGRID_WEBHOOK_PUBLIC_KEY"); key != "" {
+		return verifySendGridSignature(payload,
+			r.Header.Get("X-Twilio-Email-Event-Webhook-Signature"),
+			r.Header.Get("X-Twilio-Email-Event-Webhook-Timestamp"), key, now)
+	}
+	return verifyWebhookToken(r, "DELIVERY_WEBHOOK_SECRET")
+}
+
+// verifyWebhookToken checks that the request carries one of the secrets
+// in the named environment variable, a comma-separated list, as a token
+// query parameter or X-Webhook-Token header. Tokens cannot tell deliveries
+// apart, so it returns no id.
+func verifyWebhookToken(r *http.Request, secretEnv string) (string, error) {
+	token := r.Header.Get("X-Webhook-Token")
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	for _, secret := range envSecrets(secretEnv) {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1 {
+			return "", nil
+		}
+	}
+	return "", errors.New("token mismatch")
+}
+
+// verifySendGridSignature checks an ECDSA signature, base64 DER encoded,
+// over timestamp followed by payload. publicKey is the base64 DER key shown
+// in SendGrid's signed event webhook settings.
+func verifySendGridSignature(payload []byte, signature, timestamp, publicKey string, now time.Time) (string, error) {
+	der, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return "", fmt.Errorf("decoding public key: %w", err)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return "", fmt.Errorf("parsing public key: %w", err)
+	}
+	key, ok := parsed.(*ecdsa.PublicKey)
+	if !ok {
+		return "", errors.New("public key is not ECDSA")
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || signature == "" {
+		return "", errors.New("missing or malformed signature headers")
+	}
+	if err := checkWebhookTimestamp(time.Unix(ts, 0), now); err != nil {
+		return "", err
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return "", errors.New("malformed signature")
+	}
+	digest := sha256.Sum256(append([]byte(timestamp), payload...))
+	if !ecdsa.VerifyASN1(key, digest[:], sig) {
+		return "", errors.New("no matching signature")
+	}
+	return signedContentID(timestamp, payload), nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifySendGridSignature(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := base64.StdEncoding.EncodeToString(der)
+
+	now := time.Now()
+	payload := []byte(`[{"sg_message_id":"m1","event":"delivered"}]`)
+	sign := func(at time.Time) (string, string) {
+		ts := strconv.FormatInt(at.Unix(), 10)
+		digest := sha256.Sum256(append([]byte(ts), payload...))
+		sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.StdEncoding.EncodeToString(sig), ts
+	}
+
+	sig, ts := sign(now)
+	id, err := verifySendGridSignature(payload, sig, ts, publicKey, now)
+	if err != nil || id == "" {
+		t.Fatalf("valid signature: id %q, %v", id, err)
+	}
+	// A second signature over the same content is a replay too.
+	if other, _ := sign(now); other == sig {
+		t.Fatal("expected a distinct signature")
+	} else if again, err := verifySendGridSignature(payload, other, ts, publicKey, now); err != nil || again != id {
+		t.Fatalf("re-signed content: id %q, %v; want %q", again, err, id)
+	}
+	if _, err := verifySendGridSignature([]byte(`[]`), sig, ts, publicKey, now); err == nil {
+		t.Fatal("tampered payload accepted")
+	}
+	sig, ts = sign(now.Add(-time.Hour))
+	if _, err := verifySendGridSignature(payload, sig, ts, publicKey, now); err == nil {
+		t.Fatal("stale signature accepted")
+	}
+}
+
+func TestClaimWebhookDeliveryRejectsReplay(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now()
+	if err := claimWebhookDelivery(db, "delivery", "sig-1", now); err != nil {
+		t.Fatal(err)
+	}
+	if err := claimWebhookDelivery(db, "delivery", "sig-1", now); err == nil {
+		t.Fatal("replay accepted")
+	}
+	if err := claimWebhookDelivery(db, "stripe", "sig-1", now); err != nil {
+		t.Fatalf("same id from another source: %v", err)
+	}
+	// Ids are forgotten once their signatures could no longer verify.
+	if err := claimWebhookDelivery(db, "delivery", "sig-1", now.Add(time.Hour)); err != nil {
+		t.Fatalf("after expiry: %v", err)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxWebhookResponse caps how much of a webhook response body is stored.
+const maxWebhookResponse = 512
+
+// webhookEndpoints resolves an outbound webhook's name to its current URL.
+// Only the name is stored with a delivery, since the URLs of incoming
+// webhook services embed their secret.
+var webhookEndpoints = map[string]func() string{
+	"alert": func() string { return credential("ALERT_WEBHOOK_URL") },
+}
+
+// WebhookDelivery is one attempt to deliver an outbound webhook.
+type WebhookDelivery struct {
+	ID         int             `json:"id"`
+	Endpoint   string          `json:"endpoint"`
+	Payload    json.RawMessage `json:"payload"`
+	StatusCode int             `json:"status_code,omitempty"`
+	Response   string          `json:"response,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	// ReplayOf is the delivery this attempt replayed.
+	ReplayOf  int       `json:"replay_of,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Succeeded reports whether the endpoint accepted the delivery.
+func (d WebhookDelivery) Succeeded() bool {
+	return d.Error == "" && d.StatusCode >= 200 && d.StatusCode < 300
+}
+
+// deliverWebhook posts payload to endpoint's URL and records the attempt,
+// whatever its outcome, so it can be inspected and replayed.
+func deliverWebhook(ctx context.Context, db *sql.DB, endpoint string, payload []byte, replayOf int) (WebhookDelivery, error) {
+	d := WebhookDelivery{Endpoint: endpoint, Payload: payload, ReplayOf: replayOf}
+	err := postWebhook(ctx, webhookEndpoints[endpoint](), payload, &d)
+	if err != nil {
+		d.Error = err.Error()
+	}
+
+	var replay interface{}
+	if replayOf != 0 {
+		replay = replayOf
+	}
+	dbErr := db.QueryRow(`
+		INSERT INTO outbound_webhook_deliveries (endpoint, payload, status_code, response, error, replay_of)
+		VALUES (?, ?, ?, ?, ?, ?)
+		RETURNING id, created_at`,
+		endpoint, string(payload), d.StatusCode, d.Response, d.Error, replay).Scan(&d.ID, scanTime(&d.CreatedAt))
+	if dbErr != nil {
+		log.Printf("Error recording %s webhook delivery: %v", endpoint, dbErr)
+	}
+	return d, err
+}
+
+func postWebhook(ctx context.Context, url string, payload []byte, d *WebhookDelivery) error {
+	if url == "" {
+		return errors.New("webhook URL is not configured")
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := outboundClient(0).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, maxWebhookResponse))
+	d.StatusCode, d.Response = resp.StatusCode, string(snippet)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+const webhookDeliveryColumns = "id, endpoint, payload, status_code, response, error, COALESCE(replay_of, 0), created_at"
+
+func scanWebhookDelivery(row interface{ Scan(...any) error }) (WebhookDelivery, error) {
+	var d WebhookDelivery
+	var payload string
+	err := row.Scan(&d.ID, &d.Endpoint, &payload, &d.StatusCode, &d.Response, &d.Error, &d.ReplayOf, scanTime(&d.CreatedAt))
+	d.Payload = json.RawMessage(payload)
+	return d, err
+}
+
+// getWebhookDeliveries returns the latest 100 deliveries, newest first,
+// optionally only failed ones.
+func getWebhookDeliveries(db *sql.DB, failedOnly bool) ([]WebhookDelivery, error) {
+	query := "SELECT " + webhookDeliveryColumns + " FROM outbound_webhook_deliveries"
+	if failedOnly {
+		query += " WHERE error != '' OR status_code NOT BETWEEN 200 AND 299"
+	}
+	rows, err := db.Query(query + " ORDER BY id DESC LIMIT 100")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func deleteOldWebhookDeliveries(db *sql.DB, cutoff time.Time) (int64, error) {
+	result, err := db.Exec("DELETE FROM outbound_webhook_deliveries WHERE created_at < ?", cutoff.Format(sqliteTimeFormat))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// handleWebhookDeliveries lists outbound webhook deliveries, only failed
+// ones with ?status=failed.
+func handleWebhookDeliveries(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		deliveries, err := getWebhookDeliveries(db, r.URL.Query().Get("status") == "failed")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(deliveries)
+	}
+}
+
+// handleReplayWebhookDelivery sends a recorded delivery's payload again to
+// the endpoint's current URL. The replay is recorded as a new delivery.
+func handleReplayWebhookDelivery(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid delivery id", http.StatusBadRequest)
+			return
+		}
+		original, err := scanWebhookDelivery(db.QueryRow("SELECT "+webhookDeliveryColumns+" FROM outbound_webhook_deliveries WHERE id = ?", id))
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Delivery not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if _, ok := webhookEndpoints[original.Endpoint]; !ok {
+			http.Error(w, "Unknown webhook endpoint "+original.Endpoint, http.StatusConflict)
+			return
+		}
+
+		d, err := deliverWebhook(r.Context(), db, original.Endpoint, original.Payload, original.ID)
+		if err != nil {
+			log.Printf("Error replaying webhook delivery %d: %v", id, err)
+		}
+		recordAudit(db, r, "replay", "webhook_delivery", id, map[string]int{"delivery": d.ID})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookDeliveryLogAndReplay(t *testing.T) {
+	db := newTestDB(t)
+	srv := newTestServer(t, db, newMockSender(""))
+
+	fail := true
+	var received int
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received++
+		if fail {
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+		}
+	}))
+	defer hook.Close()
+	t.Setenv("ALERT_WEBHOOK_URL", hook.URL)
+
+	if err := postAlertWebhook(context.Background(), db, "Queue backing up", "details"); err == nil {
+		t.Fatal("expected the failed delivery to return an error")
+	}
+
+	resp, err := http.Get(srv.URL + "/api/admin/webhook-deliveries?status=failed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("list: status %d", resp.StatusCode)
+	}
+	var deliveries []WebhookDelivery
+	err = json.NewDecoder(resp.Body).Decode(&deliveries)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].StatusCode != http.StatusServiceUnavailable || deliveries[0].Response != "try later\n" {
+		t.Fatalf("deliveries = %+v", deliveries)
+	}
+
+	fail = false
+	resp, err = http.Post(srv.URL+"/api/admin/webhook-deliveries/1/replay", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var replay WebhookDelivery
+	err = json.NewDecoder(resp.Body).Decode(&replay)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !replay.Succeeded() || replay.ReplayOf != 1 || received != 2 {
+		t.Fatalf("replay = %+v, received %d", replay, received)
+	}
+	var payload map[string]string
+	if err := json.Unmarshal(replay.Payload, &payload); err != nil || payload["subject"] != "Queue backing up" {
+		t.Fatalf("replayed payload = %s", replay.Payload)
+	}
+
+	all, err := getWebhookDeliveries(db, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("got %d deliveries, want 2", len(all))
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const channelPush = "push"
+
+// PushSubscription is a browser's Web Push subscription, as returned by
+// PushManager.subscribe. It may be linked to a subscriber, who then gets
+// pushes while push is among their channels.
+type PushSubscription struct {
+	ID           int    `json:"id"`
+	SubscriberID int    `json:"subscriber_id,omitempty"`
+	Endpoint     string `json:"endpoint"`
+	Keys         struct {
+		P256DH string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+	// InsteadOfEmail, when subscribing, makes push the linked
+	// subscriber's only channel rather than adding it to their channels.
+	InsteadOfEmail bool `json:"instead_of_email,omitempty"`
+}
+
+// vapidKeys returns the VAPID key pair from VAPID_PUBLIC_KEY and
+// VAPID_PRIVATE_KEY (unpadded base64url, as generated by the web-push
+// tools). Push is off while either is unset.
+func vapidKeys() (*ecdsa.PrivateKey, string, error) {
+	pub, priv := os.Getenv("VAPID_PUBLIC_KEY"), credential("VAPID_PRIVATE_KEY")
+	if pub == "" || priv == "" {
+		return nil, "", nil
+	}
+	d, err := base64.RawURLEncoding.DecodeString(priv)
+	if err != nil || len(d) != 32 {
+		return nil, "", errors.New("VAPID_PRIVATE_KEY must be a base64url P-256 private key")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(pub)
+	if err != nil || len(raw) != 65 || raw[0] != 4 {
+		return nil, "", errors.New("VAPID_PUBLIC_KEY must be a base64url uncompressed P-256 public key")
+	}
+	key := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(d)}
+	key.Curve = elliptic.P256()
+	key.X, key.Y = new(big.Int).SetBytes(raw[1:33]), new(big.Int).SetBytes(raw[33:])
+	return key, pub, nil
+}
+
+// vapidAuthorization returns the Authorization header for a push to
+// endpoint: an ES256 JWT for the push service's origin (RFC 8292).
+func vapidAuthorization(key *ecdsa.PrivateKey, publicKey, endpoint string, now time.Time) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	subject := os.Getenv("VAPID_SUBJECT")
+	if subject == "" {
+		subject = "mailto:" + os.Getenv("EMAIL_FROM")
+	}
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": now.Add(12 * time.Hour).Unix(),
+		"sub": subject,
+	})
+	if err != nil {
+		return "", err
+	}
+	signingInput := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return "vapid t=" + signingInput + "." + base64.RawURLEncoding.EncodeToString(sig) + ", k=" + publicKey, nil
+}
+
+// hkdf derives length (at most 32) bytes from secret, salt and info
+// (RFC 5869 with SHA-256).
+func hkdf(secret, salt, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write(info)
+	expand.Write([]byte{1})
+	return expand.Sum(nil)[:length]
+}
+
+// encryptPushPayload encrypts payload for a subscription with the
+// aes128gcm content encoding (RFC 8291, RFC 8188), as a single record.
+func encryptPushPayload(sub PushSubscription, payload []byte) ([]byte, error) {
+	uaPublic, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(sub.Keys.P256DH, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid p256dh key: %w", err)
+	}
+	authSecret, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(sub.Keys.Auth, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid auth secret: %w", err)
+	}
+	uaKey, err := ecdh.P256().NewPublicKey(uaPublic)
+	if err != nil {
+		return nil, fmt.Errorf("invalid p256dh key: %w", err)
+	}
+	asKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := asKey.ECDH(uaKey)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := asKey.PublicKey().Bytes()
+
+	keyInfo := append(append([]byte("WebPush: info\x00"), uaPublic...), asPublic...)
+	ikm := hkdf(shared, authSecret, keyInfo, 32)
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	cek := hkdf(ikm, salt, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(ikm, salt, []byte("Content-Encoding: nonce\x00"), 12)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	var body bytes.Buffer
+	body.Write(salt)
+	binary.Write(&body, binary.BigEndian, uint32(4096))
+	body.WriteByte(byte(len(asPublic)))
+	body.Write(asPublic)
+	// 0x02 marks the last (and only) record.
+	body.Write(gcm.Seal(nil, nonce, append(payload, 2), nil))
+	return body.Bytes(), nil
+}
+
+// errPushGone means the push service no longer knows the subscription.
+var errPushGone = errors.New("push subscription expired")
+
+// sendPush delivers one encrypted notification.
+func sendPush(ctx context.Context, key *ecdsa.PrivateKey, publicKey string, sub PushSubscription, payload []byte) error {
+	body, err := encryptPushPayload(sub, payload)
+	if err != nil {
+		return err
+	}
+	auth, err := vapidAuthorization(key, publicKey, sub.Endpoint, time.Now())
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", strconv.Itoa(int(getEnvDuration("PUSH_TTL", 24*time.Hour).Seconds())))
+
+	resp, err := outboundClient(getEnvDuration("PUSH_TIMEOUT", 10*time.Second)).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return errPushGone
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("push service returned %s", resp.Status)
+	}
+	return nil
+}
+
+// pushRecipients returns the subscriptions that should be notified of an
+// article: anonymous ones unless the article is premium, and those linked
+// to a subscriber in its audience who takes push.
+func pushRecipients(ctx context.Context, db *sql.DB, article Article, audience []Subscriber) ([]PushSubscription, error) {
+	wantsPush := make(map[int]bool, len(audience))
+	for _, s := range audience {
+		wantsPush[s.ID] = s.wants(channelPush)
+	}
+	rows, err := db.QueryContext(ctx, "SELECT id, COALESCE(subscriber_id, 0), endpoint, p256dh, auth FROM push_subscriptions ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var subs []PushSubscription
+	for rows.Next() {
+		var s PushSubscription
+		if err := rows.Scan(&s.ID, &s.SubscriberID, &s.Endpoint, &s.Keys.P256DH, &s.Keys.Auth); err != nil {
+			return nil, err
+		}
+		if s.SubscriberID == 0 && !article.Premium || wantsPush[s.SubscriberID] {
+			subs = append(subs, s)
+		}
+	}
+	return subs, rows.Err()
+}
+
+// sendPushNotifications notifies the article's push subscribers with its
+// title, excerpt and link. Each subscription is notified at most once per
+// article; subscriptions the push service reports gone are removed.
+// Outside SEND_MODE=live nothing is pushed, since push endpoints are real
+// browsers.
+func sendPushNotifications(ctx context.Context, db *sql.DB, article Article, audience []Subscriber) (sent, failed int) {
+	if !liveSendMode() {
+		log.Printf("SEND_MODE=%s: not sending push notifications for article %d", os.Getenv("SEND_MODE"), article.ID)
+		return 0, 0
+	}
+	key, publicKey, err := vapidKeys()
+	if err != nil || key == nil {
+		if err != nil {
+			log.Printf("Error loading VAPID keys: %v", err)
+		}
+		return 0, 0
+	}
+	subs, err := pushRecipients(ctx, db, article, audience)
+	if err != nil {
+		log.Printf("Error listing push subscriptions: %v", err)
+		return 0, 0
+	}
+	payload, err := json.Marshal(map[string]string{
+		"title": article.Title,
+		"body":  article.Excerpt,
+		"url":   articleURL(article),
+	})
+	if err != nil {
+		log.Printf("Error encoding push payload: %v", err)
+		return 0, 0
+	}
+
+	for _, s := range subs {
+		recipient := strconv.Itoa(s.ID)
+		claimed, err := claimChannelDelivery(ctx, db, channelPush, article.ID, recipient, s.SubscriberID)
+		if err != nil {
+			log.Printf("Error claiming push delivery: %v", err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		err = sendPush(ctx, key, publicKey, s, payload)
+		if errors.Is(err, errPushGone) {
+			if _, err := db.ExecContext(ctx, "DELETE FROM push_subscriptions WHERE id = ?", s.ID); err != nil {
+				log.Printf("Error removing push subscription %d: %v", s.ID, err)
+			}
+		}
+		if err := finishChannelDelivery(ctx, db, channelPush, article.ID, recipient, err); err != nil {
+			log.Printf("Error recording push delivery: %v", err)
+		}
+		if err != nil {
+			failed++
+			continue
+		}
+		sent++
+	}
+	return sent, failed
+}
+
+// validPushEndpoint accepts https URLs on a named host, so the endpoint
+// cannot point pushes at addresses inside our network.
+func validPushEndpoint(endpoint string) bool {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme != "https" || u.Hostname() == "" {
+		return false
+	}
+	return net.ParseIP(u.Hostname()) == nil && u.Hostname() != "localhost"
+}
+
+// pushServiceWorker shows each push as a notification that opens the
+// article when clicked.
+const pushServiceWorker = `self.addEventListener("push", function (event) {
+	var data = event.data ? event.data.json() : {};
+	event.waitUntil(self.registration.showNotification(data.title || "New post", {body: data.body, data: data.url}));
+});
+self.addEventListener("notificationclick", function (event) {
+	event.notification.close();
+	if (event.notification.data) {
+		event.waitUntil(clients.openWindow(event.notification.data));
+	}
+});
+`
+
+func handlePushServiceWorker() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/javascript")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write([]byte(pushServiceWorker))
+	}
+}
+
+// handlePushKey returns the VAPID public key the browser passes to
+// PushManager.subscribe as applicationServerKey.
+func handlePushKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		key, publicKey, err := vapidKeys()
+		if err != nil || key == nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"public_key": publicKey})
+	}
+}
+
+// handlePushSubscriptions stores (POST) or removes (DELETE) a browser's
+// push subscription. A "token" from the subscriber's preference center
+// links it to them and adds push to their channels.
+func handlePushSubscriptions(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if key, _, err := vapidKeys(); err != nil || key == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		var req struct {
+			PushSubscription
+			Token string `json:"token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !validPushEndpoint(req.Endpoint) {
+			http.Error(w, "Invalid push endpoint", http.StatusBadRequest)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			if _, err := db.Exec("DELETE FROM push_subscriptions WHERE endpoint = ?", req.Endpoint); err != nil {
+				log.Printf("Error removing push subscription: %v", err)
+				http.Error(w, "Error removing subscription", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("OK"))
+			return
+		}
+
+		var channels []string
+		if req.Token != "" {
+			id, _, ok := parseTrackingToken(req.Token, "preferences")
+			if !ok {
+				http.Error(w, "Invalid token", http.StatusBadRequest)
+				return
+			}
+			sub, err := getSubscriber(db, id)
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Invalid token", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				log.Printf("Error loading subscriber %d: %v", id, err)
+				http.Error(w, "Error storing subscription", http.StatusInternalServerError)
+				return
+			}
+			req.SubscriberID = id
+			channels = append(sub.Channels, channelPush)
+			if req.InsteadOfEmail {
+				channels = []string{channelPush}
+			}
+		}
+		if req.InsteadOfEmail && req.SubscriberID == 0 {
+			http.Error(w, "instead_of_email needs a subscriber token", http.StatusBadRequest)
+			return
+		}
+		if _, err := encryptPushPayload(req.PushSubscription, nil); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, err := db.Exec(`
+			INSERT INTO push_subscriptions (subscriber_id, endpoint, p256dh, auth) VALUES (?, ?, ?, ?)
+			ON CONFLICT (endpoint) DO UPDATE SET subscriber_id = excluded.subscriber_id, p256dh = excluded.p256dh,
+				auth = excluded.auth`,
+			nullableID(req.SubscriberID), req.Endpoint, req.Keys.P256DH, req.Keys.Auth)
+		if err == nil && req.SubscriberID != 0 {
+			err = setChannels(db, req.SubscriberID, channels)
+		}
+		if err != nil {
+			log.Printf("Error storing push subscription: %v", err)
+			http.Error(w, "Error storing subscription", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("Subscribed to push notifications"))
+	}
+}
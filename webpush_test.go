@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// decryptPushPayload reverses encryptPushPayload with the browser's keys.
+func decryptPushPayload(t *testing.T, uaKey *ecdh.PrivateKey, authSecret, body []byte) []byte {
+	t.Helper()
+	salt, keyLen := body[:16], int(body[20])
+	asPublic, ciphertext := body[21:21+keyLen], body[21+keyLen:]
+	asKey, err := ecdh.P256().NewPublicKey(asPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, err := uaKey.ECDH(asKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := append(append([]byte("WebPush: info\x00"), uaKey.PublicKey().Bytes()...), asPublic...)
+	ikm := hkdf(shared, authSecret, info, 32)
+	block, _ := aes.NewCipher(hkdf(ikm, salt, []byte("Content-Encoding: aes128gcm\x00"), 16))
+	gcm, _ := cipher.NewGCM(block)
+	plain, err := gcm.Open(nil, hkdf(ikm, salt, []byte("Content-Encoding: nonce\x00"), 12), ciphertext, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.TrimSuffix(plain, []byte{2})
+}
+
+// verifyVAPID checks the JWT in a push's Authorization header.
+func verifyVAPID(t *testing.T, header string) {
+	t.Helper()
+	token, key, ok := strings.Cut(strings.TrimPrefix(header, "vapid t="), ", k=")
+	if !ok {
+		t.Fatalf("Authorization = %q", header)
+	}
+	raw, _ := base64.RawURLEncoding.DecodeString(key)
+	pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(raw[1:33]), Y: new(big.Int).SetBytes(raw[33:])}
+	i := strings.LastIndex(token, ".")
+	sig, _ := base64.RawURLEncoding.DecodeString(token[i+1:])
+	digest := sha256.Sum256([]byte(token[:i]))
+	if !ecdsa.Verify(pub, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		t.Error("VAPID signature does not verify")
+	}
+}
+
+func TestPushNotificationsOnPublish(t *testing.T) {
+	vapid, _ := ecdh.P256().GenerateKey(rand.Reader)
+	t.Setenv("VAPID_PRIVATE_KEY", base64.RawURLEncoding.EncodeToString(vapid.Bytes()))
+	t.Setenv("VAPID_PUBLIC_KEY", base64.RawURLEncoding.EncodeToString(vapid.PublicKey().Bytes()))
+	db := newTestDB(t)
+	sender := newMockSender("")
+
+	var mu sync.Mutex
+	var pushes [][]byte
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verifyVAPID(t, r.Header.Get("Authorization"))
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		pushes = append(pushes, body)
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer live.Close()
+	gone := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer gone.Close()
+
+	uaKey, _ := ecdh.P256().GenerateKey(rand.Reader)
+	authSecret := []byte("0123456789abcdef")
+	p256dh := base64.RawURLEncoding.EncodeToString(uaKey.PublicKey().Bytes())
+	auth := base64.RawURLEncoding.EncodeToString(authSecret)
+	_, err := db.Exec(`
+		INSERT INTO subscribers (email, name) VALUES ('push@example.com', 'Push'), ('mail@example.com', 'Mail');
+		INSERT INTO articles (title, content, excerpt) VALUES ('Hello', 'Body', 'Short');
+		UPDATE subscribers SET channels = 'push' WHERE id = 1;
+		INSERT INTO push_subscriptions (subscriber_id, endpoint, p256dh, auth) VALUES
+			(1, ?, ?, ?),
+			(NULL, ?, ?, ?)`,
+		live.URL+"/push/1", p256dh, auth, gone.URL+"/push/2", p256dh, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendNewsletterForArticle(context.Background(), db, sender, 1)
+	sendNewsletterForArticle(context.Background(), db, sender, 1)
+
+	if msgs := sender.Messages(); len(msgs) != 1 || msgs[0].GetHeader("To")[0] != "mail@example.com" {
+		t.Errorf("emails = %d, want one to mail@example.com only", len(msgs))
+	}
+	if len(pushes) != 1 {
+		t.Fatalf("pushes = %d, want 1 across both sends", len(pushes))
+	}
+	var payload map[string]string
+	if err := json.Unmarshal(decryptPushPayload(t, uaKey, authSecret, pushes[0]), &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload["title"] != "Hello" || payload["body"] != "Short" {
+		t.Errorf("payload = %v", payload)
+	}
+
+	var remaining, sent int
+	db.QueryRow("SELECT COUNT(*) FROM push_subscriptions").Scan(&remaining)
+	db.QueryRow("SELECT COUNT(*) FROM channel_deliveries WHERE channel = 'push' AND status = 'sent'").Scan(&sent)
+	if remaining != 1 || sent != 1 {
+		t.Errorf("subscriptions left = %d, sent deliveries = %d; want the gone one removed", remaining, sent)
+	}
+}
+
+func TestValidPushEndpoint(t *testing.T) {
+	tests := map[string]bool{
+		"https://fcm.googleapis.com/fcm/send/abc": true,
+		"http://fcm.googleapis.com/fcm/send/abc":  false,
+		"https://127.0.0.1/push":                  false,
+		"https://[::1]/push":                      false,
+		"https://localhost/push":                  false,
+	}
+	for endpoint, want := range tests {
+		if got := validPushEndpoint(endpoint); got != want {
+			t.Errorf("validPushEndpoint(%q) = %v, want %v", endpoint, got, want)
+		}
+	}
+}
+
+func TestPushSkippedOutsideLiveMode(t *testing.T) {
+	vapid, _ := ecdh.P256().GenerateKey(rand.Reader)
+	t.Setenv("VAPID_PRIVATE_KEY", base64.RawURLEncoding.EncodeToString(vapid.Bytes()))
+	t.Setenv("VAPID_PUBLIC_KEY", base64.RawURLEncoding.EncodeToString(vapid.PublicKey().Bytes()))
+	t.Setenv("SEND_MODE", "capture")
+	db := newTestDB(t)
+
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("pushed to a real endpoint in capture mode")
+	}))
+	defer endpoint.Close()
+	_, err := db.Exec("INSERT INTO push_subscriptions (subscriber_id, endpoint, p256dh, auth) VALUES (NULL, ?, 'x', 'y')", endpoint.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sent, failed := sendPushNotifications(context.Background(), db, Article{ID: 1, Title: "Hello"}, nil); sent != 0 || failed != 0 {
+		t.Errorf("sent %d, failed %d in capture mode", sent, failed)
+	}
+}